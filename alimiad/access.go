@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Access keys are P-256 key pairs that encrypted P2P payloads are sealed
+// to. The scheme matches p2p/encrypt.go: the ciphertext is the sender's
+// compressed ephemeral public key, a 12-byte nonce and the AES-256-GCM
+// sealed payload, keyed by SHA-256 over the ECDH shared secret, the
+// ephemeral key and the recipient key.
+
+// accessKeyCmd generates an access key pair.
+func accessKeyCmd(args []string) {
+	fs := flag.NewFlagSet("access-key", flag.ExitOnError)
+	_ = fs.Parse(args)
+
+	curve := elliptic.P256()
+	priv, x, y, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("public:  %s\n", hex.EncodeToString(elliptic.MarshalCompressed(curve, x, y)))
+	fmt.Printf("private: %s\n", hex.EncodeToString(priv))
+}
+
+// encryptCmd encrypts a file to an access key, for submission with
+// recipientKey to POST /push/blob.
+func encryptCmd(args []string) {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	to := fs.String("to", "", "public access key of the recipient")
+	in := fs.String("in", "-", "file to encrypt (- for stdin)")
+	out := fs.String("out", "-", "file to write (- for stdout)")
+	_ = fs.Parse(args)
+
+	pub, err := hex.DecodeString(*to)
+	curve := elliptic.P256()
+	px, py := elliptic.UnmarshalCompressed(curve, pub)
+	if err != nil || px == nil {
+		log.Fatal("❌ -to must be a compressed P-256 public key (see alimiad access-key)")
+	}
+	plain, err := readInput(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	eph, ex, ey, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ephPub := elliptic.MarshalCompressed(curve, ex, ey)
+	sx, _ := curve.ScalarMult(px, py, eph)
+	gcm, err := payloadCipher(sx, ephPub, pub)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		log.Fatal(err)
+	}
+	sealed := append(append(ephPub, nonce...), gcm.Seal(nil, nonce, plain, pub)...)
+	if err := writeOutput(*out, sealed); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// decryptCmd fetches an encrypted payload from a P2P node, or reads it
+// from a file, and decrypts it with a private access key.
+func decryptCmd(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	node := fs.String("node", "http://localhost:8090", "URL of the P2P node holding the block")
+	height := fs.Int("height", -1, "height of the block whose payload to decrypt")
+	in := fs.String("in", "", "file holding the ciphertext instead of -node/-height")
+	key := fs.String("key", os.Getenv("ACCESS_KEY"), "private access key (ACCESS_KEY)")
+	out := fs.String("out", "-", "file to write (- for stdout)")
+	_ = fs.Parse(args)
+
+	priv, err := hex.DecodeString(*key)
+	if err != nil || len(priv) != 32 {
+		log.Fatal("❌ -key must be a private access key (see alimiad access-key)")
+	}
+	var sealed []byte
+	switch {
+	case *in != "":
+		sealed, err = readInput(*in)
+	case *height >= 0:
+		sealed, err = fetchPayload(*node, *height)
+	default:
+		log.Fatal("❌ give -height or -in")
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	plain, err := openPayload(priv, sealed)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := writeOutput(*out, plain); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// openPayload decrypts a sealed payload with a private access key.
+func openPayload(priv, sealed []byte) ([]byte, error) {
+	curve := elliptic.P256()
+	if len(sealed) < 33+12 {
+		return nil, errors.New("ciphertext is too short")
+	}
+	ephPub, nonce, ct := sealed[:33], sealed[33:45], sealed[45:]
+	ex, ey := elliptic.UnmarshalCompressed(curve, ephPub)
+	if ex == nil {
+		return nil, errors.New("ciphertext does not start with a P-256 public key")
+	}
+	x, y := curve.ScalarBaseMult(priv)
+	pub := elliptic.MarshalCompressed(curve, x, y)
+	sx, _ := curve.ScalarMult(ex, ey, priv)
+	gcm, err := payloadCipher(sx, ephPub, pub)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, nonce, ct, pub)
+	if err != nil {
+		return nil, errors.New("payload is not encrypted to this key or was tampered with")
+	}
+	return plain, nil
+}
+
+// payloadCipher derives the AES-256-GCM cipher of one encrypted payload.
+func payloadCipher(shared *big.Int, ephPub, recipient []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared.FillBytes(make([]byte, 32)))
+	h.Write(ephPub)
+	h.Write(recipient)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// fetchPayload downloads the raw payload of the block at height.
+func fetchPayload(node string, height int) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/blocks/%d/payload", strings.TrimRight(node, "/"), height))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("❌ %s: %s", resp.Status, errorMessage(msg))
+	}
+	if resp.Header.Get("X-Recipient-Key") == "" {
+		return nil, errors.New("❌ the payload of this block is not encrypted")
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// readInput reads a file, or stdin for "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// writeOutput writes a file, or stdout for "-".
+func writeOutput(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
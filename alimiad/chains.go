@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"alirezachain/genesis"
+)
+
+// Multi-tenant hosting. `alimiad chains` serves several independent
+// chains behind one address, e.g. a private chain per student. The node
+// programs keep their state in package globals, so every chain runs as
+// its own node process on an internal port, without peers, and the host
+// proxies /chains/{id}/... to it. Each chain has a directory under -dir
+// holding its genesis.json, its stored chain and an optional .env. The
+// host saves every chain there each -save-every and again on shutdown,
+// and a restarted host imports them.
+
+// chainIDPattern restricts chain IDs, which are directory names and URL
+// path segments.
+var chainIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Files in a chain's directory.
+const (
+	tenantGenesis  = "genesis.json"
+	tenantChain    = "chain.jsonl" // GET /export on shutdown, -import on start
+	tenantStakeLog = "stake.log"   // PoS STAKE_LOG_FILE
+	tenantSyncFile = "sync.json"   // P2P SYNC_STATE_FILE
+)
+
+// tenant is one hosted chain.
+type tenant struct {
+	ID    string
+	Dir   string
+	node  *devnetNode
+	proxy *httputil.ReverseProxy
+}
+
+// TenantInfo describes a chain in GET /chains.
+type TenantInfo struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"` // prefix of the chain's routes on the host
+	Running bool   `json:"running"`
+}
+
+// createTenant makes the directory of a new chain and, for PoW and PoS,
+// writes its genesis.json with its own timestamp, so the chain keeps the
+// same genesis block across restarts. Existing chains are left alone.
+func createTenant(dir, kind string, g genesis.File) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if kind == "p2p" {
+		return nil
+	}
+	path := filepath.Join(dir, tenantGenesis)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	g.Timestamp = time.Now().Unix()
+	var err error
+	if g.Hashes, err = genesisHashes(g); err != nil {
+		return err
+	}
+	raw, _ := json.MarshalIndent(g, "", "  ")
+	return os.WriteFile(path, append(raw, '\n'), 0o644)
+}
+
+// listTenants returns the chain IDs found as directories under dir.
+func listTenants(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() && chainIDPattern.MatchString(e.Name()) {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// tenantEnv configures the node of a chain to use the files in its
+// directory.
+func tenantEnv(kind, dir string, port int) []string {
+	env := []string{fmt.Sprintf("PORT=%d", port), "PEERS="}
+	if _, err := os.Stat(filepath.Join(dir, tenantGenesis)); err == nil {
+		env = append(env, "GENESIS_FILE="+filepath.Join(dir, tenantGenesis))
+	}
+	if _, err := os.Stat(filepath.Join(dir, tenantChain)); err == nil {
+		env = append(env, "IMPORT_FILE="+filepath.Join(dir, tenantChain))
+	}
+	switch kind {
+	case "pos":
+		env = append(env, "STAKE_LOG_FILE="+filepath.Join(dir, tenantStakeLog))
+	case "p2p":
+		env = append(env, "SYNC_STATE_FILE="+filepath.Join(dir, tenantSyncFile))
+	}
+	return env
+}
+
+// saveTenant stores the chain of a running node in its directory,
+// replacing the previous copy only once the export is complete.
+func saveTenant(t *tenant) error {
+	resp, err := http.Get(t.node.URL + "/export")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, errorMessage(msg))
+	}
+	path := filepath.Join(t.Dir, tenantChain)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// writeHostError replies with the nodes' JSON error format.
+func writeHostError(w http.ResponseWriter, message string, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	_ = json.NewEncoder(w).Encode(map[string]string{"code": code, "message": message})
+}
+
+// chainsHandler lists the hosted chains on GET /chains and proxies
+// /chains/{id}/... to the node of chain id, without the prefix.
+func chainsHandler(tenants map[string]*tenant) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/chains")
+		if rest == "" || rest == "/" {
+			if r.Method != http.MethodGet {
+				writeHostError(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			list := make([]TenantInfo, 0, len(tenants))
+			for _, t := range tenants {
+				running := true
+				select {
+				case <-t.node.done:
+					running = false
+				default:
+				}
+				list = append(list, TenantInfo{ID: t.ID, Path: "/chains/" + t.ID, Running: running})
+			}
+			sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(list)
+			return
+		}
+
+		id, path := strings.TrimPrefix(rest, "/"), "/"
+		if i := strings.IndexByte(id, '/'); i >= 0 {
+			id, path = id[:i], id[i:]
+		}
+		t, ok := tenants[id]
+		if !ok {
+			writeHostError(w, fmt.Sprintf("no chain %q is hosted here", id), http.StatusNotFound)
+			return
+		}
+		r.URL.Path, r.URL.RawPath = path, ""
+		t.proxy.ServeHTTP(w, r)
+	}
+}
+
+// chainsCmd builds the chosen node program, starts one node per chain
+// directory under -dir (creating the chains named by -create first),
+// serves them all under /chains/{id}/ on -port, and saves every chain
+// to its directory periodically and before stopping.
+func chainsCmd(args []string) {
+	fs := flag.NewFlagSet("chains", flag.ExitOnError)
+	dir := fs.String("dir", "chains", "directory holding one subdirectory per chain")
+	create := fs.String("create", "", "chain IDs to create under -dir: id,...")
+	chainKind := fs.String("chain", "pow", "node type: pow, pos or p2p")
+	port := fs.Int("port", 8000, "port the chains are served on")
+	basePort := fs.Int("base-port", 9100, "internal port of the first chain's node; the others follow")
+	src := fs.String("src", ".", "repository root to build the nodes from")
+	alloc := fs.String("alloc", "", "genesis balances of created chains: address=amount[@cliff:end],...")
+	validators := fs.String("validators", "", "PoS genesis validators of created chains: name:stake[:pubkey],...")
+	saveEvery := fs.Duration("save-every", time.Minute, "how often each chain is saved to its directory (0 to save on shutdown only)")
+	_ = fs.Parse(args)
+
+	pkg, ok := nodeKinds[*chainKind]
+	if !ok {
+		log.Fatalf("-chain must be pow, pos or p2p")
+	}
+
+	var g genesis.File
+	var err error
+	if g.Allocations, err = parseAllocations(*alloc); err != nil {
+		log.Fatal(err)
+	}
+	if g.Validators, err = parseValidators(*validators); err != nil {
+		log.Fatal(err)
+	}
+	if err := g.Check(); err != nil {
+		log.Fatal(err)
+	}
+	for _, id := range strings.Split(*create, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if !chainIDPattern.MatchString(id) {
+			log.Fatalf("invalid chain ID %q: want 1-32 lowercase letters, digits, _ or -", id)
+		}
+		if err := createTenant(filepath.Join(*dir, id), *chainKind, g); err != nil {
+			log.Fatalf("create %s: %v", id, err)
+		}
+	}
+
+	root, err := filepath.Abs(*dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids, err := listTenants(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(ids) == 0 {
+		log.Fatalf("no chains in %s; name some with -create", root)
+	}
+
+	work, err := os.MkdirTemp("", "alimia-chains-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+
+	bin := filepath.Join(work, *chainKind)
+	log.Printf("🔨 Building %s ...", pkg)
+	build := exec.Command("go", "build", "-o", bin, "./"+pkg)
+	build.Dir = *src
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		log.Fatalf("build %s: %v", pkg, err)
+	}
+
+	tenants := make(map[string]*tenant, len(ids))
+	var running []*devnetNode
+	fail := func(format string, a ...interface{}) {
+		stopAll(running)
+		os.RemoveAll(work)
+		log.Fatalf(format, a...)
+	}
+	for i, id := range ids {
+		t := &tenant{ID: id, Dir: filepath.Join(root, id)}
+		nodePort := *basePort + i
+		cmd := exec.Command(bin)
+		cmd.Dir = t.Dir
+		cmd.Env = append(os.Environ(), tenantEnv(*chainKind, t.Dir, nodePort)...)
+		stdout, _ := cmd.StdoutPipe()
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Start(); err != nil {
+			fail("start %s: %v", id, err)
+		}
+		t.node = &devnetNode{Name: id, URL: fmt.Sprintf("http://localhost:%d", nodePort), cmd: cmd, done: make(chan struct{})}
+		go pipeOutput(id, stdout)
+		go func(n *devnetNode) { _ = n.cmd.Wait(); close(n.done) }(t.node)
+		running = append(running, t.node)
+
+		target, _ := url.Parse(t.node.URL)
+		t.proxy = httputil.NewSingleHostReverseProxy(target)
+		t.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			writeHostError(w, "chain "+id+" is not reachable", http.StatusBadGateway)
+		}
+		tenants[id] = t
+	}
+	for _, n := range running {
+		if err := waitReady(n.URL, 60*time.Second); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chains", chainsHandler(tenants))
+	mux.HandleFunc("/chains/", chainsHandler(tenants))
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", *port), Handler: mux}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.ListenAndServe() }()
+
+	outMu.Lock()
+	fmt.Printf("\n🏫 Hosting %d %s chain(s) on :%d\n", len(ids), *chainKind, *port)
+	for _, id := range ids {
+		fmt.Printf("   %-12s http://localhost:%d/chains/%s  (%s)\n", id, *port, id, tenants[id].Dir)
+	}
+	fmt.Printf("Press Ctrl-C to save the chains and stop.\n\n")
+	outMu.Unlock()
+
+	// saveAll stores every chain whose node is still running. Ctrl-C in a
+	// terminal also interrupts the nodes, so the periodic saves are what
+	// keeps a chain when that happens.
+	saveAll := func(quiet bool) {
+		for _, id := range ids {
+			t := tenants[id]
+			select {
+			case <-t.node.done:
+				continue
+			default:
+			}
+			if err := saveTenant(t); err != nil {
+				log.Printf("❌ save %s: %v", id, err)
+			} else if !quiet {
+				log.Printf("💾 Saved %s", filepath.Join(t.Dir, tenantChain))
+			}
+		}
+	}
+	if *saveEvery > 0 {
+		go func() {
+			for range time.Tick(*saveEvery) {
+				saveAll(true)
+			}
+		}()
+	}
+
+	// A chain whose node exits stays listed and answers 502; the others
+	// keep running.
+	for _, t := range tenants {
+		go func(t *tenant) {
+			<-t.node.done
+			log.Printf("⚠️  chain %s exited (%v)", t.ID, t.node.cmd.ProcessState)
+		}(t)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-sig:
+		log.Printf("🛑 Saving chains ...")
+	case err := <-serveErr:
+		log.Printf("⚠️  serve :%d: %v, stopping chains ...", *port, err)
+	}
+	_ = srv.Close()
+	saveAll(false)
+	stopAll(running)
+	log.Printf("✅ Chains stopped")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// protoField is a field of a message in a .proto schema.
+type protoField struct {
+	Type string
+	Num  int
+}
+
+// protoSchema maps message names to their fields by name.
+type protoSchema map[string]map[string]protoField
+
+// parseProto reads the messages of a schema as GET /proto writes it.
+func parseProto(r io.Reader) (protoSchema, error) {
+	schema := make(protoSchema)
+	var msg map[string]protoField
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(text, "message ") && strings.HasSuffix(text, "{"):
+			msg = make(map[string]protoField)
+			schema[strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "message "), "{"))] = msg
+		case text == "}":
+			msg = nil
+		case msg != nil && strings.HasSuffix(text, ";"):
+			decl, num, ok := strings.Cut(strings.TrimSuffix(text, ";"), "=")
+			decl = strings.TrimSpace(decl)
+			n, err := strconv.Atoi(strings.TrimSpace(num))
+			i := strings.LastIndex(decl, " ")
+			if !ok || err != nil || i < 0 {
+				return nil, fmt.Errorf("line %d: malformed field %q", line, text)
+			}
+			msg[decl[i+1:]] = protoField{Type: decl[:i], Num: n}
+		}
+	}
+	return schema, sc.Err()
+}
+
+// schemaChanges compares a node's schema with a snapshot. Clients keep
+// working when fields and messages are only added: removing or renaming
+// a field, changing its type or renumbering it, as reordering the
+// fields of a struct does, breaks them.
+func schemaChanges(old, cur protoSchema) (breaking, added []string) {
+	for _, name := range messageNames(old) {
+		msg, ok := cur[name]
+		if !ok {
+			breaking = append(breaking, fmt.Sprintf("message %s was removed", name))
+			continue
+		}
+		byNum := make(map[int]string, len(msg))
+		for field, f := range msg {
+			byNum[f.Num] = field
+		}
+		for _, field := range fieldNames(old[name]) {
+			was := old[name][field]
+			now, ok := msg[field]
+			switch {
+			case !ok:
+				breaking = append(breaking, fmt.Sprintf("%s.%s was removed", name, field))
+			case now.Type != was.Type:
+				breaking = append(breaking, fmt.Sprintf("%s.%s changed type from %s to %s", name, field, was.Type, now.Type))
+			case now.Num != was.Num:
+				breaking = append(breaking, fmt.Sprintf("%s.%s moved from field %d to %d", name, field, was.Num, now.Num))
+			}
+			if other := byNum[was.Num]; other != "" && other != field {
+				breaking = append(breaking, fmt.Sprintf("%s field %d is now %s instead of %s", name, was.Num, other, field))
+			}
+		}
+		for _, field := range fieldNames(msg) {
+			if _, ok := old[name][field]; !ok {
+				added = append(added, fmt.Sprintf("%s.%s (field %d)", name, field, msg[field].Num))
+			}
+		}
+	}
+	for _, name := range messageNames(cur) {
+		if _, ok := old[name]; !ok {
+			added = append(added, "message "+name)
+		}
+	}
+	return breaking, added
+}
+
+func messageNames(s protoSchema) []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func fieldNames(msg map[string]protoField) []string {
+	names := make([]string, 0, len(msg))
+	for name := range msg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// compatCmd checks a node's GET /proto against a snapshot, failing on
+// changes that break clients, or with -write records a new snapshot.
+func compatCmd(args []string) {
+	fs := flag.NewFlagSet("compat", flag.ExitOnError)
+	node := fs.String("node", "http://localhost:8080", "URL of the node")
+	snapshot := fs.String("snapshot", "", "schema snapshot to compare with, e.g. proto/pow.proto")
+	write := fs.Bool("write", false, "write the node's schema to -snapshot instead of checking it")
+	_ = fs.Parse(args)
+	if *snapshot == "" {
+		log.Fatal("-snapshot is required")
+	}
+
+	resp, err := http.Get(strings.TrimRight(*node, "/") + "/proto")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("❌ %s: %s", resp.Status, errorMessage(body))
+	}
+	cur, err := parseProto(bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("❌ %s/proto: %v", *node, err)
+	}
+
+	if *write {
+		if err := os.WriteFile(*snapshot, body, 0o644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("📸 Wrote %s (%d messages)", *snapshot, len(cur))
+		return
+	}
+
+	f, err := os.Open(*snapshot)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	old, err := parseProto(f)
+	if err != nil {
+		log.Fatalf("❌ %s: %v", *snapshot, err)
+	}
+	breaking, added := schemaChanges(old, cur)
+	for _, a := range added {
+		log.Printf("➕ %s", a)
+	}
+	for _, b := range breaking {
+		log.Printf("❌ %s", b)
+	}
+	if len(breaking) > 0 {
+		log.Printf("%d breaking change(s) against %s", len(breaking), *snapshot)
+		os.Exit(1)
+	}
+	log.Printf("✅ %s is compatible with %s", *node, *snapshot)
+}
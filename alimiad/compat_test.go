@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProtoSnapshots(t *testing.T) {
+	for _, name := range []string{"pow", "pos", "p2p"} {
+		f, err := os.Open("../proto/" + name + ".proto")
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := parseProto(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s.proto: %v", name, err)
+		}
+		if len(schema) == 0 {
+			t.Errorf("%s.proto has no messages", name)
+		}
+		if _, ok := schema["NodeInfo"]; !ok && name != "p2p" {
+			t.Errorf("%s.proto has no NodeInfo message", name)
+		}
+	}
+}
+
+func TestSchemaChanges(t *testing.T) {
+	parse := func(s string) protoSchema {
+		schema, err := parseProto(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return schema
+	}
+	old := parse(`message NodeInfo {
+  string name = 1;
+  int64 blocks = 2;
+}
+`)
+	cases := []struct {
+		cur             string
+		breaking, added []string
+	}{
+		{cur: "message NodeInfo {\n  string name = 1;\n  int64 blocks = 2;\n}\n"},
+		{
+			cur:   "message NodeInfo {\n  string name = 1;\n  int64 blocks = 2;\n  string lastHash = 3;\n}\nmessage Peer {\n}\n",
+			added: []string{"NodeInfo.lastHash (field 3)", "message Peer"},
+		},
+		{
+			cur:      "message NodeInfo {\n  int64 blocks = 1;\n  string name = 2;\n}\n",
+			breaking: []string{"NodeInfo.blocks moved from field 2 to 1", "NodeInfo field 2 is now name instead of blocks", "NodeInfo.name moved from field 1 to 2", "NodeInfo field 1 is now blocks instead of name"},
+		},
+		{
+			cur:      "message NodeInfo {\n  string name = 1;\n  string blocks = 2;\n}\n",
+			breaking: []string{"NodeInfo.blocks changed type from int64 to string"},
+		},
+		{
+			cur:      "message Chain {\n}\n",
+			breaking: []string{"message NodeInfo was removed"},
+			added:    []string{"message Chain"},
+		},
+	}
+	for i, c := range cases {
+		breaking, added := schemaChanges(old, parse(c.cur))
+		if !reflect.DeepEqual(breaking, c.breaking) || !reflect.DeepEqual(added, c.added) {
+			t.Errorf("case %d: got breaking %q added %q, want %q and %q", i, breaking, added, c.breaking, c.added)
+		}
+	}
+}
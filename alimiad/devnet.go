@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// nodeKinds maps -chain values to the package of the node program.
+var nodeKinds = map[string]string{
+	"pow": "proof-work",
+	"pos": "proof-stake",
+	"p2p": "p2p",
+}
+
+// devnetAccount is a generated key pair.
+type devnetAccount struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+	Key     string `json:"key"`
+}
+
+// devnetNode is one running node process.
+type devnetNode struct {
+	Name string
+	URL  string
+	cmd  *exec.Cmd
+	done chan struct{} // closed when the process exits
+}
+
+// outMu serialises the prefixed output of all nodes.
+var outMu sync.Mutex
+
+func newAccount(name string) devnetAccount {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("generate key: %v", err)
+	}
+	return devnetAccount{Name: name, Address: hex.EncodeToString(pub), Key: hex.EncodeToString(priv.Seed())}
+}
+
+// messagePrefix must match the nodes' message domain separator.
+const messagePrefix = "AlirezaChain Signed Message:\n"
+
+// registration builds the POST /validators/register request of a PoS
+// validator, signed with its key. Devnet validators announce no
+// metadata.
+func registration(v devnetAccount) map[string]interface{} {
+	seed, _ := hex.DecodeString(v.Key)
+	msg := fmt.Sprintf("register %q %q %q %d", v.Name, "", "", 0)
+	digest := sha256.Sum256([]byte(messagePrefix + msg))
+	return map[string]interface{}{
+		"validator": v.Name,
+		"pubKey":    v.Address,
+		"signature": hex.EncodeToString(ed25519.Sign(ed25519.NewKeyFromSeed(seed), digest[:])),
+	}
+}
+
+// pipeOutput copies r to stdout, prefixing every line with the node name.
+func pipeOutput(name string, r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		outMu.Lock()
+		fmt.Printf("[%s] %s\n", name, sc.Text())
+		outMu.Unlock()
+	}
+}
+
+// postJSON sends v to url and fails unless the node answers 2xx.
+func postJSON(url string, v interface{}) error {
+	body, _ := json.Marshal(v)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, errorMessage(msg))
+	}
+	return nil
+}
+
+// waitReady polls GET /info until the node answers.
+func waitReady(url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url + "/info")
+		if err == nil {
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return fmt.Errorf("%s did not become ready within %s", url, timeout)
+}
+
+// stopAll interrupts every node and kills those that do not exit in time.
+func stopAll(nodes []*devnetNode) {
+	for _, n := range nodes {
+		_ = n.cmd.Process.Signal(os.Interrupt)
+	}
+	deadline := time.After(5 * time.Second)
+	for _, n := range nodes {
+		select {
+		case <-n.done:
+		case <-deadline:
+			_ = n.cmd.Process.Kill()
+			<-n.done
+		}
+	}
+}
+
+// devnetCmd builds the chosen node program, starts N instances on
+// consecutive ports with every other node as a peer, funds accounts (PoW)
+// or stakes validators (PoS), and runs until interrupted.
+func devnetCmd(args []string) {
+	fs := flag.NewFlagSet("devnet", flag.ExitOnError)
+	nodes := fs.Int("nodes", 3, "number of nodes")
+	chainKind := fs.String("chain", "pow", "node type: pow, pos or p2p")
+	basePort := fs.Int("base-port", 7000, "port of the first node; the others follow")
+	src := fs.String("src", ".", "repository root to build the nodes from")
+	accounts := fs.Int("accounts", 3, "PoW: funded accounts to create")
+	fundBlocks := fs.Int("fund-blocks", 1, "PoW: blocks mined to each account on every node")
+	stake := fs.Uint64("stake", 100, "PoS: stake of each pre-staked validator")
+	_ = fs.Parse(args)
+
+	pkg, ok := nodeKinds[*chainKind]
+	if !ok {
+		log.Fatalf("-chain must be pow, pos or p2p")
+	}
+	if *nodes < 1 {
+		log.Fatal("-nodes must be at least 1")
+	}
+
+	work, err := os.MkdirTemp("", "alimia-devnet-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+
+	bin := filepath.Join(work, *chainKind)
+	log.Printf("🔨 Building %s ...", pkg)
+	build := exec.Command("go", "build", "-o", bin, "./"+pkg)
+	build.Dir = *src
+	build.Stdout, build.Stderr = os.Stderr, os.Stderr
+	if err := build.Run(); err != nil {
+		log.Fatalf("build %s: %v", pkg, err)
+	}
+
+	urls := make([]string, *nodes)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://localhost:%d", *basePort+i)
+	}
+
+	// PoS validators: one per node, every node holds every key so that
+	// any of them can forge for whichever validator is selected.
+	var validators []devnetAccount
+	var validatorKeys []string
+	if *chainKind == "pos" {
+		for i := 0; i < *nodes; i++ {
+			v := newAccount(fmt.Sprintf("validator%d", i))
+			validators = append(validators, v)
+			validatorKeys = append(validatorKeys, v.Name+":"+v.Key)
+		}
+	}
+
+	var running []*devnetNode
+	for i, url := range urls {
+		name := fmt.Sprintf("%s-%d", *chainKind, i)
+		dir := filepath.Join(work, name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Fatal(err)
+		}
+
+		var peers []string
+		for j, u := range urls {
+			if j != i {
+				peers = append(peers, u)
+			}
+		}
+		env := []string{
+			fmt.Sprintf("PORT=%d", *basePort+i),
+			"PEERS=" + strings.Join(peers, ","),
+		}
+		switch *chainKind {
+		case "pow":
+			env = append(env, "NODE_URL="+url, "DIFFICULTY=10", "COINBASE_MATURITY=1")
+		case "pos":
+			env = append(env, "VALIDATOR_KEYS="+strings.Join(validatorKeys, ","))
+		}
+
+		cmd := exec.Command(bin)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		stdout, _ := cmd.StdoutPipe()
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Start(); err != nil {
+			stopAll(running)
+			os.RemoveAll(work)
+			log.Fatalf("start %s: %v", name, err)
+		}
+		n := &devnetNode{Name: name, URL: url, cmd: cmd, done: make(chan struct{})}
+		go pipeOutput(name, stdout)
+		go func() { _ = n.cmd.Wait(); close(n.done) }()
+		running = append(running, n)
+	}
+
+	fail := func(format string, a ...interface{}) {
+		stopAll(running)
+		os.RemoveAll(work)
+		log.Fatalf(format, a...)
+	}
+	for _, n := range running {
+		if err := waitReady(n.URL, 60*time.Second); err != nil {
+			fail("%v", err)
+		}
+	}
+
+	var funded []devnetAccount
+	switch *chainKind {
+	case "pow":
+		for i := 0; i < *accounts; i++ {
+			funded = append(funded, newAccount(fmt.Sprintf("account%d", i)))
+		}
+		for _, n := range running {
+			for _, a := range funded {
+				for b := 0; b < *fundBlocks; b++ {
+					if err := postJSON(n.URL+"/mine", map[string]string{"data": "devnet funding", "miner": a.Address}); err != nil {
+						fail("fund %s on %s: %v", a.Name, n.Name, err)
+					}
+				}
+			}
+		}
+	case "pos":
+		for _, n := range running {
+			for _, v := range validators {
+				if err := postJSON(n.URL+"/validators/register", registration(v)); err != nil {
+					fail("register %s on %s: %v", v.Name, n.Name, err)
+				}
+				payload := map[string]interface{}{"validator": v.Name, "amount": *stake}
+				if err := postJSON(n.URL+"/stake", payload); err != nil {
+					fail("stake %s on %s: %v", v.Name, n.Name, err)
+				}
+			}
+		}
+	}
+
+	outMu.Lock()
+	fmt.Printf("\n🚀 Devnet up: %d %s node(s)\n", len(running), *chainKind)
+	for _, n := range running {
+		fmt.Printf("   %-8s %s\n", n.Name, n.URL)
+	}
+	for _, a := range funded {
+		fmt.Printf("   💰 %-10s address=%s key=%s\n", a.Name, a.Address, a.Key)
+	}
+	for _, v := range validators {
+		fmt.Printf("   🪙 %-10s stake=%d key=%s\n", v.Name, *stake, v.Key)
+	}
+	fmt.Printf("Press Ctrl-C to stop.\n\n")
+	outMu.Unlock()
+
+	// Run until interrupted or until a node dies.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	exited := make(chan string, len(running))
+	for _, n := range running {
+		go func(n *devnetNode) {
+			<-n.done
+			exited <- fmt.Sprintf("%s exited (%v)", n.Name, n.cmd.ProcessState)
+		}(n)
+	}
+	select {
+	case <-sig:
+		log.Printf("🛑 Stopping devnet ...")
+	case msg := <-exited:
+		log.Printf("⚠️  %s, stopping devnet ...", msg)
+	}
+	stopAll(running)
+	log.Printf("✅ Devnet stopped")
+}
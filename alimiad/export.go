@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// exportCmd streams a node's GET /export to a file for offline analysis
+// or for seeding another node with -import.
+func exportCmd(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	node := fs.String("node", "http://localhost:8080", "URL of the node to export")
+	format := fs.String("format", "jsonl", "jsonl or csv")
+	rows := fs.String("rows", "blocks", "blocks, or tx for one row per transaction (PoW only)")
+	out := fs.String("out", "-", "file to write (- for stdout)")
+	_ = fs.Parse(args)
+
+	q := url.Values{"format": {*format}, "rows": {*rows}}
+	resp, err := http.Get(strings.TrimRight(*node, "/") + "/export?" + q.Encode())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("❌ %s: %s", resp.Status, errorMessage(msg))
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *out != "-" {
+		log.Printf("📤 Wrote %s (%s)", *out, byteCount(n))
+	}
+}
+
+// byteCount formats a size for log output.
+func byteCount(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// errorMessage extracts the message of a node's JSON error response,
+// falling back to the raw body.
+func errorMessage(body []byte) string {
+	var e struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Message != "" {
+		return e.Message
+	}
+	return strings.TrimSpace(string(body))
+}
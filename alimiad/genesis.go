@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"alirezachain/chainhash"
+	"alirezachain/genesis"
+)
+
+// genesisHashes returns the genesis hash of each chain started from g.
+func genesisHashes(g genesis.File) (map[string]string, error) {
+	pow, err := g.PowHash()
+	if err != nil {
+		return nil, err
+	}
+	pos, err := g.PosHash()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"pow": pow, "pos": pos}, nil
+}
+
+// parseAllocations parses "address=amount[@cliff:end],...", where cliff
+// and end are the heights of a vesting schedule.
+func parseAllocations(s string) ([]genesis.Allocation, error) {
+	var out []genesis.Allocation
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		bad := fmt.Errorf("invalid allocation %q (want address=amount[@cliff:end])", entry)
+		addr, amount, ok := strings.Cut(entry, "=")
+		amount, vesting, vests := strings.Cut(amount, "@")
+		n, err := strconv.ParseUint(amount, 10, 64)
+		if !ok || err != nil {
+			return nil, bad
+		}
+		a := genesis.Allocation{Address: strings.TrimSpace(addr), Amount: n}
+		if vests {
+			cliff, end, ok := strings.Cut(vesting, ":")
+			c, err1 := strconv.Atoi(cliff)
+			e, err2 := strconv.Atoi(end)
+			if !ok || err1 != nil || err2 != nil {
+				return nil, bad
+			}
+			a.Vesting = &genesis.Vesting{CliffHeight: c, EndHeight: e}
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// parseValidators parses "name:stake[:pubkey],...".
+func parseValidators(s string) ([]genesis.Validator, error) {
+	var out []genesis.Validator
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid validator %q (want name:stake[:pubkey])", entry)
+		}
+		n, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid stake in %q", entry)
+		}
+		v := genesis.Validator{Name: parts[0], Stake: n}
+		if len(parts) == 3 {
+			v.PubKey = parts[2]
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// nodeGenesisHash fetches the hash of a running node's genesis block.
+func nodeGenesisHash(url string) (string, error) {
+	resp, err := http.Get(strings.TrimRight(url, "/") + "/chain")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var blocks []struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&blocks); err != nil || len(blocks) == 0 {
+		return "", errors.New("unexpected /chain response")
+	}
+	return blocks[0].Hash, nil
+}
+
+// genesisCmd writes a genesis.json, or with -verify checks an existing one
+// and, given -nodes, that every node started from it.
+func genesisCmd(args []string) {
+	fs := flag.NewFlagSet("genesis", flag.ExitOnError)
+	alloc := fs.String("alloc", "", "initial balances: address=amount[@cliff:end],... (vesting by height, PoW only)")
+	validators := fs.String("validators", "", "PoS validators: name:stake[:pubkey],...")
+	timestamp := fs.Int64("timestamp", 0, "genesis unix timestamp (default now)")
+	hashAlg := fs.String("hash", chainhash.SHA256, "block hash algorithm: "+strings.Join(chainhash.Names(), ", "))
+	chainID := fs.String("chain-id", "", "network name covered by transaction signatures, e.g. alimia-testnet")
+	halving := fs.Int("halving-interval", 0, "PoW: halve the block reward every N blocks (default: the node's EMISSION_CURVE)")
+	reward := fs.Uint64("block-reward", 0, "PoW: reward before the first halving (with -halving-interval; default BLOCK_REWARD)")
+	out := fs.String("out", "genesis.json", "file to write (- for stdout)")
+	verify := fs.String("verify", "", "genesis.json to check instead of writing one")
+	nodes := fs.String("nodes", "", "with -verify: node URLs whose genesis block must match")
+	_ = fs.Parse(args)
+
+	if *verify != "" {
+		verifyGenesis(*verify, *nodes)
+		return
+	}
+
+	var g genesis.File
+	var err error
+	if g.Allocations, err = parseAllocations(*alloc); err != nil {
+		log.Fatal(err)
+	}
+	if g.Validators, err = parseValidators(*validators); err != nil {
+		log.Fatal(err)
+	}
+	if *hashAlg != chainhash.SHA256 {
+		g.HashAlgorithm = *hashAlg
+	}
+	g.ChainID = *chainID
+	g.HalvingInterval = *halving
+	g.BlockReward = *reward
+	g.Timestamp = *timestamp
+	if g.Timestamp == 0 {
+		g.Timestamp = time.Now().Unix()
+	}
+	if err := g.Check(); err != nil {
+		log.Fatal(err)
+	}
+	if g.Hashes, err = genesisHashes(g); err != nil {
+		log.Fatal(err)
+	}
+
+	raw, _ := json.MarshalIndent(g, "", "  ")
+	raw = append(raw, '\n')
+	if *out == "-" {
+		_, _ = os.Stdout.Write(raw)
+		return
+	}
+	if err := os.WriteFile(*out, raw, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("🌱 Wrote %s", *out)
+	log.Printf("   pow genesis %s", g.Hashes["pow"])
+	log.Printf("   pos genesis %s", g.Hashes["pos"])
+}
+
+func verifyGenesis(path, nodes string) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var g genesis.File
+	if err := json.Unmarshal(raw, &g); err != nil {
+		log.Fatalf("decode %s: %v", path, err)
+	}
+	if err := g.Check(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	want, err := genesisHashes(g)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	ok := true
+	for _, chain := range []string{"pow", "pos"} {
+		if stored := g.Hashes[chain]; stored != "" && stored != want[chain] {
+			log.Printf("❌ %s: file says %s, computed %s", chain, stored, want[chain])
+			ok = false
+		} else {
+			log.Printf("✅ %s genesis %s", chain, want[chain])
+		}
+	}
+
+	for _, url := range strings.Split(nodes, ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		hash, err := nodeGenesisHash(url)
+		switch {
+		case err != nil:
+			log.Printf("❌ %s: %v", url, err)
+			ok = false
+		case hash == want["pow"]:
+			log.Printf("✅ %s matches the pow genesis", url)
+		case hash == want["pos"]:
+			log.Printf("✅ %s matches the pos genesis", url)
+		default:
+			log.Printf("❌ %s has genesis %s", url, hash)
+			ok = false
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// signedResponsePrefix must match p2p/identity.go.
+const signedResponsePrefix = "AlirezaChain node response:\n"
+
+// identifyCmd asks a P2P node for GET /info with a fresh challenge and
+// checks that the answer is signed by the key it names, or by -key.
+func identifyCmd(args []string) {
+	fs := flag.NewFlagSet("identify", flag.ExitOnError)
+	node := fs.String("node", "http://localhost:8090", "URL of the P2P node")
+	key := fs.String("key", "", "hex public key the node must hold (default: accept the key it names)")
+	_ = fs.Parse(args)
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Fatal(err)
+	}
+	challenge := hex.EncodeToString(nonce)
+	req, _ := http.NewRequest(http.MethodGet, strings.TrimRight(*node, "/")+"/info", nil)
+	req.Header.Set("X-Node-Challenge", challenge)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("❌ %s: %s", resp.Status, errorMessage(body))
+	}
+
+	named := strings.ToLower(resp.Header.Get("X-Node-Key"))
+	if named == "" {
+		log.Fatalf("❌ %s does not sign its responses", *node)
+	}
+	if *key != "" && named != strings.ToLower(*key) {
+		log.Fatalf("❌ node key is %s, want %s", named, *key)
+	}
+	pub, err := hex.DecodeString(named)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		log.Fatalf("❌ invalid node key %q", named)
+	}
+	sig, err := hex.DecodeString(resp.Header.Get("X-Node-Signature"))
+	msg := append([]byte(signedResponsePrefix+challenge+"\n"), body...)
+	if err != nil || !ed25519.Verify(pub, msg, sig) {
+		log.Fatalf("❌ the signature does not match key %s", named)
+	}
+	log.Printf("✅ %s is signed by %s for this request", *node, named)
+	_, _ = os.Stdout.Write(body)
+}
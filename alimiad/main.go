@@ -0,0 +1,76 @@
+// ------------------------------------------------------------
+// 🧰 alimiad
+// Description: Developer tooling for AlirezaChain. `alimiad devnet`
+//              launches a local multi-node network with pre-wired
+//              peers, funded accounts and staked validators;
+//              `alimiad chains` hosts independent chains under
+//              /chains/{id}/ on one port;
+//              `alimiad genesis` writes and verifies genesis files;
+//              `alimiad export` saves a node's chain as JSONL or CSV;
+//              `alimiad verify` re-checks block hashes and links;
+//              `alimiad rebuild-state` replays a chain to audit state;
+//              `alimiad access-key`, `encrypt` and `decrypt` handle
+//              encrypted P2P payloads; `alimiad identify` checks a
+//              P2P node's signed identity; `alimiad compat` checks
+//              response schemas for breaking changes.
+// ------------------------------------------------------------
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: alimiad <command> [flags]
+
+commands:
+  devnet   run a local network of N nodes until interrupted
+  chains   host several independent chains, one per directory, under /chains/{id}/
+  genesis  write or verify a genesis.json shared by PoW and PoS nodes
+  export   save a node's chain as JSONL or CSV
+  verify   re-check the hashes and links of a chain, optionally repairing a file
+  rebuild-state  replay a node's chain from genesis and compare with its stored state
+  access-key  generate a key pair that P2P payloads can be encrypted to
+  encrypt  encrypt a file to an access key
+  decrypt  decrypt a block's payload with a private access key
+  identify  check that a P2P node's /info is signed by its identity key
+  compat   check a node's response schemas against a snapshot for breaking changes`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "devnet":
+		devnetCmd(os.Args[2:])
+	case "chains":
+		chainsCmd(os.Args[2:])
+	case "genesis":
+		genesisCmd(os.Args[2:])
+	case "export":
+		exportCmd(os.Args[2:])
+	case "verify":
+		verifyCmd(os.Args[2:])
+	case "rebuild-state":
+		rebuildStateCmd(os.Args[2:])
+	case "access-key":
+		accessKeyCmd(os.Args[2:])
+	case "encrypt":
+		encryptCmd(os.Args[2:])
+	case "decrypt":
+		decryptCmd(os.Args[2:])
+	case "identify":
+		identifyCmd(os.Args[2:])
+	case "compat":
+		compatCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// stateReport mirrors StateReport of the nodes' /state/rebuild.
+type stateReport struct {
+	OK          bool   `json:"ok"`
+	Blocks      int    `json:"blocks"`
+	StakeEvents int    `json:"stakeEvents"`
+	Accounts    int    `json:"accounts"`
+	StateRoot   string `json:"stateRoot"`
+	Divergences int    `json:"divergences"`
+	Diffs       []struct {
+		Height   int    `json:"height"`
+		Account  string `json:"account"`
+		Field    string `json:"field"`
+		Stored   string `json:"stored"`
+		Replayed string `json:"replayed"`
+	} `json:"diffs"`
+}
+
+// rebuildStateCmd has a PoW or PoS node replay its chain from genesis,
+// recomputing balances, nonces and stakes, and prints every place where
+// the result differs from the node's stored state.
+func rebuildStateCmd(args []string) {
+	fs := flag.NewFlagSet("rebuild-state", flag.ExitOnError)
+	node := fs.String("node", "http://localhost:8080", "URL of a PoW or PoS node")
+	_ = fs.Parse(args)
+
+	resp, err := http.Get(strings.TrimRight(*node, "/") + "/state/rebuild")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("❌ %s: %s", resp.Status, errorMessage(msg))
+	}
+	var rep stateReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		log.Fatalf("❌ invalid report: %v", err)
+	}
+
+	replayed := fmt.Sprintf("%d blocks", rep.Blocks)
+	if rep.StakeEvents > 0 {
+		replayed += fmt.Sprintf(" and %d stake events", rep.StakeEvents)
+	}
+	log.Printf("🔁 Replayed %s: %d accounts, state root %s", replayed, rep.Accounts, rep.StateRoot)
+	if rep.OK {
+		log.Printf("✅ Stored state matches the replay")
+		return
+	}
+	for _, d := range rep.Diffs {
+		field := d.Field
+		if d.Account != "" {
+			field = d.Account + " " + field
+		}
+		log.Printf("❌ height %d: %s stored=%s replayed=%s", d.Height, field, d.Stored, d.Replayed)
+	}
+	if rep.Divergences > len(rep.Diffs) {
+		log.Printf("… and %d more", rep.Divergences-len(rep.Diffs))
+	}
+	os.Exit(1)
+}
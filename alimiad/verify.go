@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// chainReport mirrors ChainReport of the nodes' /chain/verify.
+type chainReport struct {
+	OK            bool   `json:"ok"`
+	Blocks        int    `json:"blocks"`
+	Valid         int    `json:"valid"`
+	CorruptHeight *int   `json:"corruptHeight,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// verifyCmd re-checks every block hash and link of a chain: a node's own
+// chain, or with -file a JSON Lines export, checked by the node so that
+// its hash algorithm and fork heights apply. With -repair a corrupt file
+// is truncated to the blocks before the first corrupt one.
+func verifyCmd(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	node := fs.String("node", "http://localhost:8080", "URL of the node whose rules apply")
+	file := fs.String("file", "", "JSONL chain from GET /export to check instead of the node's own chain")
+	repair := fs.Bool("repair", false, "truncate a corrupt -file to its last valid block")
+	_ = fs.Parse(args)
+
+	endpoint := strings.TrimRight(*node, "/") + "/chain/verify"
+	var resp *http.Response
+	var err error
+	if *file == "" {
+		resp, err = http.Get(endpoint)
+	} else {
+		f, ferr := os.Open(*file)
+		if ferr != nil {
+			log.Fatal(ferr)
+		}
+		resp, err = http.Post(endpoint, "application/x-ndjson", f)
+		f.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		log.Fatalf("❌ %s: %s", resp.Status, errorMessage(msg))
+	}
+	var rep chainReport
+	if err := json.NewDecoder(resp.Body).Decode(&rep); err != nil {
+		log.Fatalf("❌ invalid report: %v", err)
+	}
+
+	if rep.OK {
+		log.Printf("✅ %d blocks verified", rep.Valid)
+		return
+	}
+	log.Printf("❌ %s (%d of %d blocks valid)", rep.Error, rep.Valid, rep.Blocks)
+	if *file == "" || !*repair {
+		os.Exit(1)
+	}
+	if rep.Valid == 0 {
+		log.Fatalf("❌ the genesis block is corrupt; nothing to keep")
+	}
+	if err := truncateLines(*file, rep.Valid); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("🩹 Truncated %s to %d blocks", *file, rep.Valid)
+}
+
+// truncateLines keeps the first n lines of a file.
+func truncateLines(path string, n int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var end int64
+	r := bufio.NewReader(f)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadBytes('\n')
+		end += int64(len(line))
+		if err != nil {
+			break
+		}
+	}
+	return os.Truncate(path, end)
+}
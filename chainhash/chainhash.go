@@ -0,0 +1,65 @@
+// Package chainhash abstracts the hash function a chain uses for its
+// block hashes, so that a chain can be configured with SHA-256, BLAKE2b
+// or Keccak-256. The algorithm is recorded in the genesis file.
+package chainhash
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Algorithm names, as written in genesis files.
+const (
+	SHA256    = "sha256"
+	BLAKE2b   = "blake2b"   // BLAKE2b-256 (RFC 7693)
+	Keccak256 = "keccak256" // original Keccak padding, as used by Ethereum
+)
+
+// Hasher hashes block records.
+type Hasher interface {
+	Name() string
+	Size() int // digest length in bytes
+	Sum(data []byte) []byte
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) Name() string { return SHA256 }
+func (sha256Hasher) Size() int    { return sha256.Size }
+func (sha256Hasher) Sum(data []byte) []byte {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+type blake2bHasher struct{}
+
+func (blake2bHasher) Name() string           { return BLAKE2b }
+func (blake2bHasher) Size() int              { return 32 }
+func (blake2bHasher) Sum(data []byte) []byte { return blake2b256(data) }
+
+type keccakHasher struct{}
+
+func (keccakHasher) Name() string           { return Keccak256 }
+func (keccakHasher) Size() int              { return 32 }
+func (keccakHasher) Sum(data []byte) []byte { return keccak256(data) }
+
+// Default is the hasher of chains that do not choose one.
+var Default Hasher = sha256Hasher{}
+
+// Names lists the supported algorithms.
+func Names() []string {
+	return []string{SHA256, BLAKE2b, Keccak256}
+}
+
+// New returns the hasher for an algorithm name; "" selects Default.
+func New(name string) (Hasher, error) {
+	switch name {
+	case "", SHA256:
+		return sha256Hasher{}, nil
+	case BLAKE2b:
+		return blake2bHasher{}, nil
+	case Keccak256:
+		return keccakHasher{}, nil
+	}
+	return nil, fmt.Errorf("unknown hash algorithm %q (supported: %v)", name, Names())
+}
@@ -0,0 +1,21 @@
+// Package clock abstracts the nodes' time source so that tests and
+// simulations can control timestamps and timers. sim.Clock implements
+// Clock with simulated time.
+package clock
+
+import "time"
+
+// Clock tells the time and runs delayed work.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func())
+}
+
+// Real is the wall clock.
+type Real struct{}
+
+// Now returns the current wall-clock time.
+func (Real) Now() time.Time { return time.Now() }
+
+// AfterFunc runs f in its own goroutine after d.
+func (Real) AfterFunc(d time.Duration, f func()) { time.AfterFunc(d, f) }
@@ -0,0 +1,155 @@
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Field casings. Responses use camelCase keys; ?casing=snake asks for
+// snake_case instead. Only the names of struct fields change: keys of
+// maps, such as addresses or names, are data and are kept as they are.
+const (
+	CamelCase = "camel"
+	SnakeCase = "snake"
+)
+
+// ErrUnknownCasing is returned by Write for a ?casing= other than camel
+// or snake; nothing has been written then.
+var ErrUnknownCasing = errors.New("casing must be " + CamelCase + " or " + SnakeCase)
+
+// requestCasing returns the casing r asks for.
+func requestCasing(v string) (string, error) {
+	switch v {
+	case "", CamelCase:
+		return CamelCase, nil
+	case SnakeCase:
+		return SnakeCase, nil
+	}
+	return "", ErrUnknownCasing
+}
+
+// snakeCase turns a camelCase key into snake_case, keeping acronyms
+// together: "lastHash" is "last_hash", "nodeURL" is "node_url".
+func snakeCase(key string) string {
+	runes := []rune(key)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			acronymEnd := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || acronymEnd {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// object is a JSON object whose members keep their order.
+type object []member
+
+type member struct {
+	key   string
+	value interface{}
+}
+
+func (o object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// snakeKeys returns v with the same JSON encoding, but for struct field
+// names in snake_case.
+func snakeKeys(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if t := v.Type(); t.Implements(jsonMarshaler) || t.Implements(textMarshaler) {
+		return v.Interface()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return snakeKeys(v.Elem())
+	case reflect.Struct:
+		fields := protoFields(v.Type())
+		o := make(object, 0, len(fields))
+		for _, f := range fields {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && emptyValue(fv) {
+				continue
+			}
+			o = append(o, member{snakeCase(f.name), snakeKeys(fv)})
+		}
+		return o
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = snakeKeys(iter.Value())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 || (v.Kind() == reflect.Slice && v.IsNil()) {
+			return v.Interface()
+		}
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = snakeKeys(v.Index(i))
+		}
+		return items
+	}
+	return v.Interface()
+}
+
+// emptyValue reports whether omitempty leaves v out, as encoding/json
+// decides it.
+func emptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for key, want := range map[string]string{
+		"name":          "name",
+		"lastHash":      "last_hash",
+		"nodeURL":       "node_url",
+		"URLPrefix":     "url_prefix",
+		"sha256Root":    "sha256_root",
+		"minVersion":    "min_version",
+		"hashAlgorithm": "hash_algorithm",
+	} {
+		if got := snakeCase(key); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+type testInfo struct {
+	Name     string            `json:"name"`
+	LastHash string            `json:"lastHash"`
+	Peers    map[string]string `json:"peers"`
+	Note     string            `json:"note,omitempty"`
+}
+
+func TestWriteSnakeCasing(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/info?casing=snake", nil)
+	info := testInfo{Name: "n", LastHash: "h", Peers: map[string]string{"nodeA": "x"}}
+	if err := Write(rec, r, "NodeInfo", info); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"name":      "n",
+		"last_hash": "h",
+		"peers":     map[string]interface{}{"nodeA": "x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/info?casing=kebab", nil)
+	if err := Write(rec, r, "NodeInfo", info); err != ErrUnknownCasing {
+		t.Errorf("casing=kebab: got %v, want ErrUnknownCasing", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	got := Schema(map[string]interface{}{"NodeInfo": testInfo{}})
+	want := `syntax = "proto3";
+
+package alirezachain;
+
+message NodeInfo {
+  string name = 1;
+  string lastHash = 2;
+  map<string, string> peers = 3;
+  string note = 4;
+}
+`
+	if got != want {
+		t.Errorf("Schema:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,112 @@
+// Package codec writes API responses in the format the client asks for
+// with its Accept header: JSON (the default), CBOR (RFC 8949) or
+// Protocol Buffers (proto3 wire format, see Schema). JSON is compact
+// unless the request has ?pretty=true, and JSON and CBOR keys are
+// camelCase unless it has ?casing=snake.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types.
+const (
+	JSON     = "application/json"
+	CBOR     = "application/cbor"
+	Protobuf = "application/x-protobuf"
+)
+
+// ErrNotAcceptable is returned by Write when the Accept header allows
+// none of the formats; nothing has been written then.
+var ErrNotAcceptable = errors.New("responses are available as " + JSON + ", " + CBOR + " or " + Protobuf)
+
+// Negotiate picks the format accept prefers, JSON on a tie or when
+// accept is empty, and false if it allows none.
+func Negotiate(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return JSON, true
+	}
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var picks []candidate
+	for _, part := range strings.Split(accept, ",") {
+		media, params, _ := strings.Cut(part, ";")
+		media = strings.ToLower(strings.TrimSpace(media))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if k, v, ok := strings.Cut(strings.TrimSpace(p), "="); ok && strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		switch media {
+		case JSON, "*/*", "application/*":
+			picks = append(picks, candidate{JSON, q})
+		case CBOR, Protobuf:
+			picks = append(picks, candidate{media, q})
+		case "application/protobuf", "application/vnd.google.protobuf":
+			picks = append(picks, candidate{Protobuf, q})
+		}
+	}
+	if len(picks) == 0 {
+		return "", false
+	}
+	sort.SliceStable(picks, func(i, j int) bool {
+		if picks[i].q != picks[j].q {
+			return picks[i].q > picks[j].q
+		}
+		return picks[i].format == JSON && picks[j].format != JSON
+	})
+	return picks[0].format, true
+}
+
+// Write encodes v in the format r negotiates, with status 200. name
+// names v's message in the protobuf schema (see Schema).
+func Write(w http.ResponseWriter, r *http.Request, name string, v interface{}) error {
+	format, ok := Negotiate(r.Header.Get("Accept"))
+	w.Header().Add("Vary", "Accept")
+	if !ok {
+		return ErrNotAcceptable
+	}
+	casing, err := requestCasing(r.URL.Query().Get("casing"))
+	if err != nil {
+		return err
+	}
+	if casing == SnakeCase && format != Protobuf {
+		v = snakeKeys(reflect.ValueOf(v))
+	}
+
+	var body []byte
+	switch format {
+	case CBOR:
+		body, err = MarshalCBOR(v)
+	case Protobuf:
+		body, err = MarshalProto(v)
+		w.Header().Set("X-Protobuf-Message", name)
+	default:
+		if r.URL.Query().Get("pretty") == "true" {
+			body, err = json.MarshalIndent(v, "", "  ")
+		} else {
+			body, err = json.Marshal(v)
+		}
+		body = append(body, '\n')
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", format)
+	_, err = w.Write(body)
+	return err
+}
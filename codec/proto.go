@@ -0,0 +1,322 @@
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Protocol Buffers mapping. A struct is a message whose fields are the
+// ones JSON encodes, embedded structs flattened, numbered from 1 in
+// declaration order and named by their JSON keys. Slices are repeated
+// fields, maps are map fields and pointers to structs are nested
+// messages. A top-level slice is wrapped in a message with the items in
+// field 1. Values with no protobuf counterpart, such as interface
+// values, are carried as their JSON encoding in a bytes field. Schema
+// writes the .proto file matching this mapping.
+
+// Wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type protoField struct {
+	num       int
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// protoFieldCache holds the fields of each struct type.
+var protoFieldCache sync.Map
+
+// protoFields lists the fields of struct type t as JSON sees them.
+func protoFields(t reflect.Type) []protoField {
+	if cached, ok := protoFieldCache.Load(t); ok {
+		return cached.([]protoField)
+	}
+	var fields []protoField
+	var walk func(t reflect.Type, prefix []int)
+	walk = func(t reflect.Type, prefix []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			name, opts, _ := strings.Cut(tag, ",")
+			if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+				continue
+			}
+			index := append(append([]int(nil), prefix...), i)
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type, index)
+				continue
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			fields = append(fields, protoField{
+				num:       len(fields) + 1,
+				name:      name,
+				index:     index,
+				omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+			})
+		}
+	}
+	walk(t, nil)
+	protoFieldCache.Store(t, fields)
+	return fields
+}
+
+// MarshalProto encodes v in the protobuf wire format (see Schema).
+func MarshalProto(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Struct:
+		return appendMessage(nil, rv)
+	case reflect.Slice, reflect.Array:
+		var buf []byte
+		var err error
+		for i := 0; i < rv.Len(); i++ {
+			if buf, err = appendProtoValue(buf, 1, rv.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("protobuf: cannot encode %s as a message", rv.Type())
+}
+
+func appendMessage(buf []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	for _, f := range protoFields(v.Type()) {
+		if buf, err = appendProtoField(buf, f.num, v.FieldByIndex(f.index)); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// appendProtoField encodes a field, leaving out zero values as proto3
+// does.
+func appendProtoField(buf []byte, num int, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return buf, nil
+		}
+		if v.Kind() == reflect.Ptr {
+			return appendProtoField(buf, num, v.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 || isNested(v.Type().Elem()) {
+			if v.Len() == 0 {
+				return buf, nil
+			}
+			break
+		}
+		var err error
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = appendProtoValue(buf, num, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			entry, err := appendProtoValue(nil, 1, k)
+			if err != nil {
+				return nil, err
+			}
+			if entry, err = appendProtoValue(entry, 2, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+			buf = appendTag(buf, num, wireBytes)
+			buf = appendVarint(buf, uint64(len(entry)))
+			buf = append(buf, entry...)
+		}
+		return buf, nil
+	}
+	if v.IsZero() {
+		return buf, nil
+	}
+	return appendProtoValue(buf, num, v)
+}
+
+// isNested reports whether a slice of t cannot be a repeated field, for
+// it would be a repeated field of repeated fields.
+func isNested(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Map
+}
+
+// appendProtoValue encodes one value, zero or not: a scalar, a repeated
+// field's element or a map entry's key or value.
+func appendProtoValue(buf []byte, num int, v reflect.Value) ([]byte, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		buf = appendTag(buf, num, wireVarint)
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendVarint(appendTag(buf, num, wireVarint), uint64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return appendVarint(appendTag(buf, num, wireVarint), v.Uint()), nil
+	case reflect.Float64:
+		return appendFixed(appendTag(buf, num, wireFixed64), math.Float64bits(v.Float()), 8), nil
+	case reflect.Float32:
+		return appendFixed(appendTag(buf, num, wireFixed32), uint64(math.Float32bits(float32(v.Float()))), 4), nil
+	case reflect.String:
+		return appendBytes(buf, num, []byte(v.String())), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendBytes(buf, num, v.Bytes()), nil
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return appendBytes(buf, num, nil), nil
+		}
+		return appendProtoValue(buf, num, v.Elem())
+	case reflect.Struct:
+		msg, err := appendMessage(nil, v)
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(buf, num, msg), nil
+	}
+	raw, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return appendBytes(buf, num, raw), nil
+}
+
+func appendVarint(buf []byte, n uint64) []byte {
+	for n >= 0x80 {
+		buf = append(buf, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(buf, byte(n))
+}
+
+// appendFixed appends the low size bytes of n, little-endian.
+func appendFixed(buf []byte, n uint64, size int) []byte {
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(n>>(8*i)))
+	}
+	return buf
+}
+
+func appendTag(buf []byte, num, wire int) []byte {
+	return appendVarint(buf, uint64(num)<<3|uint64(wire))
+}
+
+func appendBytes(buf []byte, num int, b []byte) []byte {
+	buf = appendTag(buf, num, wireBytes)
+	buf = appendVarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// Schema returns the proto3 file describing the messages MarshalProto
+// writes for each root value, keyed by message name.
+func Schema(roots map[string]interface{}) string {
+	s := &schemaWriter{seen: make(map[string]bool)}
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := reflect.TypeOf(roots[name])
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() == reflect.Struct {
+			s.message(name, t)
+			continue
+		}
+		s.seen[name] = true
+		elem := s.fieldType(t.Elem(), name+"Item")
+		s.defs = append(s.defs, fmt.Sprintf("message %s {\n  repeated %s items = 1;\n}\n", name, elem))
+	}
+	return "syntax = \"proto3\";\n\npackage alirezachain;\n\n" + strings.Join(s.defs, "\n")
+}
+
+type schemaWriter struct {
+	seen map[string]bool
+	defs []string
+}
+
+// message defines the message for struct type t, once.
+func (s *schemaWriter) message(name string, t reflect.Type) string {
+	if s.seen[name] {
+		return name
+	}
+	s.seen[name] = true
+	at := len(s.defs)
+	s.defs = append(s.defs, "")
+	var b strings.Builder
+	fmt.Fprintf(&b, "message %s {\n", name)
+	for _, f := range protoFields(t) {
+		ft := t.FieldByIndex(f.index).Type
+		fmt.Fprintf(&b, "  %s %s = %d;\n", s.fieldType(ft, name+t.FieldByIndex(f.index).Name), f.name, f.num)
+	}
+	b.WriteString("}\n")
+	s.defs[at] = b.String()
+	return name
+}
+
+// fieldType returns the protobuf type of a field of Go type t; anonymous
+// structs are named fallback.
+func (s *schemaWriter) fieldType(t reflect.Type, fallback string) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.fieldType(t.Elem(), fallback)
+	case reflect.Bool:
+		return "bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "int64"
+	case reflect.Uint32, reflect.Uint16, reflect.Uint8:
+		return "uint32"
+	case reflect.Uint, reflect.Uint64:
+		return "uint64"
+	case reflect.Float64:
+		return "double"
+	case reflect.Float32:
+		return "float"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 || isNested(t.Elem()) {
+			return "bytes"
+		}
+		return "repeated " + s.fieldType(t.Elem(), fallback)
+	case reflect.Map:
+		value := "bytes"
+		if !isNested(t.Elem()) && !(t.Elem().Kind() == reflect.Slice && t.Elem().Elem().Kind() != reflect.Uint8) {
+			value = s.fieldType(t.Elem(), fallback)
+		}
+		return "map<" + s.fieldType(t.Key(), fallback) + ", " + value + ">"
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			name = fallback
+		}
+		return s.message(name, t)
+	}
+	return "bytes"
+}
@@ -0,0 +1,119 @@
+// Package genesis is the genesis.json shared by the PoW and PoS nodes
+// and written by `alimiad genesis`: its format, the rules a file must
+// meet, and the genesis hash each chain derives from it.
+package genesis
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"alirezachain/chainhash"
+)
+
+// Allocation credits Amount to Address at genesis, released over
+// Vesting if set (PoW only).
+type Allocation struct {
+	Address string   `json:"address"`
+	Amount  uint64   `json:"amount"`
+	Vesting *Vesting `json:"vesting,omitempty"`
+}
+
+// Vesting locks an allocation below CliffHeight and releases it linearly
+// until EndHeight; see proof-work/vesting.go.
+type Vesting struct {
+	CliffHeight int `json:"cliffHeight"`
+	EndHeight   int `json:"endHeight"`
+}
+
+// Validator is a validator staked at genesis (PoS only). PubKey, if
+// set, is registered as the validator's signing key.
+type Validator struct {
+	Name   string `json:"name"`
+	Stake  uint64 `json:"stake"`
+	PubKey string `json:"pubKey,omitempty"`
+}
+
+// File is the content of a genesis.json (GENESIS_FILE). Hashes holds
+// the expected genesis hash per chain ("pow", "pos"); a node refuses to
+// start if its genesis differs.
+type File struct {
+	Timestamp     int64             `json:"timestamp"`
+	Allocations   []Allocation      `json:"allocations,omitempty"`
+	Validators    []Validator       `json:"validators,omitempty"`
+	Hashes        map[string]string `json:"hashes,omitempty"`
+	HashAlgorithm string            `json:"hashAlgorithm,omitempty"` // block hash function; empty means SHA-256
+	ChainID       string            `json:"chainId,omitempty"`       // network name covered by PoW transaction signatures
+
+	// HalvingInterval, if set, fixes the halving emission curve of the
+	// PoW network: the coinbase reward, BlockReward or BLOCK_REWARD,
+	// halves every HalvingInterval blocks.
+	HalvingInterval int    `json:"halvingInterval,omitempty"`
+	BlockReward     uint64 `json:"blockReward,omitempty"`
+}
+
+// Read loads and decodes a genesis file.
+func Read(path string) (*File, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g File
+	if err := json.Unmarshal(raw, &g); err != nil {
+		return nil, fmt.Errorf("decode %s: %v", path, err)
+	}
+	return &g, nil
+}
+
+// Check rejects files the nodes would refuse.
+func (g File) Check() error {
+	if _, err := chainhash.New(g.HashAlgorithm); err != nil {
+		return err
+	}
+	if len(g.ChainID) > 64 {
+		return fmt.Errorf("chain ID is longer than 64 characters")
+	}
+	for _, c := range g.ChainID {
+		if c <= ' ' || c > '~' {
+			return fmt.Errorf("chain ID %q must be printable ASCII without spaces", g.ChainID)
+		}
+	}
+	if g.HalvingInterval < 0 {
+		return errors.New("halving interval must be positive")
+	}
+	if g.BlockReward > 0 && g.HalvingInterval == 0 {
+		return errors.New("block reward needs a halving interval")
+	}
+	seen := make(map[string]bool)
+	for _, a := range g.Allocations {
+		if a.Address == "" || a.Amount == 0 {
+			return errors.New("allocation needs an address and a positive amount")
+		}
+		if seen[a.Address] {
+			return fmt.Errorf("duplicate allocation for %s", a.Address)
+		}
+		seen[a.Address] = true
+		if v := a.Vesting; v != nil && (v.CliffHeight < 0 || v.EndHeight < 1 || v.CliffHeight > v.EndHeight) {
+			return fmt.Errorf("allocation for %s: vesting needs 0 <= cliffHeight <= endHeight and endHeight >= 1", a.Address)
+		}
+	}
+	names := make(map[string]bool)
+	for _, v := range g.Validators {
+		if v.Name == "" || v.Stake == 0 {
+			return errors.New("validator needs a name and a positive stake")
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate validator %s", v.Name)
+		}
+		names[v.Name] = true
+		if v.PubKey != "" {
+			raw, err := hex.DecodeString(v.PubKey)
+			if err != nil || len(raw) != 32 {
+				return fmt.Errorf("validator %s: public key must be 32 bytes of hex", v.Name)
+			}
+		}
+	}
+	return nil
+}
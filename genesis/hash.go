@@ -0,0 +1,111 @@
+package genesis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"alirezachain/chainhash"
+	"alirezachain/poscore"
+	"alirezachain/powcore"
+)
+
+// Genesis block data; must match the nodes.
+const (
+	PowData = "Genesis ⛓️ AlirezaChain PoW"
+	PosData = "Genesis 🪙 AlirezaChain PoS"
+)
+
+// blockHash hashes a genesis block record with the file's algorithm.
+// Transaction IDs and state roots stay SHA-256 on every chain.
+func (g File) blockHash(record string) (string, error) {
+	h, err := chainhash.New(g.HashAlgorithm)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum([]byte(record))), nil
+}
+
+// PowExtra returns the extension fields of the PoW genesis block: the
+// halving schedule, if the file fixes one, so that the genesis hash
+// covers it and nodes started with different schedules cannot share a
+// chain. It is nil otherwise, which keeps the hashes of older files.
+func (g File) PowExtra() map[string]string {
+	if g.HalvingInterval == 0 {
+		return nil
+	}
+	extra := map[string]string{"halvingInterval": strconv.Itoa(g.HalvingInterval)}
+	if g.BlockReward > 0 {
+		extra["blockReward"] = strconv.FormatUint(g.BlockReward, 10)
+	}
+	return extra
+}
+
+// PowHash is the hash of the block genesisBlock builds in the PoW node:
+// one mint transaction per allocation, committed to by the tx and state
+// roots, and the halving schedule (PowExtra).
+func (g File) PowHash() (string, error) {
+	ids := make([]string, 0, len(g.Allocations))
+	state := make(map[string]powcore.Account)
+	for _, a := range g.Allocations {
+		record := powcore.TransferRecord("", a.Address, a.Amount, 0, 0)
+		if a.Vesting != nil {
+			record += fmt.Sprintf("|vesting|%d|%d", a.Vesting.CliffHeight, a.Vesting.EndHeight)
+		}
+		ids = append(ids, powcore.TxID(record))
+		acct := state[a.Address]
+		acct.Total += a.Amount
+		state[a.Address] = acct
+	}
+
+	return g.blockHash(powcore.Header{
+		Timestamp:  g.Timestamp,
+		Data:       PowData,
+		Difficulty: "1",
+		TxRoot:     powcore.MerkleRoot(ids),
+		StateRoot:  powcore.StateRoot(powcore.CanonicalState(state)),
+		Version:    1,
+		Extra:      g.PowExtra(),
+	}.Record(false))
+}
+
+// PosHash is the hash of the block initGenesis builds in the PoS node:
+// validator stakes and allocated balances, in name order, make up the
+// state root.
+func (g File) PosHash() (string, error) {
+	stakes := make(map[string]uint64)
+	balances := make(map[string]uint64)
+	for _, v := range g.Validators {
+		stakes[v.Name] = v.Stake
+	}
+	for _, a := range g.Allocations {
+		balances[a.Address] = a.Amount
+	}
+	seen := make(map[string]bool)
+	for n := range stakes {
+		seen[n] = true
+	}
+	for n := range balances {
+		seen[n] = true
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, n := range names {
+		fmt.Fprintf(h, "%s|%d|%d|%t\n", n, stakes[n], balances[n], false)
+	}
+	stateRoot := hex.EncodeToString(h.Sum(nil))
+
+	return g.blockHash(poscore.Header{
+		Timestamp: g.Timestamp,
+		Data:      PosData,
+		Validator: "genesis",
+		StateRoot: stateRoot,
+		Version:   1,
+	}.Record(false))
+}
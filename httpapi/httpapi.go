@@ -0,0 +1,115 @@
+// Package httpapi holds what the node programs' HTTP APIs share: the
+// error body every error response carries and the request IDs that tie a
+// request to its log lines and to the calls a node makes on its behalf.
+// The middleware package writes its errors with it too, so a client
+// sees one error format whichever layer refused the request.
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the ID of a request. A client may choose it;
+// otherwise the node generates one. Responses echo it, logs mention it
+// and calls the node makes on behalf of the request pass it on, so that
+// one request can be followed across nodes.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength caps the length of an accepted request ID.
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// APIError is the body of every error response.
+type APIError struct {
+	Code      string      `json:"code"` // e.g. "bad_request", "not_found"
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+// ErrorCode derives an error code from an HTTP status, e.g.
+// "too_many_requests" for 429.
+func ErrorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+// WriteError replies with an APIError, in place of http.Error.
+func WriteError(w http.ResponseWriter, message string, status int) {
+	WriteErrorDetails(w, status, APIError{Code: ErrorCode(status), Message: message})
+}
+
+// WriteErrorDetails replies with e, filling in the request ID.
+func WriteErrorDetails(w http.ResponseWriter, status int, e APIError) {
+	if e.RequestID == "" {
+		e.RequestID = w.Header().Get(RequestIDHeader)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(e)
+}
+
+// NewRequestID generates a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs of printable ASCII without spaces, so that
+// they are safe to log and to pass on.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithRequestID accepts the request's X-Request-ID, or generates one if
+// it is missing or malformed, and sets it on the response and the request
+// context.
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID of r, "" outside WithRequestID.
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// LogRequest logs like log.Printf, tagged with the ID of r.
+func LogRequest(r *http.Request, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if id := RequestID(r); id != "" {
+		msg += " [" + id + "]"
+	}
+	log.Print(msg)
+}
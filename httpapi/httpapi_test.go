@@ -0,0 +1,40 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteErrorCarriesRequestID(t *testing.T) {
+	h := WithRequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, "slow down", http.StatusTooManyRequests)
+	}))
+	tests := []struct {
+		name, sent string
+		kept       bool
+	}{
+		{"client ID", "abc-123", true},
+		{"no ID", "", false},
+		{"ID with spaces", "a b", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(RequestIDHeader, tt.sent)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		var e APIError
+		if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		id := rec.Header().Get(RequestIDHeader)
+		if rec.Code != http.StatusTooManyRequests || e.Code != "too_many_requests" || e.Message != "slow down" {
+			t.Errorf("%s: %d %+v", tt.name, rec.Code, e)
+		}
+		if e.RequestID != id || !validRequestID(id) || (id == tt.sent) != tt.kept {
+			t.Errorf("%s: sent %q, response ID %q, body ID %q", tt.name, tt.sent, id, e.RequestID)
+		}
+	}
+}
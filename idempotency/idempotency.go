@@ -0,0 +1,224 @@
+// Package idempotency lets clients of the node programs retry a write
+// safely: a request repeated with the same Idempotency-Key gets the
+// original response back instead of a second block, stake or
+// transaction. The PoW, PoS and P2P nodes each keep one Cache.
+package idempotency
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Header carries the client's key for a write.
+const Header = "Idempotency-Key"
+
+// ReplayedHeader marks a replayed response.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// Defaults of New.
+const (
+	DefaultTTL     = 24 * time.Hour
+	DefaultMaxKeys = 10000
+	maxKeyLength   = 255
+)
+
+// result is a cached response, or a request still in progress while
+// done is false.
+type result struct {
+	id          string
+	bodyHash    [32]byte // of the request, to catch reuse of a key
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+	elem        *list.Element // in Cache.order
+}
+
+// Cache remembers the responses to requests that carried a key. Keys are
+// scoped to the client's address and the route, so two clients that
+// happen to choose the same key do not see each other's responses. It is
+// safe for concurrent use.
+type Cache struct {
+	TTL     time.Duration // how long a response is replayed
+	MaxKeys int           // keys held at most; the oldest are evicted first
+
+	now     func() time.Time
+	mu      sync.Mutex
+	results map[string]*result // client+method+path+key -> result
+	order   *list.List         // of *result, oldest first
+}
+
+// New returns a cache with the default limits that reads the time from
+// now.
+func New(now func() time.Time) *Cache {
+	return &Cache{
+		TTL:     DefaultTTL,
+		MaxKeys: DefaultMaxKeys,
+		now:     now,
+		results: make(map[string]*result),
+		order:   list.New(),
+	}
+}
+
+// Len returns the number of keys held, in progress or done.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.results)
+}
+
+// responseRecorder captures a response while passing it through.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	rr.status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(p []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(p)
+	return rr.ResponseWriter.Write(p)
+}
+
+// Wrap returns h run through Serve.
+func (c *Cache) Wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c.Serve(w, r, h)
+	}
+}
+
+// Serve runs h for r, once per key if r carries one: later requests
+// with the same key and body get the first response replayed, with the
+// same key and a different body 422, and while the first is still
+// running 409. Only successful (2xx) responses are remembered, so a
+// request that failed, or whose handler panicked, can be retried with
+// its key.
+func (c *Cache) Serve(w http.ResponseWriter, r *http.Request, h http.HandlerFunc) {
+	key := r.Header.Get(Header)
+	if key == "" {
+		h(w, r)
+		return
+	}
+	if len(key) > maxKeyLength {
+		httpapi.WriteError(w, "Idempotency-Key is too long", http.StatusBadRequest)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		httpapi.WriteError(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	id := client(r) + " " + r.Method + " " + r.URL.Path + " " + key
+
+	c.mu.Lock()
+	now := c.now()
+	c.prune(now)
+	if prev, ok := c.results[id]; ok {
+		c.mu.Unlock()
+		switch {
+		case prev.bodyHash != sum:
+			httpapi.WriteError(w, "Idempotency-Key was used for a different request", http.StatusUnprocessableEntity)
+		case !prev.done:
+			httpapi.WriteError(w, "a request with this Idempotency-Key is in progress", http.StatusConflict)
+		default:
+			w.Header().Set("Content-Type", prev.contentType)
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(prev.status)
+			_, _ = w.Write(prev.body)
+		}
+		return
+	}
+	for len(c.results) >= c.MaxKeys && c.evictOldest() {
+	}
+	res := &result{id: id, bodyHash: sum}
+	res.elem = c.order.PushBack(res)
+	c.results[id] = res
+	c.mu.Unlock()
+
+	defer func() {
+		if p := recover(); p != nil {
+			c.mu.Lock()
+			c.forget(res)
+			c.mu.Unlock()
+			panic(p)
+		}
+	}()
+	rr := &responseRecorder{ResponseWriter: w}
+	h(rr, r)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := rr.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 200 || status >= 300 {
+		c.forget(res)
+		return
+	}
+	res.done = true
+	res.status = status
+	res.contentType = w.Header().Get("Content-Type")
+	res.body = rr.body.Bytes()
+	res.expires = now.Add(c.TTL)
+}
+
+// client names who sent r: the host of its remote address.
+func client(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// prune forgets expired results. Callers must hold mu.
+func (c *Cache) prune(now time.Time) {
+	for _, res := range c.results {
+		if res.done && now.After(res.expires) {
+			c.forget(res)
+		}
+	}
+}
+
+// evictOldest forgets the oldest finished result, or the oldest one in
+// progress if none has finished, and reports whether there was one.
+// Callers must hold mu.
+func (c *Cache) evictOldest() bool {
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		if res := e.Value.(*result); res.done {
+			c.forget(res)
+			return true
+		}
+	}
+	if e := c.order.Front(); e != nil {
+		c.forget(e.Value.(*result))
+		return true
+	}
+	return false
+}
+
+// forget drops res unless it was already replaced. Callers must hold mu.
+func (c *Cache) forget(res *result) {
+	if c.results[res.id] == res {
+		delete(c.results, res.id)
+		c.order.Remove(res.elem)
+	}
+}
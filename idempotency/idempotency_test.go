@@ -0,0 +1,102 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// counting returns a handler that answers status with the number of
+// times it ran.
+func counting(status int, runs *int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*runs++
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "run %d", *runs)
+	}
+}
+
+func send(h http.HandlerFunc, client, path, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.RemoteAddr = client + ":1234"
+	req.Header.Set(Header, key)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestServe(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		status         int
+		client, path   string
+		key, body      string
+		wantCode, runs int
+		replayed       bool
+	}{
+		{"first", http.StatusCreated, "10.0.0.1", "/mine", "k", "a", http.StatusCreated, 1, false},
+		{"retry", http.StatusCreated, "10.0.0.1", "/mine", "k", "a", http.StatusCreated, 1, true},
+		{"other body", http.StatusCreated, "10.0.0.1", "/mine", "k", "b", http.StatusUnprocessableEntity, 1, false},
+		{"other client", http.StatusCreated, "10.0.0.2", "/mine", "k", "a", http.StatusCreated, 2, false},
+		{"other route", http.StatusCreated, "10.0.0.1", "/tx", "k", "a", http.StatusCreated, 3, false},
+		{"no key", http.StatusCreated, "10.0.0.1", "/mine", "", "a", http.StatusCreated, 4, false},
+		{"rejected", http.StatusBadRequest, "10.0.0.1", "/mine", "bad", "a", http.StatusBadRequest, 5, false},
+		{"rejected again", http.StatusBadRequest, "10.0.0.1", "/mine", "bad", "a", http.StatusBadRequest, 6, false},
+		{"too long", http.StatusCreated, "10.0.0.1", "/mine", strings.Repeat("k", 256), "a", http.StatusBadRequest, 6, false},
+	}
+	c := New(func() time.Time { return now })
+	runs := 0
+	for _, tt := range tests {
+		rec := send(c.Wrap(counting(tt.status, &runs)), tt.client, tt.path, tt.key, tt.body)
+		if rec.Code != tt.wantCode || runs != tt.runs || (rec.Header().Get(ReplayedHeader) == "true") != tt.replayed {
+			t.Errorf("%s: status %d after %d runs (replayed %q), want %d after %d", tt.name, rec.Code, runs, rec.Header().Get(ReplayedHeader), tt.wantCode, tt.runs)
+		}
+	}
+
+	now = now.Add(DefaultTTL + time.Second)
+	if rec := send(c.Wrap(counting(http.StatusCreated, &runs)), "10.0.0.1", "/mine", "k", "a"); rec.Header().Get(ReplayedHeader) != "" || runs != 7 {
+		t.Errorf("expired key was replayed")
+	}
+}
+
+func TestServeEvictsOldest(t *testing.T) {
+	c := New(time.Now)
+	c.MaxKeys = 3
+	runs := 0
+	h := c.Wrap(counting(http.StatusOK, &runs))
+	for i := 0; i < 5; i++ {
+		send(h, "10.0.0.1", "/tx", fmt.Sprint("k", i), "a")
+	}
+	if c.Len() != 3 {
+		t.Errorf("%d keys held, want the cap of 3", c.Len())
+	}
+	send(h, "10.0.0.1", "/tx", "k4", "a")
+	if runs != 5 {
+		t.Errorf("newest key was not replayed: %d runs", runs)
+	}
+	send(h, "10.0.0.1", "/tx", "k0", "a")
+	if runs != 6 {
+		t.Errorf("oldest key was not evicted: %d runs", runs)
+	}
+}
+
+func TestServeForgetsPanics(t *testing.T) {
+	c := New(time.Now)
+	panicking := c.Wrap(func(http.ResponseWriter, *http.Request) { panic("boom") })
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("panic was swallowed")
+			}
+		}()
+		send(panicking, "10.0.0.1", "/mine", "k", "a")
+	}()
+	runs := 0
+	if rec := send(c.Wrap(counting(http.StatusOK, &runs)), "10.0.0.1", "/mine", "k", "a"); rec.Code != http.StatusOK || runs != 1 {
+		t.Errorf("retry after a panic: status %d, %d runs", rec.Code, runs)
+	}
+}
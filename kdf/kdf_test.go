@@ -0,0 +1,52 @@
+package kdf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors from RFC 7914, sections 11 and 12.
+func TestScrypt(t *testing.T) {
+	cases := []struct {
+		password, salt string
+		N, r, p        int
+		want           string
+	}{
+		{"", "", 16, 1, 1, "77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 1024, 8, 16, "fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+	}
+	for _, c := range cases {
+		got, err := Scrypt([]byte(c.password), []byte(c.salt), c.N, c.r, c.p, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != c.want {
+			t.Errorf("Scrypt(%q, %q, %d, %d, %d) = %x", c.password, c.salt, c.N, c.r, c.p, got)
+		}
+	}
+	if _, err := Scrypt(nil, nil, 1000, 1, 1, 32); err == nil {
+		t.Error("Scrypt accepted N that is not a power of two")
+	}
+	if _, err := Scrypt(nil, nil, 1<<20, 16, 1, 32); err == nil {
+		t.Error("Scrypt accepted parameters above the memory bound")
+	}
+}
+
+// PBKDF2-HMAC-SHA256 test vectors from RFC 7914, section 11.
+func TestPBKDF2(t *testing.T) {
+	cases := []struct {
+		password, salt string
+		iter           int
+		want           string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, c := range cases {
+		got := PBKDF2([]byte(c.password), []byte(c.salt), c.iter, 64, sha256.New)
+		if hex.EncodeToString(got) != c.want {
+			t.Errorf("PBKDF2(%q, %q, %d) = %x", c.password, c.salt, c.iter, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package kdf derives keys from passwords with scrypt (RFC 7914) and
+// PBKDF2 (RFC 8018). The wallet and the PoS node both open keystores
+// encrypted under scrypt keys, and the wallet derives BIP-39 seeds with
+// PBKDF2.
+package kdf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// maxScryptMemory bounds the memory a keystore may ask scrypt to use.
+const maxScryptMemory = 1 << 30
+
+// Scrypt derives a key from a password as specified in RFC 7914. N must
+// be a power of two greater than one.
+func Scrypt(password, salt []byte, N, r, p, keyLen int) ([]byte, error) {
+	if N <= 1 || N&(N-1) != 0 {
+		return nil, errors.New("scrypt: N must be a power of two greater than 1")
+	}
+	if r <= 0 || p <= 0 || p > 16 || r > maxScryptMemory/128/N {
+		return nil, errors.New("scrypt: parameters are out of range")
+	}
+
+	b := PBKDF2(password, salt, 1, p*128*r, sha256.New)
+	xy := make([]uint32, 64*r)
+	v := make([]uint32, 32*N*r)
+	for i := 0; i < p; i++ {
+		roMix(b[i*128*r:], r, N, v, xy)
+	}
+	return PBKDF2(password, b, 1, keyLen, sha256.New), nil
+}
+
+// roMix is the scrypt sequential memory-hard mixing function.
+func roMix(b []byte, r, N int, v, xy []uint32) {
+	x := xy[:32*r]
+	y := xy[32*r:]
+
+	for i := range x {
+		x[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	for i := 0; i < N; i++ {
+		copy(v[i*32*r:], x)
+		blockMix(x, y, r)
+	}
+	for i := 0; i < N; i++ {
+		j := int(x[(2*r-1)*16] & uint32(N-1))
+		for k := range x {
+			x[k] ^= v[j*32*r+k]
+		}
+		blockMix(x, y, r)
+	}
+	for i, w := range x {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+}
+
+// blockMix applies salsa20/8 to the 2r 64-byte blocks of b, using y as
+// scratch space, and writes the even blocks followed by the odd ones back
+// into b.
+func blockMix(b, y []uint32, r int) {
+	var x [16]uint32
+	copy(x[:], b[(2*r-1)*16:])
+	for i := 0; i < 2*r; i++ {
+		for j := range x {
+			x[j] ^= b[i*16+j]
+		}
+		salsa208(&x)
+		copy(y[i*16:], x[:])
+	}
+	for i := 0; i < r; i++ {
+		copy(b[i*16:], y[2*i*16:(2*i+1)*16])
+		copy(b[(r+i)*16:], y[(2*i+1)*16:(2*i+2)*16])
+	}
+}
+
+// salsa208 applies the salsa20/8 core to x in place.
+func salsa208(x *[16]uint32) {
+	in := *x
+	for i := 0; i < 8; i += 2 {
+		x[4] ^= bits.RotateLeft32(x[0]+x[12], 7)
+		x[8] ^= bits.RotateLeft32(x[4]+x[0], 9)
+		x[12] ^= bits.RotateLeft32(x[8]+x[4], 13)
+		x[0] ^= bits.RotateLeft32(x[12]+x[8], 18)
+
+		x[9] ^= bits.RotateLeft32(x[5]+x[1], 7)
+		x[13] ^= bits.RotateLeft32(x[9]+x[5], 9)
+		x[1] ^= bits.RotateLeft32(x[13]+x[9], 13)
+		x[5] ^= bits.RotateLeft32(x[1]+x[13], 18)
+
+		x[14] ^= bits.RotateLeft32(x[10]+x[6], 7)
+		x[2] ^= bits.RotateLeft32(x[14]+x[10], 9)
+		x[6] ^= bits.RotateLeft32(x[2]+x[14], 13)
+		x[10] ^= bits.RotateLeft32(x[6]+x[2], 18)
+
+		x[3] ^= bits.RotateLeft32(x[15]+x[11], 7)
+		x[7] ^= bits.RotateLeft32(x[3]+x[15], 9)
+		x[11] ^= bits.RotateLeft32(x[7]+x[3], 13)
+		x[15] ^= bits.RotateLeft32(x[11]+x[7], 18)
+
+		x[1] ^= bits.RotateLeft32(x[0]+x[3], 7)
+		x[2] ^= bits.RotateLeft32(x[1]+x[0], 9)
+		x[3] ^= bits.RotateLeft32(x[2]+x[1], 13)
+		x[0] ^= bits.RotateLeft32(x[3]+x[2], 18)
+
+		x[6] ^= bits.RotateLeft32(x[5]+x[4], 7)
+		x[7] ^= bits.RotateLeft32(x[6]+x[5], 9)
+		x[4] ^= bits.RotateLeft32(x[7]+x[6], 13)
+		x[5] ^= bits.RotateLeft32(x[4]+x[7], 18)
+
+		x[11] ^= bits.RotateLeft32(x[10]+x[9], 7)
+		x[8] ^= bits.RotateLeft32(x[11]+x[10], 9)
+		x[9] ^= bits.RotateLeft32(x[8]+x[11], 13)
+		x[10] ^= bits.RotateLeft32(x[9]+x[8], 18)
+
+		x[12] ^= bits.RotateLeft32(x[15]+x[14], 7)
+		x[13] ^= bits.RotateLeft32(x[12]+x[15], 9)
+		x[14] ^= bits.RotateLeft32(x[13]+x[12], 13)
+		x[15] ^= bits.RotateLeft32(x[14]+x[13], 18)
+	}
+	for i := range x {
+		x[i] += in[i]
+	}
+}
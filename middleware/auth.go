@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// apiTokenHeader carries API_TOKEN. It is not Authorization, which the
+// admin API and remote signers use for their own tokens.
+const apiTokenHeader = "X-API-Token"
+
+// newAuth requires API_TOKEN on every request that can change state.
+// Reads (GET, HEAD, OPTIONS) stay open, so that peers and explorers can
+// follow the chain.
+func newAuth(cfg Config) (mux.MiddlewareFunc, error) {
+	if len(cfg.APIToken) < 16 {
+		return nil, errors.New("API_TOKEN must be set and at least 16 characters")
+	}
+	token := []byte(cfg.APIToken)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if subtle.ConstantTimeCompare([]byte(r.Header.Get(apiTokenHeader)), token) != 1 {
+					httpapi.WriteError(w, "invalid or missing "+apiTokenHeader, http.StatusUnauthorized)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// BodyLimits caps the size of request bodies per route, so that a huge
+// body is refused before a handler reads it.
+type BodyLimits struct {
+	Default int64            // bytes, for routes without a limit of their own
+	Routes  map[string]int64 // bytes, by route path template without the API prefix, e.g. "/tx/batch"
+}
+
+// LoadBodyLimits applies MAX_BODY_BYTES, the default, and BODY_LIMITS,
+// comma-separated route=bytes pairs, on top of a node's defaults:
+//
+//	BODY_LIMITS=/tx/batch=8388608,/anchor=4096
+func LoadBodyLimits(defaults BodyLimits) (BodyLimits, error) {
+	limits := BodyLimits{Default: defaults.Default, Routes: make(map[string]int64)}
+	for route, n := range defaults.Routes {
+		limits.Routes[route] = n
+	}
+	if v := os.Getenv("MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return limits, fmt.Errorf("invalid MAX_BODY_BYTES %q (at least 1)", v)
+		}
+		limits.Default = n
+	}
+	for _, pair := range strings.Split(os.Getenv("BODY_LIMITS"), ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		route, v, ok := strings.Cut(pair, "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if route = strings.TrimSpace(route); !ok || !strings.HasPrefix(route, "/") || err != nil || n < 1 {
+			return limits, fmt.Errorf("invalid BODY_LIMITS entry %q (want /route=bytes)", pair)
+		}
+		limits.Routes[route] = n
+	}
+	return limits, nil
+}
+
+// Limit returns the body limit of route.
+func (l BodyLimits) Limit(route string) int64 {
+	if n, ok := l.Routes[route]; ok {
+		return n
+	}
+	return l.Default
+}
+
+// Middleware caps the body of every request to a route of the router it
+// is used on, looked up by path template with prefix trimmed. A request
+// announcing a larger body is answered 413 at once; a larger body sent
+// without a length makes the handler's read fail.
+func (l BodyLimits) Middleware(prefix string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := r.URL.Path
+			if cur := mux.CurrentRoute(r); cur != nil {
+				if tmpl, err := cur.GetPathTemplate(); err == nil {
+					route = tmpl
+				}
+			}
+			if strings.HasPrefix(route, prefix+"/") {
+				route = strings.TrimPrefix(route, prefix)
+			}
+			limit := l.Limit(route)
+			if r.ContentLength > limit {
+				httpapi.WriteError(w, fmt.Sprintf("request body exceeds the %d bytes allowed on %s", limit, route), http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
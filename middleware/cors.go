@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight.
+const corsMaxAge = "600"
+
+// newCORS lets browser pages from CORS_ORIGINS call the API and answers
+// their preflight requests itself, before routing.
+func newCORS(cfg Config) (mux.MiddlewareFunc, error) {
+	if len(cfg.CORSOrigins) == 0 {
+		return nil, errors.New("CORS_ORIGINS must be set")
+	}
+	allowed := make(map[string]bool, len(cfg.CORSOrigins))
+	for _, o := range cfg.CORSOrigins {
+		allowed[o] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || (!allowed["*"] && !allowed[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if allowed["*"] {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Deprecation, Link, Sunset, Retry-After")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+					w.Header().Set("Access-Control-Allow-Headers", h)
+				}
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
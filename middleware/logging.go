@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// logging logs one line per request: method, path, status, duration,
+// body size and request ID.
+func logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		log.Printf("🌐 %s %s %d %s %dB%s", r.Method, r.URL.RequestURI(), rec.status, time.Since(start).Round(time.Microsecond), rec.bytes, requestIDSuffix(w))
+	})
+}
+
+// requestIDSuffix tags a log line with the request ID, as the nodes'
+// logRequest does.
+func requestIDSuffix(w http.ResponseWriter) string {
+	if id := w.Header().Get(httpapi.RequestIDHeader); id != "" {
+		return " [" + id + "]"
+	}
+	return ""
+}
+
+// statusRecorder notes the status and body size of a response. It
+// passes Flush and Hijack through, so that streaming responses and
+// WebSocket upgrades keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hj.Hijack()
+}
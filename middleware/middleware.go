@@ -0,0 +1,146 @@
+// Package middleware builds the HTTP middleware stack of the node
+// programs from configuration. HTTP_MIDDLEWARE names the middleware to
+// run, outermost first, from the built-in ones (logging, auth,
+// ratelimit, cors, compress) and any added with Register:
+//
+//	HTTP_MIDDLEWARE=logging,cors,ratelimit,auth,compress
+//
+// Programs that build their own router can add their own
+// mux.MiddlewareFunc with Register and then name it in the list, or
+// apply a stack directly with Wrap.
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Config selects and configures the middleware. Only the settings of
+// middleware named in Order are used.
+type Config struct {
+	Order       []string // HTTP_MIDDLEWARE, outermost first
+	APIToken    string   // API_TOKEN: auth's token, at least 16 characters
+	RateLimit   float64  // RATE_LIMIT: ratelimit's requests per second per client address
+	RateBurst   int      // RATE_BURST: requests a client may make at once (default 2 × RateLimit, at least 1)
+	CORSOrigins []string // CORS_ORIGINS: origins cors allows, or "*" for any
+}
+
+// factory builds a built-in middleware from the configuration.
+type factory func(cfg Config) (mux.MiddlewareFunc, error)
+
+var builtins = map[string]factory{
+	"logging":   func(Config) (mux.MiddlewareFunc, error) { return logging, nil },
+	"auth":      newAuth,
+	"ratelimit": newRateLimit,
+	"cors":      newCORS,
+	"compress":  func(Config) (mux.MiddlewareFunc, error) { return compress, nil },
+}
+
+var (
+	// custom holds the middleware added with Register.
+	custom   = make(map[string]mux.MiddlewareFunc)
+	customMu sync.Mutex
+)
+
+// Register makes mw selectable by name in HTTP_MIDDLEWARE. It panics if
+// name is empty or taken, like http.Handle.
+func Register(name string, mw mux.MiddlewareFunc) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	if name == "" || mw == nil {
+		panic("middleware: Register needs a name and a middleware")
+	}
+	if _, dup := custom[name]; dup || builtins[name] != nil {
+		panic("middleware: " + name + " registered twice")
+	}
+	custom[name] = mw
+}
+
+// Names lists the middleware that can be named in HTTP_MIDDLEWARE.
+func Names() []string {
+	customMu.Lock()
+	defer customMu.Unlock()
+	names := make([]string, 0, len(builtins)+len(custom))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FromEnv reads the configuration from the environment.
+func FromEnv() (Config, error) {
+	var cfg Config
+	for _, name := range strings.Split(os.Getenv("HTTP_MIDDLEWARE"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.Order = append(cfg.Order, name)
+		}
+	}
+	cfg.APIToken = os.Getenv("API_TOKEN")
+	if v := os.Getenv("RATE_LIMIT"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid RATE_LIMIT %q (requests per second, above 0)", v)
+		}
+		cfg.RateLimit = n
+	}
+	if v := os.Getenv("RATE_BURST"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid RATE_BURST %q (at least 1)", v)
+		}
+		cfg.RateBurst = n
+	}
+	for _, o := range strings.Split(os.Getenv("CORS_ORIGINS"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			cfg.CORSOrigins = append(cfg.CORSOrigins, o)
+		}
+	}
+	return cfg, nil
+}
+
+// Build returns the middleware cfg.Order names, outermost first.
+func Build(cfg Config) ([]mux.MiddlewareFunc, error) {
+	seen := make(map[string]bool)
+	stack := make([]mux.MiddlewareFunc, 0, len(cfg.Order))
+	for _, name := range cfg.Order {
+		if seen[name] {
+			return nil, fmt.Errorf("middleware %q is listed twice", name)
+		}
+		seen[name] = true
+		if f, ok := builtins[name]; ok {
+			mw, err := f(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			stack = append(stack, mw)
+			continue
+		}
+		customMu.Lock()
+		mw, ok := custom[name]
+		customMu.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q (available: %s)", name, strings.Join(Names(), ", "))
+		}
+		stack = append(stack, mw)
+	}
+	return stack, nil
+}
+
+// Wrap applies stack to h, the first middleware outermost.
+func Wrap(h http.Handler, stack []mux.MiddlewareFunc) http.Handler {
+	for i := len(stack) - 1; i >= 0; i-- {
+		h = stack[i](h)
+	}
+	return h
+}
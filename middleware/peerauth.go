@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// PeerTokenHeader carries the token a node presents when it pushes blocks
+// or transactions to a peer.
+const PeerTokenHeader = "X-Peer-Token"
+
+// minPeerTokenLength is the shortest token accepted, as for API_TOKEN.
+const minPeerTokenLength = 16
+
+// PeerAuth keeps random clients from injecting blocks and transactions
+// into a private network. With no tokens configured, peer pushes are
+// open, as before.
+type PeerAuth struct {
+	Token  string   // presented to peers (PEER_TOKEN)
+	Accept []string // accepted from peers: PEER_TOKEN and PEER_TOKENS
+}
+
+// LoadPeerAuth reads PEER_TOKEN, a secret shared by the whole network
+// that the node both presents and accepts, and PEER_TOKENS, further
+// comma-separated tokens it accepts, one per peer, so that a single peer
+// can be cut off by removing its token.
+func LoadPeerAuth() (PeerAuth, error) {
+	var auth PeerAuth
+	if v := os.Getenv("PEER_TOKEN"); v != "" {
+		if len(v) < minPeerTokenLength {
+			return auth, fmt.Errorf("PEER_TOKEN must be at least %d characters", minPeerTokenLength)
+		}
+		auth.Token = v
+		auth.Accept = append(auth.Accept, v)
+	}
+	for _, v := range strings.Split(os.Getenv("PEER_TOKENS"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if len(v) < minPeerTokenLength {
+			return auth, fmt.Errorf("PEER_TOKENS entries must be at least %d characters", minPeerTokenLength)
+		}
+		auth.Accept = append(auth.Accept, v)
+	}
+	return auth, nil
+}
+
+// Required reports whether pushes must carry an accepted token.
+func (a PeerAuth) Required() bool {
+	return len(a.Accept) > 0
+}
+
+// Sign sets the node's token on an outgoing push, if it has one.
+func (a PeerAuth) Sign(req *http.Request) {
+	if a.Token != "" {
+		req.Header.Set(PeerTokenHeader, a.Token)
+	}
+}
+
+// Allowed reports whether r carries an accepted token, or none is
+// required. Every token is compared, in constant time.
+func (a PeerAuth) Allowed(r *http.Request) bool {
+	if !a.Required() {
+		return true
+	}
+	got := []byte(r.Header.Get(PeerTokenHeader))
+	ok := 0
+	for _, t := range a.Accept {
+		ok |= subtle.ConstantTimeCompare(got, []byte(t))
+	}
+	return ok == 1
+}
+
+// Require guards a handler for pushes from peers: requests without an
+// accepted token get 401 Unauthorized.
+func (a PeerAuth) Require(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.Allowed(r) {
+			httpapi.WriteError(w, "invalid or missing "+PeerTokenHeader, http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// maxRateClients bounds the buckets kept; beyond it, buckets that have
+// refilled are dropped since they carry no state.
+const maxRateClients = 10000
+
+// bucket is a token bucket: tokens refill at the rate limit up to the
+// burst, and each request takes one.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimit limits each client address to RATE_LIMIT requests per
+// second with bursts of RATE_BURST, answering 429 beyond that.
+func newRateLimit(cfg Config) (mux.MiddlewareFunc, error) {
+	if cfg.RateLimit <= 0 {
+		return nil, errors.New("RATE_LIMIT must be set")
+	}
+	rate := cfg.RateLimit
+	burst := float64(cfg.RateBurst)
+	if burst == 0 {
+		burst = math.Max(1, math.Ceil(2*rate))
+	}
+
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+	allow := func(client string, now time.Time) (bool, time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(buckets) >= maxRateClients {
+			for c, b := range buckets {
+				if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+					delete(buckets, c)
+				}
+			}
+		}
+		b, ok := buckets[client]
+		if !ok {
+			b = &bucket{tokens: burst, last: now}
+			buckets[client] = b
+		}
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+		if b.tokens < 1 {
+			return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		}
+		b.tokens--
+		return true, 0
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				client = r.RemoteAddr
+			}
+			if ok, wait := allow(client, time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				httpapi.WriteError(w, "rate limit exceeded; retry later", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
@@ -0,0 +1,249 @@
+package node
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"alirezachain/chainhash"
+	"alirezachain/powcore"
+)
+
+// blockVersion mirrors the PoW node's block version.
+const blockVersion = 1
+
+// Block mirrors the PoW node's block, without the fields of later forks
+// (compact bits, anchors, extension fields). Nonce and Difficulty are
+// filled in by the pow engine; Validator and Signature are left to
+// registered engines that sign blocks.
+type Block struct {
+	Height       int           `json:"height"`
+	Timestamp    int64         `json:"timestamp"`
+	Data         string        `json:"data"`
+	Nonce        int64         `json:"nonce"`
+	Hash         string        `json:"hash"`
+	PrevHash     string        `json:"prevHash"`
+	Difficulty   int           `json:"difficulty"`
+	Validator    string        `json:"validator,omitempty"`
+	Signature    string        `json:"signature,omitempty"`
+	TxRoot       string        `json:"txRoot"`
+	StateRoot    string        `json:"stateRoot"`
+	Transactions []Transaction `json:"transactions"`
+	Version      int           `json:"version,omitempty"`
+}
+
+// Transaction mirrors the PoW node's plain transfer: Amount from From to
+// To, paying Fee to the miner. From is the sender's hex-encoded ed25519
+// public key and Signature signs the ID for the chain ID (see
+// powcore.TxSigningDigest). A coinbase has no sender and
+// uses the block height as nonce.
+type Transaction struct {
+	ID        string `json:"id"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to"`
+	Amount    uint64 `json:"amount"`
+	Fee       uint64 `json:"fee,omitempty"`
+	Nonce     uint64 `json:"nonce"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// IsCoinbase reports whether tx mints new supply.
+func (tx Transaction) IsCoinbase() bool {
+	return tx.From == ""
+}
+
+// NewTransfer returns a transfer signed with key for the network
+// chainID (see Node.ChainID), as the wallet builds it.
+func NewTransfer(chainID string, key ed25519.PrivateKey, to string, amount, fee, nonce uint64) Transaction {
+	tx := Transaction{
+		From:   hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		To:     to,
+		Amount: amount,
+		Fee:    fee,
+		Nonce:  nonce,
+	}
+	tx.ID = txHash(tx)
+	digest, _ := powcore.TxSigningDigest(chainID, tx.ID)
+	tx.Signature = hex.EncodeToString(ed25519.Sign(key, digest))
+	return tx
+}
+
+// txHash is the PoW node's transaction ID of a plain transfer.
+func txHash(tx Transaction) string {
+	return powcore.TxID(powcore.TransferRecord(tx.From, tx.To, tx.Amount, tx.Fee, tx.Nonce))
+}
+
+// verifySignature checks that tx is signed by the key in tx.From for
+// the network chainID.
+func verifySignature(tx Transaction, chainID string) error {
+	pub, err := hex.DecodeString(tx.From)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("sender must be a hex-encoded ed25519 public key")
+	}
+	digest, err := powcore.TxSigningDigest(chainID, tx.ID)
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(tx.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), digest, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// checkTransfer checks a transfer signed for chainID on its own, before
+// any state.
+func checkTransfer(tx Transaction, chainID string) error {
+	if tx.IsCoinbase() {
+		return errors.New("coinbase transactions cannot be submitted")
+	}
+	if tx.To == "" || tx.Amount == 0 {
+		return errors.New("transfer needs a recipient and a positive amount")
+	}
+	if tx.ID != txHash(tx) {
+		return errors.New("id does not match the transaction")
+	}
+	return verifySignature(tx, chainID)
+}
+
+// merkleRoot is the PoW node's transaction root.
+func merkleRoot(txs []Transaction) string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.ID
+	}
+	return powcore.MerkleRoot(ids)
+}
+
+// blockRecord is the PoW node's record before the hashv2 and compactbits
+// forks.
+func blockRecord(b Block) string {
+	return powcore.Header{
+		Height:     b.Height,
+		Timestamp:  b.Timestamp,
+		Data:       b.Data,
+		Nonce:      b.Nonce,
+		PrevHash:   b.PrevHash,
+		Difficulty: strconv.Itoa(b.Difficulty),
+		TxRoot:     b.TxRoot,
+		StateRoot:  b.StateRoot,
+		Version:    b.Version,
+	}.Record(false)
+}
+
+func calculateHash(h chainhash.Hasher, b Block) string {
+	return hex.EncodeToString(h.Sum([]byte(blockRecord(b))))
+}
+
+// meetsDifficulty reports whether hash has at least difficulty leading
+// zero bits.
+func meetsDifficulty(h chainhash.Hasher, hash string, difficulty int) bool {
+	if difficulty <= 0 || difficulty >= h.Size()*8 || len(hash) != h.Size()*2 {
+		return false
+	}
+	n, ok := new(big.Int).SetString(hash, 16)
+	if !ok {
+		return false
+	}
+	return n.Cmp(powcore.DifficultyTarget(h.Size(), difficulty)) == -1
+}
+
+// Balance is an account's holdings derived from the chain.
+type Balance struct {
+	Address   string `json:"address"`
+	Spendable uint64 `json:"spendable"`
+	Immature  uint64 `json:"immature"` // coinbase rewards awaiting maturity
+	Nonce     uint64 `json:"nonce"`    // nonce of the account's next transaction
+}
+
+// ledger maps addresses to balances.
+type ledger map[string]*Balance
+
+func (s ledger) account(addr string) *Balance {
+	if s[addr] == nil {
+		s[addr] = &Balance{Address: addr}
+	}
+	return s[addr]
+}
+
+// root is the PoW node's state root.
+func (s ledger) root() string {
+	accounts := make(map[string]powcore.Account, len(s))
+	for addr, b := range s {
+		accounts[addr] = powcore.Account{Total: b.Spendable + b.Immature, Nonce: b.Nonce}
+	}
+	return powcore.StateRoot(powcore.CanonicalState(accounts))
+}
+
+func (s ledger) clone() ledger {
+	c := make(ledger, len(s))
+	for addr, b := range s {
+		cp := *b
+		c[addr] = &cp
+	}
+	return c
+}
+
+// applyTransfer checks tx against the state and applies it.
+func (s ledger) applyTransfer(tx Transaction) error {
+	from := s.account(tx.From)
+	if tx.Nonce != from.Nonce {
+		return fmt.Errorf("expected nonce %d, got %d", from.Nonce, tx.Nonce)
+	}
+	cost := tx.Amount + tx.Fee
+	if cost < tx.Amount || from.Spendable < cost {
+		return errors.New("insufficient spendable balance")
+	}
+	from.Spendable -= cost
+	from.Nonce++
+	s.account(tx.To).Spendable += tx.Amount
+	return nil
+}
+
+// applyBlock applies the transfers of txs, then credits the coinbase as
+// immature.
+func (s ledger) applyBlock(txs []Transaction) error {
+	for i, tx := range txs {
+		if tx.IsCoinbase() {
+			continue
+		}
+		if err := s.applyTransfer(tx); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	if len(txs) > 0 {
+		s.account(txs[0].To).Immature += txs[0].Amount
+	}
+	return nil
+}
+
+// replay returns every account's balance as seen by the next block on
+// chain: a coinbase is spendable once the next block is at least
+// maturity blocks above it, genesis allocations at once.
+func replay(chain []Block, maturity int) ledger {
+	state := make(ledger)
+	next := chain[len(chain)-1].Height + 1
+	for _, b := range chain {
+		for _, tx := range b.Transactions {
+			if tx.IsCoinbase() {
+				if b.Height == 0 || powcore.Mature(b.Height, next, maturity) {
+					state.account(tx.To).Spendable += tx.Amount
+				} else {
+					state.account(tx.To).Immature += tx.Amount
+				}
+				continue
+			}
+			from := state.account(tx.From)
+			from.Spendable -= tx.Amount + tx.Fee
+			from.Nonce++
+			state.account(tx.To).Spendable += tx.Amount
+		}
+	}
+	return state
+}
@@ -0,0 +1,108 @@
+package node
+
+import (
+	"fmt"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+// ConsensusEngine decides who may extend an embedded node's chain and
+// how a block proves it. The node assembles a block's transactions and
+// roots; the engine fills in and checks the consensus fields of its
+// header. chain is the chain the block extends, genesis first, and must
+// not be modified. Engines only drive this package: the PoW and PoS
+// node programs keep their own validation and sealing, so no engine here
+// stands in for proof of stake.
+type ConsensusEngine interface {
+	// SelectProposer returns who proposes the block after the tip of
+	// chain and receives its coinbase, or "" if anyone may, in which case
+	// the coinbase pays Config.Miner.
+	SelectProposer(chain []Block) (string, error)
+
+	// Prepare fills in the consensus fields of b's header, such as its
+	// difficulty or validator, before the block is sealed.
+	Prepare(chain []Block, b *Block) error
+
+	// Seal completes b and sets its hash: the pow engine searches a
+	// nonce, a signing engine would sign it. It fails if this node cannot
+	// seal b, for example without the proposer's key. The node is not
+	// locked during Seal, which may run for several blocks at once.
+	Seal(b *Block) error
+
+	// VerifyHeader checks the consensus fields, hash and seal of b.
+	VerifyHeader(chain []Block, b Block) error
+}
+
+// EngineFactory creates a consensus engine for a node's configuration,
+// after defaults were applied.
+type EngineFactory func(cfg Config) (ConsensusEngine, error)
+
+var (
+	// engines holds the factories selectable by Config.Engine.
+	engines = map[string]EngineFactory{
+		"pow": newPowEngine,
+	}
+	enginesMu sync.Mutex
+)
+
+// RegisterEngine makes an engine selectable by name with Config.Engine.
+// It panics if name is empty or already registered, so that two engines
+// cannot silently replace each other.
+func RegisterEngine(name string, factory EngineFactory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	if name == "" || factory == nil {
+		panic("node: RegisterEngine needs a name and a factory")
+	}
+	if _, dup := engines[name]; dup {
+		panic("node: engine " + name + " registered twice")
+	}
+	engines[name] = factory
+}
+
+// Engines lists the registered engine names in order.
+func Engines() []string {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadEnginePlugin opens a Go plugin (go build -buildmode=plugin) whose
+// init functions call RegisterEngine, and returns the engines it added.
+// The plugin must be built against the same version of this package.
+func LoadEnginePlugin(path string) ([]string, error) {
+	before := make(map[string]bool)
+	for _, name := range Engines() {
+		before[name] = true
+	}
+	if _, err := plugin.Open(path); err != nil {
+		return nil, err
+	}
+	var added []string
+	for _, name := range Engines() {
+		if !before[name] {
+			added = append(added, name)
+		}
+	}
+	if len(added) == 0 {
+		return nil, fmt.Errorf("plugin %s registered no consensus engine", path)
+	}
+	return added, nil
+}
+
+// newEngine creates the engine cfg.Engine names.
+func newEngine(cfg Config) (ConsensusEngine, error) {
+	enginesMu.Lock()
+	factory, ok := engines[cfg.Engine]
+	enginesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown consensus engine %q (registered: %v)", cfg.Engine, Engines())
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,508 @@
+// Package node embeds a proof-of-work chain in a Go program: blocks,
+// signed transfers, a mempool and a miner, with no HTTP server, no peers
+// and no package globals, so that an application or a test suite can run
+// as many chains as it likes in one process.
+//
+//	n := node.New(node.Config{Alloc: map[string]uint64{alice: 1000}})
+//	if err := n.Start(ctx); err != nil { ... }
+//	events := n.Events(ctx)
+//	_ = n.Submit(node.NewTransfer(n.ChainID(), aliceKey, bob, 10, 1, 0))
+//	b, _ := n.Mine("")
+//
+// The node binaries in this repository keep their state in package
+// globals, so they cannot be instantiated more than once per process.
+// Node follows the PoW node's base rules without its forks, contracts
+// and networking: block and transaction hashes, transaction signing
+// digests, the transaction and state roots and coinbase maturity come
+// from package powcore, shared with the PoW node; nonces and fee-ordered
+// block assembly mirror it. Who may extend the chain and how a block
+// proves it is up to a ConsensusEngine (see engine.go): "pow" by
+// default, or one added with RegisterEngine or LoadEnginePlugin.
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"alirezachain/chainhash"
+	"alirezachain/clock"
+)
+
+// Config describes a chain. The zero value is a usable chain with no
+// allocations that mines only when asked to.
+type Config struct {
+	Difficulty  int               // pow: leading zero bits of every block hash, 1 to 255 (default 8)
+	Reward      uint64            // block reward (default 50, as the PoW node)
+	Miner       string            // receives the coinbase if the engine names no proposer (default "miner")
+	Maturity    int               // confirmations a coinbase needs to be spent (default 10)
+	Alloc       map[string]uint64 // genesis balances by address
+	GenesisTime time.Time         // genesis timestamp (default the clock's time)
+	BlockTime   time.Duration     // once started, mine a block this often; 0 mines only on Mine
+	MaxMempool  int               // pending transfers held at most (default 5000)
+	MaxBlockTxs int               // transfers per block at most (default 100)
+	Clock       clock.Clock       // time source and miner timer (default clock.Real)
+	Hasher      chainhash.Hasher  // block hash algorithm (default chainhash.Default)
+	ChainID     string            // network transfers are signed for (default the genesis hash)
+
+	Engine string // consensus engine, by registered name (default "pow")
+}
+
+// Kinds of Event.
+const (
+	EventTx    = "tx"    // a transfer entered the mempool
+	EventBlock = "block" // a block was appended to the chain
+)
+
+// Event reports a change to the node: Tx is set for EventTx, Block for
+// EventBlock.
+type Event struct {
+	Type  string       `json:"type"`
+	Block *Block       `json:"block,omitempty"`
+	Tx    *Transaction `json:"tx,omitempty"`
+}
+
+// eventQueue is the buffer of every Events channel. Events that do not
+// fit are dropped, so a slow reader cannot stall the node.
+const eventQueue = 256
+
+type mempoolEntry struct {
+	Tx    Transaction
+	Added time.Time
+}
+
+// Node is an in-process chain. Its methods are safe for concurrent use.
+type Node struct {
+	cfg    Config
+	engine ConsensusEngine
+	err    error // invalid configuration, reported by Start, Mine and AddBlock
+
+	mu      sync.Mutex
+	chain   []Block
+	mempool map[string]*mempoolEntry
+	subs    map[chan Event]struct{}
+	started bool
+}
+
+// New returns a node holding only the genesis block of cfg. Nothing runs
+// until Start; an invalid configuration is reported by Start.
+func New(cfg Config) *Node {
+	if cfg.Difficulty == 0 {
+		cfg.Difficulty = 8
+	}
+	if cfg.Reward == 0 {
+		cfg.Reward = 50
+	}
+	if cfg.Miner == "" {
+		cfg.Miner = "miner"
+	}
+	if cfg.Maturity <= 0 {
+		cfg.Maturity = 10
+	}
+	if cfg.MaxMempool <= 0 {
+		cfg.MaxMempool = 5000
+	}
+	if cfg.MaxBlockTxs <= 0 {
+		cfg.MaxBlockTxs = 100
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+	if cfg.Hasher == nil {
+		cfg.Hasher = chainhash.Default
+	}
+	if cfg.GenesisTime.IsZero() {
+		cfg.GenesisTime = cfg.Clock.Now()
+	}
+	if cfg.Engine == "" {
+		cfg.Engine = "pow"
+	}
+
+	n := &Node{
+		cfg:     cfg,
+		mempool: make(map[string]*mempoolEntry),
+		subs:    make(map[chan Event]struct{}),
+	}
+	n.engine, n.err = newEngine(cfg)
+	if n.err == nil && cfg.BlockTime < 0 {
+		n.err = errors.New("block time must not be negative")
+	}
+	n.chain = []Block{n.genesis()}
+	if n.cfg.ChainID == "" {
+		n.cfg.ChainID = n.chain[0].Hash
+	}
+	return n
+}
+
+// ChainID returns the network transfers to this node must be signed
+// for.
+func (n *Node) ChainID() string {
+	return n.cfg.ChainID
+}
+
+// genesis builds the genesis block, allocations in address order.
+func (n *Node) genesis() Block {
+	addrs := make([]string, 0, len(n.cfg.Alloc))
+	for addr, amount := range n.cfg.Alloc {
+		if addr != "" && amount > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	g := Block{
+		Timestamp:  n.cfg.GenesisTime.Unix(),
+		Data:       "Genesis ⛓️ AlirezaChain PoW",
+		Difficulty: 1,
+		Version:    blockVersion,
+	}
+	state := make(ledger)
+	for _, addr := range addrs {
+		tx := Transaction{To: addr, Amount: n.cfg.Alloc[addr]}
+		tx.ID = txHash(tx)
+		g.Transactions = append(g.Transactions, tx)
+		state.account(addr).Spendable += tx.Amount
+	}
+	g.TxRoot = merkleRoot(g.Transactions)
+	g.StateRoot = state.root()
+	g.Hash = calculateHash(n.cfg.Hasher, g)
+	return g
+}
+
+// Start starts the miner if Config.BlockTime is set; it stops when ctx
+// is done. Submit, Mine and AddBlock work whether the node is started
+// or not. A node can be started once.
+func (n *Node) Start(ctx context.Context) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.mu.Lock()
+	if n.started {
+		n.mu.Unlock()
+		return errors.New("node already started")
+	}
+	n.started = true
+	n.mu.Unlock()
+
+	if n.cfg.BlockTime > 0 {
+		n.scheduleMining(ctx)
+	}
+	return nil
+}
+
+// scheduleMining mines a block every BlockTime on the node's clock until
+// ctx is done.
+func (n *Node) scheduleMining(ctx context.Context) {
+	n.cfg.Clock.AfterFunc(n.cfg.BlockTime, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		_, _ = n.Mine("")
+		n.scheduleMining(ctx)
+	})
+}
+
+// Head returns the tip of the chain.
+func (n *Node) Head() Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.chain[len(n.chain)-1]
+}
+
+// Chain returns a copy of the chain, genesis first.
+func (n *Node) Chain() []Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Block(nil), n.chain...)
+}
+
+// Balance returns the balance of addr as seen by the next block.
+func (n *Node) Balance(addr string) Balance {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if b, ok := replay(n.chain, n.cfg.Maturity)[addr]; ok {
+		return *b
+	}
+	return Balance{Address: addr}
+}
+
+// Mempool returns the pending transfers in the order the miner picks
+// them: highest fee first, then oldest.
+func (n *Node) Mempool() []Transaction {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	entries := n.sortedMempool()
+	txs := make([]Transaction, len(entries))
+	for i, e := range entries {
+		txs[i] = e.Tx
+	}
+	return txs
+}
+
+// Events returns a channel receiving the node's events from now on. It
+// is closed when ctx is done.
+func (n *Node) Events(ctx context.Context) <-chan Event {
+	ch := make(chan Event, eventQueue)
+	n.mu.Lock()
+	n.subs[ch] = struct{}{}
+	n.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		n.mu.Lock()
+		delete(n.subs, ch)
+		close(ch)
+		n.mu.Unlock()
+	}()
+	return ch
+}
+
+// publish sends ev to every Events channel with room for it. Callers
+// must hold mu.
+func (n *Node) publish(ev Event) {
+	for ch := range n.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Submit adds a signed transfer to the mempool. It must spend the
+// sender's next nonce after its pending transfers, and the sender must
+// be able to cover it.
+func (n *Node) Submit(tx Transaction) error {
+	if err := checkTransfer(tx, n.cfg.ChainID); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.mempool[tx.ID]; ok {
+		return errors.New("transaction already in the mempool")
+	}
+	if len(n.mempool) >= n.cfg.MaxMempool {
+		return errors.New("mempool is full")
+	}
+	state := replay(n.chain, n.cfg.Maturity)
+	for _, e := range n.pendingBySender() {
+		if e.Tx.From == tx.From {
+			_ = state.applyTransfer(e.Tx)
+		}
+	}
+	if err := state.applyTransfer(tx); err != nil {
+		return err
+	}
+	n.mempool[tx.ID] = &mempoolEntry{Tx: tx, Added: n.cfg.Clock.Now()}
+	n.publish(Event{Type: EventTx, Tx: &tx})
+	return nil
+}
+
+// Mine assembles a block from the mempool on top of the tip, has the
+// engine prepare and seal it, and appends it. The node is not locked
+// while the block is sealed, so Mine fails if the chain moved on in the
+// meantime.
+func (n *Node) Mine(data string) (Block, error) {
+	if n.err != nil {
+		return Block{}, n.err
+	}
+	b, err := n.assemble(data)
+	if err != nil {
+		return Block{}, err
+	}
+	if err := n.engine.Seal(&b); err != nil {
+		return Block{}, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if tip := n.chain[len(n.chain)-1]; tip.Hash != b.PrevHash {
+		return Block{}, fmt.Errorf("chain moved on to height %d while block %d was sealed", tip.Height, b.Height)
+	}
+	n.appendBlock(b)
+	return b, nil
+}
+
+// assemble builds the next block on the tip from the mempool and has the
+// engine prepare it.
+func (n *Node) assemble(data string) (Block, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	tip := n.chain[len(n.chain)-1]
+	proposer, err := n.engine.SelectProposer(n.chain)
+	if err != nil {
+		return Block{}, err
+	}
+	if proposer == "" {
+		proposer = n.cfg.Miner
+	}
+	base := replay(n.chain, n.cfg.Maturity)
+	txs := n.selectTransactions(base)
+	var fees uint64
+	for _, tx := range txs {
+		fees += tx.Fee
+	}
+	cb := Transaction{To: proposer, Amount: n.cfg.Reward + fees, Nonce: uint64(tip.Height + 1)}
+	cb.ID = txHash(cb)
+	txs = append([]Transaction{cb}, txs...)
+	post := base.clone()
+	_ = post.applyBlock(txs)
+
+	b := Block{
+		Height:       tip.Height + 1,
+		Timestamp:    n.cfg.Clock.Now().Unix(),
+		Data:         data,
+		PrevHash:     tip.Hash,
+		TxRoot:       merkleRoot(txs),
+		StateRoot:    post.root(),
+		Transactions: txs,
+		Version:      blockVersion,
+	}
+	if err := n.engine.Prepare(n.chain, &b); err != nil {
+		return Block{}, err
+	}
+	return b, nil
+}
+
+// AddBlock validates a block sealed elsewhere against the tip and
+// appends it.
+func (n *Node) AddBlock(b Block) error {
+	if n.err != nil {
+		return n.err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	tip := n.chain[len(n.chain)-1]
+	if b.Height != tip.Height+1 || b.PrevHash != tip.Hash {
+		return errors.New("block does not extend the tip")
+	}
+	if err := n.engine.VerifyHeader(n.chain, b); err != nil {
+		return err
+	}
+	proposer, err := n.engine.SelectProposer(n.chain)
+	if err != nil {
+		return err
+	}
+	if err := n.checkTransactions(b, proposer); err != nil {
+		return err
+	}
+	post := replay(n.chain, n.cfg.Maturity)
+	if err := post.applyBlock(b.Transactions); err != nil {
+		return err
+	}
+	if post.root() != b.StateRoot {
+		return errors.New("state root does not match the state after applying the block")
+	}
+	n.appendBlock(b)
+	return nil
+}
+
+// checkTransactions checks the transaction list of b: one coinbase
+// first paying the reward plus fees, to proposer unless that is "", then
+// signed transfers, all committed to by the transaction root.
+func (n *Node) checkTransactions(b Block, proposer string) error {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction must be the coinbase")
+	}
+	var fees uint64
+	for i, tx := range b.Transactions[1:] {
+		if err := checkTransfer(tx, n.cfg.ChainID); err != nil {
+			return fmt.Errorf("transaction %d: %v", i+1, err)
+		}
+		fees += tx.Fee
+	}
+	cb := b.Transactions[0]
+	if cb.To == "" || cb.Nonce != uint64(b.Height) || cb.ID != txHash(cb) {
+		return errors.New("malformed coinbase")
+	}
+	if proposer != "" && cb.To != proposer {
+		return fmt.Errorf("coinbase pays %s, not the proposer %s", cb.To, proposer)
+	}
+	if want := n.cfg.Reward + fees; cb.Amount != want {
+		return fmt.Errorf("coinbase pays %d, expected %d", cb.Amount, want)
+	}
+	if b.TxRoot != merkleRoot(b.Transactions) {
+		return errors.New("transaction root mismatch")
+	}
+	return nil
+}
+
+// appendBlock appends b, drops its transfers and those it invalidated
+// from the mempool and publishes it. Callers must hold mu.
+func (n *Node) appendBlock(b Block) {
+	n.chain = append(n.chain, b)
+	for _, tx := range b.Transactions {
+		delete(n.mempool, tx.ID)
+	}
+	state := replay(n.chain, n.cfg.Maturity)
+	for _, e := range n.pendingBySender() {
+		if state.applyTransfer(e.Tx) != nil {
+			delete(n.mempool, e.Tx.ID)
+		}
+	}
+	n.publish(Event{Type: EventBlock, Block: &b})
+}
+
+// selectTransactions picks transfers in fee order that apply on top of
+// base, in several passes so that a sender's later nonces follow its
+// earlier ones. Callers must hold mu.
+func (n *Node) selectTransactions(base ledger) []Transaction {
+	work := base.clone()
+	picked := make(map[string]bool)
+	var selected []Transaction
+	candidates := n.sortedMempool()
+	for progress := true; progress && len(selected) < n.cfg.MaxBlockTxs; {
+		progress = false
+		for _, e := range candidates {
+			if picked[e.Tx.ID] || len(selected) >= n.cfg.MaxBlockTxs {
+				continue
+			}
+			if work.applyTransfer(e.Tx) == nil {
+				picked[e.Tx.ID] = true
+				selected = append(selected, e.Tx)
+				progress = true
+			}
+		}
+	}
+	return selected
+}
+
+// sortedMempool returns the mempool by fee, highest first, then by age
+// and ID. Callers must hold mu.
+func (n *Node) sortedMempool() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(n.mempool))
+	for _, e := range n.mempool {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Tx.Fee != b.Tx.Fee {
+			return a.Tx.Fee > b.Tx.Fee
+		}
+		if !a.Added.Equal(b.Added) {
+			return a.Added.Before(b.Added)
+		}
+		return a.Tx.ID < b.Tx.ID
+	})
+	return entries
+}
+
+// pendingBySender returns the mempool by sender and nonce. Callers must
+// hold mu.
+func (n *Node) pendingBySender() []*mempoolEntry {
+	entries := make([]*mempoolEntry, 0, len(n.mempool))
+	for _, e := range n.mempool {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Tx, entries[j].Tx
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.Nonce < b.Nonce
+	})
+	return entries
+}
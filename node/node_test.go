@@ -0,0 +1,256 @@
+package node
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"alirezachain/sim"
+)
+
+// proposerEngine is the pow engine with a fixed proposer, so that the
+// tests also cover engines that name who is paid the coinbase.
+type proposerEngine struct{ ConsensusEngine }
+
+func (proposerEngine) SelectProposer([]Block) (string, error) { return "validator", nil }
+
+// gatedEngine is the pow engine whose Seal reports on sealing and waits
+// for release.
+type gatedEngine struct{ ConsensusEngine }
+
+var sealing, release chan struct{}
+
+func (e gatedEngine) Seal(b *Block) error {
+	sealing <- struct{}{}
+	<-release
+	return e.ConsensusEngine.Seal(b)
+}
+
+func init() {
+	RegisterEngine("test-proposer", func(cfg Config) (ConsensusEngine, error) {
+		pow, err := newPowEngine(cfg)
+		return proposerEngine{pow}, err
+	})
+	RegisterEngine("test-gated", func(cfg Config) (ConsensusEngine, error) {
+		pow, err := newPowEngine(cfg)
+		return gatedEngine{pow}, err
+	})
+}
+
+// testKey returns the key derived from a one-byte seed and its address.
+func testKey(b byte) (ed25519.PrivateKey, string) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = b
+	key := ed25519.NewKeyFromSeed(seed)
+	return key, hex.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// newTestNode returns a node that funds alice with 1000, on a simulated
+// clock so that two nodes of the same engine share their genesis.
+func newTestNode(t *testing.T, engine string) *Node {
+	t.Helper()
+	_, alice := testKey(1)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := New(Config{Engine: engine, Alloc: map[string]uint64{alice: 1000}, Clock: sim.NewClock(start)})
+	if n.err != nil {
+		t.Fatal(n.err)
+	}
+	return n
+}
+
+var testEngines = []struct {
+	engine, payee string
+}{
+	{"pow", "miner"},
+	{"test-proposer", "validator"},
+}
+
+func TestMine(t *testing.T) {
+	aliceKey, alice := testKey(1)
+	_, bob := testKey(2)
+	for _, te := range testEngines {
+		n := newTestNode(t, te.engine)
+		if err := n.Submit(NewTransfer(n.ChainID(), aliceKey, bob, 10, 1, 0)); err != nil {
+			t.Fatalf("%s: submit: %v", te.engine, err)
+		}
+		b, err := n.Mine("block 1")
+		if err != nil {
+			t.Fatalf("%s: mine: %v", te.engine, err)
+		}
+		if len(b.Transactions) != 2 || b.Transactions[0].To != te.payee || b.Transactions[0].Amount != 51 {
+			t.Errorf("%s: transactions %+v, want a coinbase of 51 to %s and the transfer", te.engine, b.Transactions, te.payee)
+		}
+		if err := n.engine.VerifyHeader(n.Chain()[:1], b); err != nil {
+			t.Errorf("%s: mined block fails its own engine: %v", te.engine, err)
+		}
+		if got := n.Balance(alice); got.Spendable != 989 || got.Nonce != 1 {
+			t.Errorf("%s: alice %+v after the transfer", te.engine, got)
+		}
+		if got := n.Balance(te.payee); got.Immature != 51 {
+			t.Errorf("%s: %s %+v, want 51 immature", te.engine, te.payee, got)
+		}
+		if len(n.Mempool()) != 0 {
+			t.Errorf("%s: mined transfer still in the mempool", te.engine)
+		}
+
+		peer := newTestNode(t, te.engine)
+		if err := peer.AddBlock(b); err != nil {
+			t.Errorf("%s: peer rejects the block: %v", te.engine, err)
+		}
+		if peer.Head().Hash != b.Hash {
+			t.Errorf("%s: peer did not append the block", te.engine)
+		}
+	}
+}
+
+func TestAddBlockRejected(t *testing.T) {
+	aliceKey, _ := testKey(1)
+	_, bob := testKey(2)
+	tests := []struct {
+		name   string
+		engine string // "" for every engine
+		change func(n *Node, b *Block)
+		want   string
+	}{
+		{"not on the tip", "", func(n *Node, b *Block) { b.PrevHash = strings.Repeat("0", 64) }, "does not extend the tip"},
+		{"changed after sealing", "", func(n *Node, b *Block) { b.Data = "forged" }, "fails proof of work"},
+		{"validator on a pow block", "", func(n *Node, b *Block) { b.Validator = "v1" }, "no validator"},
+		{"coinbase too large", "", func(n *Node, b *Block) {
+			b.Transactions[0].Amount++
+			b.Transactions[0].ID = txHash(b.Transactions[0])
+			reseal(n, b)
+		}, "coinbase pays 52, expected 51"},
+		{"signed for another chain", "", func(n *Node, b *Block) {
+			b.Transactions[1] = NewTransfer("other", aliceKey, bob, 10, 1, 0)
+			reseal(n, b)
+		}, "invalid signature"},
+		{"state root", "", func(n *Node, b *Block) {
+			b.StateRoot = strings.Repeat("0", 64)
+			reseal(n, b)
+		}, "state root"},
+		{"coinbase to another payee", "test-proposer", func(n *Node, b *Block) {
+			b.Transactions[0].To = "miner"
+			b.Transactions[0].ID = txHash(b.Transactions[0])
+			reseal(n, b)
+		}, "not the proposer validator"},
+	}
+	for _, te := range testEngines {
+		for _, tt := range tests {
+			if tt.engine != "" && tt.engine != te.engine {
+				continue
+			}
+			miner := newTestNode(t, te.engine)
+			if err := miner.Submit(NewTransfer(miner.ChainID(), aliceKey, bob, 10, 1, 0)); err != nil {
+				t.Fatal(err)
+			}
+			b, err := miner.Mine("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer := newTestNode(t, te.engine)
+			tt.change(peer, &b)
+			err = peer.AddBlock(b)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s, %s: got %v, want an error containing %q", te.engine, tt.name, err, tt.want)
+			}
+			if peer.Head().Height != 0 {
+				t.Errorf("%s, %s: rejected block was appended", te.engine, tt.name)
+			}
+		}
+	}
+}
+
+// reseal recomputes the transaction root of b and seals it again with
+// n's engine, so that only the change under test is wrong.
+func reseal(n *Node, b *Block) {
+	b.TxRoot = merkleRoot(b.Transactions)
+	_ = n.engine.Seal(b)
+}
+
+func TestPowVerifyHeader(t *testing.T) {
+	n := newTestNode(t, "pow")
+	b, err := n.Mine("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	easy := b
+	easy.Difficulty = 4
+	_ = n.engine.Seal(&easy)
+
+	tests := []struct {
+		name  string
+		block Block
+		want  string
+	}{
+		{"sealed", b, ""},
+		{"below the minimum difficulty", easy, "below the minimum of 8"},
+		{"nonce changed", func() Block { c := b; c.Nonce++; return c }(), "fails proof of work"},
+		{"hash replaced", func() Block { c := b; c.Hash = strings.Repeat("0", 64); return c }(), "fails proof of work"},
+		{"signed", func() Block { c := b; c.Signature = "00"; return c }(), "no validator or signature"},
+	}
+	for _, tt := range tests {
+		err := n.engine.VerifyHeader(n.Chain()[:1], tt.block)
+		if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("%s: got %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestMineUnlockedWhileSealing checks that a slow seal does not block
+// the node, and that Mine drops its block if the chain moved on.
+func TestMineUnlockedWhileSealing(t *testing.T) {
+	aliceKey, _ := testKey(1)
+	_, bob := testKey(2)
+	sealing, release = make(chan struct{}), make(chan struct{})
+	n := newTestNode(t, "test-gated")
+	peer := newTestNode(t, "pow")
+	other, err := peer.Mine("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := n.Mine("slow")
+		done <- err
+	}()
+	<-sealing
+	if err := n.Submit(NewTransfer(n.ChainID(), aliceKey, bob, 10, 1, 0)); err != nil {
+		t.Errorf("submit while sealing: %v", err)
+	}
+	if err := n.AddBlock(other); err != nil {
+		t.Fatalf("add block while sealing: %v", err)
+	}
+	close(release)
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "moved on") {
+		t.Errorf("mine on a stale tip: %v", err)
+	}
+	if n.Head().Hash != other.Hash || len(n.Chain()) != 2 {
+		t.Errorf("chain %+v, want genesis and the added block", n.Chain())
+	}
+}
+
+func TestChainID(t *testing.T) {
+	aliceKey, _ := testKey(1)
+	_, bob := testKey(2)
+	n := newTestNode(t, "pow")
+	if n.ChainID() != n.Chain()[0].Hash {
+		t.Errorf("chain ID %s, want the genesis hash", n.ChainID())
+	}
+	for _, other := range []string{"", "testnet"} {
+		err := n.Submit(NewTransfer(other, aliceKey, bob, 10, 1, 0))
+		if err == nil || !strings.Contains(err.Error(), "invalid signature") {
+			t.Errorf("transfer signed for %q: %v", other, err)
+		}
+	}
+	if err := n.Submit(NewTransfer(n.ChainID(), aliceKey, bob, 10, 1, 0)); err != nil {
+		t.Errorf("transfer signed for the chain: %v", err)
+	}
+
+	named := New(Config{ChainID: "testnet"})
+	if named.ChainID() != "testnet" {
+		t.Errorf("configured chain ID %q", named.ChainID())
+	}
+}
@@ -0,0 +1,53 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+
+	"alirezachain/chainhash"
+)
+
+// powEngine is the PoW node's consensus: anyone may mine, and a block
+// is valid when its hash has Config.Difficulty leading zero bits or
+// more.
+type powEngine struct {
+	difficulty int
+	hasher     chainhash.Hasher
+}
+
+func newPowEngine(cfg Config) (ConsensusEngine, error) {
+	if cfg.Difficulty < 1 || cfg.Difficulty >= cfg.Hasher.Size()*8 {
+		return nil, fmt.Errorf("difficulty must be between 1 and %d", cfg.Hasher.Size()*8-1)
+	}
+	return &powEngine{difficulty: cfg.Difficulty, hasher: cfg.Hasher}, nil
+}
+
+func (e *powEngine) SelectProposer([]Block) (string, error) {
+	return "", nil
+}
+
+func (e *powEngine) Prepare(_ []Block, b *Block) error {
+	b.Difficulty = e.difficulty
+	return nil
+}
+
+// Seal searches nonces from zero until the hash meets the difficulty.
+func (e *powEngine) Seal(b *Block) error {
+	b.Nonce = 0
+	for b.Hash = calculateHash(e.hasher, *b); !meetsDifficulty(e.hasher, b.Hash, b.Difficulty); b.Hash = calculateHash(e.hasher, *b) {
+		b.Nonce++
+	}
+	return nil
+}
+
+func (e *powEngine) VerifyHeader(_ []Block, b Block) error {
+	switch {
+	case b.Validator != "" || b.Signature != "":
+		return errors.New("proof-of-work blocks carry no validator or signature")
+	case b.Difficulty < e.difficulty:
+		return fmt.Errorf("difficulty %d is below the minimum of %d", b.Difficulty, e.difficulty)
+	case calculateHash(e.hasher, b) != b.Hash || !meetsDifficulty(e.hasher, b.Hash, b.Difficulty):
+		return errors.New("block fails proof of work")
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"alirezachain/codec"
+	"alirezachain/httpapi"
+	"alirezachain/middleware"
+	"github.com/gorilla/mux"
+)
+
+// API versioning. Every route is served under apiPrefix. The unversioned
+// paths keep working during a deprecation window, but their responses
+// carry a Deprecation header, a Link to the /v1 path and, once a removal
+// date is set (API_SUNSET), a Sunset header.
+
+// apiPrefix is the path prefix of the current API version.
+const apiPrefix = "/v1"
+
+var (
+	// apiSunset is when the unversioned paths go away, as an HTTP date;
+	// "" if no date is set.
+	apiSunset string
+
+	// deprecatedSeen remembers which unversioned paths were logged.
+	deprecatedMu   sync.Mutex
+	deprecatedSeen = make(map[string]bool)
+
+	// httpMiddleware is the configured middleware stack (HTTP_MIDDLEWARE),
+	// outermost first.
+	httpMiddleware []mux.MiddlewareFunc
+
+	// bodyLimits caps request bodies per route (MAX_BODY_BYTES,
+	// BODY_LIMITS).
+	bodyLimits middleware.BodyLimits
+
+	// peerAuth holds the tokens of peer pushes (PEER_TOKEN, PEER_TOKENS).
+	peerAuth middleware.PeerAuth
+)
+
+// loadAPIVersioning reads API_SUNSET, a date such as 2027-06-30.
+func loadAPIVersioning() error {
+	v := os.Getenv("API_SUNSET")
+	if v == "" {
+		return nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return fmt.Errorf("invalid API_SUNSET %q: want a date like 2027-06-30", v)
+	}
+	apiSunset = t.UTC().Format(http.TimeFormat)
+	return nil
+}
+
+// defaultBodyLimits caps request bodies at 1 MiB, a chain to verify at
+// 64 MiB and the routes carrying payloads at what payloadLimit allows:
+// twice that for base64 in JSON, and 1000 times that for a batch.
+func defaultBodyLimits() middleware.BodyLimits {
+	payload := int64(payloadLimit())
+	return middleware.BodyLimits{
+		Default: 1 << 20,
+		Routes: map[string]int64{
+			"/chain/verify": 64 << 20,
+			"/push":         2*payload + 64<<10,
+			"/push/blob":    payload + 1,
+			"/push/batch":   maxBatchItems * 2 * payload,
+			"/announce":     2*payload + 64<<10,
+		},
+	}
+}
+
+// loadHTTPMiddleware builds the middleware stack, the request body
+// limits and the peer tokens from the environment (see package
+// middleware).
+func loadHTTPMiddleware() error {
+	cfg, err := middleware.FromEnv()
+	if err != nil {
+		return err
+	}
+	if httpMiddleware, err = middleware.Build(cfg); err != nil {
+		return err
+	}
+	if bodyLimits, err = middleware.LoadBodyLimits(defaultBodyLimits()); err != nil {
+		return err
+	}
+	peerAuth, err = middleware.LoadPeerAuth()
+	return err
+}
+
+// versioned mounts the routes added by register under apiPrefix and,
+// marked deprecated, at their unversioned paths, behind the request ID,
+// the configured middleware and the body limits.
+func versioned(register func(r *mux.Router)) http.Handler {
+	r := mux.NewRouter()
+	r.Use(bodyLimits.Middleware(apiPrefix))
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpapi.WriteError(w, "no such endpoint: "+r.URL.Path, http.StatusNotFound)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httpapi.WriteError(w, r.Method+" is not allowed on "+r.URL.Path, http.StatusMethodNotAllowed)
+	})
+	register(r.PathPrefix(apiPrefix).Subrouter())
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecatedPath)
+	register(legacy)
+	return httpapi.WithRequestID(middleware.Wrap(r, httpMiddleware))
+}
+
+// deprecatedPath marks a response to an unversioned path as deprecated
+// and logs the first use of each route.
+func deprecatedPath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", apiPrefix, r.URL.Path))
+		if apiSunset != "" {
+			w.Header().Set("Sunset", apiSunset)
+		}
+
+		route := r.URL.Path
+		if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+			route = tmpl
+		}
+		deprecatedMu.Lock()
+		first := !deprecatedSeen[route]
+		deprecatedSeen[route] = true
+		deprecatedMu.Unlock()
+		if first {
+			log.Printf("⚠️  Deprecated unversioned path %s %s used; switch to %s%s", r.Method, route, apiPrefix, route)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeNegotiated replies with v in the format the Accept header asks
+// for and the casing ?casing= asks for (see package codec); name is v's
+// protobuf message (GET /proto).
+func writeNegotiated(w http.ResponseWriter, r *http.Request, name string, v interface{}) {
+	switch err := codec.Write(w, r, name, v); {
+	case errors.Is(err, codec.ErrNotAcceptable):
+		httpapi.WriteError(w, err.Error(), http.StatusNotAcceptable)
+	case errors.Is(err, codec.ErrUnknownCasing):
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		httpapi.LogRequest(r, "⚠️ could not encode %s: %v", name, err)
+	}
+}
+
+// protoHandler serves the protobuf schema of the responses
+// writeNegotiated sends as application/x-protobuf.
+func protoHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = io.WriteString(w, codec.Schema(protoMessages))
+}
+
+// VersionedChain is the chain as served to peers of protocol version 2
+// and up; version 1 peers get the bare list of blocks.
+type VersionedChain struct {
+	Version int         `json:"version"`
+	Chain   []BlockView `json:"chain"`
+}
+
+// protoMessages are the messages of GET /proto, by name.
+var protoMessages = map[string]interface{}{
+	"Chain":          []BlockView{},
+	"VersionedChain": VersionedChain{},
+	"Blocks":         []BlockView{},
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestProtoSnapshot pins GET /proto to proto/p2p.proto: a change to a
+// response type must come with a new snapshot (alimiad compat -write).
+func TestProtoSnapshot(t *testing.T) {
+	want, err := os.ReadFile("../proto/p2p.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	protoHandler(rec, httptest.NewRequest(http.MethodGet, "/proto", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /proto: %d", rec.Code)
+	}
+	if got := rec.Body.String(); got != string(want) {
+		t.Errorf("GET /proto does not match proto/p2p.proto:\n%s", got)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// maxBatchItems caps the number of items in one batch request.
+const maxBatchItems = 1000
+
+// BatchItemError reports why one item of a rejected batch failed.
+type BatchItemError struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+// writeBatchErrors rejects a whole batch, listing the failed items in
+// the error details.
+func writeBatchErrors(w http.ResponseWriter, items []BatchItemError) {
+	httpapi.WriteErrorDetails(w, http.StatusBadRequest, httpapi.APIError{
+		Code:    "batch_rejected",
+		Message: fmt.Sprintf("batch rejected: %d of its items failed", len(items)),
+		Details: map[string][]BatchItemError{"items": items},
+	})
+}
+
+// pushBatchHandler appends one block per item of an array of
+// {data, extra, payload, contentType} items, all or nothing: if any item is invalid no
+// block is created and every failure is reported by its index.
+func pushBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var items []struct {
+		Data  json.RawMessage   `json:"data"`
+		Extra map[string]string `json:"extra"`
+		Blob
+		EncryptTo string `json:"encryptTo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		httpapi.WriteError(w, "invalid payload: want an array of {data, extra}", http.StatusBadRequest)
+		return
+	}
+	if len(items) == 0 || len(items) > maxBatchItems {
+		httpapi.WriteError(w, fmt.Sprintf("a batch holds 1 to %d items", maxBatchItems), http.StatusBadRequest)
+		return
+	}
+	if minBlockInterval > 0 && len(items) > 1 {
+		httpapi.WriteError(w, "MIN_BLOCK_INTERVAL is set, so a batch can create only one block", http.StatusBadRequest)
+		return
+	}
+
+	var failed []BatchItemError
+	data := make([]string, len(items))
+	for i, it := range items {
+		var err error
+		if data[i], err = parseData(it.Data); err != nil {
+			failed = append(failed, BatchItemError{Index: i, Error: err.Error()})
+		} else if strings.TrimSpace(data[i]) == "" && len(it.Payload) == 0 && it.CID == "" {
+			failed = append(failed, BatchItemError{Index: i, Error: "data or payload is required"})
+		} else if err := checkHeader(blockVersion, it.Extra); err != nil {
+			failed = append(failed, BatchItemError{Index: i, Error: err.Error()})
+		} else if items[i].Blob, _, err = prepareBlob(it.Blob, it.EncryptTo); err != nil {
+			failed = append(failed, BatchItemError{Index: i, Error: err.Error()})
+		}
+	}
+	if len(failed) > 0 {
+		writeBatchErrors(w, failed)
+		return
+	}
+
+	mu.Lock()
+	last := ledger[len(ledger)-1]
+	if wait := blockWait(last); wait > 0 {
+		mu.Unlock()
+		writeTooSoon(w, wait)
+		return
+	}
+	blocks := make([]ChainBlock, 0, len(items))
+	for i, it := range items {
+		nb := newBlock(last, data[i], it.Blob, it.Extra)
+		if !isBlockValid(nb, last) {
+			mu.Unlock()
+			httpapi.WriteError(w, "new block is not valid", http.StatusInternalServerError)
+			return
+		}
+		blocks = append(blocks, nb)
+		last = nb
+	}
+	ledger = append(ledger, blocks...)
+	notifyTip()
+	mu.Unlock()
+
+	httpapi.LogRequest(r, "🧱 New local blocks: heights %d-%d", blocks[0].Height, last.Height)
+	id := httpapi.RequestID(r)
+	clk.AfterFunc(0, func() {
+		for _, b := range blocks {
+			announceBlock(b, id)
+		}
+	})
+
+	views := make([]BlockView, len(blocks))
+	for i, b := range blocks {
+		views[i] = toView(b)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(views)
+}
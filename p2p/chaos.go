@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// chaosTransport degrades outgoing peer requests on purpose, to observe
+// how sync and fork choice cope with a bad network. It adds latency,
+// drops requests and makes peers flap between reachable and unreachable.
+type chaosTransport struct {
+	base http.RoundTripper
+
+	latency  time.Duration // added to every request (CHAOS_LATENCY)
+	jitter   time.Duration // extra random delay, up to this much (CHAOS_JITTER)
+	loss     float64       // probability a request is lost (CHAOS_LOSS)
+	flapEach time.Duration // how often peer reachability is re-rolled (CHAOS_FLAP_INTERVAL)
+	flapProb float64       // probability a peer is down after a re-roll (CHAOS_FLAP_PROB)
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	down   map[string]bool
+	rolled time.Time
+}
+
+// loadChaos wraps the transport of peerClient in the chaos transport if
+// any CHAOS_* variable is set. CHAOS_SEED makes runs reproducible.
+func loadChaos() (bool, error) {
+	t := &chaosTransport{base: peerClient.Transport, flapProb: 0.5, down: make(map[string]bool)}
+	enabled := false
+
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"CHAOS_LATENCY", &t.latency},
+		{"CHAOS_JITTER", &t.jitter},
+		{"CHAOS_FLAP_INTERVAL", &t.flapEach},
+	}
+	for _, d := range durations {
+		if v := os.Getenv(d.env); v != "" {
+			dur, err := time.ParseDuration(v)
+			if err != nil || dur < 0 {
+				return false, fmt.Errorf("invalid %s %q", d.env, v)
+			}
+			*d.dst = dur
+			enabled = true
+		}
+	}
+	probs := []struct {
+		env string
+		dst *float64
+	}{
+		{"CHAOS_LOSS", &t.loss},
+		{"CHAOS_FLAP_PROB", &t.flapProb},
+	}
+	for _, p := range probs {
+		if v := os.Getenv(p.env); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 || f > 1 {
+				return false, fmt.Errorf("invalid %s %q: must be between 0 and 1", p.env, v)
+			}
+			*p.dst = f
+			enabled = true
+		}
+	}
+	if !enabled {
+		return false, nil
+	}
+
+	seed := clk.Now().UnixNano()
+	if v := os.Getenv("CHAOS_SEED"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid CHAOS_SEED %q", v)
+		}
+		seed = n
+	}
+	t.rng = rand.New(rand.NewSource(seed))
+	t.rolled = clk.Now()
+	peerClient = &http.Client{Transport: t, Timeout: peerClient.Timeout}
+	return true, nil
+}
+
+// RoundTrip delays, drops or refuses the request as configured.
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	t.mu.Lock()
+	if t.flapEach > 0 {
+		if now := clk.Now(); now.Sub(t.rolled) >= t.flapEach {
+			for h := range t.down {
+				t.down[h] = t.rng.Float64() < t.flapProb
+			}
+			t.rolled = now
+		}
+		if _, seen := t.down[host]; !seen {
+			t.down[host] = t.rng.Float64() < t.flapProb
+		}
+	}
+	down := t.down[host]
+	lost := t.loss > 0 && t.rng.Float64() < t.loss
+	delay := t.latency
+	if t.jitter > 0 {
+		delay += time.Duration(t.rng.Int63n(int64(t.jitter) + 1))
+	}
+	t.mu.Unlock()
+
+	if down {
+		return nil, fmt.Errorf("chaos: peer %s is down", host)
+	}
+	if delay > 0 {
+		done := make(chan struct{})
+		clk.AfterFunc(delay, func() { close(done) })
+		<-done
+	}
+	if lost {
+		return nil, fmt.Errorf("chaos: request to %s lost", host)
+	}
+	return t.base.RoundTrip(req)
+}
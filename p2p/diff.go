@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// maxLocatorHashes caps the hashes GET /chain/diff accepts, as Bitcoin
+// caps block locators.
+const maxLocatorHashes = 101
+
+// ChainDiff answers GET /chain/diff: where the requester's chain meets
+// this node's and the blocks it is missing.
+type ChainDiff struct {
+	ForkHeight int         `json:"forkHeight"` // newest listed block on this chain; -1 if none is
+	ForkHash   string      `json:"forkHash,omitempty"`
+	Tip        int         `json:"tip"`
+	TipHash    string      `json:"tipHash"`
+	Blocks     []BlockView `json:"blocks"` // after the fork point
+	More       bool        `json:"more"`   // blocks stop at maxBodyRange; ask again from the last one
+}
+
+// locatorHashes reads ?have=, a comma-separated block locator: hashes of
+// the requester's chain, newest first, typically its last few blocks
+// followed by ones exponentially further back down to genesis.
+func locatorHashes(r *http.Request) ([]string, error) {
+	var hashes []string
+	for _, v := range r.URL.Query()["have"] {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, errors.New("have must list at least one block hash")
+	}
+	if len(hashes) > maxLocatorHashes {
+		return nil, fmt.Errorf("have lists %d hashes, at most %d allowed", len(hashes), maxLocatorHashes)
+	}
+	return hashes, nil
+}
+
+// chainDiffHandler serves GET /chain/diff?have=: the highest listed
+// block this node has is the fork point, and the blocks after it are
+// returned, up to maxBodyRange. If no listed block is on the chain, not
+// even genesis, the chain is returned from genesis.
+func chainDiffHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := requestVersion(r)
+	if err != nil || version < 4 {
+		rejectVersion(w)
+		return
+	}
+	hashes, err := locatorHashes(r)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	have := make(map[string]bool, len(hashes))
+	for _, h := range hashes {
+		have[h] = true
+	}
+	tip := ledger[len(ledger)-1]
+	diff := ChainDiff{ForkHeight: -1, Tip: tip.Height, TipHash: tip.Hash, Blocks: []BlockView{}}
+	for i := len(ledger) - 1; i >= 0; i-- {
+		if have[ledger[i].Hash] {
+			diff.ForkHeight, diff.ForkHash = i, ledger[i].Hash
+			break
+		}
+	}
+	end := len(ledger)
+	if end-(diff.ForkHeight+1) > maxBodyRange {
+		end, diff.More = diff.ForkHeight+1+maxBodyRange, true
+	}
+	for _, b := range ledger[diff.ForkHeight+1 : end] {
+		diff.Blocks = append(diff.Blocks, toView(b))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(versionHeader, strconv.Itoa(version))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(diff)
+}
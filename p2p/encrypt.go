@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Encrypted payloads. A payload may be encrypted to the holder of an
+// access key, a P-256 key pair separate from any signing key (see
+// `alimiad access-key`). The block records the recipient's public key,
+// and its hash covers the ciphertext, so anyone can verify the chain but
+// only the recipient can read the payload.
+//
+// The ciphertext is the sender's ephemeral public key (33 bytes,
+// compressed), a 12-byte nonce and the AES-256-GCM sealed payload. The
+// AES key is SHA-256 over the ECDH shared secret, the ephemeral key and
+// the recipient key; the recipient key is also the additional data.
+// Clients may encrypt themselves (`alimiad encrypt`) or let the node
+// encrypt on submission.
+
+// encryptionOverhead is the number of bytes encryption adds.
+const encryptionOverhead = 33 + 12 + 16
+
+// parseAccessKey decodes a hex, compressed P-256 public key.
+func parseAccessKey(s string) ([]byte, error) {
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != 33 {
+		return nil, errors.New("recipient key must be a compressed P-256 public key (66 hex characters)")
+	}
+	if x, _ := elliptic.UnmarshalCompressed(elliptic.P256(), raw); x == nil {
+		return nil, errors.New("recipient key is not a point on P-256")
+	}
+	return raw, nil
+}
+
+// encryptPayload encrypts plain to the access key recipient.
+func encryptPayload(recipient string, plain []byte) ([]byte, error) {
+	pub, err := parseAccessKey(recipient)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	px, py := elliptic.UnmarshalCompressed(curve, pub)
+	eph, ex, ey, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	ephPub := elliptic.MarshalCompressed(curve, ex, ey)
+	sx, _ := curve.ScalarMult(px, py, eph)
+
+	gcm, err := payloadCipher(sx.FillBytes(make([]byte, 32)), ephPub, pub)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append(append(ephPub, nonce...), gcm.Seal(nil, nonce, plain, pub)...)
+	return out, nil
+}
+
+// payloadCipher derives the AES-256-GCM cipher of one encrypted payload.
+func payloadCipher(shared, ephPub, recipient []byte) (cipher.AEAD, error) {
+	h := sha256.New()
+	h.Write(shared)
+	h.Write(ephPub)
+	h.Write(recipient)
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// checkEncrypted rejects a recipient key without a payload, an invalid
+// recipient key and inline ciphertexts too short to be well formed.
+func checkEncrypted(b Blob) error {
+	if b.RecipientKey == "" {
+		return nil
+	}
+	if len(b.Payload) == 0 && b.CID == "" {
+		return errors.New("recipientKey given without a payload")
+	}
+	if _, err := parseAccessKey(b.RecipientKey); err != nil {
+		return err
+	}
+	if n := len(b.Payload) + b.Size; n < encryptionOverhead {
+		return fmt.Errorf("encrypted payload must hold at least %d bytes", encryptionOverhead)
+	}
+	return nil
+}
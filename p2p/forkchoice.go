@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+)
+
+// ForkChoice decides which of two valid chains a node follows. Sync asks
+// it before replacing the ledger with a peer's chain; chains that only
+// extend the local tip are always taken.
+type ForkChoice interface {
+	Name() string
+	// Prefer reports whether candidate should replace current. Both are
+	// the headers of a whole chain, from genesis.
+	Prefer(current, candidate []BlockHeader) bool
+	// MayPrefer reports whether a chain ending at tipHeight could be
+	// preferred over current at all, so that sync can skip fetching
+	// chains that cannot win.
+	MayPrefer(current []BlockHeader, tipHeight int) bool
+}
+
+// forkChoice is the policy in use (FORK_CHOICE).
+var forkChoice ForkChoice = firstSeen{}
+
+// finalityDepth is the number of confirmations after which the finality
+// policy treats a block as final (FINALITY_DEPTH).
+var finalityDepth = 6
+
+// loadForkChoice reads FORK_CHOICE and FINALITY_DEPTH.
+func loadForkChoice() error {
+	if v := os.Getenv("FINALITY_DEPTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid FINALITY_DEPTH %q", v)
+		}
+		finalityDepth = n
+	}
+	switch v := os.Getenv("FORK_CHOICE"); v {
+	case "", "first-seen":
+		forkChoice = firstSeen{}
+	case "longest":
+		forkChoice = longestChain{}
+	case "heaviest":
+		forkChoice = heaviestWork{}
+	case "finality":
+		forkChoice = finality{depth: finalityDepth}
+	default:
+		return fmt.Errorf("unknown FORK_CHOICE %q (longest, heaviest, first-seen, finality)", v)
+	}
+	return nil
+}
+
+// firstSeen follows the longer chain and keeps the current one on a tie,
+// so a node stays on the chain it saw first.
+type firstSeen struct{}
+
+func (firstSeen) Name() string { return "first-seen" }
+
+func (firstSeen) Prefer(current, candidate []BlockHeader) bool {
+	return len(candidate) > len(current)
+}
+
+func (firstSeen) MayPrefer(current []BlockHeader, tipHeight int) bool {
+	return tipHeight+1 > len(current)
+}
+
+// longestChain follows the longer chain and breaks a tie by the lower tip
+// hash, so that every node picks the same chain whatever it saw first.
+type longestChain struct{}
+
+func (longestChain) Name() string { return "longest" }
+
+func (longestChain) Prefer(current, candidate []BlockHeader) bool {
+	if len(candidate) != len(current) {
+		return len(candidate) > len(current)
+	}
+	return candidate[len(candidate)-1].Hash < current[len(current)-1].Hash
+}
+
+func (longestChain) MayPrefer(current []BlockHeader, tipHeight int) bool {
+	return tipHeight+1 >= len(current)
+}
+
+// heaviestWork follows the chain with the most work, where a block's
+// work is the number of hashes expected to find its hash: 2^256 divided
+// by the hash plus one. For blocks mined with proof of work this is
+// their real cost; for others it is a deterministic weight. A tie keeps
+// the current chain.
+type heaviestWork struct{}
+
+func (heaviestWork) Name() string { return "heaviest" }
+
+func (heaviestWork) Prefer(current, candidate []BlockHeader) bool {
+	return chainWork(candidate).Cmp(chainWork(current)) > 0
+}
+
+func (heaviestWork) MayPrefer([]BlockHeader, int) bool { return true }
+
+// chainWork sums the work of every block in headers.
+func chainWork(headers []BlockHeader) *big.Int {
+	space := new(big.Int).Lsh(big.NewInt(1), 256)
+	total := new(big.Int)
+	for _, h := range headers {
+		n, ok := new(big.Int).SetString(h.Hash, 16)
+		if !ok {
+			continue
+		}
+		n.Add(n, big.NewInt(1))
+		total.Add(total, n.Div(space, n))
+	}
+	return total
+}
+
+// finality follows the longer chain like firstSeen, but never gives up
+// a block with depth or more confirmations; the tip has one.
+type finality struct{ depth int }
+
+func (finality) Name() string { return "finality" }
+
+func (f finality) Prefer(current, candidate []BlockHeader) bool {
+	if len(candidate) <= len(current) {
+		return false
+	}
+	common := 0
+	for common < len(current) && current[common].Hash == candidate[common].Hash {
+		common++
+	}
+	return len(current)-common < f.depth
+}
+
+func (finality) MayPrefer(current []BlockHeader, tipHeight int) bool {
+	return tipHeight+1 > len(current)
+}
+
+// ledgerHeaders returns the headers of chain.
+func ledgerHeaders(chain []ChainBlock) []BlockHeader {
+	headers := make([]BlockHeader, len(chain))
+	for i, b := range chain {
+		headers[i] = toHeader(b)
+	}
+	return headers
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Height gaps. A peer may send headers or blocks whose heights skip, for
+// example 4 then 6, because it served a partial range or lost a block.
+// Rather than rejecting the whole chain as invalid, sync detects each
+// gap, fetches the missing blocks with GET /blocks from the peer that
+// sent the chain or else from any other peer, and validates the result
+// as usual. GET /sync reports the last gap seen.
+
+// maxGapFills caps the gaps filled in one response from a peer.
+const maxGapFills = 8
+
+// fillingAnnounced is set while the blocks before an announced block
+// are being fetched, so that a burst of announcements starts one fetch.
+var fillingAnnounced int32
+
+// HeightGap describes heights that skip in what a peer sent: the block
+// after height After was at height Next.
+type HeightGap struct {
+	Peer     string `json:"peer,omitempty"` // "" for an announced block
+	After    int    `json:"after"`
+	Next     int    `json:"next"`
+	Filled   bool   `json:"filled"`
+	FilledBy string `json:"filledBy,omitempty"` // peer that served the missing blocks
+	Reason   string `json:"reason,omitempty"`   // why it could not be filled
+	Time     string `json:"time"`
+}
+
+func (g *HeightGap) Error() string {
+	src := g.Peer
+	if src == "" {
+		src = "an announcement"
+	}
+	msg := fmt.Sprintf("heights from %s skip from %d to %d", src, g.After, g.Next)
+	if g.Reason != "" {
+		msg += ": " + g.Reason
+	}
+	return msg
+}
+
+// fetchGap fetches the blocks missing between heights after and next,
+// trying peer first and then every other active peer, fastest first.
+func fetchGap(peer string, after, next int) ([]BlockView, *HeightGap) {
+	gap := &HeightGap{Peer: peer, After: after, Next: next, Time: clk.Now().Format(time.RFC3339)}
+	defer recordGap(gap)
+
+	missing := next - after - 1
+	if missing > maxBodyRange {
+		gap.Reason = fmt.Sprintf("%d blocks are missing, more than one request of %d", missing, maxBodyRange)
+		return nil, gap
+	}
+	candidates := []string{peer}
+	for _, p := range byLatency(activePeers()) {
+		if p != peer {
+			candidates = append(candidates, p)
+		}
+	}
+	for _, p := range candidates {
+		if p == "" || !peerAllowed(p) {
+			continue
+		}
+		version, err := peerVersion(p)
+		if err != nil || version < 3 {
+			continue
+		}
+		views, err := fetchBodies(p, version, after+1, next-1)
+		if err != nil || len(views) != missing {
+			continue
+		}
+		contiguous := true
+		for i, v := range views {
+			if v.Height != after+1+i {
+				contiguous = false
+				break
+			}
+		}
+		if !contiguous {
+			continue
+		}
+		gap.Filled, gap.FilledBy = true, p
+		log.Printf("🕳️  Filled heights %d-%d missing between %d and %d with blocks from %s", after+1, next-1, after, next, p)
+		return views, gap
+	}
+	gap.Reason = "no peer served the missing blocks"
+	log.Printf("🕳️  %v", gap)
+	return nil, gap
+}
+
+// fillHeaderGaps returns headers, the first following height prev (-1
+// for a chain from genesis), with every gap in their heights filled from
+// peers. Heights that go backwards are left for checkHeaders to reject.
+func fillHeaderGaps(peer string, prev int, headers []BlockHeader) ([]BlockHeader, error) {
+	fills := 0
+	for i := 0; i < len(headers); i++ {
+		after := prev
+		if i > 0 {
+			after = headers[i-1].Height
+		}
+		if headers[i].Height <= after+1 {
+			continue
+		}
+		if fills == maxGapFills {
+			return nil, fmt.Errorf("more than %d height gaps in headers from %s", maxGapFills, peer)
+		}
+		views, gap := fetchGap(peer, after, headers[i].Height)
+		if !gap.Filled {
+			return nil, gap
+		}
+		fill := make([]BlockHeader, len(views))
+		for j, v := range views {
+			fill[j] = toHeader(fromView(v))
+		}
+		headers = append(headers[:i:i], append(fill, headers[i:]...)...)
+		i += len(fill)
+		fills++
+	}
+	return headers, nil
+}
+
+// fillViewGaps is fillHeaderGaps for the blocks of GET /chain.
+func fillViewGaps(peer string, prev int, views []BlockView) ([]BlockView, error) {
+	fills := 0
+	for i := 0; i < len(views); i++ {
+		after := prev
+		if i > 0 {
+			after = views[i-1].Height
+		}
+		if views[i].Height <= after+1 {
+			continue
+		}
+		if fills == maxGapFills {
+			return nil, fmt.Errorf("more than %d height gaps in blocks from %s", maxGapFills, peer)
+		}
+		fill, gap := fetchGap(peer, after, views[i].Height)
+		if !gap.Filled {
+			return nil, gap
+		}
+		views = append(views[:i:i], append(fill, views[i:]...)...)
+		i += len(fill)
+		fills++
+	}
+	return views, nil
+}
+
+// fillAnnounced fetches the blocks between the tip and an announced
+// block further ahead, then appends them all. It does nothing while
+// another fill is running.
+func fillAnnounced(tip int, ann BlockView) {
+	if !atomic.CompareAndSwapInt32(&fillingAnnounced, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&fillingAnnounced, 0)
+	views, gap := fetchGap("", tip, ann.Height)
+	if gap.Filled {
+		appendPeerBlocks(gap.FilledBy, append(views, ann))
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// blockVersion is the header version this node produces and the newest
+// it accepts. A missing version (0) reads as 1.
+const blockVersion = 1
+
+// Limits on the extension fields a header may carry.
+const (
+	maxExtraFields = 16
+	maxExtraBytes  = 1024 // keys and values combined
+)
+
+// headerExtension returns the canonical encoding of a block's version
+// and extension fields, appended to the hash record. Version 1 blocks
+// without fields encode to "" and keep the hashes they always had;
+// anything else encodes as "|v<version>" plus the length-prefixed fields
+// in key order, as in the PoW and PoS nodes.
+func headerExtension(version int, extra map[string]string) string {
+	if version <= 1 && len(extra) == 0 {
+		return ""
+	}
+	if version == 0 {
+		version = 1
+	}
+
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("|v" + strconv.Itoa(version))
+	for _, k := range keys {
+		v := extra[k]
+		fmt.Fprintf(&sb, "|%d:%s%d:%s", len(k), k, len(v), v)
+	}
+	return sb.String()
+}
+
+// checkHeader rejects header versions from the future and oversized or
+// empty-keyed extension fields.
+func checkHeader(version int, extra map[string]string) error {
+	if version < 0 || version > blockVersion {
+		return fmt.Errorf("unsupported block version %d", version)
+	}
+	if len(extra) > maxExtraFields {
+		return fmt.Errorf("at most %d extra fields", maxExtraFields)
+	}
+	size := 0
+	for k, v := range extra {
+		if k == "" {
+			return errors.New("extra field keys must not be empty")
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxExtraBytes {
+		return fmt.Errorf("extra fields exceed %d bytes", maxExtraBytes)
+	}
+	return nil
+}
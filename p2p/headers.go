@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// Headers-first sync (protocol version 3). A node first downloads the
+// headers after its tip from every peer, which is cheap, checks how they
+// link up and picks the longest header chain. It then downloads the
+// bodies of that chain in batches spread over every peer that has it,
+// and checks each body against the hash in its header. Peers on an older
+// version are still synced a whole chain at a time (see syncFromPeer).
+
+// Body download limits.
+const (
+	bodyBatchSize   = 100 // blocks per GET /blocks request
+	maxBodyRange    = 500 // most blocks GET /blocks serves at once
+	maxBodyFetchers = 4   // parallel GET /blocks requests
+)
+
+// startFetcher runs a body fetcher of syncBodies. Simulations, whose
+// nodes share the package state, replace it to run the fetchers one
+// after another (see sim_test.go).
+var startFetcher = func(fetch func()) { go fetch() }
+
+// BlockHeader is a block without its data. The hash commits to the
+// data, so a body fetched later can be matched against its header.
+type BlockHeader struct {
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Hash      string            `json:"hash"`
+	PrevHash  string            `json:"prevHash"`
+	Version   int               `json:"version,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"`
+}
+
+func toHeader(b ChainBlock) BlockHeader {
+	return BlockHeader{
+		Height:    b.Height,
+		Timestamp: b.Timestamp,
+		Hash:      b.Hash,
+		PrevHash:  b.PrevHash,
+		Version:   b.Version,
+		Extra:     b.Extra,
+	}
+}
+
+// block returns the header as a block without data, for the checks that
+// do not recompute the hash.
+func (h BlockHeader) block() ChainBlock {
+	return ChainBlock{
+		Height:    h.Height,
+		Timestamp: h.Timestamp,
+		Hash:      h.Hash,
+		PrevHash:  h.PrevHash,
+		Version:   h.Version,
+		Extra:     h.Extra,
+	}
+}
+
+// headersHandler serves GET /headers: the headers of the chain from
+// ?since_hash= on, with the same fork report as GET /chain.
+func headersHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := requestVersion(r)
+	if err != nil || version < 3 {
+		rejectVersion(w)
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	start, fork, ok := sinceStart(r, ledger)
+	if !ok {
+		httpapi.WriteError(w, "since_height must be a height", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set(versionHeader, strconv.Itoa(version))
+	if fork != nil {
+		writeFork(w, fork)
+		return
+	}
+	headers := make([]BlockHeader, 0, len(ledger)-start)
+	for _, b := range ledger[start:] {
+		headers = append(headers, toHeader(b))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(headers)
+}
+
+// blocksHandler serves GET /blocks?from=&to=, the blocks at heights from
+// to to, inclusive. to is capped at the tip.
+func blocksHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := requestVersion(r)
+	if err != nil || version < 3 {
+		rejectVersion(w)
+		return
+	}
+	from, err1 := strconv.Atoi(r.URL.Query().Get("from"))
+	to, err2 := strconv.Atoi(r.URL.Query().Get("to"))
+	if err1 != nil || err2 != nil || from < 0 || to < from || to-from >= maxBodyRange {
+		httpapi.WriteError(w, fmt.Sprintf("from and to must be heights at most %d apart", maxBodyRange-1), http.StatusBadRequest)
+		return
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if to >= len(ledger) {
+		to = len(ledger) - 1
+	}
+	views := []BlockView{}
+	for h := from; h <= to; h++ {
+		views = append(views, toView(ledger[h]))
+	}
+
+	w.Header().Set(versionHeader, strconv.Itoa(version))
+	writeNegotiated(w, r, "Blocks", views)
+}
+
+// peerGet requests path from peer in protocol version and returns the
+// status and body of the response.
+func peerGet(peer, path string, version int) (int, []byte, error) {
+	req, _ := http.NewRequest(http.MethodGet, strings.TrimRight(peer, "/")+path, nil)
+	req.Header.Set(versionHeader, strconv.Itoa(version))
+	resp, body, err := doPeer(peer, req)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// fetchHeaders requests GET /headers with query from peer. A 409
+// Conflict is returned as fork, not as an error.
+func fetchHeaders(peer string, version int, query string) ([]BlockHeader, *ForkDetected, error) {
+	status, body, err := peerGet(peer, "/headers"+query, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		var headers []BlockHeader
+		if err := json.Unmarshal(body, &headers); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal headers: %v", err)
+		}
+		return headers, nil, nil
+	case http.StatusConflict:
+		var fork ForkDetected
+		if err := json.Unmarshal(body, &fork); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal fork: %v", err)
+		}
+		return nil, &fork, nil
+	default:
+		return nil, nil, fmt.Errorf("peer answered %d", status)
+	}
+}
+
+// fetchBodies requests the blocks at heights from to to from peer.
+func fetchBodies(peer string, version, from, to int) ([]BlockView, error) {
+	status, body, err := peerGet(peer, fmt.Sprintf("/blocks?from=%d&to=%d", from, to), version)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("peer answered %d", status)
+	}
+	var views []BlockView
+	if err := json.Unmarshal(body, &views); err != nil {
+		return nil, fmt.Errorf("unmarshal blocks: %v", err)
+	}
+	return views, nil
+}
+
+// checkHeaders verifies that headers form a chain on top of parent, or
+// from genesis if parent is nil, under every rule that does not need the
+// block data.
+func checkHeaders(parent *BlockHeader, headers []BlockHeader) error {
+	for i, h := range headers {
+		prev := parent
+		if i > 0 {
+			prev = &headers[i-1]
+		}
+		if err := checkCheckpoint(h.Height, h.Hash); err != nil {
+			return err
+		}
+		if prev == nil {
+			if h.Height != 0 || h.PrevHash != "" {
+				return errors.New("first header is not a genesis header")
+			}
+			continue
+		}
+		if h.Height != prev.Height+1 || h.PrevHash != prev.Hash {
+			return fmt.Errorf("header at height %d does not link to its parent", h.Height)
+		}
+		if err := checkHeader(h.Version, h.Extra); err != nil {
+			return fmt.Errorf("header at height %d: %v", h.Height, err)
+		}
+		if err := checkBlockTime(h.block(), prev.block()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// headerChain is a validated header chain offered by one or more peers.
+type headerChain struct {
+	peers   []string
+	base    string // hash of the local block it extends; "" if it starts at genesis
+	headers []BlockHeader
+}
+
+func (c *headerChain) tip() BlockHeader {
+	return c.headers[len(c.headers)-1]
+}
+
+// full returns the whole chain c describes on top of local.
+func (c *headerChain) full(local []BlockHeader) []BlockHeader {
+	if c.base == "" {
+		return c.headers
+	}
+	base := c.headers[0].Height
+	return append(local[:base:base], c.headers...)
+}
+
+// peerHeaders fetches and validates the headers peer has after the tip
+// of local, the headers of the ledger. It returns nil if the peer has
+// nothing that would be adopted: on a fork, only a chain the fork-choice
+// policy prefers is.
+func peerHeaders(peer string, version int, local []BlockHeader) (*headerChain, error) {
+	tip := local[len(local)-1]
+	query := "?since_hash=" + tip.Hash + "&since_height=" + strconv.Itoa(tip.Height)
+	headers, fork, err := fetchHeaders(peer, version, query)
+	if err != nil {
+		return nil, err
+	}
+	if fork != nil {
+		notePeerHeight(peer, fork.Tip)
+		if !forkChoice.MayPrefer(local, fork.Tip) {
+			return nil, nil
+		}
+		log.Printf("🍴 Fork with %s at height %d (peer tip %d), fetching its headers", peer, fork.Height, fork.Tip)
+		if headers, _, err = fetchHeaders(peer, version, ""); err != nil {
+			return nil, err
+		}
+	}
+	if len(headers) == 0 {
+		notePeerHeight(peer, tip.Height)
+		return nil, nil
+	}
+	notePeerHeight(peer, headers[len(headers)-1].Height)
+
+	prev := tip.Height
+	if fork != nil || headers[0].Height == 0 {
+		prev = -1
+	}
+	if headers, err = fillHeaderGaps(peer, prev, headers); err != nil {
+		return nil, err
+	}
+	c := &headerChain{peers: []string{peer}, headers: headers}
+	if headers[0].Height == 0 {
+		if err := checkHeaders(nil, headers); err != nil {
+			return nil, err
+		}
+		if !forkChoice.Prefer(local, headers) {
+			return nil, nil
+		}
+		return c, nil
+	}
+	if err := checkHeaders(&tip, headers); err != nil {
+		return nil, err
+	}
+	c.base = tip.Hash
+	return c, nil
+}
+
+// downloadBatch fetches the bodies of headers, trying the peers of c in
+// turn from the first-th on, and checks them against their headers.
+// syncBodies orders the peers fastest first.
+func downloadBatch(c *headerChain, first int, headers []BlockHeader) ([]ChainBlock, error) {
+	from, to := headers[0].Height, headers[len(headers)-1].Height
+	var lastErr error
+	for i := 0; i < len(c.peers); i++ {
+		p := c.peers[(first+i)%len(c.peers)]
+		version, err := peerVersion(p)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		views, err := fetchBodies(p, version, from, to)
+		if err == nil && len(views) != len(headers) {
+			err = fmt.Errorf("sent %d of %d blocks", len(views), len(headers))
+		}
+		if err != nil {
+			log.Printf("⚠️  Failed to fetch blocks %d-%d from %s: %v", from, to, p, err)
+			lastErr = err
+			continue
+		}
+		blocks := make([]ChainBlock, len(views))
+		for j, v := range views {
+			blocks[j] = fromView(v)
+			if blocks[j].Height != headers[j].Height || computeHash(blocks[j]) != headers[j].Hash {
+				err = fmt.Errorf("block at height %d does not match its header", headers[j].Height)
+				break
+			}
+		}
+		if err != nil {
+			log.Printf("⚠️  Peer %s sent bad blocks: %v", p, err)
+			lastErr = err
+			continue
+		}
+		return blocks, nil
+	}
+	return nil, fmt.Errorf("blocks %d-%d: %v", from, to, lastErr)
+}
+
+// appendSynced appends downloaded blocks if they still extend the tip.
+func appendSynced(blocks []ChainBlock) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, b := range blocks {
+		if !isBlockValid(b, ledger[len(ledger)-1]) {
+			return false
+		}
+		ledger = append(ledger, b)
+	}
+	notifyTip()
+	recordSyncProgress(ledger[len(ledger)-1].Height)
+	saveSyncState()
+	return true
+}
+
+// adoptInitial replaces a ledger that holds nothing but its own genesis
+// block with the first downloaded batch of a chain, so that an initial
+// sync makes progress that can be saved batch by batch.
+func adoptInitial(blocks []ChainBlock) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ledger) != 1 || !isChainValid(blocks) {
+		return false
+	}
+	log.Printf("🔄 Initial sync: adopting the chain with genesis %s", blocks[0].Hash)
+	ledger = blocks
+	notifyTip()
+	recordSyncProgress(ledger[len(ledger)-1].Height)
+	saveSyncState()
+	return true
+}
+
+// syncBodies downloads the bodies of c in batches, several at a time
+// from all of its peers. Blocks that extend the tip are appended as soon
+// as every batch before them has arrived. So are the blocks of an
+// initial sync, when the ledger holds only its own genesis block; any
+// other chain from genesis replaces the ledger once complete, if it is
+// still longer.
+func syncBodies(c *headerChain) {
+	mu.RLock()
+	progressive := c.base != "" || len(ledger) == 1
+	mu.RUnlock()
+	c.peers = byLatency(c.peers)
+
+	n := len(c.headers)
+	batches := (n + bodyBatchSize - 1) / bodyBatchSize
+	fetchers := len(c.peers)
+	if fetchers > maxBodyFetchers {
+		fetchers = maxBodyFetchers
+	}
+	if fetchers > batches {
+		fetchers = batches
+	}
+	log.Printf("⬇️  Downloading %d block(s) at heights %d-%d from %d peer(s)", n, c.headers[0].Height, c.tip().Height, len(c.peers))
+
+	type result struct {
+		index  int
+		blocks []ChainBlock
+		err    error
+	}
+	jobs := make(chan int, batches)
+	results := make(chan result, batches)
+	for i := 0; i < batches; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	for f := 0; f < fetchers; f++ {
+		f := f
+		startFetcher(func() {
+			for i := range jobs {
+				hi := (i + 1) * bodyBatchSize
+				if hi > n {
+					hi = n
+				}
+				blocks, err := downloadBatch(c, f, c.headers[i*bodyBatchSize:hi])
+				results <- result{i, blocks, err}
+			}
+		})
+	}
+
+	got := make([][]ChainBlock, batches)
+	next, failed := 0, false
+	for k := 0; k < batches; k++ {
+		res := <-results
+		if res.err != nil {
+			log.Printf("⚠️  Sync stopped: %v", res.err)
+			failed = true
+			continue
+		}
+		got[res.index] = res.blocks
+		for progressive && !failed && next < batches && got[next] != nil {
+			var ok bool
+			if next == 0 && c.base == "" {
+				ok = adoptInitial(got[0])
+			} else {
+				ok = appendSynced(got[next])
+			}
+			if !ok {
+				log.Println("⚠️  Tip moved during sync, dropping downloaded blocks")
+				failed = true
+			}
+			next++
+		}
+	}
+	if progressive || failed {
+		return
+	}
+
+	peerChain := make([]ChainBlock, 0, n)
+	for _, blocks := range got {
+		peerChain = append(peerChain, blocks...)
+	}
+	if !isChainValid(peerChain) {
+		log.Printf("⚠️  Chain downloaded from %s is not valid", strings.Join(c.peers, ", "))
+		return
+	}
+	mu.Lock()
+	if forkChoice.Prefer(ledgerHeaders(ledger), ledgerHeaders(peerChain)) {
+		log.Printf("🔄 Adopting %s chain from %s (len=%d, was %d)", forkChoice.Name(), strings.Join(c.peers, ", "), len(peerChain), len(ledger))
+		recordReorg(ledger, peerChain, strings.Join(c.peers, ","))
+		ledger = peerChain
+		notifyTip()
+		recordSyncProgress(peerChain[len(peerChain)-1].Height)
+		saveSyncState()
+	}
+	mu.Unlock()
+}
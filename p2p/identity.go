@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// Node identity. Every node holds an ed25519 identity key (NODE_KEY, a
+// hex seed; without it a fresh key is made at startup) and signs its
+// GET /info and POST /handshake responses with it. X-Node-Key carries
+// the public key and X-Node-Signature a signature over
+// signedResponsePrefix, the X-Node-Challenge request header (empty if
+// none was sent), a newline and the body. A client that sends a fresh
+// random challenge knows the answer was made for it, not replayed by a
+// proxy. PEER_KEYS pins peers to their keys: the handshake with a
+// pinned peer carries a challenge and fails unless the reply is signed
+// by the pinned key.
+
+const (
+	nodeKeyHeader       = "X-Node-Key"
+	nodeSignatureHeader = "X-Node-Signature"
+	nodeChallengeHeader = "X-Node-Challenge"
+
+	// signedResponsePrefix domain-separates response signatures.
+	signedResponsePrefix = "AlirezaChain node response:\n"
+
+	// maxChallengeLength caps an accepted challenge.
+	maxChallengeLength = 128
+)
+
+var (
+	// nodeKey is the identity key (NODE_KEY) and nodePub its public key
+	// in hex.
+	nodeKey ed25519.PrivateKey
+	nodePub string
+
+	// peerKeys pins peer URLs to hex public keys (PEER_KEYS,
+	// "url=key,...").
+	peerKeys = make(map[string]string)
+)
+
+// loadIdentity reads NODE_KEY and PEER_KEYS.
+func loadIdentity() error {
+	if v := strings.TrimSpace(os.Getenv("NODE_KEY")); v != "" {
+		seed, err := hex.DecodeString(v)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return errors.New("NODE_KEY must be a hex ed25519 seed (32 bytes)")
+		}
+		nodeKey = ed25519.NewKeyFromSeed(seed)
+	} else {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		nodeKey = priv
+	}
+	nodePub = hex.EncodeToString(nodeKey.Public().(ed25519.PublicKey))
+
+	for _, entry := range strings.Split(os.Getenv("PEER_KEYS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		peer, key, ok := strings.Cut(entry, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if pub, err := hex.DecodeString(key); !ok || err != nil || len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid PEER_KEYS entry %q (want url=hex public key)", entry)
+		}
+		peerKeys[strings.TrimRight(strings.TrimSpace(peer), "/")] = key
+	}
+	return nil
+}
+
+// responseMessage is what a response signature covers.
+func responseMessage(challenge string, body []byte) []byte {
+	msg := signedResponsePrefix + challenge + "\n"
+	return append([]byte(msg), body...)
+}
+
+// writeSigned writes v as indented JSON, signed with the node key for
+// the challenge of r.
+func writeSigned(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+	challenge := r.Header.Get(nodeChallengeHeader)
+	if len(challenge) > maxChallengeLength {
+		httpapi.WriteError(w, fmt.Sprintf("%s is longer than %d characters", nodeChallengeHeader, maxChallengeLength), http.StatusBadRequest)
+		return
+	}
+	sig := ed25519.Sign(nodeKey, responseMessage(challenge, body))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(nodeKeyHeader, nodePub)
+	w.Header().Set(nodeSignatureHeader, hex.EncodeToString(sig))
+	_, _ = w.Write(body)
+}
+
+// newChallenge returns a random challenge for a signed request.
+func newChallenge() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// readSigned returns the body of a signed response from peer after, if
+// the peer is pinned in PEER_KEYS, checking that its pinned key signed
+// it for challenge.
+func readSigned(peer, challenge string, resp *http.Response, body []byte) ([]byte, error) {
+	want, pinned := peerKeys[strings.TrimRight(peer, "/")]
+	if !pinned {
+		return body, nil
+	}
+	if got := strings.ToLower(resp.Header.Get(nodeKeyHeader)); got != want {
+		return nil, fmt.Errorf("peer identifies as %q, pinned to %s", got, want)
+	}
+	pub, _ := hex.DecodeString(want)
+	sig, err := hex.DecodeString(resp.Header.Get(nodeSignatureHeader))
+	if err != nil || !ed25519.Verify(pub, responseMessage(challenge, body), sig) {
+		return nil, errors.New("peer response is not signed by its pinned key")
+	}
+	return body, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// maxClockDrift is how far ahead of the local clock a block timestamp
+// may be while MIN_BLOCK_INTERVAL is set. Without this bound a producer
+// could satisfy the interval with made-up future timestamps.
+const maxClockDrift = time.Minute
+
+// minBlockInterval is the least time a block must follow its parent by
+// (MIN_BLOCK_INTERVAL); zero, the default, disables the rule. It is a
+// consensus rule: every node of a network needs the same value, or they
+// reject each other's blocks.
+var minBlockInterval time.Duration
+
+// loadMinBlockInterval reads MIN_BLOCK_INTERVAL, a duration like "2s".
+func loadMinBlockInterval() error {
+	v := os.Getenv("MIN_BLOCK_INTERVAL")
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid MIN_BLOCK_INTERVAL %q", v)
+	}
+	minBlockInterval = d
+	return nil
+}
+
+// intervalSeconds is minBlockInterval rounded up to whole seconds, the
+// resolution of block timestamps.
+func intervalSeconds() int64 {
+	return int64((minBlockInterval + time.Second - 1) / time.Second)
+}
+
+// checkBlockTime enforces MIN_BLOCK_INTERVAL on b, which extends parent.
+func checkBlockTime(b, parent ChainBlock) error {
+	if minBlockInterval <= 0 {
+		return nil
+	}
+	if b.Timestamp-parent.Timestamp < intervalSeconds() {
+		return fmt.Errorf("block at height %d follows its parent after %ds; the minimum is %s", b.Height, b.Timestamp-parent.Timestamp, minBlockInterval)
+	}
+	if ahead := time.Unix(b.Timestamp, 0).Sub(clk.Now()); ahead > maxClockDrift {
+		return fmt.Errorf("block at height %d is timestamped %s in the future", b.Height, ahead.Round(time.Second))
+	}
+	return nil
+}
+
+// blockWait returns how long a block on top of tip has to wait to meet
+// MIN_BLOCK_INTERVAL, or zero if it can be produced now.
+func blockWait(tip ChainBlock) time.Duration {
+	if minBlockInterval <= 0 {
+		return 0
+	}
+	if wait := time.Unix(tip.Timestamp+intervalSeconds(), 0).Sub(clk.Now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// writeTooSoon refuses to produce a block wait before it is allowed.
+func writeTooSoon(w http.ResponseWriter, wait time.Duration) {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	httpapi.WriteError(w, fmt.Sprintf("blocks must be at least %s apart; retry in %ds", minBlockInterval, secs), http.StatusTooManyRequests)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IPFS-backed payloads. With IPFS_API set, payloads larger than
+// IPFS_THRESHOLD are pinned on that IPFS node on submission and the
+// block records only their CID and size, which the hash commits to.
+// GET /blocks/{height}/payload fetches such payloads back from IPFS.
+
+// maxCIDLength caps the length of a recorded CID.
+const maxCIDLength = 128
+
+var (
+	// ipfsAPI is the base URL of the IPFS HTTP API (IPFS_API), e.g.
+	// http://127.0.0.1:5001; "" leaves every payload on-chain.
+	ipfsAPI string
+
+	// ipfsThreshold is the size above which payloads go to IPFS
+	// (IPFS_THRESHOLD).
+	ipfsThreshold = 16 << 10
+
+	// ipfsMaxBytes caps the size of a payload stored in IPFS
+	// (IPFS_MAX_PAYLOAD).
+	ipfsMaxBytes = 16 << 20
+
+	ipfsClient = &http.Client{Timeout: 60 * time.Second}
+)
+
+// loadIPFS reads IPFS_API, IPFS_THRESHOLD and IPFS_MAX_PAYLOAD.
+func loadIPFS() error {
+	ipfsAPI = strings.TrimRight(os.Getenv("IPFS_API"), "/")
+	if ipfsAPI == "" {
+		return nil
+	}
+	if !strings.HasPrefix(ipfsAPI, "http://") && !strings.HasPrefix(ipfsAPI, "https://") {
+		return fmt.Errorf("invalid IPFS_API %q: want an http(s) URL", ipfsAPI)
+	}
+	if v := os.Getenv("IPFS_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxPayloadBytes {
+			return fmt.Errorf("invalid IPFS_THRESHOLD %q (0-%d bytes)", v, maxPayloadBytes)
+		}
+		ipfsThreshold = n
+	}
+	if v := os.Getenv("IPFS_MAX_PAYLOAD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < ipfsThreshold {
+			return fmt.Errorf("invalid IPFS_MAX_PAYLOAD %q: want at least IPFS_THRESHOLD (%d bytes)", v, ipfsThreshold)
+		}
+		ipfsMaxBytes = n
+	}
+	return nil
+}
+
+// payloadLimit is the largest payload a submission may carry.
+func payloadLimit() int {
+	if ipfsAPI != "" && ipfsMaxBytes > maxPayloadBytes {
+		return ipfsMaxBytes
+	}
+	return maxPayloadBytes
+}
+
+// offloadBlob pins a payload above the threshold on IPFS and returns the
+// blob that records its CID instead. Smaller payloads, and all payloads
+// without IPFS_API, are returned as they are.
+func offloadBlob(b Blob) (Blob, error) {
+	if ipfsAPI == "" || len(b.Payload) <= ipfsThreshold {
+		return b, nil
+	}
+	if len(b.Payload) > ipfsMaxBytes {
+		return b, fmt.Errorf("payload exceeds %d bytes", ipfsMaxBytes)
+	}
+	cid, err := ipfsAdd(b.Payload)
+	if err != nil {
+		return b, fmt.Errorf("ipfs: %v", err)
+	}
+	log.Printf("📌 Pinned %d-byte payload on IPFS as %s", len(b.Payload), cid)
+	return Blob{ContentType: b.ContentType, CID: cid, Size: len(b.Payload)}, nil
+}
+
+// ipfsAdd adds and pins data on the IPFS node and returns its CID.
+func ipfsAdd(data []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("file", "payload")
+	if err != nil {
+		return "", err
+	}
+	_, _ = part.Write(data)
+	_ = mw.Close()
+
+	resp, err := ipfsClient.Post(ipfsAPI+"/api/v0/add?pin=true&cid-version=1", mw.FormDataContentType(), &body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("add: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var added struct {
+		Hash string `json:"Hash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return "", fmt.Errorf("add: %v", err)
+	}
+	if checkCID(added.Hash) != nil {
+		return "", fmt.Errorf("add: unexpected CID %q", added.Hash)
+	}
+	return added.Hash, nil
+}
+
+// ipfsCat opens the content of cid on the IPFS node. The caller closes
+// the returned body.
+func ipfsCat(cid string) (io.ReadCloser, error) {
+	if ipfsAPI == "" {
+		return nil, errors.New("no IPFS_API configured")
+	}
+	resp, err := ipfsClient.Post(ipfsAPI+"/api/v0/cat?arg="+cid, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("cat: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.Body, nil
+}
+
+// checkCID accepts multibase CIDs: ASCII letters and digits only.
+func checkCID(cid string) error {
+	if cid == "" || len(cid) > maxCIDLength {
+		return fmt.Errorf("cid must hold 1 to %d characters", maxCIDLength)
+	}
+	for _, c := range cid {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return fmt.Errorf("invalid cid %q", cid)
+		}
+	}
+	return nil
+}
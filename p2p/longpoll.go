@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Long-poll timeouts for GET /chain/next (?timeout=).
+const (
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 2 * time.Minute
+)
+
+// tipChanged is closed and replaced whenever the ledger grows, by a
+// local, announced or adopted peer block, waking the requests waiting in
+// nextBlockHandler. Guarded by mu.
+var tipChanged = make(chan struct{})
+
+// notifyTip wakes every waiting /chain/next request. Callers must hold mu
+// for writing.
+func notifyTip() {
+	close(tipChanged)
+	tipChanged = make(chan struct{})
+}
+
+// parsePoll reads the ?after= height and ?timeout= duration of a
+// long-poll request.
+func parsePoll(r *http.Request) (int, time.Duration, bool) {
+	after, err := strconv.Atoi(r.URL.Query().Get("after"))
+	if err != nil || after < 0 {
+		return 0, 0, false
+	}
+	timeout := defaultPollTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return 0, 0, false
+		}
+		timeout = d
+	}
+	if timeout > maxPollTimeout {
+		timeout = maxPollTimeout
+	}
+	return after, timeout, true
+}
+
+// nextBlockHandler returns the block at height ?after=+1, holding the
+// request open until it exists or ?timeout= (default 30s) passes, in
+// which case it answers 204 No Content.
+func nextBlockHandler(w http.ResponseWriter, r *http.Request) {
+	after, timeout, ok := parsePoll(r)
+	if !ok {
+		httpapi.WriteError(w, "after must be a height and timeout a duration such as 30s", http.StatusBadRequest)
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		mu.RLock()
+		if len(ledger) > after+1 {
+			b := ledger[after+1]
+			mu.RUnlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(toView(b))
+			return
+		}
+		changed := tipChanged
+		mu.RUnlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -4,7 +4,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"io"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
 	"os"
@@ -13,6 +14,9 @@ import (
 	"sync"
 	"time"
 
+	"alirezachain/clock"
+	"alirezachain/httpapi"
+	"alirezachain/idempotency"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
@@ -23,18 +27,34 @@ const (
 )
 
 type ChainBlock struct {
-	Height    int    `json:"height"`
-	Timestamp int64  `json:"timestamp"`
-	Data      string `json:"data"`
-	Hash      string `json:"hash"`
-	PrevHash  string `json:"prevHash"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Data      string            `json:"data"`
+	Hash      string            `json:"hash"`
+	PrevHash  string            `json:"prevHash"`
+	Version   int               `json:"version,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"` // extension fields, committed to by the hash
+	Blob                        // binary payload (see payload.go)
 }
 
 var (
 	ledger []ChainBlock
 	mu     sync.RWMutex
 
-	peers []string
+	peers []string // active peers (PEERS, see peerpool.go); guarded by poolMu
+
+	// clk timestamps blocks, times peer requests and runs the sync loop
+	// and block announcements; tests may replace it with a fake clock
+	// such as sim.Clock (see sim_test.go).
+	clk clock.Clock = clock.Real{}
+
+	// idem replays the responses to writes retried with an
+	// Idempotency-Key (see package idempotency).
+	idem = idempotency.New(func() time.Time { return clk.Now() })
+
+	// peerClient fetches peer chains (see peerclient.go); chaos mode
+	// wraps its transport.
+	peerClient = http.DefaultClient
 )
 
 // --- Core blockchain logic ---
@@ -43,18 +63,23 @@ func computeHash(b ChainBlock) string {
 	record := strconv.Itoa(b.Height) +
 		strconv.FormatInt(b.Timestamp, 10) +
 		b.Data +
-		b.PrevHash
+		b.PrevHash +
+		headerExtension(b.Version, b.Extra) +
+		blobExtension(b.Blob)
 
 	sum := sha256.Sum256([]byte(record))
 	return hex.EncodeToString(sum[:])
 }
 
-func newBlock(prev ChainBlock, data string) ChainBlock {
+func newBlock(prev ChainBlock, data string, blob Blob, extra map[string]string) ChainBlock {
 	b := ChainBlock{
 		Height:    prev.Height + 1,
-		Timestamp: time.Now().Unix(),
+		Timestamp: clk.Now().Unix(),
 		Data:      data,
 		PrevHash:  prev.Hash,
+		Version:   blockVersion,
+		Extra:     extra,
+		Blob:      blob,
 	}
 	b.Hash = computeHash(b)
 	return b
@@ -67,6 +92,18 @@ func isBlockValid(newB, prevB ChainBlock) bool {
 	if newB.PrevHash != prevB.Hash {
 		return false
 	}
+	if checkHeader(newB.Version, newB.Extra) != nil {
+		return false
+	}
+	if checkBlob(newB.Blob) != nil {
+		return false
+	}
+	if checkBlockTime(newB, prevB) != nil {
+		return false
+	}
+	if checkCheckpoint(newB.Height, newB.Hash) != nil {
+		return false
+	}
 	if computeHash(newB) != newB.Hash {
 		return false
 	}
@@ -74,7 +111,7 @@ func isBlockValid(newB, prevB ChainBlock) bool {
 }
 
 func isChainValid(chain []ChainBlock) bool {
-	if len(chain) == 0 {
+	if len(chain) == 0 || checkCheckpoint(chain[0].Height, chain[0].Hash) != nil {
 		return false
 	}
 	for i := 1; i < len(chain); i++ {
@@ -88,12 +125,16 @@ func isChainValid(chain []ChainBlock) bool {
 // --- Views ---
 
 type BlockView struct {
-	Height    int    `json:"height"`
-	Timestamp int64  `json:"timestamp"`
-	TimeText  string `json:"time"`
-	Data      string `json:"data"`
-	Hash      string `json:"hash"`
-	PrevHash  string `json:"prevHash"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	TimeText  string            `json:"time"`
+	Data      string            `json:"data"`
+	Hash      string            `json:"hash"`
+	PrevHash  string            `json:"prevHash"`
+	Version   int               `json:"version,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"`
+	Blob
+	PayloadSize int `json:"payloadSize,omitempty"` // raw bytes, before base64 or in IPFS
 }
 
 func toView(b ChainBlock) BlockView {
@@ -104,37 +145,93 @@ func toView(b ChainBlock) BlockView {
 		Data:      b.Data,
 		Hash:      b.Hash,
 		PrevHash:  b.PrevHash,
+		Version:   b.Version,
+		Extra:     b.Extra,
+		Blob:      b.Blob,
+
+		PayloadSize: len(b.Payload) + b.Size,
+	}
+}
+
+// fromView recovers a block from its JSON view.
+func fromView(v BlockView) ChainBlock {
+	return ChainBlock{
+		Height:    v.Height,
+		Timestamp: v.Timestamp,
+		Data:      v.Data,
+		Hash:      v.Hash,
+		PrevHash:  v.PrevHash,
+		Version:   v.Version,
+		Extra:     v.Extra,
+		Blob:      v.Blob,
 	}
 }
 
 // --- HTTP Handlers ---
 
+// chainHandler serves the chain in the wire format of the requesting
+// peer's protocol version, from ?since_hash= on if given.
 func chainHandler(w http.ResponseWriter, r *http.Request) {
+	version, err := requestVersion(r)
+	if err != nil {
+		rejectVersion(w)
+		return
+	}
+
 	mu.RLock()
 	defer mu.RUnlock()
 
-	views := make([]BlockView, 0, len(ledger))
-	for _, b := range ledger {
+	start, fork, ok := sinceStart(r, ledger)
+	if !ok {
+		httpapi.WriteError(w, "since_height must be a height", http.StatusBadRequest)
+		return
+	}
+	if fork != nil {
+		w.Header().Set(versionHeader, strconv.Itoa(version))
+		writeFork(w, fork)
+		return
+	}
+	views := make([]BlockView, 0, len(ledger)-start)
+	for _, b := range ledger[start:] {
 		views = append(views, toView(b))
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(views)
+	w.Header().Set(versionHeader, strconv.Itoa(version))
+	if version == 1 {
+		writeNegotiated(w, r, "Chain", views)
+		return
+	}
+	writeNegotiated(w, r, "VersionedChain", VersionedChain{version, views})
 }
 
 func pushHandler(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Data string `json:"data"`
+		Data      json.RawMessage   `json:"data"` // a string or a typed payload (see schema.go)
+		Extra     map[string]string `json:"extra"`
+		Blob                        // base64 payload and its content type
+		EncryptTo string            `json:"encryptTo"` // access key to encrypt the payload to
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	data, err := parseData(payload.Data)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	if strings.TrimSpace(payload.Data) == "" {
-		http.Error(w, "data is required", http.StatusBadRequest)
+	if strings.TrimSpace(data) == "" && len(payload.Payload) == 0 && payload.CID == "" {
+		httpapi.WriteError(w, "data or payload is required", http.StatusBadRequest)
+		return
+	}
+	if err := checkHeader(blockVersion, payload.Extra); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	blob, status, err := prepareBlob(payload.Blob, payload.EncryptTo)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), status)
 		return
 	}
 
@@ -142,15 +239,22 @@ func pushHandler(w http.ResponseWriter, r *http.Request) {
 	defer mu.Unlock()
 
 	last := ledger[len(ledger)-1]
-	nb := newBlock(last, payload.Data)
+	if wait := blockWait(last); wait > 0 {
+		writeTooSoon(w, wait)
+		return
+	}
+	nb := newBlock(last, data, blob, payload.Extra)
 
 	if !isBlockValid(nb, last) {
-		http.Error(w, "new block is not valid", http.StatusInternalServerError)
+		httpapi.WriteError(w, "new block is not valid", http.StatusInternalServerError)
 		return
 	}
 
 	ledger = append(ledger, nb)
-	log.Printf("🧱 New local block: height=%d hash=%s", nb.Height, nb.Hash)
+	notifyTip()
+	httpapi.LogRequest(r, "🧱 New local block: height=%d hash=%s", nb.Height, nb.Hash)
+	id := httpapi.RequestID(r)
+	clk.AfterFunc(0, func() { announceBlock(nb, id) })
 
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -158,111 +262,249 @@ func pushHandler(w http.ResponseWriter, r *http.Request) {
 	_ = enc.Encode(toView(nb))
 }
 
+// NodeInfo is the body of GET /info. It is signed (see identity.go),
+// so it is always camelCase JSON.
+type NodeInfo struct {
+	Name       string   `json:"name"`
+	Blocks     int      `json:"blocks"`
+	LastHash   string   `json:"lastHash"`
+	Peers      []string `json:"peers"`
+	Timestamp  string   `json:"timestamp"`
+	Protocol   int      `json:"protocolVersion"`
+	MinVersion int      `json:"minProtocolVersion"`
+	ForkChoice string   `json:"forkChoice"`
+	Trusted    bool     `json:"trustedOnly"`
+	NodeKey    string   `json:"nodeKey"` // signs this response, see identity.go
+}
+
 func infoHandler(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
 	defer mu.RUnlock()
 
-	type Info struct {
-		Name      string   `json:"name"`
-		Blocks    int      `json:"blocks"`
-		LastHash  string   `json:"lastHash"`
-		Peers     []string `json:"peers"`
-		Timestamp string   `json:"timestamp"`
-	}
-
 	last := ledger[len(ledger)-1]
 
-	resp := Info{
-		Name:      netName,
-		Blocks:    len(ledger),
-		LastHash:  last.Hash,
-		Peers:     peers,
-		Timestamp: time.Now().Format(time.RFC3339),
+	resp := NodeInfo{
+		Name:       netName,
+		Blocks:     len(ledger),
+		LastHash:   last.Hash,
+		Peers:      activePeers(),
+		Timestamp:  clk.Now().Format(time.RFC3339),
+		Protocol:   protocolVersion,
+		MinVersion: minProtocolVersion,
+		ForkChoice: forkChoice.Name(),
+		Trusted:    len(peerWhitelist) > 0,
+		NodeKey:    nodePub,
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(resp)
+	writeSigned(w, r, resp)
 }
 
 func peersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("detail") == "true" {
+		peerStatsHandler(w, r)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	_ = enc.Encode(peers)
+	_ = enc.Encode(activePeers())
 }
 
+// makeRouter serves the API under /v1 and, deprecated, unversioned (see
+// api.go).
 func makeRouter() http.Handler {
-	r := mux.NewRouter()
+	return versioned(routes)
+}
+
+// routes adds every API route to r.
+func routes(r *mux.Router) {
 	r.HandleFunc("/chain", chainHandler).Methods("GET")
-	r.HandleFunc("/push", pushHandler).Methods("POST")
+	r.HandleFunc("/chain/next", nextBlockHandler).Methods("GET")
+	r.HandleFunc("/chain/verify", verifyChainHandler).Methods("GET", "POST")
+	r.HandleFunc("/chain/diff", chainDiffHandler).Methods("GET")
+	r.HandleFunc("/headers", headersHandler).Methods("GET")
+	r.HandleFunc("/blocks", typedBlocksHandler).Methods("GET").Queries("type", "{type:.+}")
+	r.HandleFunc("/blocks", blocksHandler).Methods("GET")
+	r.HandleFunc("/blocks/{height:[0-9]+}/payload", payloadHandler).Methods("GET")
+	r.HandleFunc("/proto", protoHandler).Methods("GET")
+	r.HandleFunc("/schemas", schemasHandler).Methods("GET")
+	r.HandleFunc("/export", exportHandler).Methods("GET")
+	r.HandleFunc("/push", notBlacklisted(idem.Wrap(pushHandler))).Methods("POST")
+	r.HandleFunc("/push/batch", notBlacklisted(idem.Wrap(pushBatchHandler))).Methods("POST")
+	r.HandleFunc("/push/blob", notBlacklisted(idem.Wrap(pushBlobHandler))).Methods("POST")
 	r.HandleFunc("/info", infoHandler).Methods("GET")
 	r.HandleFunc("/peers", peersHandler).Methods("GET")
-	return r
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+	r.HandleFunc("/sync", syncHandler).Methods("GET")
+	r.HandleFunc("/reorgs", reorgsHandler).Methods("GET")
+	r.HandleFunc("/handshake", trustedPeer(peerAuth.Require(handshakeHandler))).Methods("POST")
+	r.HandleFunc("/announce", trustedPeer(peerAuth.Require(announceHandler))).Methods("POST")
 }
 
 // --- P2P sync ---
 
-func syncLoop(interval time.Duration) {
-	for {
-		time.Sleep(interval)
+// syncLoop syncs with peers after delay, and then on the schedule of
+// syncschedule.go.
+func syncLoop(delay time.Duration) {
+	syncMu.Lock()
+	nextSyncAt = clk.Now().Add(delay)
+	syncMu.Unlock()
+	clk.AfterFunc(delay, func() {
 		syncWithPeers()
-	}
+		syncLoop(nextSyncDelay())
+	})
 }
 
+// syncWithPeers runs one sync round. Peers that speak protocol version 3
+// take part in headers-first sync (see headers.go): the longest header
+// chain they offer is downloaded from every one of them that has it.
+// Older peers are synced one at a time.
 func syncWithPeers() {
-	if len(peers) == 0 {
+	active := activePeers()
+	if len(active) == 0 {
 		return
 	}
+	setSyncRunning(true)
+	defer setSyncRunning(false)
 
-	for _, p := range peers {
-		url := strings.TrimRight(p, "/") + "/chain"
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("⚠️  Failed to fetch from peer %s: %v", p, err)
+	var chains []*headerChain
+	for _, p := range active {
+		if !peerAllowed(p) {
+			continue
+		}
+		version, err := peerVersion(p)
+		if errors.Is(err, errVersionUnsupported) {
+			log.Printf("⛔ Peer %s is outside protocol versions %d-%d", p, minProtocolVersion, protocolVersion)
+			scorePeer(p, -peerFailurePenalty)
 			continue
 		}
-		body, err := io.ReadAll(resp.Body)
-		_ = resp.Body.Close()
 		if err != nil {
-			log.Printf("⚠️  Failed to read response from peer %s: %v", p, err)
+			log.Printf("⚠️  Handshake with peer %s failed: %v", p, err)
+			scorePeer(p, -peerFailurePenalty)
 			continue
 		}
 
-		var peerViews []BlockView
-		if err := json.Unmarshal(body, &peerViews); err != nil {
-			log.Printf("⚠️  Failed to unmarshal chain from peer %s: %v", p, err)
+		mu.RLock()
+		local := ledgerHeaders(ledger)
+		mu.RUnlock()
+		recordSyncProgress(len(local) - 1)
+		if version < 3 {
+			syncFromPeer(p, version, local)
 			continue
 		}
 
-		peerChain := make([]ChainBlock, 0, len(peerViews))
-		for _, v := range peerViews {
-			peerChain = append(peerChain, ChainBlock{
-				Height:    v.Height,
-				Timestamp: v.Timestamp,
-				Data:      v.Data,
-				Hash:      v.Hash,
-				PrevHash:  v.PrevHash,
-			})
+		c, err := peerHeaders(p, version, local)
+		if err != nil {
+			log.Printf("⚠️  Failed to fetch headers from peer %s: %v", p, err)
+			forgetPeer(p)
+			continue
 		}
-
-		if !isChainValid(peerChain) {
-			log.Printf("⚠️  Peer chain from %s is not valid", p)
+		if c == nil {
 			continue
 		}
+		merged := false
+		for _, other := range chains {
+			if other.base == c.base && other.tip().Hash == c.tip().Hash {
+				other.peers = append(other.peers, p)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			chains = append(chains, c)
+		}
+	}
+
+	// Pick the chain the fork-choice policy prefers; the first one offered
+	// wins a tie.
+	mu.RLock()
+	local := ledgerHeaders(ledger)
+	mu.RUnlock()
+	var best *headerChain
+	for _, c := range chains {
+		if best == nil || forkChoice.Prefer(best.full(local), c.full(local)) {
+			best = c
+		}
+	}
+	if best != nil {
+		syncBodies(best)
+	}
+}
 
-		mu.Lock()
-		if len(peerChain) > len(ledger) {
-			log.Printf("🔄 Adopting longer chain from %s (len=%d > %d)", p, len(peerChain), len(ledger))
-			ledger = peerChain
+// syncFromPeer syncs with a peer older than protocol version 3. It asks
+// only for the blocks after the tip of local, the headers of the ledger;
+// on a fork, and from peers that ignore since_hash, it falls back to the
+// whole chain.
+func syncFromPeer(p string, version int, local []BlockHeader) {
+	tip := local[len(local)-1]
+	query := "?since_hash=" + tip.Hash + "&since_height=" + strconv.Itoa(tip.Height)
+	peerViews, fork, err := fetchChain(p, version, query)
+	if err != nil {
+		log.Printf("⚠️  Failed to fetch from peer %s: %v", p, err)
+		forgetPeer(p)
+		return
+	}
+	switch {
+	case fork != nil:
+		notePeerHeight(p, fork.Tip)
+	case len(peerViews) == 0:
+		notePeerHeight(p, tip.Height)
+	default:
+		notePeerHeight(p, peerViews[len(peerViews)-1].Height)
+	}
+	switch {
+	case fork != nil && !forkChoice.MayPrefer(local, fork.Tip):
+		// The fork-choice policy would not adopt it.
+		return
+	case fork != nil:
+		log.Printf("🍴 Fork with %s at height %d (peer tip %d), fetching its chain", p, fork.Height, fork.Tip)
+		if peerViews, _, err = fetchChain(p, version, ""); err != nil {
+			log.Printf("⚠️  Failed to fetch from peer %s: %v", p, err)
+			forgetPeer(p)
+			return
 		}
-		mu.Unlock()
+	case len(peerViews) == 0:
+		return
+	case peerViews[0].Height > 0:
+		if peerViews, err = fillViewGaps(p, tip.Height, peerViews); err != nil {
+			log.Printf("⚠️  Blocks from %s: %v", p, err)
+			return
+		}
+		appendPeerBlocks(p, peerViews)
+		return
+	}
+	if peerViews, err = fillViewGaps(p, -1, peerViews); err != nil {
+		log.Printf("⚠️  Peer chain from %s: %v", p, err)
+		return
+	}
+
+	peerChain := make([]ChainBlock, 0, len(peerViews))
+	for _, v := range peerViews {
+		peerChain = append(peerChain, fromView(v))
+	}
+
+	if !isChainValid(peerChain) {
+		log.Printf("⚠️  Peer chain from %s is not valid", p)
+		return
+	}
+
+	mu.Lock()
+	if forkChoice.Prefer(ledgerHeaders(ledger), ledgerHeaders(peerChain)) {
+		log.Printf("🔄 Adopting %s chain from %s (len=%d, was %d)", forkChoice.Name(), p, len(peerChain), len(ledger))
+		recordReorg(ledger, peerChain, p)
+		ledger = peerChain
+		notifyTip()
+		recordSyncProgress(peerChain[len(peerChain)-1].Height)
+		saveSyncState()
 	}
+	mu.Unlock()
 }
 
 func main() {
 	_ = godotenv.Load()
+	importFile := flag.String("import", os.Getenv("IMPORT_FILE"), "JSONL chain from GET /export to load before serving")
+	repair := flag.Bool("repair", os.Getenv("REPAIR_CHAIN") == "true", "truncate a corrupt -import file to its last valid block instead of exiting")
+	flag.Parse()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -279,12 +521,61 @@ func main() {
 		}
 	}
 
+	if err := loadPeerClient(); err != nil {
+		log.Fatalf("peer client config: %v", err)
+	}
+	chaos, err := loadChaos()
+	if err != nil {
+		log.Fatalf("chaos config: %v", err)
+	}
+	meterPeerClient()
+	if err := loadProtocol(); err != nil {
+		log.Fatalf("protocol config: %v", err)
+	}
+	if err := loadMinBlockInterval(); err != nil {
+		log.Fatalf("block interval config: %v", err)
+	}
+	if err := loadSyncConfig(); err != nil {
+		log.Fatalf("sync config: %v", err)
+	}
+	if err := loadSyncSchedule(); err != nil {
+		log.Fatalf("sync schedule config: %v", err)
+	}
+	if err := loadForkChoice(); err != nil {
+		log.Fatalf("fork choice config: %v", err)
+	}
+	if err := loadReorgAlerts(); err != nil {
+		log.Fatalf("reorg alert config: %v", err)
+	}
+	if err := loadPeerFilter(); err != nil {
+		log.Fatalf("peer filter config: %v", err)
+	}
+	if err := loadPeerPool(port); err != nil {
+		log.Fatalf("peer pool config: %v", err)
+	}
+	if err := loadSchemas(); err != nil {
+		log.Fatalf("data schemas: %v", err)
+	}
+	if err := loadIPFS(); err != nil {
+		log.Fatalf("ipfs config: %v", err)
+	}
+	if err := loadAPIVersioning(); err != nil {
+		log.Fatalf("api config: %v", err)
+	}
+	if err := loadHTTPMiddleware(); err != nil {
+		log.Fatalf("middleware config: %v", err)
+	}
+	if err := loadIdentity(); err != nil {
+		log.Fatalf("identity config: %v", err)
+	}
+
 	genesis := ChainBlock{
 		Height:    0,
-		Timestamp: time.Now().Unix(),
+		Timestamp: clk.Now().Unix(),
 		Data:      "Genesis 🌐 " + netName,
 		Hash:      "",
 		PrevHash:  "",
+		Version:   blockVersion,
 	}
 	genesis.Hash = computeHash(genesis)
 
@@ -292,14 +583,54 @@ func main() {
 	ledger = append(ledger, genesis)
 	mu.Unlock()
 
+	if *importFile != "" {
+		if err := checkStoredChain(*importFile, *repair); err != nil {
+			log.Fatalf("import %s: %v", *importFile, err)
+		}
+		n, err := importChain(*importFile)
+		if err != nil {
+			log.Fatalf("import %s: %v", *importFile, err)
+		}
+		log.Printf("📥 Imported %d blocks from %s", n, *importFile)
+		mu.Lock()
+		saveSyncState()
+		mu.Unlock()
+	} else if syncStateFile != "" {
+		height, err := loadSyncState()
+		if err != nil {
+			log.Fatalf("sync state %s: %v", syncStateFile, err)
+		}
+		if height >= 0 {
+			log.Printf("⏯️  Resuming sync from height %d saved in %s", height, syncStateFile)
+		}
+	}
+
 	addr := ":" + port
 	log.Printf("%s", netBanner)
 	log.Printf("📡 Node listening on %s", addr)
+	log.Printf("🔑 Node key %s", nodePub)
 	if len(peers) > 0 {
 		log.Printf("🤝 Peers: %v", peers)
 	}
+	if len(peerWhitelist) > 0 {
+		log.Printf("🔐 Trusted-only mode: syncing with whitelisted peers only")
+	}
+	if maxPeers > 0 {
+		log.Printf("👥 At most %d active peers, rotating every %s", maxPeers, peerRotateEvery)
+	}
+	if syncMode == syncModeAdaptive {
+		log.Printf("⏱️  Adaptive sync: every %s while behind, backing off from %s to %s", syncIntervalMin, syncInterval, syncIntervalMax)
+	} else {
+		log.Printf("⏱️  Syncing every %s", syncInterval)
+	}
+	if chaos {
+		log.Printf("🌪️  Chaos mode: peer requests are delayed, dropped and flapped (CHAOS_*)")
+	}
 
-	go syncLoop(5 * time.Second)
+	syncLoop(withJitter(syncInterval))
+	if maxPeers > 0 {
+		rotateLoop(peerRotateEvery)
+	}
 
 	if err := http.ListenAndServe(addr, makeRouter()); err != nil {
 		log.Fatalf("server error: %v", err)
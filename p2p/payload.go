@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Binary payloads. A block may carry raw bytes (a document, an image
+// hash, a CBOR blob) next to its data string, together with the MIME
+// type that says how to read them. The block hash covers the bytes
+// themselves, not their base64 form, so re-encoding never changes it.
+// Large payloads may instead be kept in IPFS (see ipfs.go), and any
+// payload may be encrypted to an access key (see encrypt.go).
+
+// maxPayloadBytes caps the raw size of a block's payload.
+const maxPayloadBytes = 256 << 10
+
+// Blob is the binary payload of a block: either the bytes themselves,
+// which travel base64-encoded in JSON, or the CID and size of bytes
+// stored in IPFS.
+type Blob struct {
+	ContentType string `json:"contentType,omitempty"`
+	Payload     []byte `json:"payload,omitempty"`
+	CID         string `json:"cid,omitempty"`
+	Size        int    `json:"size,omitempty"` // of the IPFS content
+
+	// RecipientKey is the access key an encrypted payload is sealed to;
+	// ContentType then describes the plaintext.
+	RecipientKey string `json:"recipientKey,omitempty"`
+}
+
+// blobExtension returns the canonical encoding of a blob, appended to the
+// hash record: "" without a payload, so plain blocks keep their hashes,
+// "|c" plus the length-prefixed content type, CID and size for IPFS
+// content, else "|b" plus the length-prefixed content type and raw bytes.
+// Encrypted payloads add "|e" and the length-prefixed recipient key.
+func blobExtension(b Blob) string {
+	var enc string
+	if b.RecipientKey != "" {
+		enc = fmt.Sprintf("|e%d:%s", len(b.RecipientKey), b.RecipientKey)
+	}
+	if b.CID != "" || b.Size != 0 {
+		return fmt.Sprintf("|c%d:%s%d:%s%d", len(b.ContentType), b.ContentType, len(b.CID), b.CID, b.Size) + enc
+	}
+	if len(b.Payload) == 0 && b.ContentType == "" {
+		return enc
+	}
+	return fmt.Sprintf("|b%d:%s%d:%s", len(b.ContentType), b.ContentType, len(b.Payload), b.Payload) + enc
+}
+
+// checkBlob rejects oversized payloads, payloads without a valid content
+// type, content types without a payload and blobs that are both inline
+// and in IPFS, as well as malformed encrypted payloads.
+func checkBlob(b Blob) error {
+	if err := checkEncrypted(b); err != nil {
+		return err
+	}
+	inIPFS := b.CID != "" || b.Size != 0
+	switch {
+	case inIPFS && len(b.Payload) > 0:
+		return errors.New("a payload is either inline or in IPFS, not both")
+	case inIPFS:
+		if err := checkCID(b.CID); err != nil {
+			return err
+		}
+		if b.Size <= 0 {
+			return errors.New("size of IPFS content must be positive")
+		}
+	case len(b.Payload) == 0:
+		if b.ContentType != "" {
+			return errors.New("contentType given without a payload")
+		}
+		return nil
+	case len(b.Payload) > maxPayloadBytes:
+		return fmt.Errorf("payload exceeds %d bytes", maxPayloadBytes)
+	}
+	if b.ContentType == "" {
+		return errors.New("contentType is required with a payload")
+	}
+	if _, _, err := mime.ParseMediaType(b.ContentType); err != nil {
+		return fmt.Errorf("invalid contentType %q", b.ContentType)
+	}
+	return nil
+}
+
+// prepareBlob encrypts a submitted payload to encryptTo, if given,
+// moves it to IPFS if it is large enough (see offloadBlob) and checks the
+// result. On failure it also returns the HTTP status to answer with.
+func prepareBlob(b Blob, encryptTo string) (Blob, int, error) {
+	if encryptTo != "" {
+		if b.RecipientKey != "" {
+			return b, http.StatusBadRequest, errors.New("give encryptTo to have the node encrypt, or recipientKey for a payload encrypted already, not both")
+		}
+		if len(b.Payload) == 0 {
+			return b, http.StatusBadRequest, errors.New("encryptTo given without an inline payload")
+		}
+		sealed, err := encryptPayload(encryptTo, b.Payload)
+		if err != nil {
+			return b, http.StatusBadRequest, err
+		}
+		b.Payload, b.RecipientKey = sealed, encryptTo
+	}
+	if limit := payloadLimit(); len(b.Payload) > limit {
+		return b, http.StatusRequestEntityTooLarge, fmt.Errorf("payload exceeds %d bytes", limit)
+	}
+	b, err := offloadBlob(b)
+	if err != nil {
+		return b, http.StatusBadGateway, err
+	}
+	if err := checkBlob(b); err != nil {
+		return b, http.StatusBadRequest, err
+	}
+	return b, http.StatusOK, nil
+}
+
+// pushBlobHandler serves POST /push/blob: the raw request body becomes
+// the payload of a new block, typed by the request's Content-Type, with
+// ?data= as the block's data string. ?encryptTo= has the node encrypt the
+// payload to an access key, and ?recipientKey= marks a body the client
+// encrypted already.
+func pushBlobHandler(w http.ResponseWriter, r *http.Request) {
+	limit := payloadLimit()
+	raw, err := io.ReadAll(io.LimitReader(r.Body, int64(limit)+1))
+	if err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if len(raw) > limit {
+		httpapi.WriteError(w, fmt.Sprintf("payload exceeds %d bytes", limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(raw) == 0 {
+		httpapi.WriteError(w, "payload is required", http.StatusBadRequest)
+		return
+	}
+	data := r.URL.Query().Get("data")
+	if dataType(data) != "" {
+		httpapi.WriteError(w, "typed data must be sent to POST /push", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	blob := Blob{ContentType: r.Header.Get("Content-Type"), Payload: raw, RecipientKey: q.Get("recipientKey")}
+	blob, status, err := prepareBlob(blob, q.Get("encryptTo"))
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), status)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	last := ledger[len(ledger)-1]
+	if wait := blockWait(last); wait > 0 {
+		writeTooSoon(w, wait)
+		return
+	}
+	nb := newBlock(last, data, blob, nil)
+	if !isBlockValid(nb, last) {
+		httpapi.WriteError(w, "new block is not valid", http.StatusInternalServerError)
+		return
+	}
+
+	ledger = append(ledger, nb)
+	notifyTip()
+	httpapi.LogRequest(r, "🧱 New local block: height=%d hash=%s payload=%d bytes", nb.Height, nb.Hash, len(raw))
+	id := httpapi.RequestID(r)
+	clk.AfterFunc(0, func() { announceBlock(nb, id) })
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(toView(nb))
+}
+
+// payloadHandler serves GET /blocks/{height}/payload: the raw payload of
+// a block, with its content type, fetched from IPFS if the block only
+// records its CID. Encrypted payloads are served as ciphertext, with the
+// plaintext's content type in X-Payload-Content-Type.
+func payloadHandler(w http.ResponseWriter, r *http.Request) {
+	h, err := strconv.Atoi(mux.Vars(r)["height"])
+	if err != nil || h < 0 {
+		httpapi.WriteError(w, "height must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	mu.RLock()
+	if h >= len(ledger) {
+		mu.RUnlock()
+		httpapi.WriteError(w, "block not found", http.StatusNotFound)
+		return
+	}
+	b := ledger[h]
+	mu.RUnlock()
+
+	contentType := b.ContentType
+	if b.RecipientKey != "" {
+		contentType = "application/octet-stream"
+		w.Header().Set("X-Payload-Content-Type", b.ContentType)
+		w.Header().Set("X-Recipient-Key", b.RecipientKey)
+	}
+	if b.CID != "" {
+		body, err := ipfsCat(b.CID)
+		if err != nil {
+			httpapi.WriteError(w, fmt.Sprintf("payload %s is stored in IPFS: %v", b.CID, err), http.StatusBadGateway)
+			return
+		}
+		defer body.Close()
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(b.Size))
+		w.Header().Set("ETag", strconv.Quote(b.Hash))
+		_, _ = io.Copy(w, io.LimitReader(body, int64(b.Size)))
+		return
+	}
+	if len(b.Payload) == 0 {
+		httpapi.WriteError(w, "block has no payload", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b.Payload)))
+	w.Header().Set("ETag", strconv.Quote(b.Hash))
+	_, _ = w.Write(b.Payload)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Peer HTTP client. Requests to peers go through peerClient, which bounds
+// how long a peer may take to accept a connection (PEER_CONNECT_TIMEOUT),
+// to start answering (PEER_READ_TIMEOUT) and to finish (PEER_TIMEOUT),
+// and keeps up to PEER_MAX_IDLE_CONNS idle connections to each peer for
+// PEER_IDLE_TIMEOUT. Responses are read through doPeer, which refuses a
+// body larger than PEER_MAX_RESPONSE bytes. Failures are *PeerError
+// values that errors.Is matches against errPeerUnreachable,
+// errPeerTimeout and errPeerTooLarge.
+
+var (
+	peerConnectTimeout = 5 * time.Second
+	peerReadTimeout    = 15 * time.Second
+	peerTimeout        = time.Minute
+	peerIdleTimeout    = 90 * time.Second
+	peerMaxIdleConns   = 2
+	peerMaxResponse    = int64(64 << 20)
+)
+
+var (
+	errPeerUnreachable = errors.New("peer unreachable")
+	errPeerTimeout     = errors.New("peer timed out")
+	errPeerTooLarge    = errors.New("peer response too large")
+	errPeerRequest     = errors.New("peer request failed")
+)
+
+// PeerError is a failed request to a peer. Kind is one of the errPeer*
+// errors.
+type PeerError struct {
+	Peer string
+	Kind error
+	Err  error
+}
+
+func (e *PeerError) Error() string { return fmt.Sprintf("%v: %v", e.Kind, e.Err) }
+
+func (e *PeerError) Unwrap() error { return e.Err }
+
+func (e *PeerError) Is(target error) bool { return target == e.Kind }
+
+// peerError classifies err, from a request to peer, as a *PeerError.
+func peerError(peer string, err error) error {
+	var pe *PeerError
+	if errors.As(err, &pe) {
+		return err
+	}
+	kind := errPeerRequest
+	var ne net.Error
+	var op *net.OpError
+	switch {
+	case errors.As(err, &ne) && ne.Timeout():
+		kind = errPeerTimeout
+	case errors.As(err, &op) && op.Op == "dial":
+		kind = errPeerUnreachable
+	}
+	return &PeerError{Peer: peer, Kind: kind, Err: err}
+}
+
+// loadPeerClient reads PEER_CONNECT_TIMEOUT, PEER_READ_TIMEOUT,
+// PEER_TIMEOUT, PEER_IDLE_TIMEOUT, PEER_MAX_IDLE_CONNS and
+// PEER_MAX_RESPONSE, and builds peerClient.
+func loadPeerClient() error {
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"PEER_CONNECT_TIMEOUT", &peerConnectTimeout},
+		{"PEER_READ_TIMEOUT", &peerReadTimeout},
+		{"PEER_TIMEOUT", &peerTimeout},
+		{"PEER_IDLE_TIMEOUT", &peerIdleTimeout},
+	}
+	for _, d := range durations {
+		if v := os.Getenv(d.env); v != "" {
+			dur, err := time.ParseDuration(v)
+			if err != nil || dur <= 0 {
+				return fmt.Errorf("invalid %s %q", d.env, v)
+			}
+			*d.dst = dur
+		}
+	}
+	if v := os.Getenv("PEER_MAX_IDLE_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid PEER_MAX_IDLE_CONNS %q", v)
+		}
+		peerMaxIdleConns = n
+	}
+	if v := os.Getenv("PEER_MAX_RESPONSE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid PEER_MAX_RESPONSE %q (bytes)", v)
+		}
+		peerMaxResponse = n
+	}
+
+	dialer := &net.Dialer{Timeout: peerConnectTimeout, KeepAlive: 30 * time.Second}
+	peerClient = &http.Client{
+		Timeout: peerTimeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   peerConnectTimeout,
+			ResponseHeaderTimeout: peerReadTimeout,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   peerMaxIdleConns,
+			IdleConnTimeout:       peerIdleTimeout,
+		},
+	}
+	return nil
+}
+
+// doPeer sends req to peer and reads the response body, which is closed
+// on return.
+func doPeer(peer string, req *http.Request) (*http.Response, []byte, error) {
+	resp, err := peerClient.Do(req)
+	if err != nil {
+		return nil, nil, failPeer(peer, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, peerMaxResponse+1))
+	if err != nil {
+		return nil, nil, failPeer(peer, fmt.Errorf("read response: %w", err))
+	}
+	if int64(len(body)) > peerMaxResponse {
+		return nil, nil, failPeer(peer, &PeerError{Peer: peer, Kind: errPeerTooLarge, Err: fmt.Errorf("more than %d bytes", peerMaxResponse)})
+	}
+	return resp, body, nil
+}
+
+// failPeer classifies err and keeps it as the peer's last error.
+func failPeer(peer string, err error) error {
+	err = peerError(peer, err)
+	notePeerError(peer, err)
+	return err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"alirezachain/httpapi"
+)
+
+// peerRule matches a peer by address. It is a CIDR range, or a host with
+// an optional port; a host name also matches the addresses it resolved
+// to when the rule was loaded.
+type peerRule struct {
+	entry string
+	host  string
+	port  string // empty matches any port
+	ips   []net.IP
+	ipnet *net.IPNet
+}
+
+// Trusted-only mode and the blacklist (PEER_WHITELIST, PEER_BLACKLIST).
+// With a whitelist the node syncs with, announces to and accepts
+// announcements from whitelisted peers only. Blacklisted peers are never
+// contacted and may not push or announce blocks.
+var (
+	peerWhitelist []peerRule
+	peerBlacklist []peerRule
+
+	peerFilterMu sync.Mutex
+	peerVerdicts = make(map[string]bool) // peer URL -> verdict of peerAllowed
+)
+
+// loadPeerFilter reads PEER_WHITELIST and PEER_BLACKLIST, comma-separated
+// lists of peer URLs, hosts, host:port pairs and CIDR ranges.
+func loadPeerFilter() error {
+	var err error
+	if peerWhitelist, err = parsePeerRules("PEER_WHITELIST"); err != nil {
+		return err
+	}
+	peerBlacklist, err = parsePeerRules("PEER_BLACKLIST")
+	return err
+}
+
+func parsePeerRules(env string) ([]peerRule, error) {
+	var rules []peerRule
+	for _, entry := range strings.Split(os.Getenv(env), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule := peerRule{entry: entry}
+		switch {
+		case strings.Contains(entry, "://"):
+			u, err := url.Parse(entry)
+			if err != nil || u.Hostname() == "" {
+				return nil, fmt.Errorf("invalid %s entry %q", env, entry)
+			}
+			rule.host, rule.port = u.Hostname(), u.Port()
+		case strings.Contains(entry, "/"):
+			_, ipnet, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q", env, entry)
+			}
+			rule.ipnet = ipnet
+		default:
+			rule.host = entry
+			if h, p, err := net.SplitHostPort(entry); err == nil {
+				rule.host, rule.port = h, p
+			}
+		}
+		if rule.host != "" {
+			rule.ips = resolveHost(rule.host)
+			if rule.ips == nil {
+				log.Printf("⚠️  %s entry %q does not resolve; matching it by name only", env, entry)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// resolveHost returns the addresses of host, or nil if it has none.
+func resolveHost(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil
+	}
+	return ips
+}
+
+// matches reports whether the rule covers a peer at host and port, which
+// resolved to ips. An empty port, as for inbound requests, matches any
+// rule on the host.
+func (r peerRule) matches(host, port string, ips []net.IP) bool {
+	if r.ipnet != nil {
+		for _, ip := range ips {
+			if r.ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	if r.port != "" && port != "" && r.port != port {
+		return false
+	}
+	if strings.EqualFold(r.host, host) {
+		return true
+	}
+	for _, a := range r.ips {
+		for _, b := range ips {
+			if a.Equal(b) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// peerListed reports whether any of rules covers the peer.
+func peerListed(rules []peerRule, host, port string, ips []net.IP) bool {
+	for _, r := range rules {
+		if r.matches(host, port, ips) {
+			return true
+		}
+	}
+	return false
+}
+
+// addressAllowed applies the blacklist and, in trusted-only mode, the
+// whitelist to a peer address.
+func addressAllowed(host, port string, ips []net.IP) bool {
+	if peerListed(peerBlacklist, host, port, ips) {
+		return false
+	}
+	return len(peerWhitelist) == 0 || peerListed(peerWhitelist, host, port, ips)
+}
+
+// peerAllowed reports whether the node may contact the peer at URL p.
+// Verdicts are cached per URL.
+func peerAllowed(p string) bool {
+	if len(peerWhitelist) == 0 && len(peerBlacklist) == 0 {
+		return true
+	}
+	peerFilterMu.Lock()
+	defer peerFilterMu.Unlock()
+	if ok, seen := peerVerdicts[p]; seen {
+		return ok
+	}
+	ok := false
+	if u, err := url.Parse(p); err == nil && u.Hostname() != "" {
+		ok = addressAllowed(u.Hostname(), u.Port(), resolveHost(u.Hostname()))
+	}
+	peerVerdicts[p] = ok
+	if !ok {
+		log.Printf("🚫 Not contacting peer %s: blacklisted or not whitelisted", p)
+	}
+	return ok
+}
+
+// requestHost returns the source address of r.
+func requestHost(r *http.Request) (string, []net.IP) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return host, []net.IP{ip}
+	}
+	return host, nil
+}
+
+// trustedPeer guards handlers for peer traffic, such as announcements:
+// requests from blacklisted addresses, and in trusted-only mode from
+// addresses that are not whitelisted, get 403 Forbidden.
+func trustedPeer(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, ips := requestHost(r)
+		if !addressAllowed(host, "", ips) {
+			httpapi.WriteError(w, "peer not allowed", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// notBlacklisted guards handlers that create blocks: requests from
+// blacklisted addresses get 403 Forbidden.
+func notBlacklisted(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, ips := requestHost(r)
+		if peerListed(peerBlacklist, host, "", ips) {
+			httpapi.WriteError(w, "address is blacklisted", http.StatusForbidden)
+			return
+		}
+		h(w, r)
+	}
+}
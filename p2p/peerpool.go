@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer scoring: a successful exchange earns a point, a failed one costs
+// peerFailurePenalty. Scores are clamped to ±maxPeerScore so that a peer
+// with a long record can still be rotated out after it goes bad.
+const (
+	peerFailurePenalty = 5
+	maxPeerScore       = 100
+	maxPeerCandidates  = 1000
+)
+
+// The peer pool (MAX_PEERS). When set, at most maxPeers peers are synced
+// with; the others wait as candidates. Every peerRotateEvery the node
+// learns candidates from the GET /peers of its active peers and swaps
+// its peerRotateCount lowest-scoring peers for the best candidates.
+var (
+	maxPeers        int
+	peerRotateEvery = time.Minute
+	peerRotateCount = 1
+
+	// nodeURL is how peers reach this node (NODE_URL), so that it does
+	// not add itself when it learns the peers of its peers.
+	nodeURL string
+
+	poolMu     sync.Mutex
+	candidates []string               // known peers that are not active
+	peerScores = make(map[string]int) // peers that have been active; others are untried
+)
+
+// loadPeerPool reads MAX_PEERS, PEER_ROTATE_INTERVAL and
+// PEER_ROTATE_COUNT, and moves the PEERS beyond the first MAX_PEERS to
+// the candidates.
+func loadPeerPool(port string) error {
+	if v := os.Getenv("MAX_PEERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid MAX_PEERS %q", v)
+		}
+		maxPeers = n
+	}
+	if v := os.Getenv("PEER_ROTATE_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid PEER_ROTATE_INTERVAL %q", v)
+		}
+		peerRotateEvery = d
+	}
+	if v := os.Getenv("PEER_ROTATE_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid PEER_ROTATE_COUNT %q", v)
+		}
+		peerRotateCount = n
+	}
+	nodeURL = os.Getenv("NODE_URL")
+	if nodeURL == "" {
+		nodeURL = "http://localhost:" + port
+	}
+
+	if maxPeers > 0 && len(peers) > maxPeers {
+		candidates = append(candidates, peers[maxPeers:]...)
+		peers = peers[:maxPeers:maxPeers]
+	}
+	return nil
+}
+
+// activePeers returns the peers currently synced with.
+func activePeers() []string {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	return append([]string(nil), peers...)
+}
+
+// scorePeer adds delta to the score of peer.
+func scorePeer(peer string, delta int) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	s := peerScores[peer] + delta
+	if s > maxPeerScore {
+		s = maxPeerScore
+	} else if s < -maxPeerScore {
+		s = -maxPeerScore
+	}
+	peerScores[peer] = s
+}
+
+// rotateLoop discovers candidates and rotates peers every interval.
+func rotateLoop(interval time.Duration) {
+	clk.AfterFunc(interval, func() {
+		discoverPeers()
+		rotatePeers()
+		rotateLoop(interval)
+	})
+}
+
+// discoverPeers adds the peers of the active peers to the candidates.
+func discoverPeers() {
+	for _, p := range activePeers() {
+		v, err := peerVersion(p)
+		if err != nil {
+			continue
+		}
+		status, body, err := peerGet(p, "/peers", v)
+		if err != nil || status != http.StatusOK {
+			continue
+		}
+		var theirs []string
+		if err := json.Unmarshal(body, &theirs); err != nil {
+			continue
+		}
+		for _, c := range theirs {
+			addCandidate(strings.TrimRight(strings.TrimSpace(c), "/"))
+		}
+	}
+}
+
+// addCandidate records a newly discovered peer.
+func addCandidate(c string) {
+	if c == "" || c == strings.TrimRight(nodeURL, "/") || !peerAllowed(c) {
+		return
+	}
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if len(candidates) >= maxPeerCandidates {
+		return
+	}
+	for _, list := range [][]string{peers, candidates} {
+		for _, p := range list {
+			if strings.TrimRight(p, "/") == c {
+				return
+			}
+		}
+	}
+	candidates = append(candidates, c)
+	log.Printf("🔭 Discovered peer %s", c)
+}
+
+// rotatePeers fills free slots with candidates, then swaps the
+// lowest-scoring active peers for untried or better-scoring candidates.
+// Peers rotated out become candidates again and keep their score, so
+// every rotation gives one new peer a trial while good peers come back.
+func rotatePeers() {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if len(candidates) == 0 {
+		return
+	}
+	// Untried candidates first, in discovery order, then by score.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		_, triedI := peerScores[candidates[i]]
+		_, triedJ := peerScores[candidates[j]]
+		if triedI != triedJ {
+			return !triedI
+		}
+		return peerScores[candidates[i]] > peerScores[candidates[j]]
+	})
+	for len(peers) < maxPeers && len(candidates) > 0 {
+		log.Printf("➕ Adding peer %s", candidates[0])
+		peers = append(peers, candidates[0])
+		candidates = candidates[1:]
+	}
+
+	sort.SliceStable(peers, func(i, j int) bool {
+		return peerScores[peers[i]] < peerScores[peers[j]]
+	})
+	for i := 0; i < peerRotateCount && i < len(peers) && len(candidates) > 0; i++ {
+		out, in := peers[i], candidates[0]
+		score, tried := peerScores[in]
+		if tried && score <= peerScores[out] {
+			break
+		}
+		log.Printf("🔁 Rotating out peer %s (score %d) for %s", out, peerScores[out], in)
+		peerScores[out] += 0 // mark as tried
+		peers[i] = in
+		candidates = append(candidates[1:], out)
+		dropPeerHeight(out)
+	}
+}
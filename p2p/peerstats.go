@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Peer accounting. Every request to a peer goes through peerClient,
+// whose meteredTransport times it and counts the bytes sent to and
+// received from each peer. The round-trip time is the time until the
+// response headers arrive, smoothed with weight rttWeight for the newest
+// sample. Block ranges are requested from the fastest peers first (see
+// byLatency). GET /peers?detail=true and GET /metrics report the
+// figures.
+
+// rttWeight is the weight of the newest sample in the smoothed RTT.
+const rttWeight = 0.2
+
+// PeerStats is the traffic with one peer since the node started.
+type PeerStats struct {
+	Peer          string  `json:"peer"`
+	Score         int     `json:"score"`
+	Requests      int64   `json:"requests"`
+	Failures      int64   `json:"failures"` // requests without a response
+	RTTMs         float64 `json:"rttMs"`    // smoothed; 0 before the first response
+	LastRTTMs     float64 `json:"lastRttMs"`
+	BytesSent     int64   `json:"bytesSent"`     // request bodies
+	BytesReceived int64   `json:"bytesReceived"` // response bodies
+	LastSeen      string  `json:"lastSeen,omitempty"`
+	LastError     string  `json:"lastError,omitempty"`
+}
+
+var (
+	statsMu   sync.Mutex
+	peerStats = make(map[string]*PeerStats) // peerKey -> stats
+)
+
+// peerKey identifies a peer by the scheme and host of its URL, so that
+// "http://a:8090" and "http://a:8090/" share their figures.
+func peerKey(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(raw, "/")
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// statsFor returns the stats of key, creating them. Callers must hold
+// statsMu.
+func statsFor(key string) *PeerStats {
+	s := peerStats[key]
+	if s == nil {
+		s = &PeerStats{Peer: key}
+		peerStats[key] = s
+	}
+	return s
+}
+
+// meteredTransport records the time and traffic of every peer request.
+type meteredTransport struct {
+	next http.RoundTripper
+}
+
+// meterPeerClient routes peerClient, including a chaos transport,
+// through a meteredTransport.
+func meterPeerClient() {
+	next := peerClient.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	peerClient = &http.Client{Transport: &meteredTransport{next: next}, Timeout: peerClient.Timeout}
+}
+
+func (t *meteredTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.Scheme + "://" + req.URL.Host
+	start := clk.Now()
+	resp, err := t.next.RoundTrip(req)
+	rtt := clk.Now().Sub(start)
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := statsFor(key)
+	s.Requests++
+	if req.ContentLength > 0 {
+		s.BytesSent += req.ContentLength
+	}
+	if err != nil {
+		s.Failures++
+		return nil, err
+	}
+	ms := float64(rtt) / float64(time.Millisecond)
+	if s.RTTMs == 0 {
+		s.RTTMs = ms
+	} else {
+		s.RTTMs += rttWeight * (ms - s.RTTMs)
+	}
+	s.LastRTTMs = ms
+	s.LastSeen = clk.Now().Format(time.RFC3339)
+	resp.Body = &countingBody{ReadCloser: resp.Body, key: key}
+	return resp, nil
+}
+
+// countingBody adds what is read from a response to its peer's stats.
+type countingBody struct {
+	io.ReadCloser
+	key string
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		statsMu.Lock()
+		statsFor(b.key).BytesReceived += int64(n)
+		statsMu.Unlock()
+	}
+	return n, err
+}
+
+// notePeerError keeps err as the last error of peer.
+func notePeerError(peer string, err error) {
+	statsMu.Lock()
+	statsFor(peerKey(peer)).LastError = err.Error()
+	statsMu.Unlock()
+}
+
+// peerRTT returns the smoothed RTT of peer, or 0 if it never answered.
+func peerRTT(peer string) float64 {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if s := peerStats[peerKey(peer)]; s != nil {
+		return s.RTTMs
+	}
+	return 0
+}
+
+// byLatency returns peers ordered by smoothed RTT, fastest first; peers
+// that never answered keep their order after the others.
+func byLatency(peers []string) []string {
+	sorted := append([]string(nil), peers...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := peerRTT(sorted[i]), peerRTT(sorted[j])
+		if a == 0 || b == 0 {
+			return a != 0 && b == 0
+		}
+		return a < b
+	})
+	return sorted
+}
+
+// allPeerStats returns the stats of every peer contacted, with its
+// score, in peer order.
+func allPeerStats() []PeerStats {
+	poolMu.Lock()
+	scores := make(map[string]int, len(peerScores))
+	for p, s := range peerScores {
+		scores[peerKey(p)] = s
+	}
+	poolMu.Unlock()
+
+	statsMu.Lock()
+	list := make([]PeerStats, 0, len(peerStats))
+	for _, s := range peerStats {
+		st := *s
+		st.Score = scores[st.Peer]
+		list = append(list, st)
+	}
+	statsMu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Peer < list[j].Peer })
+	return list
+}
+
+// peerStatsHandler answers GET /peers?detail=true with the figures of
+// the active peers.
+func peerStatsHandler(w http.ResponseWriter, r *http.Request) {
+	active := make(map[string]bool)
+	for _, p := range activePeers() {
+		active[peerKey(p)] = true
+	}
+	list := []PeerStats{}
+	for _, s := range allPeerStats() {
+		if active[s.Peer] {
+			list = append(list, s)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(list)
+}
+
+// labelValue escapes a Prometheus label value.
+var labelValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsHandler serves the chain height and the figures of every peer
+// contacted in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	height := ledger[len(ledger)-1].Height
+	mu.RUnlock()
+	stats := allPeerStats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(w, "# HELP alimia_chain_height Height of the local chain tip.\n# TYPE alimia_chain_height gauge\nalimia_chain_height %d\n", height)
+	metrics := []struct {
+		name, kind, help string
+		value            func(PeerStats) float64
+	}{
+		{"alimia_peer_rtt_seconds", "gauge", "Smoothed round-trip time to the peer.", func(s PeerStats) float64 { return s.RTTMs / 1000 }},
+		{"alimia_peer_requests_total", "counter", "Requests sent to the peer.", func(s PeerStats) float64 { return float64(s.Requests) }},
+		{"alimia_peer_request_failures_total", "counter", "Requests to the peer that got no response.", func(s PeerStats) float64 { return float64(s.Failures) }},
+		{"alimia_peer_sent_bytes_total", "counter", "Request body bytes sent to the peer.", func(s PeerStats) float64 { return float64(s.BytesSent) }},
+		{"alimia_peer_received_bytes_total", "counter", "Response body bytes received from the peer.", func(s PeerStats) float64 { return float64(s.BytesReceived) }},
+		{"alimia_peer_score", "gauge", "Score of the peer in the peer pool.", func(s PeerStats) float64 { return float64(s.Score) }},
+	}
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range stats {
+			fmt.Fprintf(w, "%s{peer=\"%s\"} %g\n", m.name, labelValue.Replace(s.Peer), m.value(s))
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"alirezachain/httpapi"
+)
+
+// Wire protocol versions:
+//
+//	1  GET /chain returns a bare array of blocks; no announcements.
+//	2  GET /chain returns {"version": 2, "chain": [...]}, and new local
+//	   blocks are announced to v2 peers via POST /announce.
+//	3  GET /headers and GET /blocks?from=&to= for headers-first sync
+//	   (see headers.go).
+//	4  GET /chain/diff?have= returns the fork point of a block locator
+//	   and the blocks after it (see diff.go).
+//
+// Peers agree on the highest version both support through POST
+// /handshake. A node serves every version from minProtocolVersion up to
+// protocolVersion and refuses peers outside that window; raising
+// PROTOCOL_MIN_VERSION once the whole network runs a newer release
+// retires the old wire format.
+const (
+	protocolVersion = 4
+	versionHeader   = "X-Protocol-Version"
+)
+
+// minProtocolVersion is the oldest version served (PROTOCOL_MIN_VERSION).
+var minProtocolVersion = 1
+
+var (
+	peerMu       sync.Mutex
+	peerVersions = make(map[string]int) // peer URL -> negotiated version
+)
+
+// errVersionUnsupported is returned for peers outside the support window.
+var errVersionUnsupported = errors.New("protocol version not supported")
+
+// Handshake is exchanged on POST /handshake.
+type Handshake struct {
+	Version    int    `json:"version"`    // highest version the sender speaks
+	MinVersion int    `json:"minVersion"` // oldest version the sender accepts
+	Name       string `json:"name"`
+}
+
+// BlockAnnouncement carries a newly created block (version 2).
+type BlockAnnouncement struct {
+	Version int       `json:"version"`
+	Block   BlockView `json:"block"`
+}
+
+// loadProtocol reads PROTOCOL_MIN_VERSION.
+func loadProtocol() error {
+	if v := os.Getenv("PROTOCOL_MIN_VERSION"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > protocolVersion {
+			return fmt.Errorf("invalid PROTOCOL_MIN_VERSION %q (1-%d)", v, protocolVersion)
+		}
+		minProtocolVersion = n
+	}
+	return nil
+}
+
+// negotiate returns the version to speak with a peer that supports
+// versions minV to maxV.
+func negotiate(minV, maxV int) (int, error) {
+	v := maxV
+	if v > protocolVersion {
+		v = protocolVersion
+	}
+	if v < minProtocolVersion || v < minV {
+		return 0, errVersionUnsupported
+	}
+	return v, nil
+}
+
+// requestVersion returns the version a peer request is made in. Requests
+// without the header come from nodes that predate versioning (version 1).
+func requestVersion(r *http.Request) (int, error) {
+	h := r.Header.Get(versionHeader)
+	if h == "" {
+		return negotiate(1, 1)
+	}
+	n, err := strconv.Atoi(h)
+	if err != nil || n < 1 {
+		return 0, errVersionUnsupported
+	}
+	return negotiate(1, n)
+}
+
+// rejectVersion answers a request from a peer outside the support window.
+func rejectVersion(w http.ResponseWriter) {
+	httpapi.WriteError(w, fmt.Sprintf("protocol versions %d-%d supported", minProtocolVersion, protocolVersion), http.StatusUpgradeRequired)
+}
+
+// handshakeHandler agrees on a protocol version with a peer.
+func handshakeHandler(w http.ResponseWriter, r *http.Request) {
+	var hs Handshake
+	if err := json.NewDecoder(r.Body).Decode(&hs); err != nil || hs.Version < 1 {
+		httpapi.WriteError(w, "invalid handshake", http.StatusBadRequest)
+		return
+	}
+	v, err := negotiate(hs.MinVersion, hs.Version)
+	if err != nil {
+		httpapi.LogRequest(r, "⛔ Refusing %s: speaks %d-%d, we support %d-%d", hs.Name, hs.MinVersion, hs.Version, minProtocolVersion, protocolVersion)
+		rejectVersion(w)
+		return
+	}
+
+	writeSigned(w, r, Handshake{Version: v, MinVersion: minProtocolVersion, Name: netName})
+}
+
+// peerVersion returns the version negotiated with peer, performing the
+// handshake if there is none yet. Peers that do not know /handshake are
+// treated as version 1, unless they are pinned in PEER_KEYS.
+func peerVersion(peer string) (int, error) {
+	peerMu.Lock()
+	v, ok := peerVersions[peer]
+	peerMu.Unlock()
+	if ok {
+		return v, nil
+	}
+
+	body, _ := json.Marshal(Handshake{Version: protocolVersion, MinVersion: minProtocolVersion, Name: netName})
+	challenge := newChallenge()
+	req, _ := http.NewRequest(http.MethodPost, strings.TrimRight(peer, "/")+"/handshake", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(nodeChallengeHeader, challenge)
+	peerAuth.Sign(req)
+	resp, reply, err := doPeer(peer, req)
+	if err != nil {
+		return 0, err
+	}
+	_, pinned := peerKeys[strings.TrimRight(peer, "/")]
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		raw, err := readSigned(peer, challenge, resp, reply)
+		if err != nil {
+			return 0, err
+		}
+		var hs Handshake
+		if err := json.Unmarshal(raw, &hs); err != nil {
+			return 0, fmt.Errorf("invalid handshake reply: %v", err)
+		}
+		if v, err = negotiate(hs.MinVersion, hs.Version); err != nil {
+			return 0, err
+		}
+	case pinned:
+		return 0, fmt.Errorf("pinned peer answered the handshake with %s", resp.Status)
+	case resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusMethodNotAllowed:
+		if v, err = negotiate(1, 1); err != nil {
+			return 0, err
+		}
+	case resp.StatusCode == http.StatusUpgradeRequired:
+		return 0, errVersionUnsupported
+	default:
+		return 0, fmt.Errorf("handshake failed: %s", resp.Status)
+	}
+
+	peerMu.Lock()
+	peerVersions[peer] = v
+	peerMu.Unlock()
+	log.Printf("🤝 Peer %s speaks protocol v%d", peer, v)
+	return v, nil
+}
+
+// forgetPeer drops the negotiated version so that the next contact
+// handshakes again, e.g. after the peer restarted on a new release. It is
+// called after a failed request, so it also lowers the peer's score.
+func forgetPeer(peer string) {
+	peerMu.Lock()
+	delete(peerVersions, peer)
+	peerMu.Unlock()
+	dropPeerHeight(peer)
+	scorePeer(peer, -peerFailurePenalty)
+}
+
+// announceBlock sends b to every peer that speaks version 2 or later,
+// passing on the ID of the request that produced it.
+func announceBlock(b ChainBlock, reqID string) {
+	body, _ := json.Marshal(BlockAnnouncement{Version: protocolVersion, Block: toView(b)})
+	for _, p := range activePeers() {
+		if !peerAllowed(p) {
+			continue
+		}
+		v, err := peerVersion(p)
+		if err != nil || v < 2 {
+			continue
+		}
+		req, _ := http.NewRequest(http.MethodPost, strings.TrimRight(p, "/")+"/announce", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(versionHeader, strconv.Itoa(v))
+		if reqID != "" {
+			req.Header.Set(httpapi.RequestIDHeader, reqID)
+		}
+		peerAuth.Sign(req)
+		if _, _, err := doPeer(p, req); err != nil {
+			forgetPeer(p)
+		}
+	}
+}
+
+// announceHandler appends an announced block if it extends the local
+// tip. Anything else is left to the periodic sync.
+func announceHandler(w http.ResponseWriter, r *http.Request) {
+	v, err := requestVersion(r)
+	if err != nil || v < 2 {
+		rejectVersion(w)
+		return
+	}
+	var ann BlockAnnouncement
+	if err := json.NewDecoder(r.Body).Decode(&ann); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	b := fromView(ann.Block)
+
+	mu.Lock()
+	defer mu.Unlock()
+	tip := ledger[len(ledger)-1]
+	if isBlockValid(b, tip) {
+		ledger = append(ledger, b)
+		notifyTip()
+		httpapi.LogRequest(r, "📣 Appended announced block: height=%d hash=%s", b.Height, b.Hash)
+	} else if b.Height > tip.Height+1 {
+		clk.AfterFunc(0, func() { fillAnnounced(tip.Height, ann.Block) })
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// maxReorgHistory caps how many reorganizations GET /reorgs remembers.
+const maxReorgHistory = 1000
+
+// Reorg records one replacement of the ledger by a chain that does not
+// simply extend it.
+type Reorg struct {
+	Time       string `json:"time"`
+	Depth      int    `json:"depth"`      // blocks of the old chain given up
+	ForkHeight int    `json:"forkHeight"` // last block both chains share; -1 if the genesis blocks differ
+	OldTip     int    `json:"oldTip"`
+	OldTipHash string `json:"oldTipHash"`
+	NewTip     int    `json:"newTip"`
+	NewTipHash string `json:"newTipHash"`
+	Source     string `json:"source,omitempty"` // peers the new chain came from
+	RequestID  string `json:"requestId"`        // also in the log line and the webhook's X-Request-ID
+}
+
+var (
+	reorgMu  sync.Mutex
+	reorgLog []Reorg // oldest first
+
+	// reorgWebhooks receive a POST of every Reorg deeper than
+	// reorgAlertDepth (REORG_WEBHOOKS, REORG_ALERT_DEPTH).
+	reorgWebhooks   []string
+	reorgAlertDepth = 1
+
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// loadReorgAlerts reads REORG_WEBHOOKS (comma-separated URLs) and
+// REORG_ALERT_DEPTH.
+func loadReorgAlerts() error {
+	for _, u := range strings.Split(os.Getenv("REORG_WEBHOOKS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			reorgWebhooks = append(reorgWebhooks, u)
+		}
+	}
+	if v := os.Getenv("REORG_ALERT_DEPTH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid REORG_ALERT_DEPTH %q", v)
+		}
+		reorgAlertDepth = n
+	}
+	return nil
+}
+
+// recordReorg logs the switch from old to chain, if chain does not just
+// extend old, and alerts the webhooks when it is deep enough. Replacing
+// a lone genesis block during initial sync is not recorded.
+func recordReorg(old, chain []ChainBlock, source string) {
+	if len(old) <= 1 {
+		return
+	}
+	common := 0
+	for common < len(old) && common < len(chain) && old[common].Hash == chain[common].Hash {
+		common++
+	}
+	if common == len(old) {
+		return
+	}
+	oldTip, newTip := old[len(old)-1], chain[len(chain)-1]
+	r := Reorg{
+		Time:       clk.Now().Format(time.RFC3339),
+		Depth:      len(old) - common,
+		ForkHeight: common - 1,
+		OldTip:     oldTip.Height,
+		OldTipHash: oldTip.Hash,
+		NewTip:     newTip.Height,
+		NewTipHash: newTip.Hash,
+		Source:     source,
+		RequestID:  httpapi.NewRequestID(),
+	}
+	log.Printf("🔀 Reorg of depth %d at height %d: %s -> %s [%s]", r.Depth, r.ForkHeight, r.OldTipHash, r.NewTipHash, r.RequestID)
+
+	reorgMu.Lock()
+	reorgLog = append(reorgLog, r)
+	if len(reorgLog) > maxReorgHistory {
+		reorgLog = reorgLog[len(reorgLog)-maxReorgHistory:]
+	}
+	reorgMu.Unlock()
+
+	if r.Depth > reorgAlertDepth {
+		go alertReorg(r)
+	}
+}
+
+// alertReorg posts r to every webhook, with its request ID in
+// X-Request-ID.
+func alertReorg(r Reorg) {
+	body, _ := json.Marshal(r)
+	for _, u := range reorgWebhooks {
+		req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("⚠️  Reorg webhook %s failed: %v", u, err)
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(httpapi.RequestIDHeader, r.RequestID)
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			log.Printf("⚠️  Reorg webhook %s failed: %v", u, err)
+			continue
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("⚠️  Reorg webhook %s answered %s", u, resp.Status)
+		}
+	}
+}
+
+// reorgsHandler lists recorded reorganizations, newest first, optionally
+// only those at least ?min_depth= deep.
+func reorgsHandler(w http.ResponseWriter, r *http.Request) {
+	minDepth := 0
+	if v := r.URL.Query().Get("min_depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpapi.WriteError(w, "min_depth must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		minDepth = n
+	}
+
+	reorgMu.Lock()
+	list := make([]Reorg, 0, len(reorgLog))
+	for i := len(reorgLog) - 1; i >= 0; i-- {
+		if reorgLog[i].Depth >= minDepth {
+			list = append(list, reorgLog[i])
+		}
+	}
+	reorgMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(list)
+}
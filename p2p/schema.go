@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// Typed block data. Besides a plain string, POST /push accepts a typed
+// payload {"type": "note", "body": {...}} whose body is checked against
+// the schema registered for its type (DATA_SCHEMAS). The block stores the
+// payload as compact JSON, so GET /blocks?type= can find it again. Only
+// local submissions are checked; blocks from peers are taken as they are.
+
+// maxTypedBlocks caps how many blocks one GET /blocks?type= returns.
+const maxTypedBlocks = 1000
+
+// FieldSpec describes one field of a typed body.
+type FieldSpec struct {
+	Type     string `json:"type"` // string, number, boolean, object or array
+	Required bool   `json:"required,omitempty"`
+}
+
+// Schema describes the body of one data type. A strict schema rejects
+// fields it does not list.
+type Schema struct {
+	Fields map[string]FieldSpec `json:"fields"`
+	Strict bool                 `json:"strict,omitempty"`
+}
+
+// TypedData is a typed block payload.
+type TypedData struct {
+	Type string          `json:"type"`
+	Body json.RawMessage `json:"body"`
+}
+
+// schemas maps each registered type to its schema.
+var schemas = make(map[string]Schema)
+
+// loadSchemas reads the schema registry from the JSON file named by
+// DATA_SCHEMAS: an object mapping type names to schemas.
+func loadSchemas() error {
+	path := os.Getenv("DATA_SCHEMAS")
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &schemas); err != nil {
+		return fmt.Errorf("decode %s: %v", path, err)
+	}
+	for name, s := range schemas {
+		if name == "" {
+			return errors.New("schema with an empty type name")
+		}
+		for field, spec := range s.Fields {
+			switch spec.Type {
+			case "string", "number", "boolean", "object", "array":
+			default:
+				return fmt.Errorf("schema %s: field %s has unknown type %q", name, field, spec.Type)
+			}
+		}
+	}
+	return nil
+}
+
+// parseData turns the data of a submission into the string a block
+// stores: a JSON string as it is, a typed payload as compact JSON once
+// its body matches the schema of its type.
+func parseData(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", nil
+	}
+	if raw[0] != '{' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", errors.New("data must be a string or a typed payload")
+		}
+		if dataType(s) != "" {
+			return "", errors.New("typed data must be sent as an object, not a string")
+		}
+		return s, nil
+	}
+
+	var td TypedData
+	if err := json.Unmarshal(raw, &td); err != nil {
+		return "", errors.New("invalid typed payload")
+	}
+	schema, ok := schemas[td.Type]
+	if !ok {
+		return "", fmt.Errorf("unknown data type %q", td.Type)
+	}
+	if err := schema.check(td.Body); err != nil {
+		return "", fmt.Errorf("%s: %v", td.Type, err)
+	}
+	out, _ := json.Marshal(td)
+	return string(out), nil
+}
+
+// check validates body against the schema.
+func (s Schema) check(body json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return errors.New("body must be an object")
+	}
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		spec := s.Fields[name]
+		v, ok := fields[name]
+		if !ok || string(v) == "null" {
+			if spec.Required {
+				return fmt.Errorf("field %s is required", name)
+			}
+			continue
+		}
+		if got := jsonKind(v); got != spec.Type {
+			return fmt.Errorf("field %s must be of type %s, not %s", name, spec.Type, got)
+		}
+	}
+	if s.Strict {
+		for name := range fields {
+			if _, ok := s.Fields[name]; !ok {
+				return fmt.Errorf("field %s is not in the schema", name)
+			}
+		}
+	}
+	return nil
+}
+
+// jsonKind names the JSON type of a value.
+func jsonKind(v json.RawMessage) string {
+	switch v[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+// dataType returns the type of a typed block payload, or "" for plain
+// data.
+func dataType(data string) string {
+	if !strings.HasPrefix(data, `{"type":`) {
+		return ""
+	}
+	var td TypedData
+	if json.Unmarshal([]byte(data), &td) != nil {
+		return ""
+	}
+	return td.Type
+}
+
+// typedBlocksHandler serves GET /blocks?type=: the blocks whose payload
+// has the given type, oldest first, optionally within ?from= and ?to=.
+func typedBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	typ := r.URL.Query().Get("type")
+	from, to := 0, -1
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"from", &from}, {"to", &to}} {
+		if v := r.URL.Query().Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				httpapi.WriteError(w, p.name+" must be a height", http.StatusBadRequest)
+				return
+			}
+			*p.dst = n
+		}
+	}
+
+	mu.RLock()
+	if to < 0 || to >= len(ledger) {
+		to = len(ledger) - 1
+	}
+	views := []BlockView{}
+	for h := from; h <= to && len(views) < maxTypedBlocks; h++ {
+		if dataType(ledger[h].Data) == typ {
+			views = append(views, toView(ledger[h]))
+		}
+	}
+	mu.RUnlock()
+
+	writeNegotiated(w, r, "Blocks", views)
+}
+
+// schemasHandler lists the registered data types.
+func schemasHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(schemas)
+}
@@ -0,0 +1,560 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"alirezachain/clock"
+	"alirezachain/idempotency"
+	"alirezachain/sim"
+)
+
+// Simulation harness. The node keeps its state in package globals, so
+// simNet runs several nodes in one process by swapping that state: each
+// simNode holds one node's copy, and every request it serves and every
+// timer it set runs with that copy installed. TestSimStateCovered fails
+// when a global is added that is neither swapped nor listed in
+// sharedGlobals. Requests travel over a
+// sim.Network and time comes from a sim.Clock, so the real handlers, the
+// sync loop and the fork choice run deterministically. Configuration
+// (forkChoice, minBlockInterval, ...) is shared by all nodes.
+
+// simNode is the state of one simulated node while another is installed.
+type simNode struct {
+	id      string
+	handler http.Handler
+	served  map[string]int // requests served, by path without the API prefix
+
+	ledger           []ChainBlock
+	peers            []string
+	clk              clock.Clock
+	peerClient       *http.Client
+	nodeKey          ed25519.PrivateKey
+	nodePub          string
+	peerKeys         map[string]string
+	nodeURL          string
+	candidates       []string
+	peerScores       map[string]int
+	peerStats        map[string]*PeerStats
+	peerVersions     map[string]int
+	peerVerdicts     map[string]bool
+	idem             *idempotency.Cache
+	reorgLog         []Reorg
+	tipChanged       chan struct{}
+	fillingAnnounced int32
+	syncWait         time.Duration
+	savedHeight      int
+	savedHash        string
+	peerHeights      map[string]int
+	syncRunning      bool
+	lastSyncAt       time.Time
+	nextSyncAt       time.Time
+	syncSamples      []syncSample
+	gapCount         int
+	lastGap          *HeightGap
+}
+
+// simStack holds the nodes entered, innermost last: a node serving a
+// request may itself call a peer.
+var simStack []*simNode
+
+// save copies the package state into n.
+func (n *simNode) save() {
+	n.ledger, n.peers, n.clk, n.peerClient = ledger, peers, clk, peerClient
+	n.nodeKey, n.nodePub, n.peerKeys, n.nodeURL = nodeKey, nodePub, peerKeys, nodeURL
+	n.candidates, n.peerScores, n.peerStats = candidates, peerScores, peerStats
+	n.peerVersions, n.peerVerdicts, n.idem = peerVersions, peerVerdicts, idem
+	n.reorgLog, n.tipChanged, n.fillingAnnounced = reorgLog, tipChanged, fillingAnnounced
+	n.syncWait, n.savedHeight, n.savedHash = syncWait, savedHeight, savedHash
+	n.peerHeights, n.syncRunning, n.lastSyncAt, n.nextSyncAt = peerHeights, syncRunning, lastSyncAt, nextSyncAt
+	n.syncSamples, n.gapCount, n.lastGap = syncSamples, gapCount, lastGap
+}
+
+// load installs the state of n.
+func (n *simNode) load() {
+	ledger, peers, clk, peerClient = n.ledger, n.peers, n.clk, n.peerClient
+	nodeKey, nodePub, peerKeys, nodeURL = n.nodeKey, n.nodePub, n.peerKeys, n.nodeURL
+	candidates, peerScores, peerStats = n.candidates, n.peerScores, n.peerStats
+	peerVersions, peerVerdicts, idem = n.peerVersions, n.peerVerdicts, n.idem
+	reorgLog, tipChanged, fillingAnnounced = n.reorgLog, n.tipChanged, n.fillingAnnounced
+	syncWait, savedHeight, savedHash = n.syncWait, n.savedHeight, n.savedHash
+	peerHeights, syncRunning, lastSyncAt, nextSyncAt = n.peerHeights, n.syncRunning, n.lastSyncAt, n.nextSyncAt
+	syncSamples, gapCount, lastGap = n.syncSamples, n.gapCount, n.lastGap
+}
+
+// enter installs n until the matching leave.
+func (n *simNode) enter() {
+	if len(simStack) > 0 {
+		simStack[len(simStack)-1].save()
+	}
+	simStack = append(simStack, n)
+	n.load()
+}
+
+// leave saves the innermost node and reinstalls the one it interrupted.
+func leave() {
+	n := simStack[len(simStack)-1]
+	n.save()
+	simStack = simStack[:len(simStack)-1]
+	if len(simStack) > 0 {
+		simStack[len(simStack)-1].load()
+	}
+}
+
+// nodeClock is a node's view of the simulation clock: its timers run
+// with the node installed.
+type nodeClock struct {
+	n *simNode
+	c *sim.Clock
+}
+
+func (k nodeClock) Now() time.Time { return k.c.Now() }
+
+func (k nodeClock) AfterFunc(d time.Duration, f func()) {
+	k.c.AfterFunc(d, func() {
+		k.n.enter()
+		defer leave()
+		f()
+	})
+}
+
+// simNet is a set of nodes named n0, n1, ... that share a genesis block
+// and list each other as peers, reachable at http://n0, http://n1, ...
+type simNet struct {
+	t     *testing.T
+	Clock *sim.Clock
+	Net   *sim.Network
+	nodes []*simNode
+}
+
+// newSimNet builds n nodes and starts their sync loops. The package
+// state and configuration are restored when the test ends.
+func newSimNet(t *testing.T, n int, seed int64) *simNet {
+	outer := &simNode{}
+	outer.save()
+	savedForkChoice, savedInterval, savedLimits, savedFetcher := forkChoice, minBlockInterval, bodyLimits, startFetcher
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() {
+		log.SetOutput(defaultLogOutput)
+		simStack = nil
+		outer.load()
+		forkChoice, minBlockInterval, bodyLimits, startFetcher = savedForkChoice, savedInterval, savedLimits, savedFetcher
+	})
+	bodyLimits = defaultBodyLimits()
+	// A fetcher goroutine would install a peer's state while the syncing
+	// node runs on, so fetchers run in turn; the results channel holds
+	// every batch, so none of them blocks.
+	startFetcher = func(fetch func()) { fetch() }
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := &simNet{t: t, Clock: sim.NewClock(start)}
+	s.Net = sim.NewNetwork(s.Clock, seed)
+
+	genesis := ChainBlock{Timestamp: start.Unix(), Data: "Genesis 🌐 " + netName, Version: blockVersion}
+	genesis.Hash = computeHash(genesis)
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("n%d", i)
+		seed := make([]byte, ed25519.SeedSize)
+		seed[0] = byte(i + 1)
+		key := ed25519.NewKeyFromSeed(seed)
+
+		node := &simNode{
+			id:           id,
+			served:       make(map[string]int),
+			ledger:       []ChainBlock{genesis},
+			peerClient:   &http.Client{Transport: &meteredTransport{next: s.Net.Client(id).Transport}},
+			nodeKey:      key,
+			nodePub:      hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+			peerKeys:     make(map[string]string),
+			nodeURL:      "http://" + id,
+			peerScores:   make(map[string]int),
+			peerStats:    make(map[string]*PeerStats),
+			peerVersions: make(map[string]int),
+			peerVerdicts: make(map[string]bool),
+			tipChanged:   make(chan struct{}),
+			savedHeight:  -1,
+			peerHeights:  make(map[string]int),
+		}
+		node.clk = nodeClock{node, s.Clock}
+		node.idem = idempotency.New(node.clk.Now)
+		for j := 0; j < n; j++ {
+			if j != i {
+				node.peers = append(node.peers, fmt.Sprintf("http://n%d", j))
+			}
+		}
+		node.enter()
+		router := makeRouter() // binds the node's idempotency cache
+		leave()
+		node.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			node.served[strings.TrimPrefix(r.URL.Path, apiPrefix)]++
+			node.enter()
+			defer leave()
+			router.ServeHTTP(w, r)
+		})
+		s.Net.Handle(id, node.handler)
+		s.nodes = append(s.nodes, node)
+	}
+	for _, node := range s.nodes {
+		node.enter()
+		syncLoop(syncInterval)
+		leave()
+	}
+	return s
+}
+
+// defaultLogOutput is where the log package writes outside simulations.
+var defaultLogOutput = log.Writer()
+
+// node returns the node with the given ID.
+func (s *simNet) node(id string) *simNode {
+	for _, n := range s.nodes {
+		if n.id == id {
+			return n
+		}
+	}
+	s.t.Fatalf("no node %s", id)
+	return nil
+}
+
+// push asks node id for a block with data over POST /push, as a local
+// client that partitions do not cut off, and returns the response
+// status. The block is announced when the clock next runs.
+func (s *simNet) push(id, data string) int {
+	body := fmt.Sprintf(`{"data":%q}`, data)
+	req := httptest.NewRequest(http.MethodPost, apiPrefix+"/push", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	s.node(id).handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+// mustPush is push for a block that has to be accepted.
+func (s *simNet) mustPush(id, data string) ChainBlock {
+	if code := s.push(id, data); code != http.StatusOK {
+		s.t.Fatalf("push %q to %s: %d", data, id, code)
+	}
+	return s.tip(id)
+}
+
+// run advances the simulation by d.
+func (s *simNet) run(d time.Duration) {
+	s.Clock.Advance(d)
+}
+
+// tip returns the last block of node id.
+func (s *simNet) tip(id string) ChainBlock {
+	l := s.node(id).ledger
+	return l[len(l)-1]
+}
+
+// converged reports whether every node has the same tip.
+func (s *simNet) converged() bool {
+	for _, n := range s.nodes[1:] {
+		if s.tip(n.id).Hash != s.tip(s.nodes[0].id).Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// TestSimAnnounce checks that a pushed block reaches every peer through
+// POST /announce, before any sync round.
+func TestSimAnnounce(t *testing.T) {
+	s := newSimNet(t, 3, 1)
+	b := s.mustPush("n0", "hello")
+	s.run(time.Second)
+	if !s.converged() || s.tip("n2").Hash != b.Hash {
+		t.Fatalf("block not announced: n1 at %d, n2 at %d", s.tip("n1").Height, s.tip("n2").Height)
+	}
+	if got := s.node("n1").served["/announce"]; got != 1 {
+		t.Errorf("n1 served %d announcements, want 1", got)
+	}
+}
+
+// TestSimFork has two nodes make competing blocks at the same height.
+// Under the longest-chain policy every node settles on the lower tip
+// hash, whichever block it saw first.
+func TestSimFork(t *testing.T) {
+	s := newSimNet(t, 3, 1)
+	forkChoice = longestChain{}
+
+	a := s.mustPush("n0", "left")
+	b := s.mustPush("n1", "right")
+	want := a
+	if b.Hash < a.Hash {
+		want = b
+	}
+	s.run(time.Minute)
+
+	if !s.converged() {
+		t.Fatalf("nodes did not converge: %s %s %s", s.tip("n0").Hash, s.tip("n1").Hash, s.tip("n2").Hash)
+	}
+	if got := s.tip("n0"); got.Hash != want.Hash {
+		t.Errorf("settled on %s (%s), want %s (%s)", got.Hash, got.Data, want.Hash, want.Data)
+	}
+	loser := "n0"
+	if want.Hash == a.Hash {
+		loser = "n1"
+	}
+	if r := s.node(loser).reorgLog; len(r) != 1 || r[0].Depth != 1 || r[0].ForkHeight != 0 {
+		t.Errorf("%s recorded reorgs %+v, want one of depth 1 at height 0", loser, r)
+	}
+}
+
+// TestSimForkFirstSeen checks that the first-seen policy keeps each node
+// on its own block while the competing chains are as long, and that the
+// next block ends the fork.
+func TestSimForkFirstSeen(t *testing.T) {
+	s := newSimNet(t, 2, 1)
+
+	a := s.mustPush("n0", "left")
+	b := s.mustPush("n1", "right")
+	s.run(time.Minute)
+	if s.tip("n0").Hash != a.Hash || s.tip("n1").Hash != b.Hash {
+		t.Fatal("a node gave up its own block for one as long")
+	}
+
+	c := s.mustPush("n1", "right-2")
+	s.run(time.Minute)
+	if !s.converged() || s.tip("n0").Hash != c.Hash {
+		t.Fatalf("n0 did not follow the longer chain: at %d", s.tip("n0").Height)
+	}
+}
+
+// TestSimPartition splits four nodes in two, grows a chain on each side
+// and heals the split: everyone ends on the longer side's chain.
+func TestSimPartition(t *testing.T) {
+	s := newSimNet(t, 4, 2)
+	s.Net.Partition([]string{"n0", "n1"}, []string{"n2", "n3"})
+
+	left := s.mustPush("n0", "left")
+	s.mustPush("n2", "right-1")
+	s.run(time.Second)
+	right := s.mustPush("n3", "right-2")
+	s.run(time.Minute)
+
+	if s.tip("n1").Hash != left.Hash {
+		t.Errorf("n1 did not follow its side: tip %s", s.tip("n1").Data)
+	}
+	if s.tip("n2").Hash != right.Hash {
+		t.Errorf("n2 did not follow its side: tip %s", s.tip("n2").Data)
+	}
+
+	s.Net.Heal()
+	s.run(time.Minute)
+	if !s.converged() || s.tip("n0").Hash != right.Hash {
+		t.Fatalf("not converged on the longer side after healing: n0 at %s, n2 at %s", s.tip("n0").Data, s.tip("n2").Data)
+	}
+	for _, id := range []string{"n0", "n1"} {
+		if r := s.node(id).reorgLog; len(r) != 1 || r[0].Depth != 1 {
+			t.Errorf("%s recorded reorgs %+v, want one of depth 1", id, r)
+		}
+	}
+}
+
+// TestSimLatency gives one peer a slow link. The node measures the
+// round trips on the simulation clock and downloads block bodies from
+// the faster peer.
+func TestSimLatency(t *testing.T) {
+	s := newSimNet(t, 3, 3)
+	s.Net.SetLatency(10*time.Millisecond, 0)
+	s.Net.SetLinkLatency("n0", "n1", 200*time.Millisecond)
+
+	s.Net.Partition([]string{"n0"})
+	for i := 0; i < 3; i++ {
+		s.mustPush("n1", fmt.Sprintf("block %d", i))
+	}
+	s.run(time.Minute)
+	s.Net.Heal()
+	s.run(time.Minute)
+
+	if !s.converged() || s.tip("n0").Height != 3 {
+		t.Fatalf("n0 did not catch up: at %d", s.tip("n0").Height)
+	}
+	stats := s.node("n0").peerStats
+	if got := stats["http://n1"].LastRTTMs; got != 400 {
+		t.Errorf("RTT to n1 = %vms, want 400", got)
+	}
+	if got := stats["http://n2"].LastRTTMs; got != 20 {
+		t.Errorf("RTT to n2 = %vms, want 20", got)
+	}
+	if s.node("n2").served["/blocks"] == 0 || s.node("n1").served["/blocks"] != 0 {
+		t.Errorf("bodies served by n1 %d times, by n2 %d times; want only n2", s.node("n1").served["/blocks"], s.node("n2").served["/blocks"])
+	}
+}
+
+// TestSimLossyNetwork checks that nodes converge despite jitter and lost
+// requests, relying on the periodic sync.
+func TestSimLossyNetwork(t *testing.T) {
+	s := newSimNet(t, 4, 7)
+	s.Net.SetLatency(20*time.Millisecond, 300*time.Millisecond)
+	s.Net.SetDropRate(0.3)
+
+	var last ChainBlock
+	for i := 0; i < 5; i++ {
+		last = s.mustPush(fmt.Sprintf("n%d", i%4), fmt.Sprintf("block %d", i))
+		s.run(10 * time.Second)
+	}
+	s.Net.SetDropRate(0)
+	s.run(time.Minute)
+
+	if !s.converged() {
+		t.Fatalf("nodes did not converge: %s %s %s %s", s.tip("n0").Data, s.tip("n1").Data, s.tip("n2").Data, s.tip("n3").Data)
+	}
+	if s.tip("n0").Height < last.Height {
+		t.Errorf("converged at height %d, below the last block pushed at %d", s.tip("n0").Height, last.Height)
+	}
+	if s.Net.Dropped == 0 {
+		t.Error("no request was dropped")
+	}
+}
+
+// TestSimBlockInterval checks MIN_BLOCK_INTERVAL in a simulation: a push
+// too soon after the tip is refused, and peers take the block once it
+// is allowed.
+func TestSimBlockInterval(t *testing.T) {
+	s := newSimNet(t, 2, 4)
+	minBlockInterval = 10 * time.Second
+
+	s.run(10 * time.Second)
+	s.mustPush("n0", "first")
+	if code := s.push("n0", "too soon"); code != http.StatusTooManyRequests {
+		t.Fatalf("push right after the tip: %d, want 429", code)
+	}
+	s.run(10 * time.Second)
+	b := s.mustPush("n0", "second")
+	s.run(time.Minute)
+	if !s.converged() || s.tip("n1").Hash != b.Hash {
+		t.Fatalf("n1 at %d, want %d", s.tip("n1").Height, b.Height)
+	}
+}
+
+// TestSimLongSync syncs a node that missed more blocks than one body
+// batch holds, so that syncBodies downloads several batches from two
+// peers and appends them in order. Run it with -race too.
+func TestSimLongSync(t *testing.T) {
+	s := newSimNet(t, 3, 5)
+	s.Net.Partition([]string{"n0"})
+	const blocks = 2*bodyBatchSize + 50
+	for i := 0; i < blocks; i++ {
+		s.mustPush("n1", fmt.Sprintf("block %d", i))
+	}
+	s.run(time.Minute)
+	if s.tip("n2").Height != blocks || s.tip("n0").Height != 0 {
+		t.Fatalf("before healing: n0 at %d, n2 at %d", s.tip("n0").Height, s.tip("n2").Height)
+	}
+
+	s.Net.Heal()
+	s.run(time.Minute)
+	if !s.converged() || s.tip("n0").Height != blocks {
+		t.Fatalf("n0 did not catch up: at %d, want %d", s.tip("n0").Height, blocks)
+	}
+	served := s.node("n1").served["/blocks"] + s.node("n2").served["/blocks"]
+	if want := (blocks + bodyBatchSize - 1) / bodyBatchSize; served < want {
+		t.Errorf("bodies came in %d request(s), want at least %d batches", served, want)
+	}
+}
+
+// sharedGlobals are the package variables every simulated node shares:
+// configuration, locks, sentinel errors and process-wide helpers. Every
+// other variable is node state and must be swapped by simNode.
+var sharedGlobals = map[string]bool{
+	"apiSunset": true, "bodyLimits": true, "checkpoints": true, "deprecatedMu": true,
+	"deprecatedSeen": true, "errPeerRequest": true, "errPeerTimeout": true, "errPeerTooLarge": true,
+	"errPeerUnreachable": true, "errVersionUnsupported": true, "finalityDepth": true, "forkChoice": true,
+	"httpMiddleware": true, "ipfsAPI": true, "ipfsClient": true, "ipfsMaxBytes": true,
+	"ipfsThreshold": true, "labelValue": true, "maxPeers": true, "minBlockInterval": true,
+	"minProtocolVersion": true, "mu": true, "peerAuth": true, "peerBlacklist": true,
+	"peerConnectTimeout": true, "peerFilterMu": true, "peerIdleTimeout": true, "peerMaxIdleConns": true,
+	"peerMaxResponse": true, "peerMu": true, "peerReadTimeout": true, "peerRotateCount": true,
+	"peerRotateEvery": true, "peerTimeout": true, "peerWhitelist": true, "poolMu": true,
+	"protoMessages": true, "reorgAlertDepth": true, "reorgMu": true, "reorgWebhooks": true,
+	"schemas": true, "startFetcher": true, "statsMu": true, "syncInterval": true,
+	"syncIntervalMax": true, "syncIntervalMin": true, "syncJitter": true, "syncMode": true,
+	"syncMu": true, "syncRNG": true, "syncStateFile": true, "webhookClient": true,
+}
+
+// TestSimStateCovered checks that every package variable is either
+// shared or a simNode field that save and load both copy, so that a new
+// global cannot leak between simulated nodes unnoticed.
+func TestSimStateCovered(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	globals := make(map[string]bool)
+	saved, loaded := make(map[string]bool), make(map[string]bool)
+	for _, pkg := range pkgs {
+		for name, f := range pkg.Files {
+			for _, d := range f.Decls {
+				switch d := d.(type) {
+				case *ast.GenDecl:
+					if d.Tok != token.VAR || strings.HasSuffix(name, "_test.go") {
+						continue
+					}
+					for _, spec := range d.Specs {
+						for _, id := range spec.(*ast.ValueSpec).Names {
+							globals[id.Name] = true
+						}
+					}
+				case *ast.FuncDecl:
+					if d.Recv == nil || (d.Name.Name != "save" && d.Name.Name != "load") {
+						continue
+					}
+					ast.Inspect(d.Body, func(n ast.Node) bool {
+						a, ok := n.(*ast.AssignStmt)
+						if !ok {
+							return true
+						}
+						for _, lhs := range a.Lhs {
+							switch lhs := lhs.(type) {
+							case *ast.SelectorExpr: // n.field = global
+								saved[lhs.Sel.Name] = d.Name.Name == "save"
+							case *ast.Ident: // global = n.field
+								loaded[lhs.Name] = d.Name.Name == "load"
+							}
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+
+	fields := make(map[string]bool)
+	st := reflect.TypeOf(simNode{})
+	for i := 0; i < st.NumField(); i++ {
+		fields[st.Field(i).Name] = true
+	}
+	for g := range globals {
+		switch {
+		case sharedGlobals[g]:
+			if fields[g] {
+				t.Errorf("%s is both shared and swapped", g)
+			}
+		case !fields[g]:
+			t.Errorf("package variable %s is neither a simNode field nor in sharedGlobals", g)
+		case !saved[g] || !loaded[g]:
+			t.Errorf("simNode.%s is not copied by both save and load", g)
+		}
+	}
+	for g := range sharedGlobals {
+		if !globals[g] {
+			t.Errorf("sharedGlobals lists %s, which is not a package variable", g)
+		}
+	}
+}
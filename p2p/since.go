@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// ForkDetected answers GET /chain?since_hash= with 409 Conflict when the
+// hash is not on this node's chain.
+type ForkDetected struct {
+	Error   string `json:"error"`
+	Height  int    `json:"height"` // where the chains disagree; 0 unless since_height was given
+	Tip     int    `json:"tip"`
+	TipHash string `json:"tipHash"`
+}
+
+// sinceStart returns the index of the first block GET /chain should
+// serve: 0 without ?since_hash=, otherwise the block after that hash.
+// ?since_height= names the height the requester has the hash at, which
+// makes the lookup direct and lets a fork be located. fork is set if the
+// hash is not on chain. Callers must hold mu.
+func sinceStart(r *http.Request, chain []ChainBlock) (start int, fork *ForkDetected, ok bool) {
+	hash := r.URL.Query().Get("since_hash")
+	if hash == "" {
+		return 0, nil, true
+	}
+	height := -1
+	if v := r.URL.Query().Get("since_height"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, nil, false
+		}
+		height = n
+	}
+
+	if height >= 0 && height < len(chain) && chain[height].Hash == hash {
+		return height + 1, nil, true
+	}
+	if height < 0 {
+		for i := len(chain) - 1; i >= 0; i-- {
+			if chain[i].Hash == hash {
+				return i + 1, nil, true
+			}
+		}
+	}
+
+	tip := chain[len(chain)-1]
+	fork = &ForkDetected{Error: "fork detected", Tip: tip.Height, TipHash: tip.Hash}
+	if height >= 0 {
+		fork.Height = height
+		if fork.Height > tip.Height+1 {
+			fork.Height = tip.Height + 1
+		}
+	}
+	return 0, fork, true
+}
+
+// writeFork sends a ForkDetected response: an httpapi.APIError with the
+// fork as details, which also carries the fork's fields at the top
+// level, where peers of earlier releases read them.
+func writeFork(w http.ResponseWriter, fork *ForkDetected) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(struct {
+		httpapi.APIError
+		*ForkDetected
+	}{httpapi.APIError{Code: "fork_detected", Message: fork.Error, Details: fork, RequestID: w.Header().Get(httpapi.RequestIDHeader)}, fork})
+}
+
+// fetchChain requests GET /chain with query from peer in the wire format
+// of version. A 409 Conflict is returned as fork, not as an error.
+func fetchChain(peer string, version int, query string) ([]BlockView, *ForkDetected, error) {
+	req, _ := http.NewRequest(http.MethodGet, strings.TrimRight(peer, "/")+"/chain"+query, nil)
+	req.Header.Set(versionHeader, strconv.Itoa(version))
+	resp, body, err := doPeer(peer, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusConflict:
+		var fork ForkDetected
+		if err := json.Unmarshal(body, &fork); err != nil {
+			return nil, nil, fmt.Errorf("unmarshal fork: %v", err)
+		}
+		return nil, &fork, nil
+	default:
+		return nil, nil, fmt.Errorf("peer answered %s", resp.Status)
+	}
+
+	var views []BlockView
+	if version == 1 {
+		err = json.Unmarshal(body, &views)
+	} else {
+		var env struct {
+			Chain []BlockView `json:"chain"`
+		}
+		err = json.Unmarshal(body, &env)
+		views = env.Chain
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal chain: %v", err)
+	}
+	return views, nil, nil
+}
+
+// appendPeerBlocks appends blocks a peer sent after our tip. They are
+// dropped if the tip moved meanwhile; the next sync round asks again.
+func appendPeerBlocks(peer string, views []BlockView) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	added := 0
+	for _, v := range views {
+		b := fromView(v)
+		if !isBlockValid(b, ledger[len(ledger)-1]) {
+			break
+		}
+		ledger = append(ledger, b)
+		added++
+	}
+	if added > 0 {
+		notifyTip()
+		recordSyncProgress(ledger[len(ledger)-1].Height)
+		saveSyncState()
+		log.Printf("⬇️  Appended %d block(s) from %s, height=%d", added, peer, ledger[len(ledger)-1].Height)
+	}
+	if added < len(views) {
+		log.Printf("⚠️  Rejected %d block(s) from %s that do not extend the tip", len(views)-added, peer)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+)
+
+// Sync schedule. A sync round starts SYNC_INTERVAL after the previous
+// one ended, plus a random delay of up to SYNC_JITTER so that a fleet
+// started together does not poll its peers in lockstep. With
+// SYNC_MODE=adaptive the wait is SYNC_INTERVAL_MIN while a peer is known
+// to be ahead, and otherwise doubles from SYNC_INTERVAL after every
+// round, up to SYNC_INTERVAL_MAX.
+
+const (
+	syncModeFixed    = "fixed"
+	syncModeAdaptive = "adaptive"
+)
+
+var (
+	syncInterval    = 5 * time.Second
+	syncJitter      time.Duration
+	syncMode        = syncModeFixed
+	syncIntervalMin = time.Second
+	syncIntervalMax = 30 * time.Second
+
+	syncWait time.Duration // wait before the next round, without jitter
+	syncRNG  = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// loadSyncSchedule reads SYNC_INTERVAL, SYNC_JITTER, SYNC_MODE,
+// SYNC_INTERVAL_MIN and SYNC_INTERVAL_MAX.
+func loadSyncSchedule() error {
+	durations := []struct {
+		env string
+		dst *time.Duration
+		min time.Duration
+	}{
+		{"SYNC_INTERVAL", &syncInterval, time.Millisecond},
+		{"SYNC_JITTER", &syncJitter, 0},
+		{"SYNC_INTERVAL_MIN", &syncIntervalMin, time.Millisecond},
+		{"SYNC_INTERVAL_MAX", &syncIntervalMax, time.Millisecond},
+	}
+	for _, d := range durations {
+		if v := os.Getenv(d.env); v != "" {
+			dur, err := time.ParseDuration(v)
+			if err != nil || dur < d.min {
+				return fmt.Errorf("invalid %s %q", d.env, v)
+			}
+			*d.dst = dur
+		}
+	}
+	if v := os.Getenv("SYNC_MODE"); v != "" {
+		if v != syncModeFixed && v != syncModeAdaptive {
+			return fmt.Errorf("invalid SYNC_MODE %q (want %s or %s)", v, syncModeFixed, syncModeAdaptive)
+		}
+		syncMode = v
+	}
+	if syncMode == syncModeAdaptive && !(syncIntervalMin <= syncInterval && syncInterval <= syncIntervalMax) {
+		return fmt.Errorf("SYNC_INTERVAL (%s) must be between SYNC_INTERVAL_MIN (%s) and SYNC_INTERVAL_MAX (%s)", syncInterval, syncIntervalMin, syncIntervalMax)
+	}
+	syncWait = syncInterval
+	return nil
+}
+
+// syncBehind reports whether a peer has reported a higher tip than ours.
+func syncBehind() bool {
+	mu.RLock()
+	local := ledger[len(ledger)-1].Height
+	mu.RUnlock()
+
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	for _, h := range peerHeights {
+		if h > local {
+			return true
+		}
+	}
+	return false
+}
+
+// nextSyncDelay returns how long to wait before the next sync round,
+// after a round ended.
+func nextSyncDelay() time.Duration {
+	if syncMode == syncModeAdaptive {
+		if syncBehind() {
+			syncWait = syncIntervalMin
+		} else if syncWait < syncInterval {
+			syncWait = syncInterval
+		} else {
+			syncWait *= 2
+			if syncWait > syncIntervalMax {
+				syncWait = syncIntervalMax
+			}
+		}
+	}
+	return withJitter(syncWait)
+}
+
+// withJitter adds a random delay of up to SYNC_JITTER to d.
+func withJitter(d time.Duration) time.Duration {
+	if syncJitter > 0 {
+		d += time.Duration(syncRNG.Int63n(int64(syncJitter) + 1))
+	}
+	return d
+}
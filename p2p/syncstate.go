@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sync progress (SYNC_STATE_FILE). Whenever sync extends or replaces the
+// ledger, the new blocks are written to this JSON Lines file in the
+// format of GET /export. A node interrupted during a long initial sync
+// loads the file on restart and resumes from the last validated block
+// instead of starting over.
+var (
+	syncStateFile string
+	savedHeight   = -1 // height of the last block in syncStateFile
+	savedHash     string
+)
+
+// checkpoints pins the hash of the block at a height (SYNC_CHECKPOINTS).
+// Chains that disagree at a checkpoint are rejected before their blocks
+// are downloaded.
+var checkpoints = make(map[int]string)
+
+// loadSyncConfig reads SYNC_STATE_FILE and SYNC_CHECKPOINTS
+// ("height:hash,...").
+func loadSyncConfig() error {
+	syncStateFile = os.Getenv("SYNC_STATE_FILE")
+	for _, entry := range strings.Split(os.Getenv("SYNC_CHECKPOINTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		height, hash, ok := strings.Cut(entry, ":")
+		n, err := strconv.Atoi(height)
+		if !ok || err != nil || n < 0 || len(hash) != 64 {
+			return fmt.Errorf("invalid checkpoint %q (height:hash)", entry)
+		}
+		checkpoints[n] = strings.ToLower(hash)
+	}
+	return nil
+}
+
+// checkCheckpoint rejects a block hash that contradicts a checkpoint.
+func checkCheckpoint(height int, hash string) error {
+	if want, ok := checkpoints[height]; ok && want != hash {
+		return fmt.Errorf("block at height %d does not match checkpoint %s", height, want)
+	}
+	return nil
+}
+
+// loadSyncState replaces the ledger with the blocks saved in
+// SYNC_STATE_FILE, up to the first one that cannot be read or is not
+// valid, e.g. because the node stopped in the middle of writing it.
+// Returns the height resumed from, or -1 if there is nothing to resume.
+func loadSyncState() (int, error) {
+	f, err := os.Open(syncStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return -1, nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	var blocks []ChainBlock
+	complete := false
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var b ChainBlock
+		if err := dec.Decode(&b); err != nil {
+			complete = errors.Is(err, io.EOF)
+			break
+		}
+		if len(blocks) == 0 {
+			if b.Height != 0 || b.PrevHash != "" || computeHash(b) != b.Hash || checkCheckpoint(0, b.Hash) != nil {
+				return -1, errors.New("first block is not a valid genesis block")
+			}
+		} else if !isBlockValid(b, blocks[len(blocks)-1]) {
+			break
+		}
+		blocks = append(blocks, b)
+	}
+	if len(blocks) == 0 {
+		return -1, nil
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	ledger = blocks
+	tip := blocks[len(blocks)-1]
+	if complete {
+		savedHeight, savedHash = tip.Height, tip.Hash
+	} else {
+		// Cut the unusable tail, so that later blocks are appended to
+		// the valid ones.
+		log.Printf("⚠️  Dropping blocks after height %d from %s: unreadable or not valid", tip.Height, syncStateFile)
+		saveSyncState()
+	}
+	return tip.Height, nil
+}
+
+// saveSyncState writes the blocks the ledger gained since the last save
+// to SYNC_STATE_FILE, rewriting the file if the ledger was replaced
+// meanwhile. Failures are logged; sync goes on without the file. Callers
+// must hold mu.
+func saveSyncState() {
+	if syncStateFile == "" {
+		return
+	}
+	rewrite := savedHeight < 0 || savedHeight >= len(ledger) || ledger[savedHeight].Hash != savedHash
+	if !rewrite && savedHeight == len(ledger)-1 {
+		return
+	}
+
+	var err error
+	if rewrite {
+		err = writeBlocks(syncStateFile+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, ledger)
+		if err == nil {
+			err = os.Rename(syncStateFile+".tmp", syncStateFile)
+		}
+	} else {
+		err = writeBlocks(syncStateFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, ledger[savedHeight+1:])
+	}
+	if err != nil {
+		log.Printf("⚠️  Could not save sync progress to %s: %v", syncStateFile, err)
+		savedHeight = -1
+		return
+	}
+	tip := ledger[len(ledger)-1]
+	savedHeight, savedHash = tip.Height, tip.Hash
+}
+
+// writeBlocks writes blocks to path as JSON Lines.
+func writeBlocks(path string, flag int, blocks []ChainBlock) error {
+	f, err := os.OpenFile(path, flag, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, b := range blocks {
+		if err := enc.Encode(b); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// syncRateWindow is how far back GET /sync looks to measure how fast the
+// ledger grows through sync.
+const syncRateWindow = time.Minute
+
+// SyncStatus is the response of GET /sync.
+type SyncStatus struct {
+	Syncing         bool       `json:"syncing"` // a peer is known to be ahead of us
+	InProgress      bool       `json:"inProgress"`
+	LocalHeight     int        `json:"localHeight"`
+	BestPeer        string     `json:"bestPeer,omitempty"`
+	BestPeerHeight  int        `json:"bestPeerHeight"`
+	BlocksBehind    int        `json:"blocksBehind"`
+	BlocksPerSecond float64    `json:"blocksPerSecond"`
+	ETASeconds      float64    `json:"etaSeconds,omitempty"` // only while syncing with a known rate
+	LastSync        string     `json:"lastSync,omitempty"`
+	NextSync        string     `json:"nextSync,omitempty"`
+	SyncMode        string     `json:"syncMode"` // "fixed" or "adaptive"
+	Gaps            int        `json:"gaps"`     // height gaps seen in what peers sent
+	LastGap         *HeightGap `json:"lastGap,omitempty"`
+}
+
+// syncSample is the local height at a point in time.
+type syncSample struct {
+	at     time.Time
+	height int
+}
+
+var (
+	syncMu      sync.Mutex
+	peerHeights = make(map[string]int) // last tip height seen from each peer
+	syncRunning bool
+	lastSyncAt  time.Time
+	nextSyncAt  time.Time
+	syncSamples []syncSample // within syncRateWindow, oldest first
+	gapCount    int
+	lastGap     *HeightGap
+)
+
+// notePeerHeight records the tip height a peer reported. Reports come
+// from successful exchanges, so each one also raises the peer's score.
+func notePeerHeight(peer string, height int) {
+	syncMu.Lock()
+	peerHeights[peer] = height
+	syncMu.Unlock()
+	scorePeer(peer, 1)
+}
+
+// dropPeerHeight forgets the height of an unreachable peer.
+func dropPeerHeight(peer string) {
+	syncMu.Lock()
+	delete(peerHeights, peer)
+	syncMu.Unlock()
+}
+
+// recordSyncProgress samples the local height for the sync rate.
+func recordSyncProgress(height int) {
+	syncMu.Lock()
+	defer syncMu.Unlock()
+	now := clk.Now()
+	syncSamples = append(syncSamples, syncSample{at: now, height: height})
+	cut := 0
+	for cut < len(syncSamples)-1 && now.Sub(syncSamples[cut].at) > syncRateWindow {
+		cut++
+	}
+	syncSamples = syncSamples[cut:]
+}
+
+// recordGap counts a height gap and keeps it as the last one.
+func recordGap(gap *HeightGap) {
+	syncMu.Lock()
+	gapCount++
+	lastGap = gap
+	syncMu.Unlock()
+}
+
+// setSyncRunning marks the start or end of a sync round.
+func setSyncRunning(running bool) {
+	syncMu.Lock()
+	syncRunning = running
+	if !running {
+		lastSyncAt = clk.Now()
+	}
+	syncMu.Unlock()
+}
+
+// syncHandler reports how far the node is behind its peers and how fast
+// it is catching up.
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	local := ledger[len(ledger)-1].Height
+	mu.RUnlock()
+
+	syncMu.Lock()
+	st := SyncStatus{InProgress: syncRunning, LocalHeight: local, SyncMode: syncMode}
+	for p, h := range peerHeights {
+		if st.BestPeer == "" || h > st.BestPeerHeight || (h == st.BestPeerHeight && p < st.BestPeer) {
+			st.BestPeer, st.BestPeerHeight = p, h
+		}
+	}
+	if n := len(syncSamples); n > 1 {
+		first, last := syncSamples[0], syncSamples[n-1]
+		if secs := last.at.Sub(first.at).Seconds(); secs > 0 && last.height > first.height {
+			st.BlocksPerSecond = float64(last.height-first.height) / secs
+		}
+	}
+	if !lastSyncAt.IsZero() {
+		st.LastSync = lastSyncAt.Format(time.RFC3339)
+	}
+	if !nextSyncAt.IsZero() && !syncRunning {
+		st.NextSync = nextSyncAt.Format(time.RFC3339)
+	}
+	st.Gaps, st.LastGap = gapCount, lastGap
+	syncMu.Unlock()
+
+	if st.BestPeerHeight > local {
+		st.BlocksBehind = st.BestPeerHeight - local
+		st.Syncing = true
+	}
+	if st.Syncing && st.BlocksPerSecond > 0 {
+		st.ETASeconds = float64(st.BlocksBehind) / st.BlocksPerSecond
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(st)
+}
@@ -0,0 +1,29 @@
+package poscore
+
+import "testing"
+
+func TestRecord(t *testing.T) {
+	h := Header{Height: 12, Timestamp: 1700000120, Data: "a|b", Validator: "v1", PrevHash: "p", StateRoot: "s", Version: 1}
+	if got, want := h.Record(false), "121700000120a|bv1ps"; got != want {
+		t.Errorf("v1 record %q, want %q", got, want)
+	}
+	if got, want := h.Record(true), "v2|12|1700000120|3:a|b|2:v1|p|s"; got != want {
+		t.Errorf("v2 record %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name string
+		a, b Header
+	}{
+		{"height into timestamp", Header{Height: 12, Timestamp: 345}, Header{Height: 1, Timestamp: 2345}},
+		{"data into validator", Header{Data: "ab", Validator: "c"}, Header{Data: "a", Validator: "bc"}},
+	}
+	for _, tt := range tests {
+		if tt.a.Record(false) != tt.b.Record(false) {
+			t.Errorf("%s: test headers should collide before hashv2", tt.name)
+		}
+		if tt.a.Record(true) == tt.b.Record(true) {
+			t.Errorf("%s: v2 records collide: %q", tt.name, tt.a.Record(true))
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Package powcore holds the consensus encodings of the PoW chain that
+// more than one program must reproduce bit for bit: transaction IDs and
+// signing digests, the transaction and state roots, the record a block hash covers, the
+// difficulty target and coinbase maturity. The PoW node, the embedded
+// node library (package node) and the genesis tooling (package genesis)
+// all build on it, so they cannot drift apart.
+package powcore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TransferRecord is what the ID of a plain transfer covers. Transactions
+// with further terms (hash-timelocks, oracles, schedules, vesting) append
+// "|" and the record of each.
+func TransferRecord(from, to string, amount, fee, nonce uint64) string {
+	return from + "|" +
+		to + "|" +
+		strconv.FormatUint(amount, 10) + "|" +
+		strconv.FormatUint(fee, 10) + "|" +
+		strconv.FormatUint(nonce, 10)
+}
+
+// TxID returns the ID of a transaction record: its SHA-256 in hex.
+func TxID(record string) string {
+	h := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(h[:])
+}
+
+// TxSigningPrefix separates transaction signatures from other uses of a
+// key, such as signed messages.
+const TxSigningPrefix = "AlirezaChain Transaction:\n"
+
+// TxSigningDigest returns what the transaction with ID id is signed over
+// on the network chainID: SHA-256(TxSigningPrefix + chainID + "\n" + id),
+// or the ID itself for "", as chains signed before chain IDs. The nodes
+// always have a chain ID, so they no longer accept the latter.
+func TxSigningDigest(chainID, id string) ([]byte, error) {
+	digest, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, errors.New("malformed transaction id")
+	}
+	if chainID == "" {
+		return digest, nil
+	}
+	sum := sha256.Sum256([]byte(TxSigningPrefix + chainID + "\n" + id))
+	return sum[:], nil
+}
+
+// MerkleRoot returns the transaction root of a block: the Merkle root
+// of its transaction IDs, hashed pairwise with SHA-256 and the last one
+// repeated on odd levels. It is "" for no transactions.
+func MerkleRoot(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	level := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		raw, _ := hex.DecodeString(id)
+		level = append(level, raw)
+	}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			h := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, h[:])
+		}
+		level = next
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// Account is what the state root records of an account: Total is its
+// spendable plus immature balance.
+type Account struct {
+	Total uint64
+	Nonce uint64
+}
+
+// CanonicalState encodes accounts as one "address|total|nonce" line per
+// account with a balance or nonce, in address order.
+func CanonicalState(accounts map[string]Account) []byte {
+	addrs := make([]string, 0, len(accounts))
+	for addr := range accounts {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	var buf bytes.Buffer
+	for _, addr := range addrs {
+		a := accounts[addr]
+		if a.Total == 0 && a.Nonce == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s|%d|%d\n", addr, a.Total, a.Nonce)
+	}
+	return buf.Bytes()
+}
+
+// StateRoot returns the SHA-256 of a canonical state in hex.
+func StateRoot(canonical []byte) string {
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// Header is the part of a block its hash covers.
+type Header struct {
+	Height     int
+	Timestamp  int64
+	Data       string
+	Nonce      int64
+	PrevHash   string
+	Difficulty string // leading zero bits in decimal, or compact bits in hex after the compactbits fork
+	TxRoot     string
+	StateRoot  string
+	Version    int
+	Extra      map[string]string
+}
+
+// Record returns what the block hash covers: the header fields, joined
+// by "|" behind a "v2" tag once the hashv2 fork is active and
+// concatenated before it, followed by Extension.
+func (h Header) Record(v2 bool) string {
+	ext := Extension(h.Version, h.Extra)
+	if v2 {
+		return strings.Join([]string{
+			"v2",
+			strconv.Itoa(h.Height),
+			strconv.FormatInt(h.Timestamp, 10),
+			h.Data,
+			strconv.FormatInt(h.Nonce, 10),
+			h.PrevHash,
+			h.Difficulty,
+			h.TxRoot,
+			h.StateRoot,
+		}, "|") + ext
+	}
+	return strconv.Itoa(h.Height) +
+		strconv.FormatInt(h.Timestamp, 10) +
+		h.Data +
+		strconv.FormatInt(h.Nonce, 10) +
+		h.PrevHash +
+		h.Difficulty +
+		h.TxRoot +
+		h.StateRoot +
+		ext
+}
+
+// Extension returns the canonical encoding of a header's version and
+// extension fields, appended to the record the block hash covers.
+// Version 1 headers without extension fields encode to "", so blocks
+// hashed before headers were versioned keep their hashes. Otherwise the
+// encoding is "|v<version>" followed by every field in key order, with
+// key and value each prefixed by their length, so no two different
+// maps encode alike.
+func Extension(version int, extra map[string]string) string {
+	if version <= 1 && len(extra) == 0 {
+		return ""
+	}
+	if version == 0 {
+		version = 1
+	}
+
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString("|v" + strconv.Itoa(version))
+	for _, k := range keys {
+		v := extra[k]
+		fmt.Fprintf(&sb, "|%d:%s%d:%s", len(k), k, len(v), v)
+	}
+	return sb.String()
+}
+
+// DifficultyTarget returns the target a hash of size bytes must stay
+// below to have difficulty leading zero bits.
+func DifficultyTarget(size, difficulty int) *big.Int {
+	target := big.NewInt(1)
+	return target.Lsh(target, uint(size*8-difficulty))
+}
+
+// Mature reports whether a coinbase mined at height may be spent by a
+// block at next, maturity blocks being required in between. Genesis
+// allocations are not coinbases and are spendable at once.
+func Mature(height, next, maturity int) bool {
+	return next-height >= maturity
+}
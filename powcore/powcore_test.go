@@ -0,0 +1,89 @@
+package powcore
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The expected values below were computed independently of this
+// package, from the encodings documented by the PoW node.
+
+func TestTxIDAndMerkleRoot(t *testing.T) {
+	ids := []string{
+		TxID(TransferRecord("", "alice", 50, 0, 1)),
+		TxID(TransferRecord("alice", "bob", 10, 1, 0)),
+		TxID(TransferRecord("bob", "carol", 3, 0, 0)),
+	}
+	want := []string{
+		"9428f6fe1fd294c2257bbfe1486e0ab9486a918d5cb23576984f3623364d6b37",
+		"0ca15c195afedbe535ac9080ecfc2d90b4a9fece385397f473220d30bc2c48fc",
+		"f01c179cf81e219691239be67877139a4c9158871f68488ea7545e714a952ccc",
+	}
+	for i := range ids {
+		if ids[i] != want[i] {
+			t.Errorf("tx %d: ID %s, want %s", i, ids[i], want[i])
+		}
+	}
+	if got := MerkleRoot(ids); got != "67760fd6533b115a516670de79d0706a0e9a5ab4458fc4d06fb411c9c2624cd8" {
+		t.Errorf("MerkleRoot = %s", got)
+	}
+	if got := MerkleRoot(ids[:1]); got != ids[0] {
+		t.Errorf("MerkleRoot of one ID = %s, want the ID", got)
+	}
+	if got := MerkleRoot(nil); got != "" {
+		t.Errorf("MerkleRoot of no IDs = %q", got)
+	}
+}
+
+func TestTxSigningDigest(t *testing.T) {
+	id := "0ca15c195afedbe535ac9080ecfc2d90b4a9fece385397f473220d30bc2c48fc"
+	tests := []struct {
+		chainID, want string
+	}{
+		{"", id},
+		{"testnet", "62cf58d0a87c08f68aec746703cef93158c2d7427501813f2124611b6c86d390"},
+	}
+	for _, tt := range tests {
+		digest, err := TxSigningDigest(tt.chainID, id)
+		if err != nil || hex.EncodeToString(digest) != tt.want {
+			t.Errorf("chain %q: digest %x (%v), want %s", tt.chainID, digest, err, tt.want)
+		}
+	}
+	if _, err := TxSigningDigest("testnet", "not hex"); err == nil {
+		t.Error("malformed ID accepted")
+	}
+}
+
+func TestStateRoot(t *testing.T) {
+	state := CanonicalState(map[string]Account{
+		"bob":   {Total: 7, Nonce: 1},
+		"alice": {Total: 37, Nonce: 1},
+		"empty": {},
+	})
+	if string(state) != "alice|37|1\nbob|7|1\n" {
+		t.Errorf("CanonicalState = %q", state)
+	}
+	if got := StateRoot(state); got != "fe4584d3f7e4abf45c5e0ef6443cc64c84de02d447f807a41b1b1ea3f5d89027" {
+		t.Errorf("StateRoot = %s", got)
+	}
+}
+
+func TestHeaderRecord(t *testing.T) {
+	h := Header{Height: 2, Timestamp: 1700000000, Data: "d", Nonce: 42, PrevHash: "p", Difficulty: "18", TxRoot: "t", StateRoot: "s", Version: 1}
+	if got := h.Record(false); got != "21700000000d42p18ts" {
+		t.Errorf("Record(false) = %q", got)
+	}
+	if got := h.Record(true); got != "v2|2|1700000000|d|42|p|18|t|s" {
+		t.Errorf("Record(true) = %q", got)
+	}
+	h.Extra = map[string]string{"uncleRoot": "u", "anchorRoot": "ab"}
+	if got := h.Record(false); got != "21700000000d42p18ts|v1|10:anchorRoot2:ab|9:uncleRoot1:u" {
+		t.Errorf("Record with extension fields = %q", got)
+	}
+}
+
+func TestMature(t *testing.T) {
+	if !Mature(1, 11, 10) || Mature(2, 11, 10) {
+		t.Error("a coinbase must be maturity blocks below the spending block")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Checkpoint attestations for hybrid consensus. A PoW node in hybrid mode
+// (see proof-work/hybrid.go) sends every CHECKPOINT_INTERVAL-th block to
+// POST /attest; each active validator whose key this node holds signs the
+// block's height and hash. The PoW node treats the block as final once
+// validators holding more than two thirds of the active stake have signed.
+//
+// With ATTEST_POW_NODE set, the node only attests blocks that are on that
+// PoW node's chain. A validator never attests two hashes at one height.
+
+// checkpointPrefix domain-separates checkpoint attestations from block
+// and message signatures.
+const checkpointPrefix = "AlirezaChain Checkpoint:\n"
+
+var (
+	// attestPowNode is the PoW node checkpoints are checked against
+	// (ATTEST_POW_NODE); "" attests whatever is asked.
+	attestPowNode string
+
+	// attestedHashes remembers the hash attested at each height. Guarded
+	// by mu.
+	attestedHashes = make(map[int]string)
+
+	attestClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// Attestation is one validator's signature over a checkpoint.
+type Attestation struct {
+	Validator string `json:"validator"`
+	PubKey    string `json:"pubKey"`
+	Stake     uint64 `json:"stake"`
+	Signature string `json:"signature"`
+}
+
+// CheckpointVote answers POST /attest.
+type CheckpointVote struct {
+	Height       int           `json:"height"`
+	Hash         string        `json:"hash"`
+	Attestations []Attestation `json:"attestations"`
+	TotalStake   uint64        `json:"totalStake"` // of the active validator set
+}
+
+// loadAttestation reads ATTEST_POW_NODE.
+func loadAttestation() error {
+	attestPowNode = strings.TrimRight(os.Getenv("ATTEST_POW_NODE"), "/")
+	if attestPowNode != "" && !strings.HasPrefix(attestPowNode, "http://") && !strings.HasPrefix(attestPowNode, "https://") {
+		return fmt.Errorf("invalid ATTEST_POW_NODE %q: want an http(s) URL", attestPowNode)
+	}
+	return nil
+}
+
+// checkpointDigest returns the digest validators sign for a checkpoint.
+func checkpointDigest(height int, hash string) []byte {
+	h := sha256.Sum256([]byte(checkpointPrefix + strconv.Itoa(height) + ":" + hash))
+	return h[:]
+}
+
+// powBlockExists asks attestPowNode whether its chain holds hash at
+// height.
+func powBlockExists(height int, hash string) (bool, error) {
+	q := url.Values{"since_hash": {hash}, "since_height": {strconv.Itoa(height)}}
+	resp, err := attestClient.Get(attestPowNode + "/chain?" + q.Encode())
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	}
+	return false, fmt.Errorf("PoW node answered %s", resp.Status)
+}
+
+// attestHandler signs a checkpoint with every active validator key this
+// node holds.
+func attestHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Height int    `json:"height"`
+		Hash   string `json:"hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if raw, err := hex.DecodeString(req.Hash); err != nil || len(raw) != 32 || req.Height <= 0 {
+		httpapi.WriteError(w, "height must be positive and hash a 32-byte hex hash", http.StatusBadRequest)
+		return
+	}
+	req.Hash = strings.ToLower(req.Hash)
+	if attestPowNode != "" {
+		ok, err := powBlockExists(req.Height, req.Hash)
+		if err != nil {
+			httpapi.WriteError(w, "cannot check the checkpoint: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if !ok {
+			httpapi.WriteError(w, "checkpoint is not on the PoW chain", http.StatusConflict)
+			return
+		}
+	}
+
+	mu.Lock()
+	if prev, ok := attestedHashes[req.Height]; ok && prev != req.Hash {
+		mu.Unlock()
+		httpapi.WriteError(w, fmt.Sprintf("already attested %s at height %d", prev, req.Height), http.StatusConflict)
+		return
+	}
+	vote := CheckpointVote{Height: req.Height, Hash: req.Hash, Attestations: []Attestation{}}
+	digest := checkpointDigest(req.Height, req.Hash)
+	for _, v := range activeValidators() {
+		vote.TotalStake += stakes[v]
+		priv, ok := keyring[v]
+		if !ok {
+			continue
+		}
+		vote.Attestations = append(vote.Attestations, Attestation{
+			Validator: v,
+			PubKey:    hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+			Stake:     stakes[v],
+			Signature: hex.EncodeToString(ed25519.Sign(priv, digest)),
+		})
+	}
+	if len(vote.Attestations) > 0 {
+		attestedHashes[req.Height] = req.Hash
+		httpapi.LogRequest(r, "🗳️  Attested checkpoint height=%d hash=%s by %d validators", req.Height, req.Hash, len(vote.Attestations))
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(vote)
+}
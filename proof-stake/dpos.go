@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// Delegated proof of stake. With ACTIVE_SET=dpos, the active set is not
+// the top validators by stake but the top MAX_VALIDATORS by votes. Token
+// holders vote for one staked, untombstoned candidate each, weighted by
+// their liquid balance, and every DPOS_EPOCH blocks the votes are tallied
+// and the winners become the active set until the next election. A
+// voter may change its vote only DPOS_VOTE_COOLDOWN blocks after the last
+// change. Until an election elects anyone, the stake-based set is used.
+
+// Active set modes (ACTIVE_SET).
+const (
+	activeSetStake = "stake"
+	activeSetDPoS  = "dpos"
+)
+
+// DPoSVote is a token holder's vote.
+type DPoSVote struct {
+	Voter     string `json:"voter"`
+	Candidate string `json:"candidate"`
+	Height    int    `json:"height"` // chain height when the vote was cast
+}
+
+// CandidateTally is a candidate's result in an election.
+type CandidateTally struct {
+	Candidate string `json:"candidate"`
+	Votes     uint64 `json:"votes"` // balance of its voters
+	Voters    int    `json:"voters"`
+}
+
+var (
+	// activeSetMode decides how the active set is formed (ACTIVE_SET).
+	activeSetMode = activeSetStake
+
+	// dposEpoch is the number of blocks between elections (DPOS_EPOCH).
+	dposEpoch = 20
+
+	// dposCooldown is the number of blocks a voter must wait before
+	// changing its vote (DPOS_VOTE_COOLDOWN).
+	dposCooldown = 10
+
+	// dposVotes holds each voter's current vote. Guarded by mu.
+	dposVotes = make(map[string]DPoSVote)
+
+	// electedSet is the active set chosen by the last election, sorted by
+	// name, and lastElection the height it was held at. Guarded by mu.
+	electedSet   []string
+	lastElection int
+)
+
+// loadDPoS reads ACTIVE_SET, DPOS_EPOCH and DPOS_VOTE_COOLDOWN.
+func loadDPoS() error {
+	switch v := os.Getenv("ACTIVE_SET"); v {
+	case "":
+	case activeSetStake, activeSetDPoS:
+		activeSetMode = v
+	default:
+		return fmt.Errorf("invalid ACTIVE_SET %q (stake or dpos)", v)
+	}
+	if v := os.Getenv("DPOS_EPOCH"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid DPOS_EPOCH %q", v)
+		}
+		dposEpoch = n
+	}
+	if v := os.Getenv("DPOS_VOTE_COOLDOWN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid DPOS_VOTE_COOLDOWN %q", v)
+		}
+		dposCooldown = n
+	}
+	return nil
+}
+
+// eligibleCandidate reports whether v may stand for election. Callers
+// must hold mu.
+func eligibleCandidate(v string) bool {
+	return stakes[v] >= minStake && stakes[v] > 0 && !tombstoned[v]
+}
+
+// electedValidators returns the members of the elected set that are
+// still eligible, or nil outside DPoS mode. Callers must hold mu.
+func electedValidators() []string {
+	if activeSetMode != activeSetDPoS {
+		return nil
+	}
+	var list []string
+	for _, v := range electedSet {
+		if eligibleCandidate(v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// tallyVotes sums the balance behind every eligible candidate, most
+// votes first, ties broken by name. Callers must hold mu.
+func tallyVotes() []CandidateTally {
+	byCandidate := make(map[string]*CandidateTally)
+	for _, vote := range dposVotes {
+		if !eligibleCandidate(vote.Candidate) || balances[vote.Voter] == 0 {
+			continue
+		}
+		t, ok := byCandidate[vote.Candidate]
+		if !ok {
+			t = &CandidateTally{Candidate: vote.Candidate}
+			byCandidate[vote.Candidate] = t
+		}
+		t.Votes += balances[vote.Voter]
+		t.Voters++
+	}
+	list := make([]CandidateTally, 0, len(byCandidate))
+	for _, t := range byCandidate {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Votes != list[j].Votes {
+			return list[i].Votes > list[j].Votes
+		}
+		return list[i].Candidate < list[j].Candidate
+	})
+	return list
+}
+
+// processElection holds an election if height ends an epoch. Callers
+// must hold mu.
+func processElection(height int) {
+	if activeSetMode != activeSetDPoS || height%dposEpoch != 0 {
+		return
+	}
+	tally := tallyVotes()
+	if maxValidators > 0 && len(tally) > maxValidators {
+		tally = tally[:maxValidators]
+	}
+	elected := make([]string, 0, len(tally))
+	for _, t := range tally {
+		elected = append(elected, t.Candidate)
+	}
+	sort.Strings(elected)
+	electedSet, lastElection = elected, height
+	refreshStakeMetrics()
+	if len(elected) == 0 {
+		log.Printf("🗳️  Election at height %d elected nobody; keeping the stake-based active set", height)
+		return
+	}
+	log.Printf("🗳️  Election at height %d elected %d validators: %s", height, len(elected), strings.Join(elected, ", "))
+}
+
+// dposVoteHandler casts or changes a token holder's vote. An empty
+// candidate withdraws it.
+func dposVoteHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Voter     string `json:"voter"`
+		Candidate string `json:"candidate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	payload.Voter = strings.TrimSpace(payload.Voter)
+	payload.Candidate = strings.TrimSpace(payload.Candidate)
+	if payload.Voter == "" {
+		httpapi.WriteError(w, "voter is required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if activeSetMode != activeSetDPoS {
+		httpapi.WriteError(w, "this node does not run DPoS elections (ACTIVE_SET=dpos)", http.StatusConflict)
+		return
+	}
+	if balances[payload.Voter] == 0 {
+		httpapi.WriteError(w, "voter holds no tokens", http.StatusForbidden)
+		return
+	}
+	if payload.Candidate != "" && !eligibleCandidate(payload.Candidate) {
+		httpapi.WriteError(w, "candidate must be a staked, untombstoned validator", http.StatusBadRequest)
+		return
+	}
+	height := chain[len(chain)-1].Height
+	prev, voted := dposVotes[payload.Voter]
+	if voted && prev.Candidate == payload.Candidate {
+		httpapi.WriteError(w, "vote is unchanged", http.StatusConflict)
+		return
+	}
+	if voted && height < prev.Height+dposCooldown {
+		httpapi.WriteErrorDetails(w, http.StatusTooManyRequests, httpapi.APIError{
+			Code:    "vote_cooldown",
+			Message: fmt.Sprintf("vote can change again at height %d", prev.Height+dposCooldown),
+			Details: map[string]int{"height": height, "allowedHeight": prev.Height + dposCooldown},
+		})
+		return
+	}
+
+	vote := DPoSVote{Voter: payload.Voter, Candidate: payload.Candidate, Height: height}
+	if payload.Candidate == "" {
+		delete(dposVotes, payload.Voter)
+		httpapi.LogRequest(r, "🗳️  %s withdrew its vote", payload.Voter)
+	} else {
+		dposVotes[payload.Voter] = vote
+		httpapi.LogRequest(r, "🗳️  %s votes for %s", payload.Voter, payload.Candidate)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(vote)
+}
+
+// dposHandler reports the election state: the elected set, the running
+// tally for the next election and the votes behind it.
+func dposHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Mode         string           `json:"mode"`
+		Epoch        int              `json:"epoch"`
+		Cooldown     int              `json:"cooldown"`
+		Seats        int              `json:"seats"` // MAX_VALIDATORS; 0 for no cap
+		LastElection int              `json:"lastElection"`
+		NextElection int              `json:"nextElection"`
+		Elected      []string         `json:"elected"`
+		Tally        []CandidateTally `json:"tally"`
+		Votes        []DPoSVote       `json:"votes"`
+	}
+
+	mu.RLock()
+	height := chain[len(chain)-1].Height
+	resp := Response{
+		Mode:         activeSetMode,
+		Epoch:        dposEpoch,
+		Cooldown:     dposCooldown,
+		Seats:        maxValidators,
+		LastElection: lastElection,
+		NextElection: (height/dposEpoch + 1) * dposEpoch,
+		Elected:      append([]string{}, electedSet...),
+		Tally:        tallyVotes(),
+		Votes:        make([]DPoSVote, 0, len(dposVotes)),
+	}
+	for _, v := range dposVotes {
+		resp.Votes = append(resp.Votes, v)
+	}
+	mu.RUnlock()
+	sort.Slice(resp.Votes, func(i, j int) bool { return resp.Votes[i].Voter < resp.Votes[j].Voter })
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
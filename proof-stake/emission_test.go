@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestLinearRewardAt covers the linear curve around the height where the
+// decay reaches the initial reward, including decays whose product with
+// the height would overflow.
+func TestLinearRewardAt(t *testing.T) {
+	huge := EmissionSchedule{Curve: curveLinear, InitialReward: math.MaxUint64, DecayPerBlock: 1 << 62, MinReward: 7}
+	tests := []struct {
+		name   string
+		e      EmissionSchedule
+		height int
+		want   uint64
+	}{
+		{"first block", EmissionSchedule{Curve: curveLinear, InitialReward: 10, DecayPerBlock: 3}, 1, 10},
+		{"last step above zero", EmissionSchedule{Curve: curveLinear, InitialReward: 10, DecayPerBlock: 3}, 4, 1},
+		{"decay past the reward", EmissionSchedule{Curve: curveLinear, InitialReward: 10, DecayPerBlock: 3}, 5, 0},
+		{"floor", EmissionSchedule{Curve: curveLinear, InitialReward: 10, DecayPerBlock: 3, MinReward: 2}, 4, 2},
+		{"no decay", EmissionSchedule{Curve: curveLinear, InitialReward: 10}, math.MaxInt32, 10},
+		{"huge decay, last step", huge, 4, math.MaxUint64 - 3<<62},
+		{"huge decay, product overflows", huge, 6, 7},
+		{"huge height", EmissionSchedule{Curve: curveLinear, InitialReward: 10, DecayPerBlock: 1 << 40, MinReward: 1}, math.MaxInt32, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.e.rewardAt(tt.height); got != tt.want {
+			t.Errorf("%s: reward at %d is %d, want %d", tt.name, tt.height, got, tt.want)
+		}
+	}
+}
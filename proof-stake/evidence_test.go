@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"alirezachain/chainhash"
+	"alirezachain/poscore"
+)
+
+// signedChain installs a chain of n blocks after genesis, all forged and
+// signed by validator v1, and returns v1's key.
+func signedChain(t *testing.T, n int) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasher = chainhash.Default
+	pubKeys = map[string]ed25519.PublicKey{"v1": priv.Public().(ed25519.PublicKey)}
+	tombstoned = make(map[string]bool)
+	chain = []StakeBlock{{Timestamp: 1700000000, Data: "genesis"}}
+	chain[0].Hash = computeHash(chain[0])
+	for h := 1; h <= n; h++ {
+		b := StakeBlock{Height: h, Timestamp: 1700000000 + int64(h)*10, Data: "block", Validator: "v1", PrevHash: chain[h-1].Hash}
+		chain = append(chain, signWith(priv, b))
+	}
+	t.Cleanup(func() {
+		chain, pubKeys, tombstoned = nil, make(map[string]ed25519.PublicKey), make(map[string]bool)
+		forkHeights = make(poscore.Forks)
+	})
+	return priv
+}
+
+// signWith hashes b and signs the hash with priv.
+func signWith(priv ed25519.PrivateKey, b StakeBlock) StakeBlock {
+	b.Hash = computeHash(b)
+	digest, _ := hex.DecodeString(b.Hash)
+	b.Signature = hex.EncodeToString(ed25519.Sign(priv, digest))
+	return b
+}
+
+// heightShifted passes the signed block at height 12 off as a block at
+// height 1 by moving the height's second digit into the timestamp.
+func heightShifted(b StakeBlock) StakeBlock {
+	s := strconv.Itoa(b.Height)
+	b.Height, _ = strconv.Atoi(s[:1])
+	b.Timestamp, _ = strconv.ParseInt(s[1:]+strconv.FormatInt(b.Timestamp, 10), 10, 64)
+	return b
+}
+
+func TestEvidenceDoubleSign(t *testing.T) {
+	priv := signedChain(t, 5)
+	other := chain[3]
+	other.Data = "other"
+	ev := Evidence{First: chain[3], Second: signWith(priv, other)}
+	if err := verifyEvidence(ev); err != nil {
+		t.Fatalf("real double sign rejected: %v", err)
+	}
+}
+
+func TestEvidenceRejected(t *testing.T) {
+	priv := signedChain(t, 12)
+	forged := heightShifted(chain[12])
+	if computeHash(forged) != chain[12].Hash {
+		t.Fatal("test setup: shifted block does not share the hash of block 12")
+	}
+	ahead := chain[12]
+	ahead.Height, ahead.Data = 13, "ahead"
+	other := chain[3]
+	other.Data = "other"
+
+	tests := []struct {
+		name string
+		ev   Evidence
+		want string
+	}{
+		{"block 12 as height 1", Evidence{First: chain[1], Second: forged}, "at height 12, not 1"},
+		{"beyond the tip", Evidence{First: signWith(priv, ahead), Second: signWith(priv, StakeBlock{Height: 13, Validator: "v1", Data: "x"})}, "outside the local chain"},
+		{"identical", Evidence{First: chain[3], Second: chain[3]}, "identical"},
+		{"unsigned", Evidence{First: chain[3], Second: func() StakeBlock { b := signWith(priv, other); b.Signature = ""; return b }()}, "malformed signature"},
+	}
+	for _, tt := range tests {
+		err := verifyEvidence(tt.ev)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+// TestHashV2SeparatesHeight checks that from hashv2 on a block can no
+// longer be passed off as one at another height.
+func TestHashV2SeparatesHeight(t *testing.T) {
+	signedChain(t, 0)
+	forkHeights[poscore.ForkHashV2] = 1
+	b := StakeBlock{Height: 12, Timestamp: 1700000120, Data: "block", Validator: "v1"}
+	if computeHash(b) == computeHash(heightShifted(b)) {
+		t.Error("hashv2 record still lets the height run into the timestamp")
+	}
+}
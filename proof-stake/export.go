@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+)
+
+// Export formats for GET /export (?format=).
+const (
+	exportJSONL = "jsonl"
+	exportCSV   = "csv"
+)
+
+// exportHandler streams the chain as JSON Lines (one block per line, the
+// format -import reads back) or CSV.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportJSONL
+	}
+	if format != exportJSONL && format != exportCSV {
+		httpapi.WriteError(w, "format must be jsonl or csv", http.StatusBadRequest)
+		return
+	}
+
+	// Blocks are never modified once appended, so the snapshot can be
+	// streamed without holding mu.
+	mu.RLock()
+	blocks := chain
+	mu.RUnlock()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=chain.%s", format))
+	if format == exportJSONL {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, b := range blocks {
+			_ = enc.Encode(b)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"height", "timestamp", "hash", "prevHash", "validator", "stateRoot", "version", "data"})
+	for _, b := range blocks {
+		_ = cw.Write([]string{
+			strconv.Itoa(b.Height),
+			strconv.FormatInt(b.Timestamp, 10),
+			b.Hash,
+			b.PrevHash,
+			b.Validator,
+			b.StateRoot,
+			strconv.Itoa(b.Version),
+			b.Data,
+		})
+	}
+	cw.Flush()
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"alirezachain/poscore"
+)
+
+// forkHeights holds the consensus upgrades scheduled in FORK_HEIGHTS (see
+// poscore.KnownForks). As on the PoW node, each applies from its height
+// on, and blocks below it keep the old rules.
+var forkHeights = make(poscore.Forks)
+
+// loadForks reads FORK_HEIGHTS ("hashv2=100").
+func loadForks() error {
+	forks, err := poscore.ParseForks(os.Getenv("FORK_HEIGHTS"))
+	if err != nil {
+		return err
+	}
+	forkHeights = forks
+	return nil
+}
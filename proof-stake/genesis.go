@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"alirezachain/genesis"
+)
+
+// initGenesis seeds the state and builds the genesis block. Without a
+// file a demo validator "genesis" holds a stake of 1 and the block is
+// stamped with the current time; with one, the file's validators and
+// allocations make up the initial state and its timestamp is used, so
+// every node given the same file builds the same block. Callers must
+// hold mu.
+func initGenesis(g *genesis.File) (StakeBlock, error) {
+	block := StakeBlock{
+		Height:    0,
+		Timestamp: clk.Now().Unix(),
+		Data:      "Genesis 🪙 " + posName,
+		Validator: "genesis",
+		PrevHash:  "",
+		Version:   blockVersion,
+	}
+	if g == nil {
+		// Optional initial stake for a demo validator.
+		stakes["genesis"] = 1
+		registerGenesisValidator("genesis")
+		block.StateRoot = stateRoot("", 0)
+		block.Hash = computeHash(block)
+		return block, nil
+	}
+
+	block.Timestamp = g.Timestamp
+	for _, v := range g.Validators {
+		if v.Name == "" || v.Stake == 0 {
+			return StakeBlock{}, fmt.Errorf("validator needs a name and a positive stake")
+		}
+		if _, dup := stakes[v.Name]; dup {
+			return StakeBlock{}, fmt.Errorf("duplicate validator %s", v.Name)
+		}
+		stakes[v.Name] = v.Stake
+		if v.PubKey != "" {
+			pub, err := parsePubKey(v.PubKey)
+			if err != nil {
+				return StakeBlock{}, fmt.Errorf("validator %s: %v", v.Name, err)
+			}
+			if existing, ok := pubKeys[v.Name]; ok && !existing.Equal(pub) {
+				return StakeBlock{}, fmt.Errorf("validator %s: public key differs from the local key", v.Name)
+			}
+			pubKeys[v.Name] = pub
+		}
+		registerGenesisValidator(v.Name)
+	}
+	for _, a := range g.Allocations {
+		if a.Address == "" || a.Amount == 0 {
+			return StakeBlock{}, fmt.Errorf("allocation needs an address and a positive amount")
+		}
+		if _, dup := balances[a.Address]; dup {
+			return StakeBlock{}, fmt.Errorf("duplicate allocation for %s", a.Address)
+		}
+		balances[a.Address] = a.Amount
+		minted += a.Amount
+	}
+	block.StateRoot = stateRoot("", 0)
+	block.Hash = computeHash(block)
+
+	if want := g.Hashes["pos"]; want != "" && want != block.Hash {
+		return StakeBlock{}, fmt.Errorf("genesis hash %s does not match the file's %s", block.Hash, want)
+	}
+	return block, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"alirezachain/chainhash"
+	"alirezachain/genesis"
+)
+
+// TestGenesisHash checks that the block the node builds from a genesis
+// file has the hash `alimiad genesis` records for it.
+func TestGenesisHash(t *testing.T) {
+	files := []genesis.File{
+		{Timestamp: 1700000000, Validators: []genesis.Validator{{Name: "v1", Stake: 10}}},
+		{Timestamp: 1700000000,
+			Validators: []genesis.Validator{
+				{Name: "v1", Stake: 10},
+				{Name: "v2", Stake: 30, PubKey: "3b6a27bcceb6a42d62a3a8d02a6f0d73653215771de243a63ac048a18b59da29"},
+			},
+			Allocations: []genesis.Allocation{{Address: "alice", Amount: 100}, {Address: "v1", Amount: 5}},
+		},
+		{Timestamp: 1700000001, HashAlgorithm: chainhash.Keccak256, Validators: []genesis.Validator{{Name: "v1", Stake: 1}}},
+	}
+	reset := func() {
+		hasher = chainhash.Default
+		stakes = make(map[string]uint64)
+		balances = make(map[string]uint64)
+		pubKeys = make(map[string]ed25519.PublicKey)
+		registry = make(map[string]*ValidatorInfo)
+		minted = 0
+	}
+	defer reset()
+	for i, g := range files {
+		reset()
+		var err error
+		if hasher, err = chainhash.New(g.HashAlgorithm); err != nil {
+			t.Fatal(err)
+		}
+		block, err := initGenesis(&g)
+		if err != nil {
+			t.Fatalf("file %d: %v", i, err)
+		}
+		want, err := g.PosHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Hash != want {
+			t.Errorf("file %d: node builds %s, genesis package says %s", i, block.Hash, want)
+		}
+	}
+}
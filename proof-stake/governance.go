@@ -0,0 +1,515 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Proposal statuses.
+const (
+	statusVoting   = "voting"
+	statusPassed   = "passed"
+	statusRejected = "rejected"
+	statusFailed   = "failed" // passed, but the parameter change could not be applied
+)
+
+// Proposal is a governance proposal. Text proposals only record the
+// outcome; param proposals change a consensus parameter when they pass.
+type Proposal struct {
+	ID             int               `json:"id"`
+	Type           string            `json:"type"` // "text" or "param"
+	Title          string            `json:"title"`
+	Description    string            `json:"description,omitempty"`
+	Param          string            `json:"param,omitempty"`
+	Value          uint64            `json:"value,omitempty"`
+	Proposer       string            `json:"proposer"`
+	SubmitHeight   int               `json:"submitHeight"`
+	ContentHash    string            `json:"contentHash"` // what votes sign, with the chain ID
+	DeadlineHeight int               `json:"deadlineHeight"`
+	Votes          map[string]string `json:"votes"` // validator -> yes|no|abstain
+	Status         string            `json:"status"`
+	Tally          *Tally            `json:"tally,omitempty"`
+}
+
+// Tally is the stake-weighted result of a proposal at its deadline.
+type Tally struct {
+	Yes        uint64 `json:"yes"`
+	No         uint64 `json:"no"`
+	Abstain    uint64 `json:"abstain"`
+	TotalStake uint64 `json:"totalStake"`
+}
+
+// govParam is a consensus parameter that proposals may change.
+type govParam struct {
+	get func() uint64
+	set func(uint64) error
+}
+
+var (
+	proposals []*Proposal
+
+	// proposalsPath is GOV_PROPOSALS_FILE, where proposals are kept
+	// across restarts; empty keeps them in memory only.
+	proposalsPath string
+
+	// Governance parameters (GOV_VOTING_PERIOD, GOV_QUORUM_PERCENT,
+	// GOV_THRESHOLD_PERCENT). The voting period is measured in blocks.
+	votingPeriod            = 20
+	quorumPercent    uint64 = 33
+	thresholdPercent uint64 = 50
+)
+
+// govParams lists the parameters adjustable through governance.
+var govParams = map[string]govParam{
+	"min_stake": {
+		get: func() uint64 { return minStake },
+		set: func(v uint64) error { minStake = v; refreshStakeMetrics(); return nil },
+	},
+	"max_validators": {
+		get: func() uint64 { return uint64(maxValidators) },
+		set: func(v uint64) error { maxValidators = int(v); refreshStakeMetrics(); return nil },
+	},
+	"forge_limit": {
+		get: func() uint64 { return uint64(forgeLimit) },
+		set: func(v uint64) error {
+			if v >= uint64(forgeWindow) {
+				return errors.New("forge_limit must be below forge_window")
+			}
+			forgeLimit = int(v)
+			return nil
+		},
+	},
+	"forge_window": {
+		get: func() uint64 { return uint64(forgeWindow) },
+		set: func(v uint64) error {
+			if v < 2 || v <= uint64(forgeLimit) {
+				return errors.New("forge_window must be at least 2 and above forge_limit")
+			}
+			forgeWindow = int(v)
+			return nil
+		},
+	},
+	"slash_percent": {
+		get: func() uint64 { return slashPercent },
+		set: func(v uint64) error {
+			if v > 100 {
+				return errors.New("slash_percent must be at most 100")
+			}
+			slashPercent = v
+			return nil
+		},
+	},
+	"block_reward": {
+		get: func() uint64 { return emission.InitialReward },
+		set: func(v uint64) error { emission.InitialReward = v; return nil },
+	},
+	"unbonding_period": {
+		get: func() uint64 { return uint64(unbondingPeriod) },
+		set: func(v uint64) error {
+			if v == 0 {
+				return errors.New("unbonding_period must be positive")
+			}
+			unbondingPeriod = int(v)
+			return nil
+		},
+	},
+	"voting_period": {
+		get: func() uint64 { return uint64(votingPeriod) },
+		set: func(v uint64) error {
+			if v == 0 {
+				return errors.New("voting_period must be positive")
+			}
+			votingPeriod = int(v)
+			return nil
+		},
+	},
+}
+
+// proposalMessage is the message a validator signs with its key to open
+// a proposal on this chain.
+func proposalMessage(proposer, typ, title, description, param string, value uint64) string {
+	return fmt.Sprintf("propose %s %q %q %q %q %q %d", chainID, proposer, typ, title, description, param, value)
+}
+
+// contentHash identifies the terms of p and the height it was opened at,
+// so that a vote for one proposal never counts for another that reuses
+// its ID.
+func contentHash(p *Proposal) string {
+	msg := proposalMessage(p.Proposer, p.Type, p.Title, p.Description, p.Param, p.Value)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %d %d", msg, p.ID, p.SubmitHeight)))
+	return hex.EncodeToString(sum[:])
+}
+
+// voteMessage is the message a validator signs with its key to vote on
+// p.
+func voteMessage(p *Proposal, option string) string {
+	return fmt.Sprintf("vote %s %d %s %s", chainID, p.ID, p.ContentHash, option)
+}
+
+// checkValidatorSignature verifies that signature signs msg, in the
+// signed-message format, with the registered key of validator. Callers
+// must hold mu.
+func checkValidatorSignature(validator, msg, signature string) error {
+	pub, ok := pubKeys[validator]
+	if !ok {
+		return errors.New("validator has no registered public key")
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize || !ed25519.Verify(pub, messageDigest(msg), sig) {
+		return errors.New("signature must sign the message with the validator's registered key")
+	}
+	return nil
+}
+
+// writeBadSignature refuses a governance request whose signature does
+// not verify, showing the exact message to sign.
+func writeBadSignature(w http.ResponseWriter, err error, msg string) {
+	httpapi.WriteErrorDetails(w, http.StatusForbidden, httpapi.APIError{
+		Code:    "bad_signature",
+		Message: err.Error(),
+		Details: map[string]string{"message": msg},
+	})
+}
+
+// tallyProposal weighs every vote by the voter's current stake.
+// Tombstoned validators' votes are ignored. Callers must hold mu.
+func tallyProposal(p *Proposal) Tally {
+	var t Tally
+	for v, s := range stakes {
+		if !tombstoned[v] {
+			t.TotalStake += s
+		}
+	}
+	for voter, option := range p.Votes {
+		if tombstoned[voter] {
+			continue
+		}
+		switch option {
+		case "yes":
+			t.Yes += stakes[voter]
+		case "no":
+			t.No += stakes[voter]
+		case "abstain":
+			t.Abstain += stakes[voter]
+		}
+	}
+	return t
+}
+
+// processProposals closes every proposal whose deadline has been reached
+// at the given height and applies accepted parameter changes. Callers
+// must hold mu.
+func processProposals(height int) {
+	closed := false
+	defer func() {
+		if closed {
+			saveProposalsOrLog()
+		}
+	}()
+	for _, p := range proposals {
+		if p.Status != statusVoting || height < p.DeadlineHeight {
+			continue
+		}
+		closed = true
+
+		t := tallyProposal(p)
+		p.Tally = &t
+
+		turnout := t.Yes + t.No + t.Abstain
+		quorum := t.TotalStake > 0 && turnout*100 >= t.TotalStake*quorumPercent
+		passed := quorum && t.Yes*100 > (t.Yes+t.No)*thresholdPercent
+		if !passed {
+			p.Status = statusRejected
+			log.Printf("🗳️  Proposal %d rejected (yes=%d no=%d abstain=%d)", p.ID, t.Yes, t.No, t.Abstain)
+			continue
+		}
+
+		p.Status = statusPassed
+		if p.Type == "param" {
+			if err := govParams[p.Param].set(p.Value); err != nil {
+				p.Status = statusFailed
+				log.Printf("⚠️  Proposal %d passed but could not be applied: %v", p.ID, err)
+				continue
+			}
+			log.Printf("🗳️  Proposal %d passed: %s=%d", p.ID, p.Param, p.Value)
+			continue
+		}
+		log.Printf("🗳️  Proposal %d passed", p.ID)
+	}
+}
+
+// loadProposals reads the proposals kept in path, if it exists, and
+// applies again the parameter changes of those that passed, in order.
+// Callers must hold mu.
+func loadProposals(path string) error {
+	proposalsPath = path
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*Proposal
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return fmt.Errorf("decode %s: %v", path, err)
+	}
+	for i, p := range list {
+		if p.ID != i+1 {
+			return fmt.Errorf("%s: proposal %d is listed as number %d", path, p.ID, i+1)
+		}
+		if p.Votes == nil {
+			p.Votes = make(map[string]string)
+		}
+		if p.Status != statusPassed || p.Type != "param" {
+			continue
+		}
+		param, ok := govParams[p.Param]
+		if !ok {
+			return fmt.Errorf("%s: proposal %d: unknown param %q", path, p.ID, p.Param)
+		}
+		if err := param.set(p.Value); err != nil {
+			return fmt.Errorf("%s: proposal %d: %v", path, p.ID, err)
+		}
+	}
+	proposals = list
+	return nil
+}
+
+// saveProposals rewrites GOV_PROPOSALS_FILE. Callers must hold mu.
+func saveProposals() error {
+	if proposalsPath == "" {
+		return nil
+	}
+	raw, _ := json.MarshalIndent(proposals, "", "  ")
+	tmp := proposalsPath + ".tmp"
+	if err := os.WriteFile(tmp, append(raw, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, proposalsPath)
+}
+
+// saveProposalsOrLog is saveProposals for callers that carry on when the
+// file cannot be written. Callers must hold mu.
+func saveProposalsOrLog() {
+	if err := saveProposals(); err != nil {
+		log.Printf("⚠️  Could not write governance proposals %s: %v", proposalsPath, err)
+	}
+}
+
+// findProposal looks up a proposal by the {id} route variable. Callers
+// must hold mu.
+func findProposal(r *http.Request) (*Proposal, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 || id > len(proposals) {
+		return nil, false
+	}
+	return proposals[id-1], true
+}
+
+// listProposalsHandler returns all proposals, optionally filtered by
+// ?status=.
+func listProposalsHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	status := r.URL.Query().Get("status")
+	list := make([]*Proposal, 0, len(proposals))
+	for _, p := range proposals {
+		if status == "" || p.Status == status {
+			list = append(list, p)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(list)
+}
+
+// getProposalHandler returns a single proposal.
+func getProposalHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	p, ok := findProposal(r)
+	if !ok {
+		httpapi.WriteError(w, "proposal not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
+
+// submitProposalHandler opens a new proposal. Only staked validators may
+// propose, signing proposalMessage with their registered key.
+func submitProposalHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Type        string `json:"type"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Param       string `json:"param"`
+		Value       uint64 `json:"value"`
+		Proposer    string `json:"proposer"`
+		Signature   string `json:"signature"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	payload.Title = strings.TrimSpace(payload.Title)
+	payload.Proposer = strings.TrimSpace(payload.Proposer)
+	if payload.Title == "" || payload.Proposer == "" {
+		httpapi.WriteError(w, "title and proposer are required", http.StatusBadRequest)
+		return
+	}
+	switch payload.Type {
+	case "", "text":
+		payload.Type = "text"
+		payload.Param, payload.Value = "", 0
+	case "param":
+		if _, ok := govParams[payload.Param]; !ok {
+			names := make([]string, 0, len(govParams))
+			for n := range govParams {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			httpapi.WriteError(w, "param must be one of: "+strings.Join(names, ", "), http.StatusBadRequest)
+			return
+		}
+	default:
+		httpapi.WriteError(w, `type must be "text" or "param"`, http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if stakes[payload.Proposer] == 0 || tombstoned[payload.Proposer] {
+		httpapi.WriteError(w, "proposer must be a staked validator", http.StatusForbidden)
+		return
+	}
+	msg := proposalMessage(payload.Proposer, payload.Type, payload.Title, payload.Description, payload.Param, payload.Value)
+	if err := checkValidatorSignature(payload.Proposer, msg, payload.Signature); err != nil {
+		writeBadSignature(w, err, msg)
+		return
+	}
+	for _, open := range proposals {
+		if open.Status == statusVoting && proposalMessage(open.Proposer, open.Type, open.Title, open.Description, open.Param, open.Value) == msg {
+			httpapi.WriteError(w, fmt.Sprintf("proposal %d is already open with the same terms", open.ID), http.StatusConflict)
+			return
+		}
+	}
+
+	height := chain[len(chain)-1].Height
+	p := &Proposal{
+		ID:             len(proposals) + 1,
+		Type:           payload.Type,
+		Title:          payload.Title,
+		Description:    payload.Description,
+		Param:          payload.Param,
+		Value:          payload.Value,
+		Proposer:       payload.Proposer,
+		SubmitHeight:   height,
+		DeadlineHeight: height + votingPeriod,
+		Votes:          make(map[string]string),
+		Status:         statusVoting,
+	}
+	p.ContentHash = contentHash(p)
+	proposals = append(proposals, p)
+	saveProposalsOrLog()
+	httpapi.LogRequest(r, "📜 Proposal %d submitted by %s (deadline height %d)", p.ID, p.Proposer, p.DeadlineHeight)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
+
+// voteHandler records or replaces a validator's vote on an open proposal.
+// The vote is signed over voteMessage with the voter's registered key.
+func voteHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Voter     string `json:"voter"`
+		Option    string `json:"option"`
+		Signature string `json:"signature"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	payload.Voter = strings.TrimSpace(payload.Voter)
+	switch payload.Option {
+	case "yes", "no", "abstain":
+	default:
+		httpapi.WriteError(w, `option must be "yes", "no" or "abstain"`, http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	p, ok := findProposal(r)
+	if !ok {
+		httpapi.WriteError(w, "proposal not found", http.StatusNotFound)
+		return
+	}
+	if p.Status != statusVoting {
+		httpapi.WriteError(w, "voting period has ended", http.StatusConflict)
+		return
+	}
+	if stakes[payload.Voter] == 0 || tombstoned[payload.Voter] {
+		httpapi.WriteError(w, "voter must be a staked validator", http.StatusForbidden)
+		return
+	}
+	msg := voteMessage(p, payload.Option)
+	if err := checkValidatorSignature(payload.Voter, msg, payload.Signature); err != nil {
+		writeBadSignature(w, err, msg)
+		return
+	}
+
+	p.Votes[payload.Voter] = payload.Option
+	saveProposalsOrLog()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
+
+// govParamsHandler returns the current value of every governable parameter.
+func govParamsHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	values := make(map[string]uint64, len(govParams))
+	for name, p := range govParams {
+		values[name] = p.get()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(values)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// signMessage signs msg in the signed-message format.
+func signMessage(priv ed25519.PrivateKey, msg string) string {
+	return hex.EncodeToString(ed25519.Sign(priv, messageDigest(msg)))
+}
+
+// post sends body as JSON to h with the given route variables.
+func post(h http.HandlerFunc, vars map[string]string, body interface{}) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(raw)), vars)
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+// stakedChain is signedChain with v1 staking 100 on chain "testnet",
+// and no proposals or withdrawals.
+func stakedChain(t *testing.T, n int) ed25519.PrivateKey {
+	t.Helper()
+	priv := signedChain(t, n)
+	oldChainID, oldSlash, oldPeriod := chainID, slashPercent, unbondingPeriod
+	chainID = "testnet"
+	stakes = map[string]uint64{"v1": 100}
+	t.Cleanup(func() {
+		chainID, slashPercent, unbondingPeriod, burned = oldChainID, oldSlash, oldPeriod, 0
+		stakes, stakeEvents, proposals = make(map[string]uint64), nil, nil
+		stakeLocks, unbondings = make(map[string][]StakeLock), make(map[string][]Unbonding)
+	})
+	return priv
+}
+
+// openProposal opens a text proposal titled title, signed by v1.
+func openProposal(t *testing.T, priv ed25519.PrivateKey, title string) *Proposal {
+	t.Helper()
+	rec := post(submitProposalHandler, nil, map[string]string{
+		"title":     title,
+		"proposer":  "v1",
+		"signature": signMessage(priv, proposalMessage("v1", "text", title, "", "", 0)),
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("propose %q: %d %s", title, rec.Code, rec.Body)
+	}
+	return proposals[len(proposals)-1]
+}
+
+func TestVoteBoundToProposal(t *testing.T) {
+	priv := stakedChain(t, 2)
+	vote := func(sig string) int {
+		return post(voteHandler, map[string]string{"id": "1"}, map[string]string{"voter": "v1", "option": "yes", "signature": sig}).Code
+	}
+
+	first := openProposal(t, priv, "first")
+	signed := signMessage(priv, voteMessage(first, "yes"))
+	if code := vote(signed); code != http.StatusOK {
+		t.Fatalf("vote on proposal 1: %d", code)
+	}
+
+	// A node that lost its proposals numbers the next one 1 again.
+	proposals = nil
+	second := openProposal(t, priv, "second")
+	if second.ID != first.ID || second.ContentHash == first.ContentHash {
+		t.Fatalf("test setup: proposals %+v and %+v", first, second)
+	}
+	if code := vote(signed); code != http.StatusForbidden {
+		t.Errorf("vote for the first proposal counted for the second: %d", code)
+	}
+	if code := vote(signMessage(priv, fmt.Sprintf("vote other 1 %s yes", second.ContentHash))); code != http.StatusForbidden {
+		t.Errorf("vote signed for another chain: %d", code)
+	}
+	if code := vote(signMessage(priv, voteMessage(second, "yes"))); code != http.StatusOK || second.Votes["v1"] != "yes" {
+		t.Errorf("vote on the second proposal: %d", code)
+	}
+}
+
+func TestUnstakeUnbonding(t *testing.T) {
+	priv := stakedChain(t, 2)
+	stakeLocks["v1"] = []StakeLock{{Amount: 30, Until: 50}}
+	unbondingPeriod, slashPercent = 10, 50
+	unstake := func(amount uint64, n int) int {
+		return post(unstakeHandler, nil, map[string]interface{}{
+			"validator": "v1",
+			"amount":    amount,
+			"signature": signMessage(priv, unstakeMessage("v1", amount, n)),
+		}).Code
+	}
+
+	if code := unstake(80, 0); code != http.StatusConflict {
+		t.Errorf("withdrawal of locked stake: %d", code)
+	}
+	if code := unstake(40, 0); code != http.StatusOK {
+		t.Fatalf("withdrawal: %d", code)
+	}
+	if code := unstake(40, 0); code != http.StatusForbidden {
+		t.Errorf("replayed withdrawal: %d", code)
+	}
+	want := []Unbonding{{Amount: 40, Until: 13}}
+	if stakes["v1"] != 60 || !reflect.DeepEqual(pendingUnbondings("v1", 12), want) {
+		t.Fatalf("after withdrawing 40: stake %d, unbonding %+v", stakes["v1"], unbondings["v1"])
+	}
+	if pending := pendingUnbondings("v1", 13); len(pending) != 0 {
+		t.Errorf("withdrawal still pending at its release height: %+v", pending)
+	}
+
+	if penalty := slashValidator("v1"); penalty != 50 {
+		t.Errorf("slash burned %d, want 30 of the stake and 20 of the withdrawal", penalty)
+	}
+	want[0].Amount = 20
+
+	stakes, tombstoned, burned = make(map[string]uint64), make(map[string]bool), 0
+	stakeLocks, unbondings = make(map[string][]StakeLock), make(map[string][]Unbonding)
+	restoreStakes()
+	if stakes["v1"] != 30 || burned != 50 || !reflect.DeepEqual(unbondings["v1"], want) {
+		t.Errorf("restored stake %d, burned %d, unbonding %+v", stakes["v1"], burned, unbondings["v1"])
+	}
+}
+
+func TestProposalsPersisted(t *testing.T) {
+	priv := stakedChain(t, 2)
+	oldMinStake, oldPath := minStake, proposalsPath
+	t.Cleanup(func() { minStake, proposalsPath = oldMinStake, oldPath })
+
+	proposalsPath = filepath.Join(t.TempDir(), "proposals.json")
+	openProposal(t, priv, "passed").Status = statusPassed
+	proposals[0].Type, proposals[0].Param, proposals[0].Value = "param", "min_stake", 5
+	open := openProposal(t, priv, "open")
+	if code := post(voteHandler, map[string]string{"id": "2"}, map[string]string{
+		"voter": "v1", "option": "no", "signature": signMessage(priv, voteMessage(open, "no")),
+	}).Code; code != http.StatusOK {
+		t.Fatalf("vote: %d", code)
+	}
+
+	saved := proposals
+	proposals, minStake = nil, 1
+	if err := loadProposals(proposalsPath); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(proposals, saved) {
+		t.Errorf("loaded %+v, want %+v", proposals, saved)
+	}
+	if minStake != 5 {
+		t.Errorf("min stake %d after loading a passed proposal setting it to 5", minStake)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"alirezachain/kdf"
+)
+
+// Keystore is a passphrase-encrypted validator key in the format written
+// by `wallet import`.
+type Keystore struct {
+	Version int    `json:"version"`
+	Address string `json:"address"`
+	Crypto  struct {
+		Cipher     string `json:"cipher"`
+		Ciphertext string `json:"ciphertext"`
+		Nonce      string `json:"nonce"`
+		KDF        string `json:"kdf"`
+		KDFParams  struct {
+			N     int    `json:"n"`
+			R     int    `json:"r"`
+			P     int    `json:"p"`
+			DKLen int    `json:"dklen"`
+			Salt  string `json:"salt"`
+		} `json:"kdfparams"`
+	} `json:"crypto"`
+}
+
+// decryptKeystore opens the keystore file at path with password.
+func decryptKeystore(path, password string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks Keystore
+	if err := json.Unmarshal(raw, &ks); err != nil {
+		return nil, err
+	}
+	c := ks.Crypto
+	if ks.Version != 1 || c.Cipher != "aes-256-gcm" || c.KDF != "scrypt" || c.KDFParams.DKLen != 32 {
+		return nil, errors.New("unsupported keystore format")
+	}
+
+	salt, err := hex.DecodeString(c.KDFParams.Salt)
+	if err != nil {
+		return nil, errors.New("malformed keystore salt")
+	}
+	dk, err := kdf.Scrypt([]byte(password), salt, c.KDFParams.N, c.KDFParams.R, c.KDFParams.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(c.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("malformed keystore nonce")
+	}
+	ct, err := hex.DecodeString(c.Ciphertext)
+	if err != nil {
+		return nil, errors.New("malformed keystore ciphertext")
+	}
+	seed, err := gcm.Open(nil, nonce, ct, []byte(ks.Address))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("wrong password or corrupted keystore")
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	if hex.EncodeToString(priv.Public().(ed25519.PublicKey)) != ks.Address {
+		return nil, errors.New("keystore address does not match its key")
+	}
+	return priv, nil
+}
+
+// loadKeystores parses a VALIDATOR_KEYSTORES value ("name:path,...") and
+// adds every decrypted key to the keyring, like loadKeyring does for
+// plaintext seeds.
+func loadKeystores(spec, password string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, path, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.TrimSpace(path) == "" {
+			return fmt.Errorf("malformed keystore entry %q", entry)
+		}
+		priv, err := decryptKeystore(strings.TrimSpace(path), password)
+		if err != nil {
+			return fmt.Errorf("keystore for %s: %v", name, err)
+		}
+		keyring[name] = priv
+		pubKeys[name] = priv.Public().(ed25519.PublicKey)
+	}
+	return nil
+}
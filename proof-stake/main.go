@@ -12,6 +12,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"math/big"
 	"net/http"
@@ -22,6 +25,12 @@ import (
 	"sync"
 	"time"
 
+	"alirezachain/chainhash"
+	"alirezachain/clock"
+	"alirezachain/genesis"
+	"alirezachain/httpapi"
+	"alirezachain/idempotency"
+	"alirezachain/poscore"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
@@ -33,23 +42,31 @@ const (
 
 // StakeBlock represents a block in the PoS chain.
 type StakeBlock struct {
-	Height    int    `json:"height"`
-	Timestamp int64  `json:"timestamp"`
-	Data      string `json:"data"`
-	Validator string `json:"validator"`
-	Hash      string `json:"hash"`
-	PrevHash  string `json:"prevHash"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Data      string            `json:"data"`
+	Validator string            `json:"validator"`
+	Hash      string            `json:"hash"`
+	PrevHash  string            `json:"prevHash"`
+	StateRoot string            `json:"stateRoot"`
+	Signature string            `json:"signature,omitempty"` // validator signature over Hash
+	Version   int               `json:"version,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"` // extension fields, committed to by the hash
 }
 
 // BlockView is a user-friendly representation for JSON responses.
 type BlockView struct {
-	Height    int    `json:"height"`
-	Timestamp int64  `json:"timestamp"`
-	TimeText  string `json:"time"`
-	Data      string `json:"data"`
-	Validator string `json:"validator"`
-	Hash      string `json:"hash"`
-	PrevHash  string `json:"prevHash"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	TimeText  string            `json:"time"`
+	Data      string            `json:"data"`
+	Validator string            `json:"validator"`
+	Hash      string            `json:"hash"`
+	PrevHash  string            `json:"prevHash"`
+	StateRoot string            `json:"stateRoot"`
+	Signature string            `json:"signature,omitempty"`
+	Version   int               `json:"version,omitempty"`
+	Extra     map[string]string `json:"extra,omitempty"`
 }
 
 func toView(b StakeBlock) BlockView {
@@ -61,6 +78,10 @@ func toView(b StakeBlock) BlockView {
 		Validator: b.Validator,
 		Hash:      b.Hash,
 		PrevHash:  b.PrevHash,
+		StateRoot: b.StateRoot,
+		Signature: b.Signature,
+		Version:   b.Version,
+		Extra:     b.Extra,
 	}
 }
 
@@ -69,18 +90,89 @@ var (
 	chain  []StakeBlock
 	stakes = make(map[string]uint64) // validator -> stake amount
 	mu     sync.RWMutex
+
+	// clk timestamps blocks and activity; tests may replace it with a
+	// fake clock such as sim.Clock.
+	clk clock.Clock = clock.Real{}
+
+	// idem replays the responses to writes retried with an
+	// Idempotency-Key (see package idempotency).
+	idem = idempotency.New(func() time.Time { return clk.Now() })
+
+	// hasher computes block hashes (SHA-256 unless the genesis file
+	// names another algorithm).
+	hasher = chainhash.Default
+
+	// chainID names the network in signed governance and unstake
+	// messages: the genesis file's chainId, or else the genesis hash.
+	chainID string
 )
 
-// computeHash calculates the SHA-256 hash of a block.
+// Consensus parameters. All can be overridden via the environment
+// (MIN_STAKE, MAX_VALIDATORS, FORGE_LIMIT, FORGE_WINDOW); a maxValidators
+// of 0 means no cap. A validator may forge at most forgeLimit of any
+// forgeWindow consecutive blocks; a forgeLimit of 0 means no limit.
+var (
+	minStake      uint64 = 1
+	maxValidators        = 21
+	forgeLimit           = 0
+	forgeWindow          = 10
+)
+
+// computeHash calculates the hash of a block with the chain's hash
+// algorithm, over the record of the rules in force at its height.
 func computeHash(b StakeBlock) string {
-	record := strconv.Itoa(b.Height) +
-		strconv.FormatInt(b.Timestamp, 10) +
-		b.Data +
-		b.Validator +
-		b.PrevHash
-
-	sum := sha256.Sum256([]byte(record))
-	return hex.EncodeToString(sum[:])
+	return header(b).Hash(hasher, forkHeights)
+}
+
+// header returns what the hash of b commits to.
+func header(b StakeBlock) poscore.Header {
+	return poscore.Header{
+		Height:    b.Height,
+		Timestamp: b.Timestamp,
+		Data:      b.Data,
+		Validator: b.Validator,
+		PrevHash:  b.PrevHash,
+		StateRoot: b.StateRoot,
+		Version:   b.Version,
+		Extra:     b.Extra,
+	}
+}
+
+// stateRoot returns a deterministic hash of the PoS state — every
+// validator's stake, reward balance and tombstone flag, in name order —
+// as it would be after crediting reward to rewardTo. Callers must hold mu.
+func stateRoot(rewardTo string, reward uint64) string {
+	return computeStateRoot(stakes, balances, tombstoned, rewardTo, reward)
+}
+
+// computeStateRoot is stateRoot over the given state.
+func computeStateRoot(stakes, balances map[string]uint64, tombstoned map[string]bool, rewardTo string, reward uint64) string {
+	seen := make(map[string]bool, len(stakes))
+	for v := range stakes {
+		seen[v] = true
+	}
+	for v := range balances {
+		seen[v] = true
+	}
+	if rewardTo != "" {
+		seen[rewardTo] = true
+	}
+	names := make([]string, 0, len(seen))
+	for v := range seen {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, v := range names {
+		bal := balances[v]
+		if v == rewardTo {
+			bal += reward
+		}
+		fmt.Fprintf(h, "%s|%d|%d|%t\n", v, stakes[v], bal, tombstoned[v])
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // isBlockValid verifies a new block against the previous block.
@@ -91,6 +183,12 @@ func isBlockValid(newB, prevB StakeBlock) bool {
 	if newB.PrevHash != prevB.Hash {
 		return false
 	}
+	if checkHeader(newB.Version, newB.Extra) != nil {
+		return false
+	}
+	if checkBlockTime(newB, prevB) != nil {
+		return false
+	}
 	if computeHash(newB) != newB.Hash {
 		return false
 	}
@@ -110,41 +208,114 @@ func isChainValid(c []StakeBlock) bool {
 	return true
 }
 
+// activeValidators returns the validators eligible for selection: those
+// holding at least minStake and not tombstoned, capped to the top
+// maxValidators by stake. In DPoS mode the elected set takes their place
+// once there is one (see dpos.go). The result is sorted by name. Callers
+// must hold mu.
+func activeValidators() []string {
+	if elected := electedValidators(); len(elected) > 0 {
+		return elected
+	}
+	eligible := make([]string, 0, len(stakes))
+	for v, s := range stakes {
+		if s >= minStake && !tombstoned[v] {
+			eligible = append(eligible, v)
+		}
+	}
+
+	// Rank by stake (ties broken by name) to apply the set size cap.
+	sort.Slice(eligible, func(i, j int) bool {
+		si, sj := stakes[eligible[i]], stakes[eligible[j]]
+		if si != sj {
+			return si > sj
+		}
+		return eligible[i] < eligible[j]
+	})
+	if maxValidators > 0 && len(eligible) > maxValidators {
+		eligible = eligible[:maxValidators]
+	}
+
+	sort.Strings(eligible)
+	return eligible
+}
+
+// forgedRecently counts the blocks validator forged among the last
+// forgeWindow-1 blocks of c, the ones sharing a window with the next
+// block. Callers must hold mu.
+func forgedRecently(c []StakeBlock, validator string) int {
+	start := len(c) - (forgeWindow - 1)
+	if start < 1 {
+		start = 1 // the genesis block has no forger
+	}
+	n := 0
+	for _, b := range c[start:] {
+		if b.Validator == validator {
+			n++
+		}
+	}
+	return n
+}
+
+// forgeCapped reports whether validator has used up its FORGE_LIMIT and
+// may not forge the block following c. Callers must hold mu.
+func forgeCapped(c []StakeBlock, validator string) bool {
+	return forgeLimit > 0 && forgedRecently(c, validator) >= forgeLimit
+}
+
 // selectValidator chooses a validator based on stake and previous hash.
-// The higher the stake, the higher the chance of being selected.
+// The higher the stake, the higher the chance of being selected; locked
+// stake counts with its bonus (see stakelock.go). Only
+// validators in the active set carry selection weight, and of those only
+// the ones within their forging rate limit, unless every one of them has
+// reached it: the chain must not stall. In round-robin mode the active
+// validators simply take turns, and FORGE_LIMIT does not apply. Callers
+// must hold mu.
 func selectValidator(prev StakeBlock) (string, bool) {
-	if len(stakes) == 0 {
+	validators := activeValidators()
+	if len(validators) == 0 {
 		return "", false
 	}
+	if validatorSelection == selectionRoundRobin {
+		return roundRobinValidator(validators, prev.Height+1), true
+	}
+	if forgeLimit > 0 {
+		allowed := make([]string, 0, len(validators))
+		for _, v := range validators {
+			if !forgeCapped(chain, v) {
+				allowed = append(allowed, v)
+			}
+		}
+		if len(allowed) > 0 {
+			validators = allowed
+		} else {
+			log.Printf("⚠️  Every active validator reached FORGE_LIMIT; ignoring it at height %d", prev.Height+1)
+		}
+	}
 
 	// Build a deterministic seed from previous hash.
 	seedBytes := sha256.Sum256([]byte(prev.Hash + "|pos"))
 	seedInt := new(big.Int).SetBytes(seedBytes[:])
 
-	// Compute total stake.
+	// Compute total active weight: stake plus the bonus of stake locks.
+	height := prev.Height + 1
 	var total uint64 = 0
-	for _, s := range stakes {
-		total += s
+	for _, v := range validators {
+		total += selectionWeight(v, height)
 	}
 	if total == 0 {
 		return "", false
 	}
 
 	// Pick a random position in [0, total).
-	mod := new(big.Int).Mod(seedInt, big.NewInt(int64(total)))
-	target := uint64(mod.Int64())
+	mod := new(big.Int).Mod(seedInt, new(big.Int).SetUint64(total))
+	target := mod.Uint64()
 
-	// Iterate through validators to find the selected one.
+	// Iterate through validators (sorted, for determinism) to find the
+	// selected one.
 	var cumulative uint64 = 0
-	// To make it deterministic, iterate validators in sorted order.
-	validators := make([]string, 0, len(stakes))
-	for v := range stakes {
-		validators = append(validators, v)
-	}
-	sort.Strings(validators)
-
 	for _, v := range validators {
-		cumulative += stakes[v]
+		cumulative += selectionWeight(v, height)
 		if target < cumulative {
 			return v, true
 		}
@@ -153,26 +324,40 @@ func selectValidator(prev StakeBlock) (string, bool) {
 	return validators[len(validators)-1], true
 }
 
-// forgeBlock creates a new block selected by PoS.
-func forgeBlock(data string) (StakeBlock, bool) {
-	mu.RLock()
-	defer mu.RUnlock()
+// errNoStake is returned by forgeBlock when no validator can be selected.
+var errNoStake = errors.New("no stake available for forging")
 
+// forgeBlock creates a new block selected by PoS, committing to the
+// state after its reward is credited and carrying extra as header
+// extension fields. With PROPOSE_TIMEOUT set, proposers that cannot sign
+// in time are passed over (see rounds.go). Callers must hold mu.
+func forgeBlock(data string, extra map[string]string) (StakeBlock, error) {
 	last := chain[len(chain)-1]
 	validator, ok := selectValidator(last)
 	if !ok {
-		return StakeBlock{}, false
+		return StakeBlock{}, errNoStake
+	}
+
+	build := func(validator string, extra map[string]string) StakeBlock {
+		b := StakeBlock{
+			Height:    last.Height + 1,
+			Timestamp: clk.Now().Unix(),
+			Data:      data,
+			Validator: validator,
+			PrevHash:  last.Hash,
+			StateRoot: stateRoot(validator, emission.rewardAt(last.Height+1)),
+			Version:   blockVersion,
+			Extra:     extra,
+		}
+		b.Hash = computeHash(b)
+		return b
 	}
-
-	b := StakeBlock{
-		Height:    last.Height + 1,
-		Timestamp: time.Now().Unix(),
-		Data:      data,
-		Validator: validator,
-		PrevHash:  last.Hash,
+	if proposeTimeout > 0 {
+		return forgeRounds(last, validator, extra, build)
 	}
-	b.Hash = computeHash(b)
-	return b, true
+	b := build(validator, extra)
+	signBlock(&b)
+	return b, nil
 }
 
 // --- HTTP Handlers ---
@@ -182,46 +367,107 @@ func getChainHandler(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
 	defer mu.RUnlock()
 
-	views := make([]BlockView, 0, len(chain))
-	for _, b := range chain {
+	start, fork, ok := sinceStart(r, chain)
+	if !ok {
+		httpapi.WriteError(w, "since_height must be a height", http.StatusBadRequest)
+		return
+	}
+	if fork != nil {
+		writeFork(w, fork)
+		return
+	}
+	views := make([]BlockView, 0, len(chain)-start)
+	for _, b := range chain[start:] {
 		views = append(views, toView(b))
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(views)
+	writeNegotiated(w, r, "Chain", views)
 }
 
-// stakeHandler allows adding stake for a validator.
+// stakeHandler allows adding stake for a registered validator.
 func stakeHandler(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		Validator string `json:"validator"`
 		Amount    uint64 `json:"amount"`
+		LockUntil int    `json:"lockUntil,omitempty"` // lock the stake below this height for a weight bonus
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 	payload.Validator = strings.TrimSpace(payload.Validator)
 	if payload.Validator == "" || payload.Amount == 0 {
-		http.Error(w, "validator and positive amount are required", http.StatusBadRequest)
+		httpapi.WriteError(w, "validator and positive amount are required", http.StatusBadRequest)
+		return
+	}
+	if err := checkStakeAmount(payload.Amount); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	mu.Lock()
-	stakes[payload.Validator] += payload.Amount
-	current := stakes[payload.Validator]
+	if tombstoned[payload.Validator] {
+		mu.Unlock()
+		httpapi.WriteError(w, "validator is tombstoned", http.StatusForbidden)
+		return
+	}
+	if registry[payload.Validator] == nil {
+		mu.Unlock()
+		httpapi.WriteError(w, "validator is not registered; register it with POST /validators/register first", http.StatusNotFound)
+		return
+	}
+	tip := chain[len(chain)-1].Height
+	if payload.LockUntil != 0 && payload.LockUntil <= tip+1 {
+		mu.Unlock()
+		httpapi.WriteError(w, fmt.Sprintf("lockUntil must be above the next height, %d", tip+1), http.StatusBadRequest)
+		return
+	}
+	added, queued := payload.Amount, uint64(0)
+	if room, capped := stakeRoom(payload.Validator); capped && room < payload.Amount {
+		if stakeCapMode == capModeReject {
+			mu.Unlock()
+			httpapi.WriteErrorDetails(w, http.StatusConflict, httpapi.APIError{
+				Code:    "stake_cap",
+				Message: fmt.Sprintf("stake would lift the validator above MAX_STAKE_SHARE (%d%% of the total stake)", maxStakeShare),
+				Details: map[string]uint64{"maxAmount": room},
+			})
+			return
+		}
+		added, queued = room, payload.Amount-room
+	}
+	var lock *StakeLock
+	if added > 0 {
+		stakes[payload.Validator] += added
+		if payload.LockUntil > 0 {
+			lock = &StakeLock{Amount: added, Until: payload.LockUntil, Bonus: lockBonus(payload.LockUntil - tip)}
+			stakeLocks[payload.Validator] = append(stakeLocks[payload.Validator], *lock)
+			recordLockedStake(payload.Validator, *lock)
+		} else {
+			recordStakeEvent(stakeAdded, payload.Validator, added)
+		}
+	}
+	if queued > 0 {
+		queuedStakes[payload.Validator] += queued
+	}
+	releaseQueuedStakes()
+	current, pending := stakes[payload.Validator], queuedStakes[payload.Validator]
+	markActive(payload.Validator, clk.Now())
 	mu.Unlock()
 
-	log.Printf("💰 Stake updated: validator=%s total=%d", payload.Validator, current)
+	httpapi.LogRequest(r, "💰 Stake updated: validator=%s total=%d queued=%d", payload.Validator, current, pending)
 
 	w.Header().Set("Content-Type", "application/json")
 	resp := map[string]interface{}{
 		"validator": payload.Validator,
 		"total":     current,
 	}
+	if pending > 0 {
+		resp["queued"] = pending
+	}
+	if lock != nil {
+		resp["lock"] = lock
+	}
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	_ = enc.Encode(resp)
@@ -230,36 +476,70 @@ func stakeHandler(w http.ResponseWriter, r *http.Request) {
 // forgeHandler triggers forging a new block using PoS.
 func forgeHandler(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Data string `json:"data"`
+		Data  string            `json:"data"`
+		Extra map[string]string `json:"extra"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 	payload.Data = strings.TrimSpace(payload.Data)
 	if payload.Data == "" {
-		http.Error(w, "data is required", http.StatusBadRequest)
+		httpapi.WriteError(w, "data is required", http.StatusBadRequest)
 		return
 	}
-
-	b, ok := forgeBlock(payload.Data)
-	if !ok {
-		http.Error(w, "no stake available for forging", http.StatusBadRequest)
+	if err := checkHeader(blockVersion, payload.Extra); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := payload.Extra[roundField]; ok && proposeTimeout > 0 {
+		httpapi.WriteError(w, "extra field round is set by the node", http.StatusBadRequest)
+		return
+	}
+	if _, ok := payload.Extra[relayHashField]; ok && relayPowNode != "" {
+		httpapi.WriteError(w, "extra field powHash is set by the relay", http.StatusBadRequest)
 		return
 	}
 
 	mu.Lock()
 	defer mu.Unlock()
 
+	if wait := blockWait(chain[len(chain)-1]); wait > 0 {
+		writeTooSoon(w, wait)
+		return
+	}
+	b, err := forgeBlock(payload.Data, relayExtra(payload.Extra))
+	if err == errNoStake {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
 	last := chain[len(chain)-1]
 	if !isBlockValid(b, last) {
-		http.Error(w, "forged block is not valid", http.StatusInternalServerError)
+		httpapi.WriteError(w, "forged block is not valid", http.StatusInternalServerError)
+		return
+	}
+	// The block must commit to the state after its reward is credited;
+	// check that before anything is appended or credited.
+	if root := stateRoot(b.Validator, emission.rewardAt(b.Height)); root != b.StateRoot {
+		httpapi.LogRequest(r, "⚠️  State root mismatch at height %d: header=%s actual=%s", b.Height, b.StateRoot, root)
+		httpapi.WriteError(w, "forged block does not commit to the resulting state", http.StatusInternalServerError)
 		return
 	}
 
 	chain = append(chain, b)
-	log.Printf("🧱 Forged PoS block: height=%d validator=%s hash=%s", b.Height, b.Validator, b.Hash)
+	notifyTip()
+	recordSlot(b)
+	creditReward(b)
+	recordRelay(b)
+	httpapi.LogRequest(r, "🧱 Forged PoS block: height=%d validator=%s hash=%s", b.Height, b.Validator, b.Hash)
+	processProposals(b.Height)
+	processElection(b.Height)
 
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -267,25 +547,49 @@ func forgeHandler(w http.ResponseWriter, r *http.Request) {
 	_ = enc.Encode(toView(b))
 }
 
-// validatorsHandler returns the current stake distribution.
+// validatorsHandler returns the registered validators with their
+// metadata and the current stake distribution, in name order. Validators
+// that hold stake without a registration, e.g. restored from a stake log,
+// are listed with Registered empty.
 func validatorsHandler(w http.ResponseWriter, r *http.Request) {
 	mu.RLock()
 	defer mu.RUnlock()
 
 	type ValidatorStake struct {
-		Validator string `json:"validator"`
-		Stake     uint64 `json:"stake"`
+		ValidatorInfo
+		Stake   uint64      `json:"stake"`
+		Active  bool        `json:"active"`
+		Capped  bool        `json:"capped,omitempty"` // reached FORGE_LIMIT for the next block
+		Rewards uint64      `json:"rewards"`
+		Queued  uint64      `json:"queued,omitempty"` // stake waiting for room under MAX_STAKE_SHARE
+		Weight  uint64      `json:"weight"`           // selection weight at the next height
+		Locks   []StakeLock `json:"locks,omitempty"`
+		Unbonds []Unbonding `json:"unbonding,omitempty"` // withdrawn stake not yet released
 	}
-	list := make([]ValidatorStake, 0, len(stakes))
-	for v, s := range stakes {
-		list := append(list, ValidatorStake{Validator: v, Stake: s})
-		_ = list
+
+	active := make(map[string]bool)
+	for _, v := range activeValidators() {
+		active[v] = true
 	}
 
-	// Correction: we need separate slice to fill
-	list = list[:0]
-	for v, s := range stakes {
-		list = append(list, ValidatorStake{Validator: v, Stake: s})
+	names := make([]string, 0, len(registry))
+	for v := range registry {
+		names = append(names, v)
+	}
+	for v := range stakes {
+		if registry[v] == nil {
+			names = append(names, v)
+		}
+	}
+	sort.Strings(names)
+	next := chain[len(chain)-1].Height + 1
+	list := make([]ValidatorStake, 0, len(names))
+	for _, v := range names {
+		info := ValidatorInfo{Name: v}
+		if reg := registry[v]; reg != nil {
+			info = *reg
+		}
+		list = append(list, ValidatorStake{ValidatorInfo: info, Stake: stakes[v], Active: active[v], Capped: forgeCapped(chain, v), Rewards: balances[v], Queued: queuedStakes[v], Weight: selectionWeight(v, next), Locks: activeLocks(v, next), Unbonds: pendingUnbondings(v, next)})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -294,79 +598,284 @@ func validatorsHandler(w http.ResponseWriter, r *http.Request) {
 	_ = enc.Encode(list)
 }
 
-// infoHandler returns general information about the chain.
-func infoHandler(w http.ResponseWriter, r *http.Request) {
-	mu.RLock()
-	defer mu.RUnlock()
+// NodeInfo is the body of GET /info.
+type NodeInfo struct {
+	Name          string      `json:"name"`
+	Blocks        int         `json:"blocks"`
+	LastHash      string      `json:"lastHash"`
+	Validators    []InfoStake `json:"validators"` // in name order
+	Timestamp     string      `json:"timestamp"`
+	HashAlgorithm string      `json:"hashAlgorithm"`
+	Selection     string      `json:"selection"`
+}
 
-	type Info struct {
-		Name      string            `json:"name"`
-		Blocks    int               `json:"blocks"`
-		LastHash  string            `json:"lastHash"`
-		Validators map[string]uint64 `json:"validators"`
-		Timestamp string            `json:"timestamp"`
-	}
+// InfoStake is a validator's stake in GET /info.
+type InfoStake struct {
+	Validator string `json:"validator"`
+	Stake     uint64 `json:"stake"`
+}
 
+func infoHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
 	last := chain[len(chain)-1]
-
-	// Copy stakes map to avoid races.
-	valCopy := make(map[string]uint64, len(stakes))
+	validators := make([]InfoStake, 0, len(stakes))
 	for v, s := range stakes {
-		valCopy[v] = s
+		validators = append(validators, InfoStake{Validator: v, Stake: s})
 	}
-
-	resp := Info{
-		Name:       posName,
-		Blocks:     len(chain),
-		LastHash:   last.Hash,
-		Validators: valCopy,
-		Timestamp:  time.Now().Format(time.RFC3339),
+	resp := NodeInfo{
+		Name:          posName,
+		Blocks:        len(chain),
+		LastHash:      last.Hash,
+		Validators:    validators,
+		Timestamp:     clk.Now().Format(time.RFC3339),
+		HashAlgorithm: hasher.Name(),
+		Selection:     validatorSelection,
 	}
+	mu.RUnlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(resp)
+	sort.Slice(resp.Validators, func(i, j int) bool { return resp.Validators[i].Validator < resp.Validators[j].Validator })
+	writeNegotiated(w, r, "NodeInfo", resp)
 }
 
-// router sets up all HTTP routes.
+// router serves the API under /v1 and, deprecated, unversioned (see
+// api.go).
 func router() http.Handler {
-	r := mux.NewRouter()
+	return versioned(routes)
+}
+
+// routes adds every API route to r.
+func routes(r *mux.Router) {
 	r.HandleFunc("/chain", getChainHandler).Methods("GET")
-	r.HandleFunc("/stake", stakeHandler).Methods("POST")
-	r.HandleFunc("/forge", forgeHandler).Methods("POST")
+	r.HandleFunc("/proto", protoHandler).Methods("GET")
+	r.HandleFunc("/chain/next", nextBlockHandler).Methods("GET")
+	r.HandleFunc("/chain/verify", verifyChainHandler).Methods("GET", "POST")
+	r.HandleFunc("/export", exportHandler).Methods("GET")
+	r.HandleFunc("/stake", idem.Wrap(stakeHandler)).Methods("POST")
+	r.HandleFunc("/unstake", idem.Wrap(unstakeHandler)).Methods("POST")
+	r.HandleFunc("/stakes/history", stakeHistoryHandler).Methods("GET")
+	r.HandleFunc("/state/rebuild", rebuildStateHandler).Methods("GET")
+	r.HandleFunc("/forge", idem.Wrap(forgeHandler)).Methods("POST")
 	r.HandleFunc("/validators", validatorsHandler).Methods("GET")
+	r.HandleFunc("/validators/register", registerValidatorHandler).Methods("POST")
+	r.HandleFunc("/validators/metrics", validatorMetricsHandler).Methods("GET")
+	r.HandleFunc("/validators/{name}/performance", performanceHandler).Methods("GET")
 	r.HandleFunc("/info", infoHandler).Methods("GET")
-	return r
+	r.HandleFunc("/supply", supplyHandler).Methods("GET")
+	r.HandleFunc("/params", paramsHandler).Methods("GET")
+	r.HandleFunc("/evidence", evidenceHandler).Methods("POST")
+	r.HandleFunc("/evidence", listEvidenceHandler).Methods("GET")
+	r.HandleFunc("/gov/params", govParamsHandler).Methods("GET")
+	r.HandleFunc("/gov/proposals", listProposalsHandler).Methods("GET")
+	r.HandleFunc("/gov/proposals", submitProposalHandler).Methods("POST")
+	r.HandleFunc("/gov/proposals/{id}", getProposalHandler).Methods("GET")
+	r.HandleFunc("/gov/proposals/{id}/votes", voteHandler).Methods("POST")
+	r.HandleFunc("/verify", verifyMessageHandler).Methods("POST")
+	r.HandleFunc("/attest", attestHandler).Methods("POST")
+	r.HandleFunc("/dpos", dposHandler).Methods("GET")
+	r.HandleFunc("/dpos/votes", dposVoteHandler).Methods("POST")
+	r.HandleFunc("/rounds", roundsHandler).Methods("GET")
+	r.HandleFunc("/relay", relayHandler).Methods("GET")
+	r.HandleFunc("/relay/pow/{hash}", relayProofHandler).Methods("GET")
+}
+
+// loadConfig applies consensus parameters and node settings from the
+// environment, exiting on malformed values.
+func loadConfig() {
+	if v := os.Getenv("MIN_STAKE"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			log.Fatalf("invalid MIN_STAKE %q: %v", v, err)
+		}
+		minStake = n
+	}
+	if v := os.Getenv("MAX_VALIDATORS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_VALIDATORS %q", v)
+		}
+		maxValidators = n
+	}
+	if v := os.Getenv("FORGE_WINDOW"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			log.Fatalf("invalid FORGE_WINDOW %q (at least 2)", v)
+		}
+		forgeWindow = n
+	}
+	if v := os.Getenv("FORGE_LIMIT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n >= forgeWindow {
+			log.Fatalf("invalid FORGE_LIMIT %q (0 to FORGE_WINDOW-1)", v)
+		}
+		forgeLimit = n
+	}
+	if v := os.Getenv("SLASH_PERCENT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n > 100 {
+			log.Fatalf("invalid SLASH_PERCENT %q", v)
+		}
+		slashPercent = n
+	}
+	if v := os.Getenv("GOV_VOTING_PERIOD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			log.Fatalf("invalid GOV_VOTING_PERIOD %q", v)
+		}
+		votingPeriod = n
+	}
+	if v := os.Getenv("GOV_QUORUM_PERCENT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n > 100 {
+			log.Fatalf("invalid GOV_QUORUM_PERCENT %q", v)
+		}
+		quorumPercent = n
+	}
+	if v := os.Getenv("GOV_THRESHOLD_PERCENT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n > 100 {
+			log.Fatalf("invalid GOV_THRESHOLD_PERCENT %q", v)
+		}
+		thresholdPercent = n
+	}
+	if err := loadForks(); err != nil {
+		log.Fatalf("fork config: %v", err)
+	}
+	if err := loadStakeBounds(); err != nil {
+		log.Fatalf("stake config: %v", err)
+	}
+	if err := loadStakeLocks(); err != nil {
+		log.Fatalf("stake lock config: %v", err)
+	}
+	if err := loadUnbonding(); err != nil {
+		log.Fatalf("unbonding config: %v", err)
+	}
+	if err := loadEmission(); err != nil {
+		log.Fatalf("emission config: %v", err)
+	}
+	if err := loadMinBlockInterval(); err != nil {
+		log.Fatalf("block interval config: %v", err)
+	}
+	if err := loadAPIVersioning(); err != nil {
+		log.Fatalf("api config: %v", err)
+	}
+	if err := loadHTTPMiddleware(); err != nil {
+		log.Fatalf("middleware config: %v", err)
+	}
+	if err := loadAttestation(); err != nil {
+		log.Fatalf("attestation config: %v", err)
+	}
+	if err := loadSelection(); err != nil {
+		log.Fatalf("selection config: %v", err)
+	}
+	if err := loadDPoS(); err != nil {
+		log.Fatalf("dpos config: %v", err)
+	}
+	if err := loadRounds(); err != nil {
+		log.Fatalf("round config: %v", err)
+	}
+	if err := loadRelay(); err != nil {
+		log.Fatalf("relay config: %v", err)
+	}
+	if err := loadKeyring(os.Getenv("VALIDATOR_KEYS")); err != nil {
+		log.Fatalf("invalid VALIDATOR_KEYS: %v", err)
+	}
+	if err := loadKeystores(os.Getenv("VALIDATOR_KEYSTORES"), os.Getenv("KEYSTORE_PASSWORD")); err != nil {
+		log.Fatalf("invalid VALIDATOR_KEYSTORES: %v", err)
+	}
+	if err := loadRemoteSigners(os.Getenv("REMOTE_SIGNERS")); err != nil {
+		log.Fatalf("invalid REMOTE_SIGNERS: %v", err)
+	}
+	signerToken = os.Getenv("REMOTE_SIGNER_TOKEN")
+	if err := loadRegistry(os.Getenv("VALIDATOR_REGISTRY_FILE")); err != nil {
+		log.Fatalf("validator registry: %v", err)
+	}
+	if err := openStakeLog(os.Getenv("STAKE_LOG_FILE")); err != nil {
+		log.Fatalf("stake log: %v", err)
+	}
+	for _, p := range strings.Split(os.Getenv("PEERS"), ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			peers = append(peers, p)
+		}
+	}
 }
 
 func main() {
 	_ = godotenv.Load()
+	importFile := flag.String("import", os.Getenv("IMPORT_FILE"), "JSONL chain from GET /export to load before serving")
+	repair := flag.Bool("repair", os.Getenv("REPAIR_CHAIN") == "true", "truncate a corrupt -import file to its last valid block instead of exiting")
+	flag.Parse()
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8082"
 	}
 
-	// Initialize genesis block.
-	genesis := StakeBlock{
-		Height:    0,
-		Timestamp: time.Now().Unix(),
-		Data:      "Genesis 🪙 " + posName,
-		Validator: "genesis",
-		PrevHash:  "",
+	loadConfig()
+
+	var genesisFile *genesis.File
+	if path := os.Getenv("GENESIS_FILE"); path != "" {
+		g, err := genesis.Read(path)
+		if err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+		genesisFile = g
+		if hasher, err = chainhash.New(g.HashAlgorithm); err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
 	}
-	genesis.Hash = computeHash(genesis)
 
+	// Initialize genesis block.
 	mu.Lock()
-	chain = append(chain, genesis)
-	// Optional initial stake for a demo validator.
-	stakes["genesis"] = 1
+	block, err := initGenesis(genesisFile)
+	if err != nil {
+		log.Fatalf("genesis: %v", err)
+	}
+	genesisBalances = make(map[string]uint64, len(balances))
+	for a, n := range balances {
+		genesisBalances[a] = n
+	}
+	if n := restoreStakes(); n > 0 {
+		log.Printf("📒 Restored stakes from %d events in %s", n, stakeLogPath)
+	} else {
+		recordGenesisStakes()
+	}
+	chainID = block.Hash
+	if genesisFile != nil && genesisFile.ChainID != "" {
+		chainID = genesisFile.ChainID
+	}
+	if err := loadProposals(os.Getenv("GOV_PROPOSALS_FILE")); err != nil {
+		log.Fatalf("governance proposals: %v", err)
+	}
+	refreshStakeMetrics()
+	chain = append(chain, block)
 	mu.Unlock()
 
+	if *importFile != "" {
+		if err := checkStoredChain(*importFile, *repair); err != nil {
+			log.Fatalf("import %s: %v", *importFile, err)
+		}
+		n, err := importChain(*importFile)
+		if err != nil {
+			log.Fatalf("import %s: %v", *importFile, err)
+		}
+		log.Printf("📥 Imported %d blocks from %s", n, *importFile)
+	}
+
 	addr := ":" + port
 	log.Printf("%s", posBanner)
 	log.Printf("🚀 PoS node listening on %s", addr)
+	log.Printf("⚖️  Min stake=%d, max validators=%d", minStake, maxValidators)
+	if forgeLimit > 0 {
+		log.Printf("⏳ Forge limit: %d of any %d consecutive blocks per validator", forgeLimit, forgeWindow)
+	}
+	if maxStakeShare > 0 {
+		log.Printf("🧮 Stake share cap: %d%% of the total stake per validator (%s)", maxStakeShare, stakeCapMode)
+	}
+	if relayPowNode != "" {
+		log.Printf("🌉 Relaying PoW blocks from %s every %s", relayPowNode, relayInterval)
+		relayLoop()
+	}
 
 	if err := http.ListenAndServe(addr, router()); err != nil {
 		log.Fatalf("server error: %v", err)
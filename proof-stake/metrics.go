@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// StakeMetrics quantifies how concentrated the active stake is.
+type StakeMetrics struct {
+	Validators int     `json:"validators"`
+	TotalStake uint64  `json:"totalStake"`
+	Gini       float64 `json:"gini"` // 0 = perfectly equal, towards 1 = one validator holds everything
+	// Nakamoto is the smallest number of validators that together hold
+	// more than a third of the stake, enough to halt a BFT network;
+	// NakamotoMajority is the same for more than half.
+	Nakamoto         int `json:"nakamoto"`
+	NakamotoMajority int `json:"nakamotoMajority"`
+}
+
+// stakeMetrics is refreshed by refreshStakeMetrics whenever stakes or the
+// active set rules change. Guarded by mu.
+var stakeMetrics StakeMetrics
+
+// refreshStakeMetrics recomputes stakeMetrics over the active set.
+// Callers must hold mu.
+func refreshStakeMetrics() {
+	active := activeValidators()
+	amounts := make([]uint64, 0, len(active))
+	var total uint64
+	for _, v := range active {
+		amounts = append(amounts, stakes[v])
+		total += stakes[v]
+	}
+	sort.Slice(amounts, func(i, j int) bool { return amounts[i] < amounts[j] })
+
+	m := StakeMetrics{Validators: len(amounts), TotalStake: total}
+	if total > 0 {
+		// Gini over the ascending amounts: 2*sum(i*x_i)/(n*sum) - (n+1)/n.
+		var weighted float64
+		for i, a := range amounts {
+			weighted += float64(i+1) * float64(a)
+		}
+		n := float64(len(amounts))
+		m.Gini = 2*weighted/(n*float64(total)) - (n+1)/n
+
+		var acc uint64
+		for i := len(amounts) - 1; i >= 0; i-- {
+			acc += amounts[i]
+			count := len(amounts) - i
+			if m.Nakamoto == 0 && acc*3 > total {
+				m.Nakamoto = count
+			}
+			if acc*2 > total {
+				m.NakamotoMajority = count
+				break
+			}
+		}
+	}
+	stakeMetrics = m
+}
+
+// validatorMetricsHandler returns the decentralization metrics of the
+// current stake distribution.
+func validatorMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	m := stakeMetrics
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(m)
+}
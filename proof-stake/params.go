@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GovernanceParams are the rules proposals are decided by.
+type GovernanceParams struct {
+	VotingPeriod     int    `json:"votingPeriod"` // blocks
+	QuorumPercent    uint64 `json:"quorumPercent"`
+	ThresholdPercent uint64 `json:"thresholdPercent"`
+}
+
+// ConsensusParams answers GET /params: the rules the next block is
+// forged and validated by. Governance can change several of them, so
+// they are read at the current tip.
+type ConsensusParams struct {
+	Height           int              `json:"height"` // of the next block
+	Reward           uint64           `json:"reward"`
+	Emission         EmissionSchedule `json:"emission"`
+	MinBlockInterval string           `json:"minBlockInterval"`
+	ProposeTimeout   string           `json:"proposeTimeout"` // 0s when rounds are off
+	MinStake         uint64           `json:"minStake"`
+	MinStakeAmount   uint64           `json:"minStakeAmount"`
+	MaxStakeAmount   uint64           `json:"maxStakeAmount"`   // 0 for no limit
+	MaxStakeShare    uint64           `json:"maxStakeShare"`    // percent, 0 for no cap
+	StakeCapMode     string           `json:"stakeCapMode"`     // "reject" or "queue"
+	LockBonusPercent uint64           `json:"lockBonusPercent"` // per LockBonusBlocks of a lock's term
+	LockBonusBlocks  int              `json:"lockBonusBlocks"`
+	LockBonusMax     uint64           `json:"lockBonusMax"`
+	UnbondingPeriod  int              `json:"unbondingPeriod"` // blocks withdrawn stake stays slashable
+	MaxValidators    int              `json:"maxValidators"`   // 0 for no cap
+	ForgeLimit       int              `json:"forgeLimit"`      // 0 for no limit
+	ForgeWindow      int              `json:"forgeWindow"`
+	SlashPercent     uint64           `json:"slashPercent"`
+	Selection        string           `json:"selection"` // "stake" or "round-robin"
+	ActiveSet        string           `json:"activeSet"` // "stake" or "dpos"
+	DPoSEpoch        int              `json:"dposEpoch,omitempty"`
+	HashAlgorithm    string           `json:"hashAlgorithm"`
+	ChainID          string           `json:"chainId"` // signed into governance and unstake messages
+	Governance       GovernanceParams `json:"governance"`
+}
+
+// paramsHandler reports the consensus parameters in force for the next
+// block.
+func paramsHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	height := chain[len(chain)-1].Height + 1
+	p := ConsensusParams{
+		Height:           height,
+		Reward:           emission.rewardAt(height),
+		Emission:         emission,
+		MinBlockInterval: minBlockInterval.String(),
+		ProposeTimeout:   proposeTimeout.String(),
+		MinStake:         minStake,
+		MinStakeAmount:   minStakeAmount,
+		MaxStakeAmount:   maxStakeAmount,
+		MaxStakeShare:    maxStakeShare,
+		StakeCapMode:     stakeCapMode,
+		LockBonusPercent: lockBonusPercent,
+		LockBonusBlocks:  lockBonusBlocks,
+		LockBonusMax:     lockBonusMax,
+		UnbondingPeriod:  unbondingPeriod,
+		MaxValidators:    maxValidators,
+		ForgeLimit:       forgeLimit,
+		ForgeWindow:      forgeWindow,
+		SlashPercent:     slashPercent,
+		Selection:        validatorSelection,
+		ActiveSet:        activeSetMode,
+		HashAlgorithm:    hasher.Name(),
+		ChainID:          chainID,
+		Governance: GovernanceParams{
+			VotingPeriod:     votingPeriod,
+			QuorumPercent:    quorumPercent,
+			ThresholdPercent: thresholdPercent,
+		},
+	}
+	if activeSetMode == activeSetDPoS {
+		p.DPoSEpoch = dposEpoch
+	}
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
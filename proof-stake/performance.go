@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// ValidatorPerformance tracks how a validator has used its slots.
+type ValidatorPerformance struct {
+	Validator string `json:"validator"`
+	Proposed  int    `json:"proposed"`
+	// Expected is the number of blocks the validator should have
+	// proposed given its selection probability at every height.
+	Expected float64 `json:"expected"`
+	Ratio    float64 `json:"ratio"` // proposed / expected
+	// Missed counts slots the validator was selected for but that were
+	// forged unsigned although it has a registered key, e.g. because its
+	// remote signer was unreachable.
+	Missed             int   `json:"missed"`
+	LastProposedHeight int   `json:"lastProposedHeight,omitempty"`
+	LastSeen           int64 `json:"lastSeen,omitempty"` // unix time of its last block or stake
+}
+
+// performance holds the record of every validator. Guarded by mu.
+var performance = make(map[string]*ValidatorPerformance)
+
+// perfRecord returns the record of v, creating it if needed. Callers
+// must hold mu.
+func perfRecord(v string) *ValidatorPerformance {
+	p, ok := performance[v]
+	if !ok {
+		p = &ValidatorPerformance{Validator: v}
+		performance[v] = p
+	}
+	return p
+}
+
+// recordSlot credits every active validator with its selection
+// probability for b's height, 1 for the validator whose turn it was in
+// round-robin mode, and records b's proposer. It must run before anything
+// that changes the stakes b was selected with. Callers must hold mu.
+func recordSlot(b StakeBlock) {
+	active := activeValidators()
+	if validatorSelection == selectionRoundRobin {
+		if len(active) > 0 {
+			perfRecord(roundRobinValidator(active, b.Height)).Expected++
+		}
+	} else {
+		var total uint64
+		for _, v := range active {
+			total += selectionWeight(v, b.Height)
+		}
+		if total > 0 {
+			for _, v := range active {
+				perfRecord(v).Expected += float64(selectionWeight(v, b.Height)) / float64(total)
+			}
+		}
+	}
+
+	p := perfRecord(b.Validator)
+	p.Proposed++
+	p.LastProposedHeight = b.Height
+	p.LastSeen = b.Timestamp
+	if _, hasKey := pubKeys[b.Validator]; hasKey && b.Signature == "" {
+		p.Missed++
+	}
+}
+
+// markActive records activity by a validator outside block production.
+// Callers must hold mu.
+func markActive(v string, now time.Time) {
+	perfRecord(v).LastSeen = now.Unix()
+}
+
+// performanceHandler returns the performance record of a validator.
+func performanceHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	mu.RLock()
+	rec, ok := performance[name]
+	var p ValidatorPerformance
+	if ok {
+		p = *rec
+	}
+	mu.RUnlock()
+	if !ok {
+		httpapi.WriteError(w, "validator not found", http.StatusNotFound)
+		return
+	}
+	if p.Expected > 0 {
+		p.Ratio = float64(p.Proposed) / p.Expected
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// maxStateDiffs caps how many divergences a StateReport lists.
+const maxStateDiffs = 100
+
+// genesisBalances is the reward balance state of the genesis block: the
+// genesis file's allocations. Replays start from it.
+var genesisBalances map[string]uint64
+
+// StateDiff is a divergence between replayed and stored state.
+type StateDiff struct {
+	Height   int    `json:"height"`
+	Account  string `json:"account,omitempty"`
+	Field    string `json:"field"`
+	Stored   string `json:"stored"`
+	Replayed string `json:"replayed"`
+}
+
+// StateReport is the result of replaying the chain from genesis.
+type StateReport struct {
+	OK          bool        `json:"ok"`
+	Blocks      int         `json:"blocks"`
+	StakeEvents int         `json:"stakeEvents"`
+	Accounts    int         `json:"accounts"`
+	StateRoot   string      `json:"stateRoot"` // of the replayed state at the tip
+	Divergences int         `json:"divergences"`
+	Diffs       []StateDiff `json:"diffs,omitempty"` // the first maxStateDiffs
+}
+
+// add records a divergence.
+func (r *StateReport) add(d StateDiff) {
+	r.Divergences++
+	if len(r.Diffs) < maxStateDiffs {
+		r.Diffs = append(r.Diffs, d)
+	}
+}
+
+// rebuildState recomputes stakes, tombstones and reward balances from
+// genesis: block rewards come from the chain, stake changes from the
+// stake history, each applied before the first block forged after it.
+// The state before each block is checked against the block's state root,
+// and the final state against the node's live state. Callers must hold
+// mu.
+func rebuildState() StateReport {
+	rep := StateReport{Blocks: len(chain), StakeEvents: len(stakeEvents)}
+	st := make(map[string]uint64)
+	bal := make(map[string]uint64)
+	tomb := make(map[string]bool)
+	var mint, burn uint64
+	for a, n := range genesisBalances {
+		bal[a] = n
+		mint += n
+	}
+
+	next := 0
+	apply := func(ev StakeEvent) {
+		st[ev.Validator] = ev.Total
+		if ev.Kind == stakeSlashed {
+			tomb[ev.Validator] = true
+			burn += ev.Amount
+		}
+	}
+	for next < len(stakeEvents) && stakeEvents[next].Kind == stakeGenesis {
+		apply(stakeEvents[next])
+		next++
+	}
+
+	for _, b := range chain {
+		if b.Height > 0 {
+			for next < len(stakeEvents) && stakeEvents[next].Height < b.Height {
+				apply(stakeEvents[next])
+				next++
+			}
+		}
+		reward := emission.rewardAt(b.Height)
+		rewardTo := b.Validator
+		if b.Height == 0 {
+			rewardTo = ""
+		}
+		if root := computeStateRoot(st, bal, tomb, rewardTo, reward); root != b.StateRoot {
+			rep.add(StateDiff{Height: b.Height, Field: "stateRoot", Stored: b.StateRoot, Replayed: root})
+		}
+		if b.Height > 0 {
+			bal[b.Validator] += reward
+			mint += reward
+		}
+	}
+	for ; next < len(stakeEvents); next++ {
+		apply(stakeEvents[next])
+	}
+
+	tip := chain[len(chain)-1].Height
+	names := make(map[string]bool)
+	for _, m := range []map[string]uint64{st, bal, stakes, balances} {
+		for v := range m {
+			names[v] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for v := range names {
+		sorted = append(sorted, v)
+	}
+	sort.Strings(sorted)
+	for _, v := range sorted {
+		if stakes[v] != st[v] {
+			rep.add(StateDiff{Height: tip, Account: v, Field: "stake", Stored: strconv.FormatUint(stakes[v], 10), Replayed: strconv.FormatUint(st[v], 10)})
+		}
+		if balances[v] != bal[v] {
+			rep.add(StateDiff{Height: tip, Account: v, Field: "balance", Stored: strconv.FormatUint(balances[v], 10), Replayed: strconv.FormatUint(bal[v], 10)})
+		}
+		if tombstoned[v] != tomb[v] {
+			rep.add(StateDiff{Height: tip, Account: v, Field: "tombstoned", Stored: strconv.FormatBool(tombstoned[v]), Replayed: strconv.FormatBool(tomb[v])})
+		}
+	}
+	if minted != mint {
+		rep.add(StateDiff{Height: tip, Field: "minted", Stored: strconv.FormatUint(minted, 10), Replayed: strconv.FormatUint(mint, 10)})
+	}
+	if burned != burn {
+		rep.add(StateDiff{Height: tip, Field: "burned", Stored: strconv.FormatUint(burned, 10), Replayed: strconv.FormatUint(burn, 10)})
+	}
+
+	rep.Accounts = len(sorted)
+	rep.StateRoot = computeStateRoot(st, bal, tomb, "", 0)
+	rep.OK = rep.Divergences == 0
+	return rep
+}
+
+// rebuildStateHandler replays the chain and stake history and reports
+// where the result differs from the stored state.
+func rebuildStateHandler(w http.ResponseWriter, r *http.Request) {
+	mu.RLock()
+	rep := rebuildState()
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Limits on registration metadata.
+const (
+	maxValidatorName = 64
+	maxDisplayName   = 64
+	maxWebsite       = 200
+)
+
+// ValidatorInfo is what a validator registered with POST
+// /validators/register. Only registered validators can stake. The
+// validators of the genesis state are registered from the start, with
+// Registered set to "genesis".
+type ValidatorInfo struct {
+	Name        string `json:"validator"`
+	PubKey      string `json:"pubKey,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	Website     string `json:"website,omitempty"`
+	Commission  uint64 `json:"commission"` // announced commission, in percent
+	Registered  string `json:"registered"`
+}
+
+var (
+	// registry holds the registered validators by name; guarded by mu.
+	registry = make(map[string]*ValidatorInfo)
+
+	// registryPath is VALIDATOR_REGISTRY_FILE, rewritten on every
+	// registration; empty keeps the registry in memory only.
+	registryPath string
+)
+
+// registrationMessage is the message a validator signs with its key to
+// register or update its metadata.
+func registrationMessage(v ValidatorInfo) string {
+	return fmt.Sprintf("register %q %q %q %d", v.Name, v.DisplayName, v.Website, v.Commission)
+}
+
+// check validates the metadata of a registration.
+func (v *ValidatorInfo) check() error {
+	switch {
+	case v.Name == "" || len(v.Name) > maxValidatorName:
+		return fmt.Errorf("validator must be 1 to %d characters", maxValidatorName)
+	case len(v.DisplayName) > maxDisplayName:
+		return fmt.Errorf("displayName must be at most %d characters", maxDisplayName)
+	case len(v.Website) > maxWebsite:
+		return fmt.Errorf("website must be at most %d characters", maxWebsite)
+	case v.Commission > 100:
+		return fmt.Errorf("commission is a percentage from 0 to 100")
+	}
+	if v.Website != "" {
+		u, err := url.Parse(v.Website)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("website must be an http or https URL")
+		}
+	}
+	return nil
+}
+
+// loadRegistry reads the registrations kept in path, if it exists, and
+// registers their public keys.
+func loadRegistry(path string) error {
+	registryPath = path
+	if path == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []ValidatorInfo
+	if err := json.Unmarshal(raw, &list); err != nil {
+		return fmt.Errorf("decode %s: %v", path, err)
+	}
+	for i := range list {
+		v := list[i]
+		if err := v.check(); err != nil {
+			return fmt.Errorf("%s: %s: %v", path, v.Name, err)
+		}
+		if v.PubKey != "" {
+			pub, err := parsePubKey(v.PubKey)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %v", path, v.Name, err)
+			}
+			if existing, ok := pubKeys[v.Name]; ok && !existing.Equal(pub) {
+				return fmt.Errorf("%s: %s: public key differs from the local key", path, v.Name)
+			}
+			pubKeys[v.Name] = pub
+		}
+		registry[v.Name] = &v
+	}
+	return nil
+}
+
+// saveRegistry rewrites VALIDATOR_REGISTRY_FILE. Callers must hold mu.
+func saveRegistry() error {
+	if registryPath == "" {
+		return nil
+	}
+	list := make([]ValidatorInfo, 0, len(registry))
+	for _, v := range registry {
+		list = append(list, *v)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	raw, _ := json.MarshalIndent(list, "", "  ")
+	tmp := registryPath + ".tmp"
+	if err := os.WriteFile(tmp, append(raw, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, registryPath)
+}
+
+// registerGenesisValidator registers a validator of the genesis state,
+// keeping an entry already restored from VALIDATOR_REGISTRY_FILE.
+// Callers must hold mu.
+func registerGenesisValidator(name string) {
+	if _, ok := registry[name]; ok {
+		return
+	}
+	v := &ValidatorInfo{Name: name, Registered: "genesis"}
+	if pub, ok := pubKeys[name]; ok {
+		v.PubKey = hex.EncodeToString(pub)
+	}
+	registry[name] = v
+}
+
+// registerValidatorHandler registers a validator, or updates its
+// metadata. The request is signed with the validator's key over
+// registrationMessage, so that nobody can claim a name with a key they
+// do not hold; a registered key cannot be replaced.
+func registerValidatorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ValidatorInfo
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	v := payload.ValidatorInfo
+	v.Name = strings.TrimSpace(v.Name)
+	v.DisplayName = strings.TrimSpace(v.DisplayName)
+	v.Website = strings.TrimSpace(v.Website)
+	if err := v.check(); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pub, err := parsePubKey(v.PubKey)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	v.PubKey = hex.EncodeToString(pub)
+	msg := registrationMessage(v)
+	sig, err := hex.DecodeString(payload.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize || !ed25519.Verify(pub, messageDigest(msg), sig) {
+		httpapi.WriteErrorDetails(w, http.StatusBadRequest, httpapi.APIError{
+			Code:    "bad_signature",
+			Message: "signature must sign the registration message with the validator's key",
+			Details: map[string]string{"message": msg},
+		})
+		return
+	}
+
+	mu.Lock()
+	if tombstoned[v.Name] {
+		mu.Unlock()
+		httpapi.WriteError(w, "validator is tombstoned", http.StatusForbidden)
+		return
+	}
+	if existing, ok := pubKeys[v.Name]; ok && !existing.Equal(pub) {
+		mu.Unlock()
+		httpapi.WriteError(w, "validator already has a different public key", http.StatusConflict)
+		return
+	}
+	prev, updated := registry[v.Name]
+	v.Registered = clk.Now().UTC().Format(time.RFC3339)
+	if updated {
+		v.Registered = prev.Registered
+	}
+	pubKeys[v.Name] = pub
+	registry[v.Name] = &v
+	err = saveRegistry()
+	mu.Unlock()
+	if err != nil {
+		httpapi.LogRequest(r, "⚠️  Could not write validator registry %s: %v", registryPath, err)
+	}
+	httpapi.LogRequest(r, "🪪 Validator registered: validator=%s displayName=%q", v.Name, v.DisplayName)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !updated {
+		w.WriteHeader(http.StatusCreated)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Cross-chain relay. With RELAY_POW_NODE set, the node asks that PoW
+// node every RELAY_INTERVAL for its latest finalized checkpoint, or its
+// tip outside hybrid mode, and commits the block's height and hash to
+// the next forged block through the powHeight and powHash extension
+// fields. GET /relay/pow/{hash} then proves the PoW block existed by
+// that block's time. The PoW node relays PoS blocks the other way (see
+// proof-work/relay.go).
+
+// Extension fields holding a relayed PoW block.
+const (
+	relayHeightField = "powHeight"
+	relayHashField   = "powHash"
+)
+
+var (
+	// relayPowNode is the PoW node whose blocks are relayed
+	// (RELAY_POW_NODE); "" relays nothing.
+	relayPowNode string
+
+	// relayInterval is the time between relays (RELAY_INTERVAL).
+	relayInterval = 30 * time.Second
+
+	// pendingRelay is the PoW block waiting for the next forged block;
+	// nil if none. Guarded by mu.
+	pendingRelay *RelayedBlock
+
+	relayClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// RelayedBlock is a block of the other chain handed to this one.
+type RelayedBlock struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+	Time   string `json:"time"` // when it was relayed
+}
+
+// PoWRelayProof answers GET /relay/pow/{hash}.
+type PoWRelayProof struct {
+	PowHeight     int    `json:"powHeight"`
+	PowHash       string `json:"powHash"`
+	Status        string `json:"status"` // "pending" or "committed"
+	BlockHeight   int    `json:"blockHeight,omitempty"`
+	BlockHash     string `json:"blockHash,omitempty"`
+	Validator     string `json:"validator,omitempty"`
+	Signature     string `json:"signature,omitempty"` // the validator's signature over blockHash
+	TimeText      string `json:"time,omitempty"`
+	Confirmations int    `json:"confirmations,omitempty"`
+}
+
+// loadRelay reads RELAY_POW_NODE and RELAY_INTERVAL.
+func loadRelay() error {
+	relayPowNode = strings.TrimRight(os.Getenv("RELAY_POW_NODE"), "/")
+	if relayPowNode != "" && !strings.HasPrefix(relayPowNode, "http://") && !strings.HasPrefix(relayPowNode, "https://") {
+		return fmt.Errorf("invalid RELAY_POW_NODE %q: want an http(s) URL", relayPowNode)
+	}
+	if v := os.Getenv("RELAY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid RELAY_INTERVAL %q: want a duration like 30s", v)
+		}
+		relayInterval = d
+	}
+	return nil
+}
+
+// relayLoop relays the PoW node's latest block every relayInterval.
+func relayLoop() {
+	clk.AfterFunc(relayInterval, func() {
+		if err := relayOnce(); err != nil {
+			log.Printf("⚠️  Relay from %s failed: %v", relayPowNode, err)
+		}
+		relayLoop()
+	})
+}
+
+// relayGet fetches path from the PoW node into v.
+func relayGet(path string, v interface{}) error {
+	resp, err := relayClient.Get(relayPowNode + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PoW node answered %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// relayOnce queues the PoW node's latest finalized block, or its tip,
+// for the next forged block unless the chain already carries it.
+func relayOnce() error {
+	var cp struct {
+		FinalizedHeight int    `json:"finalizedHeight"`
+		FinalizedHash   string `json:"finalizedHash"`
+	}
+	if err := relayGet("/checkpoints", &cp); err != nil {
+		return err
+	}
+	height, hash := cp.FinalizedHeight, cp.FinalizedHash
+	if height == 0 {
+		var info struct {
+			Blocks   int    `json:"blocks"`
+			LastHash string `json:"lastHash"`
+		}
+		if err := relayGet("/info", &info); err != nil {
+			return err
+		}
+		height, hash = info.Blocks-1, info.LastHash
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if (pendingRelay != nil && pendingRelay.Hash == hash) || relayedAt(hash) >= 0 {
+		return nil
+	}
+	pendingRelay = &RelayedBlock{Height: height, Hash: hash, Time: clk.Now().Format(time.RFC3339)}
+	log.Printf("🌉 Relaying PoW block %d (%s)", height, hash)
+	return nil
+}
+
+// relayExtra adds the pending PoW block to the extension fields of the
+// next block, unless they have no room left. Callers must hold mu.
+func relayExtra(extra map[string]string) map[string]string {
+	if pendingRelay == nil || len(extra)+2 > maxExtraFields {
+		return extra
+	}
+	merged := make(map[string]string, len(extra)+2)
+	for k, v := range extra {
+		merged[k] = v
+	}
+	merged[relayHeightField] = strconv.Itoa(pendingRelay.Height)
+	merged[relayHashField] = pendingRelay.Hash
+	return merged
+}
+
+// recordRelay clears the pending PoW block once b carries it. Callers
+// must hold mu.
+func recordRelay(b StakeBlock) {
+	if pendingRelay != nil && b.Extra[relayHashField] == pendingRelay.Hash {
+		pendingRelay = nil
+	}
+}
+
+// relayedAt returns the height of the block carrying the PoW block
+// hash, or -1. Callers must hold mu.
+func relayedAt(hash string) int {
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].Extra[relayHashField] == hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// relayHandler reports the relay configuration, the PoW block waiting
+// for the next block and the newest one committed.
+func relayHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Source    string         `json:"source"` // RELAY_POW_NODE; "" when off
+		Interval  string         `json:"interval"`
+		Pending   *RelayedBlock  `json:"pending,omitempty"`
+		Committed *PoWRelayProof `json:"committed,omitempty"`
+	}
+
+	mu.RLock()
+	resp := Response{Source: relayPowNode, Interval: relayInterval.String(), Pending: pendingRelay}
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].Extra[relayHashField] != "" {
+			proof := relayProof(chain[i])
+			resp.Committed = &proof
+			break
+		}
+	}
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
+
+// relayProof describes the PoW block committed to by b. Callers must
+// hold mu.
+func relayProof(b StakeBlock) PoWRelayProof {
+	powHeight, _ := strconv.Atoi(b.Extra[relayHeightField])
+	return PoWRelayProof{
+		PowHeight:     powHeight,
+		PowHash:       b.Extra[relayHashField],
+		Status:        "committed",
+		BlockHeight:   b.Height,
+		BlockHash:     b.Hash,
+		Validator:     b.Validator,
+		Signature:     b.Signature,
+		TimeText:      time.Unix(b.Timestamp, 0).Format(time.RFC3339),
+		Confirmations: chain[len(chain)-1].Height - b.Height + 1,
+	}
+}
+
+// relayProofHandler proves that a PoW block hash was committed to this
+// chain.
+func relayProofHandler(w http.ResponseWriter, r *http.Request) {
+	hash := strings.ToLower(mux.Vars(r)["hash"])
+
+	mu.RLock()
+	var proof PoWRelayProof
+	found := true
+	if h := relayedAt(hash); h >= 0 {
+		proof = relayProof(chain[h])
+	} else if pendingRelay != nil && pendingRelay.Hash == hash {
+		proof = PoWRelayProof{PowHeight: pendingRelay.Height, PowHash: hash, Status: "pending"}
+	} else {
+		found = false
+	}
+	mu.RUnlock()
+	if !found {
+		httpapi.WriteError(w, "PoW block was not relayed to this chain", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if proof.Status == "pending" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(proof)
+}
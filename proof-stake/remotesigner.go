@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"alirezachain/poscore"
+)
+
+// SignRequest asks a remote signer to sign a block. The signer hashes
+// the header itself and takes the height from it.
+type SignRequest struct {
+	Block poscore.Header `json:"block"`
+}
+
+// SignResponse carries the signature, the key that produced it and the
+// block hash the signer computed.
+type SignResponse struct {
+	PubKey    string `json:"pubKey"`
+	Signature string `json:"signature"`
+	Hash      string `json:"hash"`
+}
+
+var (
+	// remoteSigners maps validators to the URL of the signer holding
+	// their key (REMOTE_SIGNERS, "name:url,..."); signerToken is sent as
+	// a bearer token (REMOTE_SIGNER_TOKEN).
+	remoteSigners = make(map[string]string)
+	signerToken   string
+
+	signerClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// loadRemoteSigners parses a REMOTE_SIGNERS value.
+func loadRemoteSigners(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, url, ok := strings.Cut(entry, ":")
+		name, url = strings.TrimSpace(name), strings.TrimSpace(url)
+		if !ok || name == "" || url == "" {
+			return fmt.Errorf("malformed signer entry %q", entry)
+		}
+		remoteSigners[name] = strings.TrimRight(url, "/")
+	}
+	return nil
+}
+
+// remoteSign asks the validator's remote signer to sign b and checks the
+// answer. A signer is trusted like a local key: if the validator has no
+// registered public key yet, the signer's key is registered.
+func remoteSign(url string, b StakeBlock) (string, error) {
+	body, err := json.Marshal(SignRequest{Block: header(b)})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, url+"/sign", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+signerToken)
+	}
+
+	resp, err := signerClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("signer answered %s", resp.Status)
+	}
+	var sr SignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return "", err
+	}
+
+	if sr.Hash != b.Hash {
+		return "", fmt.Errorf("signer hashed the block to %s, not %s: its FORK_HEIGHTS or genesis hash algorithm differ", sr.Hash, b.Hash)
+	}
+	pub, err := parsePubKey(sr.PubKey)
+	if err != nil {
+		return "", err
+	}
+	if reg, ok := pubKeys[b.Validator]; ok && !reg.Equal(pub) {
+		return "", fmt.Errorf("signer key does not match the registered key of %s", b.Validator)
+	}
+	digest, _ := hex.DecodeString(b.Hash)
+	sig, err := hex.DecodeString(sr.Signature)
+	if err != nil || !ed25519.Verify(pub, digest, sig) {
+		return "", fmt.Errorf("signer returned an invalid signature")
+	}
+	pubKeys[b.Validator] = pub
+	return sr.Signature, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Round-based proposing. With PROPOSE_TIMEOUT set, each height is decided
+// in rounds, as in Tendermint. The proposer of round 0 is the selected
+// validator, and it has PROPOSE_TIMEOUT to sign the block with its local
+// key or remote signer. If it cannot, because its signer is down or slow
+// or this node cannot sign for its registered key, the round times out
+// and the next validator in stake order proposes round 1, and so on
+// through the active set. Validators without a registered key still
+// forge unsigned blocks. Blocks decided after round 0 record their round
+// in the "round" extension field.
+
+// roundField is the extension field holding a block's round.
+const roundField = "round"
+
+var (
+	// proposeTimeout bounds how long a proposer has to sign its block
+	// (PROPOSE_TIMEOUT); 0 turns rounds off and forges unsigned blocks for
+	// proposers that cannot sign.
+	proposeTimeout time.Duration
+
+	// lastRounds describes how the latest height was decided. Guarded by
+	// mu.
+	lastRounds RoundState
+)
+
+// RoundSkip is a round whose proposer timed out.
+type RoundSkip struct {
+	Round    int    `json:"round"`
+	Proposer string `json:"proposer"`
+	Reason   string `json:"reason"`
+}
+
+// RoundState describes how a height was decided.
+type RoundState struct {
+	Height   int         `json:"height"`
+	Round    int         `json:"round"`
+	Proposer string      `json:"proposer,omitempty"` // "" if every round timed out
+	Skipped  []RoundSkip `json:"skipped"`
+}
+
+// loadRounds reads PROPOSE_TIMEOUT, which also bounds calls to remote
+// signers.
+func loadRounds() error {
+	v := os.Getenv("PROPOSE_TIMEOUT")
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fmt.Errorf("invalid PROPOSE_TIMEOUT %q: want a duration like 3s", v)
+	}
+	proposeTimeout = d
+	if d > 0 {
+		signerClient.Timeout = d
+	}
+	return nil
+}
+
+// stakeOrder sorts validators by stake, largest first, ties broken by
+// name. Callers must hold mu.
+func stakeOrder(validators []string) []string {
+	order := append([]string(nil), validators...)
+	sort.Slice(order, func(i, j int) bool {
+		si, sj := stakes[order[i]], stakes[order[j]]
+		if si != sj {
+			return si > sj
+		}
+		return order[i] < order[j]
+	})
+	return order
+}
+
+// propose has the proposer of b sign it. Callers must hold mu.
+func propose(b *StakeBlock) error {
+	err := trySign(b)
+	if err == errNoSigner {
+		if _, registered := pubKeys[b.Validator]; !registered {
+			return nil
+		}
+		return errors.New("this node cannot sign for its registered key")
+	}
+	return err
+}
+
+// forgeRounds decides the block following last, starting with first as
+// proposer. build creates the block of a given proposer. Callers must
+// hold mu.
+func forgeRounds(last StakeBlock, first string, extra map[string]string, build func(string, map[string]string) StakeBlock) (StakeBlock, error) {
+	order := stakeOrder(activeValidators())
+	start := 0
+	for i, v := range order {
+		if v == first {
+			start = i
+		}
+	}
+
+	state := RoundState{Height: last.Height + 1, Skipped: []RoundSkip{}}
+	for round := 0; round < len(order); round++ {
+		proposer := order[(start+round)%len(order)]
+		fields := extra
+		if round > 0 {
+			fields = make(map[string]string, len(extra)+1)
+			for k, v := range extra {
+				fields[k] = v
+			}
+			fields[roundField] = strconv.Itoa(round)
+		}
+		b := build(proposer, fields)
+		if err := propose(&b); err != nil {
+			state.Skipped = append(state.Skipped, RoundSkip{Round: round, Proposer: proposer, Reason: err.Error()})
+			perfRecord(proposer).Missed++
+			log.Printf("⏱️  Round %d at height %d: proposer %s timed out: %v", round, b.Height, proposer, err)
+			continue
+		}
+		state.Round, state.Proposer = round, proposer
+		lastRounds = state
+		return b, nil
+	}
+	lastRounds = state
+	return StakeBlock{}, fmt.Errorf("every proposer timed out at height %d", state.Height)
+}
+
+// roundsHandler reports how the latest height was decided.
+func roundsHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Timeout string      `json:"timeout"` // "0s" when rounds are off
+		Last    *RoundState `json:"last,omitempty"`
+	}
+
+	mu.RLock()
+	resp := Response{Timeout: proposeTimeout.String()}
+	if lastRounds.Height > 0 {
+		last := lastRounds
+		resp.Last = &last
+	}
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
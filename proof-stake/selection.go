@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Validator selection modes (VALIDATOR_SELECTION). "stake", the default,
+// picks a validator at random, weighted by stake; "round-robin" rotates
+// through the active set in name order, one validator per height, so
+// small test networks produce blocks fairly and predictably.
+const (
+	selectionStake      = "stake"
+	selectionRoundRobin = "round-robin"
+)
+
+// validatorSelection is the selection mode in force.
+var validatorSelection = selectionStake
+
+// loadSelection reads VALIDATOR_SELECTION.
+func loadSelection() error {
+	switch v := os.Getenv("VALIDATOR_SELECTION"); v {
+	case "":
+	case selectionStake, selectionRoundRobin:
+		validatorSelection = v
+	default:
+		return fmt.Errorf("invalid VALIDATOR_SELECTION %q (stake or round-robin)", v)
+	}
+	return nil
+}
+
+// roundRobinValidator returns the validator whose turn height is among
+// the active validators, sorted by name as activeValidators returns them.
+func roundRobinValidator(active []string, height int) string {
+	return active[height%len(active)]
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// Validator keys. pubKeys holds the registered public key of every
+// validator that has one; keyring holds the private keys this node can
+// sign with, loaded from VALIDATOR_KEYS ("name:hexseed,name:hexseed") or
+// from encrypted VALIDATOR_KEYSTORES.
+var (
+	pubKeys = make(map[string]ed25519.PublicKey)
+	keyring = make(map[string]ed25519.PrivateKey)
+)
+
+// loadKeyring parses a VALIDATOR_KEYS value and registers the public key of
+// every validator it contains.
+func loadKeyring(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, seedHex, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return fmt.Errorf("malformed key entry %q", entry)
+		}
+		seed, err := hex.DecodeString(strings.TrimSpace(seedHex))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("key for %s must be a %d-byte hex seed", name, ed25519.SeedSize)
+		}
+		priv := ed25519.NewKeyFromSeed(seed)
+		name = strings.TrimSpace(name)
+		keyring[name] = priv
+		pubKeys[name] = priv.Public().(ed25519.PublicKey)
+	}
+	return nil
+}
+
+// parsePubKey decodes a hex-encoded ed25519 public key.
+func parsePubKey(s string) (ed25519.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("public key must be 32 bytes of hex")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// errNoSigner is returned by trySign for validators that this node
+// holds no key or remote signer for.
+var errNoSigner = errors.New("no key or remote signer for the validator")
+
+// trySign signs b.Hash with the validator's key if this node holds it,
+// or through the validator's remote signer. Callers must hold mu.
+func trySign(b *StakeBlock) error {
+	priv, ok := keyring[b.Validator]
+	if !ok {
+		url, remote := remoteSigners[b.Validator]
+		if !remote {
+			return errNoSigner
+		}
+		sig, err := remoteSign(url, *b)
+		if err != nil {
+			return err
+		}
+		b.Signature = sig
+		return nil
+	}
+	digest, err := hex.DecodeString(b.Hash)
+	if err != nil {
+		return err
+	}
+	b.Signature = hex.EncodeToString(ed25519.Sign(priv, digest))
+	return nil
+}
+
+// signBlock signs b like trySign. Blocks forged for validators without a
+// key or signer, or whose remote signer fails, stay unsigned. Callers
+// must hold mu.
+func signBlock(b *StakeBlock) {
+	if err := trySign(b); err != nil && err != errNoSigner {
+		log.Printf("⚠️  Remote signer for %s failed at height %d: %v", b.Validator, b.Height, err)
+	}
+}
+
+// verifyBlockSignature checks that b is signed by its validator's
+// registered key and that the signature covers the block's real hash.
+func verifyBlockSignature(b StakeBlock) error {
+	pub, ok := pubKeys[b.Validator]
+	if !ok {
+		return fmt.Errorf("validator %s has no registered public key", b.Validator)
+	}
+	if computeHash(b) != b.Hash {
+		return fmt.Errorf("block %d hash does not match its contents", b.Height)
+	}
+	digest, err := hex.DecodeString(b.Hash)
+	if err != nil {
+		return fmt.Errorf("block %d has a malformed hash", b.Height)
+	}
+	sig, err := hex.DecodeString(b.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("block %d has a malformed signature", b.Height)
+	}
+	if !ed25519.Verify(pub, digest, sig) {
+		return fmt.Errorf("block %d signature is not valid for %s", b.Height, b.Validator)
+	}
+	return nil
+}
+
+// messagePrefix domain-separates off-chain messages so that a message
+// signature can never double as a block signature.
+const messagePrefix = "AlirezaChain Signed Message:\n"
+
+// messageDigest returns the digest signed for an off-chain message.
+func messageDigest(message string) []byte {
+	h := sha256.Sum256([]byte(messagePrefix + message))
+	return h[:]
+}
+
+// SignedMessage is an off-chain message signed by a validator key.
+type SignedMessage struct {
+	Validator string `json:"validator,omitempty"`
+	PubKey    string `json:"pubKey,omitempty"`
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// verifyMessageHandler checks a message signature against a public key,
+// or against the registered key of a validator.
+func verifyMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req SignedMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	var pub ed25519.PublicKey
+	if req.PubKey != "" {
+		p, err := parsePubKey(req.PubKey)
+		if err != nil {
+			httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pub = p
+	}
+	if req.Validator != "" {
+		mu.RLock()
+		reg, ok := pubKeys[req.Validator]
+		mu.RUnlock()
+		if !ok {
+			httpapi.WriteError(w, "validator has no registered public key", http.StatusNotFound)
+			return
+		}
+		if pub != nil && !pub.Equal(reg) {
+			httpapi.WriteError(w, "pubKey is not the validator's registered key", http.StatusBadRequest)
+			return
+		}
+		pub = reg
+	}
+	if pub == nil {
+		httpapi.WriteError(w, "validator or pubKey is required", http.StatusBadRequest)
+		return
+	}
+	sig, err := hex.DecodeString(req.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		httpapi.WriteError(w, "malformed signature", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{
+		"valid":  ed25519.Verify(pub, messageDigest(req.Message), sig),
+		"pubKey": hex.EncodeToString(pub),
+	})
+}
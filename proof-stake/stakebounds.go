@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Stake cap modes (STAKE_CAP_MODE): what happens to the part of a stake
+// that would lift a validator above MAX_STAKE_SHARE. "reject", the
+// default, refuses the whole request; "queue" adds what fits and holds
+// the rest until the validator's share leaves room for it.
+const (
+	capModeReject = "reject"
+	capModeQueue  = "queue"
+)
+
+// Bounds on POST /stake (MIN_STAKE_AMOUNT, MAX_STAKE_AMOUNT,
+// MAX_STAKE_SHARE, STAKE_CAP_MODE). A maxStakeAmount of 0 means no
+// limit; a maxStakeShare of 0 means no cap on a validator's percentage
+// of the total stake.
+var (
+	minStakeAmount uint64 = 1
+	maxStakeAmount uint64
+	maxStakeShare  uint64
+	stakeCapMode   = capModeReject
+
+	// queuedStakes holds stake waiting for room under maxStakeShare, by
+	// validator. Guarded by mu; not persisted.
+	queuedStakes = make(map[string]uint64)
+)
+
+// loadStakeBounds reads the stake bounds from the environment.
+func loadStakeBounds() error {
+	if v := os.Getenv("MIN_STAKE_AMOUNT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid MIN_STAKE_AMOUNT %q (at least 1)", v)
+		}
+		minStakeAmount = n
+	}
+	if v := os.Getenv("MAX_STAKE_AMOUNT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_STAKE_AMOUNT %q", v)
+		}
+		maxStakeAmount = n
+	}
+	if maxStakeAmount > 0 && maxStakeAmount < minStakeAmount {
+		return fmt.Errorf("MAX_STAKE_AMOUNT (%d) is below MIN_STAKE_AMOUNT (%d)", maxStakeAmount, minStakeAmount)
+	}
+	if v := os.Getenv("MAX_STAKE_SHARE"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n > 99 {
+			return fmt.Errorf("invalid MAX_STAKE_SHARE %q (a percentage from 1 to 99, or 0 for no cap)", v)
+		}
+		maxStakeShare = n
+	}
+	switch v := os.Getenv("STAKE_CAP_MODE"); v {
+	case "":
+	case capModeReject, capModeQueue:
+		stakeCapMode = v
+	default:
+		return fmt.Errorf("invalid STAKE_CAP_MODE %q (reject or queue)", v)
+	}
+	return nil
+}
+
+// checkStakeAmount applies MIN_STAKE_AMOUNT and MAX_STAKE_AMOUNT to one
+// request.
+func checkStakeAmount(amount uint64) error {
+	if amount < minStakeAmount || (maxStakeAmount > 0 && amount > maxStakeAmount) {
+		if maxStakeAmount > 0 {
+			return fmt.Errorf("amount must be between %d and %d", minStakeAmount, maxStakeAmount)
+		}
+		return fmt.Errorf("amount must be at least %d", minStakeAmount)
+	}
+	return nil
+}
+
+// totalStake sums the stake of all validators. Callers must hold mu.
+func totalStake() uint64 {
+	var total uint64
+	for _, s := range stakes {
+		total += s
+	}
+	return total
+}
+
+// stakeRoom returns how much stake validator can add without holding
+// more than maxStakeShare percent of the total, and false if there is no
+// cap. The first stake on a network without any is not capped. Callers
+// must hold mu.
+func stakeRoom(validator string) (uint64, bool) {
+	total := totalStake()
+	if maxStakeShare == 0 || total == 0 {
+		return 0, false
+	}
+	// (s+x)/(T+x) <= cap/100  <=>  x <= (cap*T - 100*s) / (100-cap)
+	room := new(big.Int).Mul(new(big.Int).SetUint64(maxStakeShare), new(big.Int).SetUint64(total))
+	room.Sub(room, new(big.Int).Mul(big.NewInt(100), new(big.Int).SetUint64(stakes[validator])))
+	if room.Sign() <= 0 {
+		return 0, true
+	}
+	room.Div(room, new(big.Int).SetUint64(100-maxStakeShare))
+	if !room.IsUint64() {
+		return 0, false
+	}
+	return room.Uint64(), true
+}
+
+// maxReleasePasses bounds the passes of one releaseQueuedStakes. Queued
+// validators can leapfrog each other in small steps, each release making
+// room for the next; what is left stays queued for the next stake change.
+const maxReleasePasses = 1000
+
+// releaseQueuedStakes adds queued stake, in validator name order, as far
+// as maxStakeShare allows, repeating while a release makes room for
+// another, and records one event per validator. Queues of tombstoned
+// validators are dropped. Callers must hold mu.
+func releaseQueuedStakes() {
+	releases := make(map[string]uint64)
+	for pass, progress := 0, true; progress && pass < maxReleasePasses; pass++ {
+		progress = false
+		names := make([]string, 0, len(queuedStakes))
+		for v := range queuedStakes {
+			names = append(names, v)
+		}
+		sort.Strings(names)
+		for _, v := range names {
+			if tombstoned[v] {
+				delete(queuedStakes, v)
+				continue
+			}
+			amount := queuedStakes[v]
+			if room, capped := stakeRoom(v); capped && room < amount {
+				amount = room
+			}
+			if amount == 0 {
+				continue
+			}
+			stakes[v] += amount
+			if queuedStakes[v] -= amount; queuedStakes[v] == 0 {
+				delete(queuedStakes, v)
+			}
+			releases[v] += amount
+			progress = true
+		}
+	}
+	names := make([]string, 0, len(releases))
+	for v := range releases {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	for _, v := range names {
+		recordStakeEvent(stakeReleased, v, releases[v])
+	}
+	refreshStakeMetrics()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Kinds of stake change.
+const (
+	stakeGenesis  = "genesis" // initial stake from the genesis state
+	stakeAdded    = "stake"   // POST /stake
+	stakeSlashed  = "slash"   // burned for double signing
+	stakeReleased = "release" // queued stake admitted under MAX_STAKE_SHARE
+	stakeUnbonded = "unbond"  // POST /unstake
+)
+
+// StakeEvent records one change to a validator's stake and the totals
+// after it, so that the stakes map can be audited and rebuilt.
+type StakeEvent struct {
+	Seq         int         `json:"seq"`
+	Time        string      `json:"time"`
+	Height      int         `json:"height"` // chain tip when the change was made
+	Kind        string      `json:"kind"`
+	Validator   string      `json:"validator"`
+	Amount      uint64      `json:"amount"`              // stake added, withdrawn, or burned by a slash
+	Total       uint64      `json:"total"`               // the validator's stake afterwards
+	TotalStaked uint64      `json:"totalStaked"`         // stake of all validators afterwards
+	LockUntil   int         `json:"lockUntil,omitempty"` // the amount is locked below this height
+	LockBonus   uint64      `json:"lockBonus,omitempty"` // its selection-weight bonus while locked, in percent
+	Unbonding   []Unbonding `json:"unbonding,omitempty"` // the validator's withdrawals afterwards, on unbond and slash
+}
+
+var (
+	stakeEvents []StakeEvent // oldest first; guarded by mu
+
+	// stakeLog appends every event to STAKE_LOG_FILE as JSON Lines.
+	stakeLog     *os.File
+	stakeLogPath string
+)
+
+// openStakeLog loads the events already in path and opens it for
+// appending. An empty path keeps the log in memory only.
+func openStakeLog(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var ev StakeEvent
+		if err := dec.Decode(&ev); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			f.Close()
+			return fmt.Errorf("%s: event %d: %v", path, len(stakeEvents)+1, err)
+		}
+		stakeEvents = append(stakeEvents, ev)
+	}
+	stakeLog, stakeLogPath = f, path
+	return nil
+}
+
+// recordStakeEvent logs a change of amount to validator's stake, after
+// it was applied. Callers must hold mu.
+func recordStakeEvent(kind, validator string, amount uint64) {
+	logStakeEvent(newStakeEvent(kind, validator, amount))
+}
+
+// recordLockedStake logs stake added to validator under lock, after it
+// was applied. Callers must hold mu.
+func recordLockedStake(validator string, lock StakeLock) {
+	ev := newStakeEvent(stakeAdded, validator, lock.Amount)
+	ev.LockUntil, ev.LockBonus = lock.Until, lock.Bonus
+	logStakeEvent(ev)
+}
+
+// newStakeEvent describes a change of amount to validator's stake at the
+// current tip. Callers must hold mu.
+func newStakeEvent(kind, validator string, amount uint64) StakeEvent {
+	height := 0
+	if len(chain) > 0 {
+		height = chain[len(chain)-1].Height
+	}
+	ev := StakeEvent{
+		Seq:         len(stakeEvents) + 1,
+		Time:        clk.Now().Format(time.RFC3339),
+		Height:      height,
+		Kind:        kind,
+		Validator:   validator,
+		Amount:      amount,
+		Total:       stakes[validator],
+		TotalStaked: totalStake(),
+	}
+	if kind == stakeUnbonded || kind == stakeSlashed {
+		ev.Unbonding = append([]Unbonding(nil), unbondings[validator]...)
+	}
+	return ev
+}
+
+// logStakeEvent appends ev to the history and to STAKE_LOG_FILE. Callers
+// must hold mu.
+func logStakeEvent(ev StakeEvent) {
+	stakeEvents = append(stakeEvents, ev)
+	if stakeLog == nil {
+		return
+	}
+	line, _ := json.Marshal(ev)
+	if _, err := stakeLog.Write(append(line, '\n')); err != nil {
+		log.Printf("⚠️  Could not append to stake log %s: %v", stakeLogPath, err)
+	}
+}
+
+// recordGenesisStakes logs the initial stakes, in name order, when a new
+// stake log starts. Callers must hold mu.
+func recordGenesisStakes() {
+	names := make([]string, 0, len(stakes))
+	for v := range stakes {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	for _, v := range names {
+		recordStakeEvent(stakeGenesis, v, stakes[v])
+	}
+}
+
+// restoreStakes rebuilds stakes, tombstones, stake locks, withdrawals
+// and the burned total from the events loaded from STAKE_LOG_FILE: each
+// validator gets the total of its last event.
+// Returns the number of events replayed. Callers must hold mu.
+func restoreStakes() int {
+	for _, ev := range stakeEvents {
+		stakes[ev.Validator] = ev.Total
+		if ev.LockUntil > 0 {
+			stakeLocks[ev.Validator] = append(stakeLocks[ev.Validator], StakeLock{Amount: ev.Amount, Until: ev.LockUntil, Bonus: ev.LockBonus})
+		}
+		if ev.Kind == stakeUnbonded || ev.Kind == stakeSlashed {
+			unbondings[ev.Validator] = append([]Unbonding(nil), ev.Unbonding...)
+		}
+		if ev.Kind == stakeSlashed {
+			tombstoned[ev.Validator] = true
+			burned += ev.Amount
+		}
+	}
+	return len(stakeEvents)
+}
+
+// stakeHistoryHandler lists stake changes, oldest first, optionally only
+// those of ?validator=.
+func stakeHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	validator := r.URL.Query().Get("validator")
+
+	mu.RLock()
+	list := make([]StakeEvent, 0, len(stakeEvents))
+	for _, ev := range stakeEvents {
+		if validator == "" || ev.Validator == validator {
+			list = append(list, ev)
+		}
+	}
+	mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(list)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// Unbonding is stake a validator withdrew with POST /unstake. It no
+// longer counts for selection or governance, but it can still be slashed
+// until it is released.
+type Unbonding struct {
+	Amount uint64 `json:"amount"`
+	Until  int    `json:"until"` // first height at which it is released
+}
+
+var (
+	// unbondingPeriod (UNBONDING_PERIOD) is the number of blocks
+	// withdrawn stake stays slashable.
+	unbondingPeriod = 100
+
+	// unbondings holds the withdrawals of every validator, oldest first,
+	// including released ones. Guarded by mu.
+	unbondings = make(map[string][]Unbonding)
+)
+
+// loadUnbonding reads the unbonding period from the environment.
+func loadUnbonding() error {
+	if v := os.Getenv("UNBONDING_PERIOD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid UNBONDING_PERIOD %q (at least 1)", v)
+		}
+		unbondingPeriod = n
+	}
+	return nil
+}
+
+// unstakeMessage is the message a validator signs with its key for its
+// n-th withdrawal, so that a signed withdrawal cannot be replayed.
+func unstakeMessage(validator string, amount uint64, n int) string {
+	return fmt.Sprintf("unstake %s %s %d %d", chainID, validator, amount, n)
+}
+
+// pendingUnbondings returns the withdrawals of validator not yet released
+// at height. Callers must hold mu.
+func pendingUnbondings(validator string, height int) []Unbonding {
+	var pending []Unbonding
+	for _, u := range unbondings[validator] {
+		if height < u.Until {
+			pending = append(pending, u)
+		}
+	}
+	return pending
+}
+
+// slashUnbondings burns percent of validator's withdrawals not yet
+// released at height and returns the amount burned. Callers must hold mu.
+func slashUnbondings(validator string, height int, percent uint64) uint64 {
+	var penalty uint64
+	list := unbondings[validator]
+	for i := range list {
+		if height < list[i].Until {
+			p := list[i].Amount * percent / 100
+			list[i].Amount -= p
+			penalty += p
+		}
+	}
+	return penalty
+}
+
+// unstakeHandler withdraws stake that is not locked. The amount leaves
+// the validator's stake at once and is released unbondingPeriod blocks
+// later; stake is not drawn from liquid balances, so none is credited.
+func unstakeHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Validator string `json:"validator"`
+		Amount    uint64 `json:"amount"`
+		Signature string `json:"signature"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	payload.Validator = strings.TrimSpace(payload.Validator)
+	if payload.Validator == "" || payload.Amount == 0 {
+		httpapi.WriteError(w, "validator and positive amount are required", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if tombstoned[payload.Validator] {
+		httpapi.WriteError(w, "validator is tombstoned", http.StatusForbidden)
+		return
+	}
+	msg := unstakeMessage(payload.Validator, payload.Amount, len(unbondings[payload.Validator]))
+	if err := checkValidatorSignature(payload.Validator, msg, payload.Signature); err != nil {
+		writeBadSignature(w, err, msg)
+		return
+	}
+	tip := chain[len(chain)-1].Height
+	free := stakes[payload.Validator]
+	for _, l := range activeLocks(payload.Validator, tip+1) {
+		free -= l.Amount
+	}
+	if payload.Amount > free {
+		httpapi.WriteErrorDetails(w, http.StatusConflict, httpapi.APIError{
+			Code:    "stake_locked",
+			Message: "amount exceeds the validator's unlocked stake",
+			Details: map[string]uint64{"maxAmount": free},
+		})
+		return
+	}
+
+	u := Unbonding{Amount: payload.Amount, Until: tip + 1 + unbondingPeriod}
+	stakes[payload.Validator] -= payload.Amount
+	unbondings[payload.Validator] = append(unbondings[payload.Validator], u)
+	recordStakeEvent(stakeUnbonded, payload.Validator, payload.Amount)
+	refreshStakeMetrics()
+	httpapi.LogRequest(r, "🔓 Stake withdrawn: validator=%s amount=%d releasedAt=%d", payload.Validator, u.Amount, u.Until)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{
+		"validator": payload.Validator,
+		"total":     stakes[payload.Validator],
+		"unbonding": pendingUnbondings(payload.Validator, tip+1),
+	})
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/joho/godotenv"
+)
+
+// Admin API and dashboard. With ADMIN_TOKEN set, GET /admin serves a web
+// dashboard for operators and /admin/... the JSON API behind it: node
+// status, pausing mining, banning peers and reloading configuration.
+// Requests must carry the token as a bearer token or, so that a browser
+// can open the dashboard, as the password of HTTP basic auth. Without
+// ADMIN_TOKEN every admin route answers 403.
+
+// adminToken authenticates admin requests (ADMIN_TOKEN).
+var adminToken string
+
+// adminProbeClient asks peers for their status; it must answer within
+// one dashboard refresh.
+var adminProbeClient = &http.Client{Timeout: 2 * time.Second}
+
+// PeerStatus is one peer as seen by GET /admin/status.
+type PeerStatus struct {
+	URL       string `json:"url"`
+	Banned    bool   `json:"banned"`
+	Reachable bool   `json:"reachable"`
+	Blocks    int    `json:"blocks,omitempty"`
+	LatencyMs int64  `json:"latencyMs,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ValidatorPerformance summarises a hybrid validator's checkpoint
+// attestations.
+type ValidatorPerformance struct {
+	Validator     string  `json:"validator"`
+	Stake         uint64  `json:"stake"` // at its latest attestation
+	Attested      int     `json:"attested"`
+	Checkpoints   int     `json:"checkpoints"` // since its first attestation
+	Participation float64 `json:"participation"`
+	LastHeight    int     `json:"lastHeight"`
+}
+
+// AdminStatus answers GET /admin/status.
+type AdminStatus struct {
+	Height    int    `json:"height"`
+	Hash      string `json:"hash"`
+	Finalized int    `json:"finalized"`
+	Snapshot  int    `json:"snapshot,omitempty"` // height fast synced from
+	Sync      struct {
+		BestPeer int     `json:"bestPeer"` // most blocks reported by a reachable peer
+		Behind   int     `json:"behind"`
+		Progress float64 `json:"progress"` // own blocks / bestPeer, capped at 1
+	} `json:"sync"`
+	Mempool struct {
+		Count    int `json:"count"`
+		Bytes    int `json:"bytes"`
+		MaxTxs   int `json:"maxTxs"`
+		MaxBytes int `json:"maxBytes"`
+	} `json:"mempool"`
+	Mining     MiningControls         `json:"mining"`
+	Peers      []PeerStatus           `json:"peers"`
+	Validators []ValidatorPerformance `json:"validators"`
+}
+
+// loadAdmin reads ADMIN_TOKEN.
+func loadAdmin() error {
+	adminToken = os.Getenv("ADMIN_TOKEN")
+	if adminToken != "" && len(adminToken) < 16 {
+		return errors.New("ADMIN_TOKEN must be at least 16 characters")
+	}
+	return nil
+}
+
+// adminOnly lets requests carrying the admin token through to next.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			httpapi.WriteError(w, "the admin API is disabled; set ADMIN_TOKEN", http.StatusForbidden)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="AlirezaChain admin"`)
+			httpapi.WriteError(w, "invalid or missing admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// probePeer asks a peer for GET /info and times the answer.
+func probePeer(url string) PeerStatus {
+	st := PeerStatus{URL: url, Banned: peerBanned(url)}
+	start := time.Now()
+	resp, err := adminProbeClient.Get(strings.TrimRight(url, "/") + "/info")
+	if err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		st.Error = "peer answered " + resp.Status
+		return st
+	}
+	var info struct {
+		Blocks int `json:"blocks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		st.Error = err.Error()
+		return st
+	}
+	st.Reachable, st.Blocks, st.LatencyMs = true, info.Blocks, time.Since(start).Milliseconds()
+	return st
+}
+
+// validatorPerformance tallies the attestations of every validator in
+// the remembered checkpoints. Callers must hold mu.
+func validatorPerformance() []ValidatorPerformance {
+	byName := make(map[string]*ValidatorPerformance)
+	var order []string
+	for i, cp := range checkpoints {
+		for _, a := range cp.Attestations {
+			v := byName[a.Validator]
+			if v == nil {
+				v = &ValidatorPerformance{Validator: a.Validator, Checkpoints: len(checkpoints) - i}
+				byName[a.Validator] = v
+				order = append(order, a.Validator)
+			}
+			v.Attested++
+			v.Stake, v.LastHeight = a.Stake, cp.Height
+		}
+	}
+	perf := make([]ValidatorPerformance, 0, len(order))
+	for _, name := range order {
+		v := byName[name]
+		v.Participation = float64(v.Attested) / float64(v.Checkpoints)
+		perf = append(perf, *v)
+	}
+	sort.Slice(perf, func(i, j int) bool { return perf[i].Validator < perf[j].Validator })
+	return perf
+}
+
+// adminStatusHandler reports what the dashboard shows: the chain tip,
+// sync progress against the peers, the mempool, mining, peers and
+// hybrid validators.
+func adminStatusHandler(w http.ResponseWriter, r *http.Request) {
+	var st AdminStatus
+
+	mu.Lock()
+	expireMempool(clk.Now())
+	last := powChain[len(powChain)-1]
+	st.Height, st.Hash, st.Finalized = last.Height, last.Hash, finalized.Height
+	if synced != nil {
+		st.Snapshot = synced.height
+	}
+	st.Mempool.Count, st.Mempool.Bytes = len(mempool), mempoolBytes
+	st.Mempool.MaxTxs, st.Mempool.MaxBytes = mempoolPolicy.MaxTxs, mempoolPolicy.MaxBytes
+	st.Validators = validatorPerformance()
+	blocks := len(powChain)
+	mu.Unlock()
+	st.Mining = miningControls()
+
+	peersMu.RLock()
+	urls := append([]string(nil), peers...)
+	for p := range bannedPeers {
+		known := false
+		for _, u := range urls {
+			known = known || strings.TrimRight(u, "/") == p
+		}
+		if !known {
+			urls = append(urls, p)
+		}
+	}
+	peersMu.RUnlock()
+
+	st.Peers = make([]PeerStatus, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			st.Peers[i] = probePeer(u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	st.Sync.BestPeer = blocks
+	for _, p := range st.Peers {
+		if p.Reachable && !p.Banned && p.Blocks > st.Sync.BestPeer {
+			st.Sync.BestPeer = p.Blocks
+		}
+	}
+	st.Sync.Behind = st.Sync.BestPeer - blocks
+	st.Sync.Progress = float64(blocks) / float64(st.Sync.BestPeer)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(st)
+}
+
+// adminPeerHandler bans or unbans the peer in the request body.
+func adminPeerHandler(ban bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Peer string `json:"peer"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+		peer := strings.TrimRight(strings.TrimSpace(payload.Peer), "/")
+		if peer == "" {
+			httpapi.WriteError(w, "peer is required", http.StatusBadRequest)
+			return
+		}
+
+		peersMu.Lock()
+		if ban {
+			bannedPeers[peer] = true
+		} else {
+			delete(bannedPeers, peer)
+		}
+		banned := make([]string, 0, len(bannedPeers))
+		for p := range bannedPeers {
+			banned = append(banned, p)
+		}
+		peersMu.Unlock()
+		sort.Strings(banned)
+		if ban {
+			log.Printf("🚫 Banned peer %s", peer)
+		} else {
+			log.Printf("🤝 Unbanned peer %s", peer)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(map[string]interface{}{"banned": banned})
+	}
+}
+
+// adminReloadHandler re-reads .env over the environment and applies the
+// settings that can change while the node runs: PEERS, MAX_BLOCK_TXS,
+// MINING_THREADS and MINING_DUTY_CYCLE. Nothing is applied if any of
+// them is invalid.
+func adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := godotenv.Overload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		httpapi.WriteError(w, "read .env: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	newPeers := parsePeers(os.Getenv("PEERS"))
+	mu.Lock()
+	blockTxs := maxBlockTxs
+	mu.Unlock()
+	if v := os.Getenv("MAX_BLOCK_TXS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			httpapi.WriteError(w, fmt.Sprintf("invalid MAX_BLOCK_TXS %q", v), http.StatusBadRequest)
+			return
+		}
+		blockTxs = n
+	}
+	ctl, err := readMiningControls(miningControls())
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	peersMu.Lock()
+	peers = newPeers
+	peersMu.Unlock()
+	mu.Lock()
+	maxBlockTxs = blockTxs
+	mu.Unlock()
+	miningMu.Lock()
+	mining.Threads, mining.DutyCycle = ctl.Threads, ctl.DutyCycle
+	miningMu.Unlock()
+	log.Printf("🔄 Reloaded config: %d peer(s), MAX_BLOCK_TXS=%d, MINING_THREADS=%d, MINING_DUTY_CYCLE=%d",
+		len(newPeers), blockTxs, ctl.Threads, ctl.DutyCycle)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{
+		"peers":           newPeers,
+		"maxBlockTxs":     blockTxs,
+		"miningThreads":   ctl.Threads,
+		"miningDutyCycle": ctl.DutyCycle,
+	})
+}
+
+// adminDashboardHandler serves the dashboard page; it polls GET
+// /admin/status and posts the buttons to the admin API.
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	_, _ = w.Write([]byte(adminDashboard))
+}
+
+const adminDashboard = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>AlirezaChain PoW admin</title>
+<style>
+body { font: 14px system-ui, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 1.6em; }
+table { border-collapse: collapse; }
+td, th { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+.ok { color: #1a7f37; } .bad { color: #cf222e; }
+button { margin-right: 6px; }
+#error { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>⛏️ AlirezaChain PoW admin</h1>
+<div id="error"></div>
+<div>
+  <button onclick="post('mining/pause')">Pause mining</button>
+  <button onclick="post('mining/resume')">Resume mining</button>
+  <button onclick="post('config/reload')">Reload config</button>
+  <input id="peer" placeholder="http://peer:8081" size="28">
+  <button onclick="ban(document.getElementById('peer').value, true)">Ban peer</button>
+</div>
+<h2>Chain</h2><table id="chain"></table>
+<h2>Peers</h2><table id="peers"></table>
+<h2>Validators</h2><table id="validators"></table>
+<script>
+const base = location.pathname.replace(/\/$/, '') + '/';
+const esc = s => String(s).replace(/[&<>"']/g, c => '&#' + c.charCodeAt(0) + ';');
+const rows = (id, head, body) => {
+  document.getElementById(id).innerHTML =
+    '<tr>' + head.map(h => '<th>' + h + '</th>').join('') + '</tr>' +
+    body.map(r => '<tr>' + r.map(c => '<td>' + c + '</td>').join('') + '</tr>').join('');
+};
+async function call(path, body) {
+  const opts = body === undefined ? {} : {method: 'POST', headers: {'Content-Type': 'application/json'}, body: JSON.stringify(body)};
+  const resp = await fetch(base + path, opts);
+  const data = await resp.json();
+  if (!resp.ok) throw new Error(data.message || resp.statusText);
+  return data;
+}
+async function post(path, body) {
+  try { await call(path, body || {}); refresh(); } catch (e) { document.getElementById('error').textContent = e.message; }
+}
+function ban(peer, on) { if (peer) post(on ? 'peers/ban' : 'peers/unban', {peer: peer}); }
+async function refresh() {
+  try {
+    const s = await call('status');
+    document.getElementById('error').textContent = '';
+    rows('chain', ['', ''], [
+      ['Height', s.height], ['Tip', '<code>' + esc(s.hash) + '</code>'], ['Finalized', s.finalized],
+      ['Sync', (100 * s.sync.progress).toFixed(1) + '% (' + s.sync.behind + ' behind, best peer ' + s.sync.bestPeer + ' blocks)'],
+      ['Mempool', s.mempool.count + ' / ' + s.mempool.maxTxs + ' txs, ' + s.mempool.bytes + ' / ' + s.mempool.maxBytes + ' bytes'],
+      ['Mining', (s.mining.paused ? '<span class="bad">paused</span>' : '<span class="ok">running</span>') +
+        ', ' + s.mining.threads + ' thread(s) at ' + s.mining.dutyCycle + '%'],
+    ]);
+    rows('peers', ['Peer', 'Status', 'Blocks', 'Latency', ''], s.peers.map(p => [
+      esc(p.url),
+      p.banned ? '<span class="bad">banned</span>' : p.reachable ? '<span class="ok">up</span>' : '<span class="bad">' + esc(p.error) + '</span>',
+      p.reachable ? p.blocks : '', p.reachable ? p.latencyMs + ' ms' : '',
+      '<button data-peer="' + esc(p.url) + '" onclick="ban(this.dataset.peer, ' + !p.banned + ')">' + (p.banned ? 'Unban' : 'Ban') + '</button>',
+    ]));
+    rows('validators', ['Validator', 'Stake', 'Attested', 'Participation', 'Last checkpoint'], s.validators.map(v => [
+      esc(v.validator), v.stake, v.attested + ' / ' + v.checkpoints, (100 * v.participation).toFixed(1) + '%', v.lastHeight,
+    ]));
+  } catch (e) {
+    document.getElementById('error').textContent = e.message;
+  }
+}
+refresh();
+setInterval(refresh, 3000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Document anchoring. POST /anchor queues a SHA-256 digest; the next
+// mined block lists the queued digests in Anchors and commits to them
+// through the anchorRoot extension field, the Merkle root of the list.
+// GET /anchor/{digest} then proves the digest existed by that block's
+// time.
+
+const (
+	// anchorRootField is the extension field holding a block's anchor root.
+	anchorRootField = "anchorRoot"
+
+	// maxBlockAnchors caps the digests one block anchors.
+	maxBlockAnchors = 1000
+
+	// maxPendingAnchors caps the digests queued for the next blocks.
+	maxPendingAnchors = 10000
+)
+
+var (
+	// pendingAnchors are the digests waiting for a block, oldest first.
+	// Guarded by mu.
+	pendingAnchors []string
+
+	// anchored maps each anchored digest to the height of its block.
+	// Guarded by mu.
+	anchored = make(map[string]int)
+)
+
+// ProofStep is one level of a Merkle inclusion proof: the sibling hash
+// and the side it is on.
+type ProofStep struct {
+	Hash string `json:"hash"`
+	Side string `json:"side"` // "left" or "right"
+}
+
+// AnchorRecord locates an anchored digest in the chain.
+type AnchorRecord struct {
+	Digest        string      `json:"digest"`
+	Status        string      `json:"status"` // "pending" or "anchored"
+	BlockHeight   int         `json:"blockHeight,omitempty"`
+	BlockHash     string      `json:"blockHash,omitempty"`
+	Timestamp     int64       `json:"timestamp,omitempty"`
+	TimeText      string      `json:"time,omitempty"`
+	AnchorRoot    string      `json:"anchorRoot,omitempty"`
+	Index         int         `json:"index,omitempty"` // position in the block's anchor list
+	Proof         []ProofStep `json:"proof,omitempty"` // from the digest up to the anchor root
+	Confirmations int         `json:"confirmations,omitempty"`
+	Finalized     bool        `json:"finalized,omitempty"` // attested in hybrid mode
+}
+
+// parseDigest normalizes a hex SHA-256 digest.
+func parseDigest(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", errors.New("digest must be a hex SHA-256 digest (64 characters)")
+	}
+	return s, nil
+}
+
+// merkleLevels builds the Merkle tree over the digests, bottom level
+// first, duplicating the last node of odd-sized levels as merkleRoot
+// does for transactions.
+func merkleLevels(digests []string) [][][]byte {
+	level := make([][]byte, 0, len(digests))
+	for _, d := range digests {
+		b, _ := hex.DecodeString(d)
+		level = append(level, b)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+			levels[len(levels)-1] = level
+		}
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			h := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, h[:])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// anchorRoot is the Merkle root of the digests, "" for none.
+func anchorRoot(digests []string) string {
+	if len(digests) == 0 {
+		return ""
+	}
+	levels := merkleLevels(digests)
+	return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+// anchorProof returns the inclusion proof of the digest at index i.
+func anchorProof(digests []string, i int) []ProofStep {
+	var proof []ProofStep
+	levels := merkleLevels(digests)
+	for _, level := range levels[:len(levels)-1] {
+		if i%2 == 0 {
+			proof = append(proof, ProofStep{Hash: hex.EncodeToString(level[i+1]), Side: "right"})
+		} else {
+			proof = append(proof, ProofStep{Hash: hex.EncodeToString(level[i-1]), Side: "left"})
+		}
+		i /= 2
+	}
+	return proof
+}
+
+// checkAnchors verifies that a block's anchor list is well formed and
+// matches the anchor root in its header.
+func checkAnchors(b PowBlock) error {
+	if len(b.Anchors) > maxBlockAnchors {
+		return fmt.Errorf("at most %d anchors per block", maxBlockAnchors)
+	}
+	seen := make(map[string]bool, len(b.Anchors))
+	for i, d := range b.Anchors {
+		if n, err := parseDigest(d); err != nil || n != d {
+			return fmt.Errorf("anchor %d is not a lowercase hex SHA-256 digest", i)
+		}
+		if seen[d] {
+			return fmt.Errorf("anchor %d is a duplicate", i)
+		}
+		seen[d] = true
+	}
+	if b.Extra[anchorRootField] != anchorRoot(b.Anchors) {
+		return errors.New("anchor root mismatch")
+	}
+	return nil
+}
+
+// takeAnchors returns the pending digests for the next block and the
+// header fields committing to them, merged into extra. Callers must hold
+// mu.
+func takeAnchors(extra map[string]string) ([]string, map[string]string) {
+	n := len(pendingAnchors)
+	if n == 0 {
+		return nil, extra
+	}
+	if n > maxBlockAnchors {
+		n = maxBlockAnchors
+	}
+	anchors := append([]string(nil), pendingAnchors[:n]...)
+	merged := make(map[string]string, len(extra)+1)
+	for k, v := range extra {
+		merged[k] = v
+	}
+	merged[anchorRootField] = anchorRoot(anchors)
+	return anchors, merged
+}
+
+// recordAnchors indexes the digests anchored by b and drops them from
+// the queue. Callers must hold mu.
+func recordAnchors(b PowBlock) {
+	if len(b.Anchors) == 0 {
+		return
+	}
+	for _, d := range b.Anchors {
+		if _, ok := anchored[d]; !ok {
+			anchored[d] = b.Height
+		}
+	}
+	kept := pendingAnchors[:0]
+	for _, d := range pendingAnchors {
+		if _, ok := anchored[d]; !ok {
+			kept = append(kept, d)
+		}
+	}
+	pendingAnchors = kept
+}
+
+// anchorHandler queues a digest for the next block.
+func anchorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	digest, err := parseDigest(payload.Digest)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	rec, ok := lookupAnchor(digest)
+	if !ok {
+		if len(pendingAnchors) >= maxPendingAnchors {
+			mu.Unlock()
+			httpapi.WriteError(w, "anchor queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		pendingAnchors = append(pendingAnchors, digest)
+		rec = AnchorRecord{Digest: digest, Status: "pending"}
+		httpapi.LogRequest(r, "⚓ Queued anchor %s", digest)
+	}
+	mu.Unlock()
+
+	if !ok && devMode {
+		devMine()
+		mu.Lock()
+		rec, _ = lookupAnchor(digest)
+		mu.Unlock()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if rec.Status == "pending" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rec)
+}
+
+// lookupAnchor finds a digest in the chain or the queue. Callers must
+// hold mu.
+func lookupAnchor(digest string) (AnchorRecord, bool) {
+	if h, ok := anchored[digest]; ok {
+		b := powChain[h]
+		rec := AnchorRecord{
+			Digest:        digest,
+			Status:        "anchored",
+			BlockHeight:   b.Height,
+			BlockHash:     b.Hash,
+			Timestamp:     b.Timestamp,
+			TimeText:      time.Unix(b.Timestamp, 0).Format(time.RFC3339),
+			AnchorRoot:    b.Extra[anchorRootField],
+			Confirmations: powChain[len(powChain)-1].Height - b.Height + 1,
+			Finalized:     isFinal(b.Height),
+		}
+		for i, d := range b.Anchors {
+			if d == digest {
+				rec.Index = i
+				rec.Proof = anchorProof(b.Anchors, i)
+				break
+			}
+		}
+		return rec, true
+	}
+	for _, d := range pendingAnchors {
+		if d == digest {
+			return AnchorRecord{Digest: digest, Status: "pending"}, true
+		}
+	}
+	return AnchorRecord{}, false
+}
+
+// getAnchorHandler reports where a digest was anchored, with the proof
+// that its block commits to it.
+func getAnchorHandler(w http.ResponseWriter, r *http.Request) {
+	digest, err := parseDigest(mux.Vars(r)["digest"])
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	rec, ok := lookupAnchor(digest)
+	mu.Unlock()
+
+	if !ok {
+		httpapi.WriteError(w, "digest not anchored", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rec)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestProtoSnapshot pins GET /proto to proto/pow.proto: a change to a
+// response type must come with a new snapshot (alimiad compat -write).
+func TestProtoSnapshot(t *testing.T) {
+	want, err := os.ReadFile("../proto/pow.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	protoHandler(rec, httptest.NewRequest(http.MethodGet, "/proto", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /proto: %d", rec.Code)
+	}
+	if got := rec.Body.String(); got != string(want) {
+		t.Errorf("GET /proto does not match proto/pow.proto:\n%s", got)
+	}
+}
+
+// TestMiningControlsNeedAdmin checks that mining can only be paused and
+// resumed through the admin API.
+func TestMiningControlsNeedAdmin(t *testing.T) {
+	defer func() { adminToken = ""; setMiningPaused(false) }()
+	h := makeRouter()
+	send := func(path, token string) int {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	adminToken = "0123456789abcdef"
+	tests := []struct {
+		path, token string
+		want        int
+		paused      bool
+	}{
+		{"/v1/mining/pause", adminToken, http.StatusNotFound, false},
+		{"/v1/admin/mining/pause", "", http.StatusUnauthorized, false},
+		{"/v1/admin/mining/pause", adminToken, http.StatusOK, true},
+		{"/v1/mining/resume", adminToken, http.StatusNotFound, true},
+		{"/v1/admin/mining/resume", adminToken, http.StatusOK, false},
+	}
+	for _, tt := range tests {
+		if code := send(tt.path, tt.token); code != tt.want || miningControls().Paused != tt.paused {
+			t.Errorf("POST %s: %d, paused %v; want %d, paused %v", tt.path, code, miningControls().Paused, tt.want, tt.paused)
+		}
+	}
+}
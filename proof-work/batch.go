@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"alirezachain/httpapi"
+)
+
+// maxBatchItems caps the number of items in one batch request.
+const maxBatchItems = 1000
+
+// BatchItemError reports why one item of a rejected batch failed.
+type BatchItemError struct {
+	Index  int    `json:"index"`
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeBatchErrors rejects a whole batch, listing the failed items in
+// the error details.
+func writeBatchErrors(w http.ResponseWriter, items []BatchItemError) {
+	httpapi.WriteErrorDetails(w, http.StatusBadRequest, httpapi.APIError{
+		Code:    "batch_rejected",
+		Message: fmt.Sprintf("batch rejected: %d of its items failed", len(items)),
+		Details: map[string][]BatchItemError{"items": items},
+	})
+}
+
+// snapshotMempool returns a function restoring the mempool to its
+// current contents, including entries evicted meanwhile. Callers must
+// hold mu.
+func snapshotMempool() func() {
+	pool := make(map[string]*mempoolEntry, len(mempool))
+	for id, e := range mempool {
+		pool[id] = e
+	}
+	spends := make(map[string]string, len(pendingSpends))
+	for k, id := range pendingSpends {
+		spends[k] = id
+	}
+	bytes := mempoolBytes
+	return func() {
+		mempool, pendingSpends, mempoolBytes = pool, spends, bytes
+	}
+}
+
+// submitTxBatchHandler accepts an array of signed transfers all or
+// nothing: if any fails, none is added and every failure is reported by
+// its index. Transactions of one sender must be in nonce order.
+func submitTxBatchHandler(w http.ResponseWriter, r *http.Request) {
+	var txs []Transaction
+	if err := json.NewDecoder(r.Body).Decode(&txs); err != nil {
+		httpapi.WriteError(w, "invalid payload: want an array of transactions", http.StatusBadRequest)
+		return
+	}
+	if len(txs) == 0 || len(txs) > maxBatchItems {
+		httpapi.WriteError(w, fmt.Sprintf("a batch holds 1 to %d transactions", maxBatchItems), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	restore := snapshotMempool()
+	var failed []BatchItemError
+	for i := range txs {
+		if status, err := acceptTx(&txs[i]); err != nil {
+			failed = append(failed, BatchItemError{Index: i, Status: status, Error: err.Error()})
+		}
+	}
+	if len(failed) > 0 {
+		restore()
+		compactMempoolJournal()
+	}
+	mu.Unlock()
+
+	if len(failed) > 0 {
+		httpapi.LogRequest(r, "↩️  Rejected batch of %d transactions (%d failed)", len(txs), len(failed))
+		writeBatchErrors(w, failed)
+		return
+	}
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.ID
+	}
+	go announceTxs(ids, httpapi.RequestID(r))
+	if devMode {
+		devMine()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(txs)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Compact "bits" encode a target in 32 bits, as Bitcoin's nBits do: the
+// high byte is the target's length in bytes and the low three bytes are
+// its most significant bytes. Bit 23 is a sign bit and must be clear.
+// After the compactbits fork blocks carry Bits instead of a leading-zero
+// Difficulty, so the target can move in steps far smaller than a factor
+// of two.
+
+// compactToTarget decodes compact bits. It reports false for negative or
+// zero targets and for targets wider than the hash.
+func compactToTarget(bits uint32) (*big.Int, bool) {
+	size := int(bits >> 24)
+	mantissa := int64(bits & 0x007fffff)
+	if bits&0x00800000 != 0 || mantissa == 0 {
+		return nil, false
+	}
+	target := big.NewInt(mantissa)
+	if size <= 3 {
+		target.Rsh(target, uint(8*(3-size)))
+	} else {
+		target.Lsh(target, uint(8*(size-3)))
+	}
+	if target.Sign() == 0 || target.BitLen() > hasher.Size()*8 {
+		return nil, false
+	}
+	return target, true
+}
+
+// targetToCompact encodes a positive target, dropping all but its three
+// most significant bytes.
+func targetToCompact(target *big.Int) uint32 {
+	size := (target.BitLen() + 7) / 8
+	var mantissa uint64
+	if size <= 3 {
+		mantissa = target.Uint64() << uint(8*(3-size))
+	} else {
+		mantissa = new(big.Int).Rsh(target, uint(8*(size-3))).Uint64()
+	}
+	// Keep the sign bit clear by moving to a one byte longer encoding.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		size++
+	}
+	return uint32(size)<<24 | uint32(mantissa)
+}
+
+// powLimit is the easiest target, that of difficulty 1.
+func powLimit() *big.Int {
+	return difficultyTarget(1)
+}
+
+// blockTarget returns the target b's hash must stay below, decoded from
+// the difficulty field in force at b's height, or nil if that field is
+// missing or out of range.
+func blockTarget(b PowBlock) *big.Int {
+	if forkActive(forkCompactBits, b.Height) {
+		if b.Difficulty != 0 {
+			return nil
+		}
+		target, ok := compactToTarget(b.Bits)
+		if !ok || target.Cmp(powLimit()) > 0 {
+			return nil
+		}
+		return target
+	}
+	if b.Bits != 0 || b.Difficulty <= 0 || b.Difficulty >= hasher.Size()*8 {
+		return nil
+	}
+	return difficultyTarget(b.Difficulty)
+}
+
+// difficultyField is the difficulty as the block hash commits to it:
+// the leading-zero count before the compactbits fork, the compact bits
+// in hex after it.
+func difficultyField(b PowBlock) string {
+	if forkActive(forkCompactBits, b.Height) {
+		return fmt.Sprintf("%08x", b.Bits)
+	}
+	return strconv.Itoa(b.Difficulty)
+}
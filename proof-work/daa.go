@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Difficulty adjustment algorithms for compact-bits retargeting
+// (DIFFICULTY_ALGORITHM). The choice is a consensus rule: every node of
+// a network must run the same algorithm with the same parameters.
+const (
+	// daaProportional scales the parent's target by the parent's block
+	// time alone (see nextBits).
+	daaProportional = "proportional"
+	// daaLWMA uses a linearly weighted moving average of recent block
+	// times, so the newest blocks count most.
+	daaLWMA = "lwma"
+	// daaASERT moves the target exponentially with how far the chain is
+	// ahead of or behind its ideal schedule since an anchor block.
+	daaASERT = "asert"
+)
+
+var (
+	difficultyAlgorithm = daaProportional
+
+	// lwmaWindow is the number of blocks LWMA averages (LWMA_WINDOW).
+	lwmaWindow = 45
+
+	// asertHalfLife is how far behind schedule the chain must fall for
+	// ASERT to double the target (ASERT_HALF_LIFE).
+	asertHalfLife = 10 * time.Minute
+)
+
+// loadDifficultyAlgorithm reads DIFFICULTY_ALGORITHM, LWMA_WINDOW and
+// ASERT_HALF_LIFE. LWMA and ASERT need both the retarget and the
+// compactbits forks.
+func loadDifficultyAlgorithm() error {
+	if v := os.Getenv("DIFFICULTY_ALGORITHM"); v != "" {
+		switch v {
+		case daaProportional, daaLWMA, daaASERT:
+			difficultyAlgorithm = v
+		default:
+			return fmt.Errorf("unknown DIFFICULTY_ALGORITHM %q (proportional, lwma, asert)", v)
+		}
+	}
+	if v := os.Getenv("LWMA_WINDOW"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			return fmt.Errorf("invalid LWMA_WINDOW %q (at least 2)", v)
+		}
+		lwmaWindow = n
+	}
+	if v := os.Getenv("ASERT_HALF_LIFE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return fmt.Errorf("invalid ASERT_HALF_LIFE %q (at least 1s)", v)
+		}
+		asertHalfLife = d
+	}
+	if difficultyAlgorithm != daaProportional {
+		_, retarget := forkHeights[forkRetarget]
+		_, compact := forkHeights[forkCompactBits]
+		if !retarget || !compact {
+			return fmt.Errorf("DIFFICULTY_ALGORITHM %s needs the retarget and compactbits forks in FORK_HEIGHTS", difficultyAlgorithm)
+		}
+	}
+	return nil
+}
+
+// lwmaTarget is the LWMA target for the block following chain: the
+// average target of the last lwmaWindow blocks, scaled by their block
+// times weighted 1 (oldest) to n (newest) over the weighted target time.
+// Block times are clamped to [0, 6×targetBlockTime] so that a single
+// bad timestamp cannot swing the result.
+func lwmaTarget(chain []PowBlock) *big.Int {
+	n := lwmaWindow
+	if n > len(chain)-1 {
+		n = len(chain) - 1
+	}
+	window := chain[len(chain)-n:]
+
+	sumTarget := new(big.Int)
+	var weighted time.Duration
+	for i, b := range window {
+		t := blockTarget(b)
+		if t == nil {
+			t = difficultyTarget(defaultDifficulty)
+		}
+		sumTarget.Add(sumTarget, t)
+
+		solve := time.Duration(b.Timestamp-chain[len(chain)-n+i-1].Timestamp) * time.Second
+		if solve < 0 {
+			solve = 0
+		}
+		if solve > 6*targetBlockTime {
+			solve = 6 * targetBlockTime
+		}
+		weighted += time.Duration(i+1) * solve
+	}
+
+	// Bound the fall to a tenth of the expected weighted time, so a burst
+	// of same-second timestamps cannot drive the target to zero.
+	k := time.Duration(n*(n+1)/2) * targetBlockTime
+	if weighted < k/10 {
+		weighted = k / 10
+	}
+
+	target := sumTarget.Quo(sumTarget, big.NewInt(int64(n)))
+	target.Mul(target, big.NewInt(int64(weighted)))
+	return target.Quo(target, big.NewInt(int64(k)))
+}
+
+// asertTarget is the ASERT target for the block following chain, or nil
+// while chain has not reached the anchor. The anchor is the first block
+// retargeted with compact bits; the result is the anchor's target times
+// 2^((elapsed - targetBlockTime×blocks) / asertHalfLife), computed in
+// 16-bit fixed point with the cubic approximation of 2^x used by
+// Bitcoin Cash's aserti3-2d so that every node rounds identically.
+func asertTarget(chain []PowBlock) *big.Int {
+	start := forkHeights[forkRetarget]
+	if h := forkHeights[forkCompactBits]; h > start {
+		start = h
+	}
+	last := chain[len(chain)-1]
+	if last.Height < start {
+		return nil
+	}
+	anchor := chain[start]
+	target := blockTarget(anchor)
+	if target == nil {
+		return nil
+	}
+
+	elapsed := time.Duration(last.Timestamp-anchor.Timestamp) * time.Second
+	behind := elapsed - targetBlockTime*time.Duration(last.Height-anchor.Height)
+	// Div rounds towards minus infinity, so the fraction below is never
+	// negative.
+	exponent := new(big.Int).Lsh(big.NewInt(int64(behind)), 16)
+	exponent.Div(exponent, big.NewInt(int64(asertHalfLife)))
+
+	shifts := new(big.Int).Rsh(exponent, 16).Int64()
+	frac := new(big.Int).And(exponent, big.NewInt(0xffff))
+
+	// factor = 2^16 × 2^(frac/2^16), approximated by a cubic.
+	poly := new(big.Int).Mul(big.NewInt(195766423245049), frac)
+	f2 := new(big.Int).Mul(frac, frac)
+	poly.Add(poly, new(big.Int).Mul(big.NewInt(971821376), f2))
+	poly.Add(poly, new(big.Int).Mul(big.NewInt(5127), new(big.Int).Mul(f2, frac)))
+	poly.Add(poly, new(big.Int).Lsh(big.NewInt(1), 47))
+	factor := poly.Rsh(poly, 48)
+	factor.Add(factor, big.NewInt(1<<16))
+
+	target = new(big.Int).Mul(target, factor)
+	shifts -= 16
+	// Beyond these shifts the result is clamped anyway.
+	switch {
+	case shifts > 512:
+		return powLimit()
+	case shifts < -512:
+		return big.NewInt(1)
+	case shifts >= 0:
+		target.Lsh(target, uint(shifts))
+	default:
+		target.Rsh(target, uint(-shifts))
+	}
+	return target
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+var (
+	// devMode mines every accepted transaction at once, at difficulty 1
+	// (-dev, DEV_MODE), so application developers need not wait for
+	// blocks.
+	devMode bool
+
+	// devMu serializes dev-mode mining so that concurrent submissions do
+	// not mine competing blocks on the same tip.
+	devMu sync.Mutex
+)
+
+// loadDevMode turns dev mode on. Difficulty drops to 1, the easiest
+// there is; retargeting, which would raise it again, and a minimum block
+// interval, which would delay blocks, are refused.
+func loadDevMode(dev bool) error {
+	if !dev {
+		return nil
+	}
+	if _, ok := forkHeights[forkRetarget]; ok {
+		return errors.New("dev mode cannot be combined with the retarget fork in FORK_HEIGHTS")
+	}
+	if minBlockInterval > 0 {
+		return errors.New("dev mode cannot be combined with MIN_BLOCK_INTERVAL")
+	}
+	devMode = true
+	defaultDifficulty = 1
+	return nil
+}
+
+// devMine mines blocks until neither the mempool nor the anchor queue
+// holds anything more to include. It does nothing while mining is paused.
+func devMine() {
+	devMu.Lock()
+	defer devMu.Unlock()
+	for !miningControls().Paused {
+		mu.Lock()
+		last := powChain[len(powChain)-1]
+		base := ledgerState(powChain)
+		txs := selectTransactions(base)
+		anchors, extra := takeAnchors(nil)
+		uncles, extra := takeUncles(extra)
+		difficulty, bits := nextWork(powChain, defaultDifficulty)
+		mu.Unlock()
+		if len(txs) == 0 && len(anchors) == 0 {
+			return
+		}
+
+		data := fmt.Sprintf("dev block (%d transactions, %d anchors)", len(txs), len(anchors))
+		b, err := mineBlock(last, data, difficulty, bits, minerAddress, txs, base, extra, anchors, uncles)
+		if err != nil {
+			log.Printf("⚠️  Dev block at height %d not mined: %v", last.Height+1, err)
+			return
+		}
+		mu.Lock()
+		err = appendBlock(b)
+		mu.Unlock()
+		if err != nil {
+			log.Printf("⚠️  Dev block at height %d rejected: %v", b.Height, err)
+			return
+		}
+	}
+}
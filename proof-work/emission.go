@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"alirezachain/genesis"
+)
+
+// Emission curves.
+const (
+	curveFixed   = "fixed"   // every block pays initialReward
+	curveLinear  = "linear"  // reward drops by decayPerBlock each block, down to minReward
+	curveHalving = "halving" // reward halves every halvingInterval blocks
+)
+
+// EmissionSchedule describes how much new supply each block mints.
+type EmissionSchedule struct {
+	Curve           string `json:"curve"`
+	InitialReward   uint64 `json:"initialReward"`
+	DecayPerBlock   uint64 `json:"decayPerBlock,omitempty"`
+	MinReward       uint64 `json:"minReward,omitempty"`
+	HalvingInterval int    `json:"halvingInterval,omitempty"`
+
+	// FeeBurnPercent of the fees of each block is burned instead of paid
+	// to its miner (FEE_BURN_PERCENT). It is a consensus rule: every node
+	// of a network needs the same value.
+	FeeBurnPercent uint64 `json:"feeBurnPercent,omitempty"`
+
+	// UncleRewardPercent of the reward for its height is credited to the
+	// miner of each uncle a block includes (UNCLE_REWARD_PERCENT); see
+	// uncles.go. Zero leaves uncles out. Also a consensus rule.
+	UncleRewardPercent uint64 `json:"uncleRewardPercent,omitempty"`
+}
+
+var (
+	emission = EmissionSchedule{
+		Curve:           curveHalving,
+		InitialReward:   50,
+		HalvingInterval: 210,
+	}
+
+	// rewardsIssued is the total block reward of the chain, added to as
+	// blocks are appended. Guarded by mu.
+	rewardsIssued uint64
+
+	// feesBurned is the total of fees burned, counted like rewardsIssued.
+	feesBurned uint64
+)
+
+// rewardAt returns the block reward for a block at the given height.
+// The genesis block mints nothing.
+func (e EmissionSchedule) rewardAt(height int) uint64 {
+	if height <= 0 {
+		return 0
+	}
+	switch e.Curve {
+	case curveLinear:
+		// Past InitialReward/DecayPerBlock steps the decay exceeds the
+		// initial reward; stop there, before the product can overflow.
+		steps := uint64(height - 1)
+		if e.DecayPerBlock > 0 && steps > e.InitialReward/e.DecayPerBlock {
+			return e.MinReward
+		}
+		decay := e.DecayPerBlock * steps
+		if decay >= e.InitialReward || e.InitialReward-decay < e.MinReward {
+			return e.MinReward
+		}
+		return e.InitialReward - decay
+	case curveHalving:
+		halvings := (height - 1) / e.HalvingInterval
+		if halvings >= 64 {
+			return 0
+		}
+		return e.InitialReward >> uint(halvings)
+	default:
+		return e.InitialReward
+	}
+}
+
+// burnedFees returns the part of the fees of txs that is burned, rounded
+// down.
+func burnedFees(txs []Transaction) uint64 {
+	fees := totalFees(txs)
+	if fees > math.MaxUint64/100 {
+		return fees / 100 * emission.FeeBurnPercent
+	}
+	return fees * emission.FeeBurnPercent / 100
+}
+
+// minerFees returns the part of the fees of txs that the coinbase
+// collects.
+func minerFees(txs []Transaction) uint64 {
+	return totalFees(txs) - burnedFees(txs)
+}
+
+// recordIssuance counts the block reward, uncle rewards and burned fees
+// of b, which was just appended. Callers must hold mu.
+func recordIssuance(b PowBlock) {
+	rewardsIssued += emission.rewardAt(b.Height)
+	for _, c := range uncleCredits(b) {
+		rewardsIssued += c.Amount
+	}
+	feesBurned += burnedFees(b.Transactions)
+}
+
+// loadEmission reads the emission schedule from the environment
+// (EMISSION_CURVE, BLOCK_REWARD, REWARD_DECAY, MIN_REWARD,
+// HALVING_INTERVAL), FEE_BURN_PERCENT and UNCLE_REWARD_PERCENT.
+func loadEmission() error {
+	if v := os.Getenv("EMISSION_CURVE"); v != "" {
+		switch v {
+		case curveFixed, curveLinear, curveHalving:
+			emission.Curve = v
+		default:
+			return fmt.Errorf("unknown EMISSION_CURVE %q", v)
+		}
+	}
+	for key, dst := range map[string]*uint64{
+		"BLOCK_REWARD": &emission.InitialReward,
+		"REWARD_DECAY": &emission.DecayPerBlock,
+		"MIN_REWARD":   &emission.MinReward,
+	} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", key, v)
+			}
+			*dst = n
+		}
+	}
+	if v := os.Getenv("HALVING_INTERVAL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid HALVING_INTERVAL %q", v)
+		}
+		emission.HalvingInterval = n
+	}
+	if v := os.Getenv("FEE_BURN_PERCENT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n > 100 {
+			return fmt.Errorf("invalid FEE_BURN_PERCENT %q (0-100)", v)
+		}
+		emission.FeeBurnPercent = n
+	}
+	if v := os.Getenv("UNCLE_REWARD_PERCENT"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || n > 100 {
+			return fmt.Errorf("invalid UNCLE_REWARD_PERCENT %q (0-100)", v)
+		}
+		emission.UncleRewardPercent = n
+	}
+	return nil
+}
+
+// applyGenesisEmission puts the halving schedule of a genesis file, if it
+// has one, in place of the environment's. Rewards are a consensus rule,
+// so a network started from one file agrees on them.
+func applyGenesisEmission(g *genesis.File) error {
+	if g.HalvingInterval == 0 {
+		if g.BlockReward > 0 {
+			return fmt.Errorf("blockReward needs a halvingInterval")
+		}
+		return nil
+	}
+	if g.HalvingInterval < 0 {
+		return fmt.Errorf("invalid halvingInterval %d", g.HalvingInterval)
+	}
+	if v := os.Getenv("EMISSION_CURVE"); v != "" && v != curveHalving {
+		return fmt.Errorf("halvingInterval conflicts with EMISSION_CURVE=%s", v)
+	}
+	emission.Curve = curveHalving
+	emission.HalvingInterval = g.HalvingInterval
+	if g.BlockReward > 0 {
+		emission.InitialReward = g.BlockReward
+	}
+	return nil
+}
+
+// RewardStatus answers GET /mining/reward: the coinbase reward of the next
+// block and, under the halving curve, when it next halves.
+type RewardStatus struct {
+	Height          int    `json:"height"` // of the next block
+	Reward          uint64 `json:"reward"`
+	Curve           string `json:"curve"`
+	Epoch           int    `json:"epoch"` // halvings so far
+	HalvingInterval int    `json:"halvingInterval,omitempty"`
+	HalvingHeight   int    `json:"halvingHeight,omitempty"` // first block of the next epoch
+	BlocksLeft      int    `json:"blocksLeft,omitempty"`    // until HalvingHeight
+	NextReward      uint64 `json:"nextEpochReward"`
+}
+
+// rewardStatus describes the reward at height. Only the halving curve has
+// epochs; under the others the next epoch is the next block.
+func rewardStatus(height int) RewardStatus {
+	s := RewardStatus{
+		Height: height,
+		Reward: emission.rewardAt(height),
+		Curve:  emission.Curve,
+	}
+	if emission.Curve != curveHalving {
+		s.NextReward = emission.rewardAt(height + 1)
+		return s
+	}
+	s.Epoch = (height - 1) / emission.HalvingInterval
+	s.HalvingInterval = emission.HalvingInterval
+	s.HalvingHeight = (s.Epoch+1)*emission.HalvingInterval + 1
+	s.BlocksLeft = s.HalvingHeight - height
+	s.NextReward = emission.rewardAt(s.HalvingHeight)
+	return s
+}
+
+// rewardHandler reports the reward of the next block and the halving
+// ahead of it.
+func rewardHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	next := powChain[len(powChain)-1].Height + 1
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rewardStatus(next))
+}
+
+// supplyHandler reports issuance so far and the reward of the next block.
+// What accounts hold is derived by replaying the chain; what was issued
+// comes from the genesis allocations and the block rewards counted as
+// blocks were appended. Whatever was issued but is no longer held is
+// reported as burned.
+func supplyHandler(w http.ResponseWriter, r *http.Request) {
+	type Supply struct {
+		Circulating uint64           `json:"circulating"` // spendable by accounts
+		Vesting     uint64           `json:"vesting"`     // part of Circulating not vested yet
+		Immature    uint64           `json:"immature"`
+		Locked      uint64           `json:"locked"` // escrowed by hash-timelock contracts and schedules
+		Minted      uint64           `json:"minted"` // held in total: circulating, immature and locked
+		Genesis     uint64           `json:"genesis"`
+		Rewards     uint64           `json:"rewards"`
+		Burned      uint64           `json:"burned"`
+		FeesBurned  uint64           `json:"feesBurned"` // part of Burned
+		Height      int              `json:"height"`
+		NextReward  uint64           `json:"nextReward"`
+		Schedule    EmissionSchedule `json:"schedule"`
+	}
+
+	mu.Lock()
+	last := powChain[len(powChain)-1]
+
+	var resp Supply
+	for addr, bal := range ledgerState(powChain) {
+		if strings.HasPrefix(addr, htlcPrefix) || strings.HasPrefix(addr, schedulePrefix) {
+			resp.Locked += bal.Spendable
+		} else {
+			resp.Circulating += bal.Spendable
+		}
+		resp.Immature += bal.Immature
+		resp.Vesting += bal.Vesting
+	}
+	for _, tx := range powChain[0].Transactions {
+		resp.Genesis += tx.Amount
+	}
+	resp.Rewards = rewardsIssued
+	resp.FeesBurned = feesBurned
+	mu.Unlock()
+
+	resp.Minted = resp.Circulating + resp.Immature + resp.Locked
+	if issued := resp.Genesis + resp.Rewards; issued > resp.Minted {
+		resp.Burned = issued - resp.Minted
+	}
+	resp.Height = last.Height
+	resp.NextReward = emission.rewardAt(last.Height + 1)
+	resp.Schedule = emission
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
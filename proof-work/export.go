@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+)
+
+// Export formats for GET /export (?format=).
+const (
+	exportJSONL = "jsonl"
+	exportCSV   = "csv"
+)
+
+// TxRow is a transaction together with the block that includes it, as
+// exported with ?rows=tx.
+type TxRow struct {
+	Height    int    `json:"height"`
+	BlockHash string `json:"blockHash"`
+	Index     int    `json:"index"`
+	Transaction
+}
+
+// exportHandler streams the chain as JSON Lines (one block per line, the
+// format -import reads back) or CSV, with ?rows=tx one row per
+// transaction instead of per block.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportJSONL
+	}
+	rows := r.URL.Query().Get("rows")
+	if rows == "" {
+		rows = "blocks"
+	}
+	if (format != exportJSONL && format != exportCSV) || (rows != "blocks" && rows != "tx") {
+		httpapi.WriteError(w, "format must be jsonl or csv and rows blocks or tx", http.StatusBadRequest)
+		return
+	}
+
+	// Blocks are never modified once appended, so the snapshot can be
+	// streamed without holding mu.
+	mu.Lock()
+	blocks, pruned := powChain, synced != nil
+	mu.Unlock()
+	if pruned {
+		httpapi.WriteError(w, errPruned.Error(), http.StatusConflict)
+		return
+	}
+
+	name := "chain"
+	if rows == "tx" {
+		name = "transactions"
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", name, format))
+	if format == exportJSONL {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		for _, b := range blocks {
+			if rows == "blocks" {
+				_ = enc.Encode(b)
+				continue
+			}
+			for i, tx := range b.Transactions {
+				_ = enc.Encode(TxRow{Height: b.Height, BlockHash: b.Hash, Index: i, Transaction: tx})
+			}
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	if rows == "blocks" {
+		_ = cw.Write([]string{"height", "timestamp", "hash", "prevHash", "difficulty", "bits", "nonce", "txCount", "txRoot", "stateRoot", "version", "data"})
+	} else {
+		_ = cw.Write([]string{"height", "blockHash", "index", "id", "from", "to", "amount", "fee", "nonce"})
+	}
+	for _, b := range blocks {
+		if rows == "blocks" {
+			_ = cw.Write([]string{
+				strconv.Itoa(b.Height),
+				strconv.FormatInt(b.Timestamp, 10),
+				b.Hash,
+				b.PrevHash,
+				strconv.Itoa(b.Difficulty),
+				strconv.FormatUint(uint64(b.Bits), 10),
+				strconv.FormatInt(b.Nonce, 10),
+				strconv.Itoa(len(b.Transactions)),
+				b.TxRoot,
+				b.StateRoot,
+				strconv.Itoa(b.Version),
+				b.Data,
+			})
+			continue
+		}
+		for i, tx := range b.Transactions {
+			_ = cw.Write([]string{
+				strconv.Itoa(b.Height),
+				b.Hash,
+				strconv.Itoa(i),
+				tx.ID,
+				tx.From,
+				tx.To,
+				strconv.FormatUint(tx.Amount, 10),
+				strconv.FormatUint(tx.Fee, 10),
+				strconv.FormatUint(tx.Nonce, 10),
+			})
+		}
+	}
+	cw.Flush()
+}
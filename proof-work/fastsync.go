@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Fast sync. Instead of replaying the whole history, a new node started
+// with FAST_SYNC_PEER downloads a state snapshot from that peer (GET
+// /snapshot): every header from genesis, the transactions of the last
+// COINBASE_MATURITY blocks, whose coinbases are still maturing, every
+// account's balance and nonce at the snapshot height and the terms of
+// schedules created before those blocks that still have runs left (see
+// schedule.go). The node checks
+// the header chain's links and proof of work, that the snapshot block is
+// the trusted checkpoint, and that the accounts hash to the state root in
+// its header, then fetches and fully validates the blocks after it.
+//
+// The checkpoint is FAST_SYNC_CHECKPOINT (height:hash), taken from a
+// finalized checkpoint on a node the operator trusts. In hybrid mode it
+// may be left out: the node then takes the peer's latest finalized
+// checkpoint and has its own HYBRID_VALIDATORS attest it again.
+//
+// Transactions older than the snapshot window are not kept, so the node
+// has no receipts for them and cannot export or replay its chain.
+
+var (
+	// fastSyncPeer is the node to fast sync from (FAST_SYNC_PEER); ""
+	// syncs nothing.
+	fastSyncPeer string
+
+	// fastSyncHeight and fastSyncHash are the trusted checkpoint
+	// (FAST_SYNC_CHECKPOINT).
+	fastSyncHeight int
+	fastSyncHash   string
+
+	// synced is what the node knows in place of the history it skipped;
+	// nil unless it fast synced. Guarded by mu.
+	synced *syncedState
+
+	syncClient = &http.Client{Timeout: 60 * time.Second}
+)
+
+// Snapshot answers GET /snapshot.
+type Snapshot struct {
+	Height   int        `json:"height"`
+	Hash     string     `json:"hash"`
+	Accounts []Balance  `json:"accounts"` // as seen by the block after the snapshot
+	Blocks   []PowBlock `json:"blocks"`   // from genesis; only the maturity window keeps transactions
+
+	// Schedules created before the window that still have runs left.
+	Schedules []Schedule `json:"schedules,omitempty"`
+}
+
+// syncedState is the state a fast-synced node starts from.
+type syncedState struct {
+	height int         // of the snapshot block
+	base   LedgerState // at height, with only window coinbases immature
+	window []Transaction
+
+	// schedules holds the pending schedules created before the window.
+	schedules []Schedule
+}
+
+// errPruned is returned for operations that need the history a
+// fast-synced node skipped.
+var errPruned = errors.New("this node fast synced and does not hold the transactions before its snapshot")
+
+// loadFastSync reads FAST_SYNC_PEER and FAST_SYNC_CHECKPOINT.
+func loadFastSync() error {
+	fastSyncPeer = strings.TrimRight(os.Getenv("FAST_SYNC_PEER"), "/")
+	v := os.Getenv("FAST_SYNC_CHECKPOINT")
+	if fastSyncPeer == "" {
+		if v != "" {
+			return errors.New("FAST_SYNC_CHECKPOINT needs FAST_SYNC_PEER")
+		}
+		return nil
+	}
+	if v == "" {
+		if len(hybridValidators) == 0 {
+			return errors.New("FAST_SYNC_PEER needs FAST_SYNC_CHECKPOINT, or HYBRID_VALIDATORS to confirm the peer's finalized checkpoint")
+		}
+		return nil
+	}
+	h, hash, ok := strings.Cut(v, ":")
+	n, err := strconv.Atoi(h)
+	if !ok || err != nil || n <= 0 || hash == "" {
+		return fmt.Errorf("invalid FAST_SYNC_CHECKPOINT %q (height:hash)", v)
+	}
+	fastSyncHeight, fastSyncHash = n, strings.ToLower(hash)
+	return nil
+}
+
+// windowStart is the lowest height whose transactions a snapshot at
+// height keeps.
+func windowStart(height int) int {
+	start := height - coinbaseMaturity + 1
+	if start < 1 {
+		start = 1
+	}
+	return start
+}
+
+// snapshotHandler serves the snapshot at ?height=, by default the latest
+// finalized checkpoint in hybrid mode and the tip otherwise.
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	height := powChain[len(powChain)-1].Height
+	if finalized.Height > 0 {
+		height = finalized.Height
+	}
+	if v := r.URL.Query().Get("height"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n >= len(powChain) {
+			httpapi.WriteError(w, "height must be a height on the chain", http.StatusBadRequest)
+			return
+		}
+		height = n
+	}
+	if synced != nil && height < synced.height {
+		httpapi.WriteError(w, errPruned.Error(), http.StatusConflict)
+		return
+	}
+
+	snap := Snapshot{Height: height, Hash: powChain[height].Hash, Blocks: make([]PowBlock, 0, height+1)}
+	start := windowStart(height)
+	for _, b := range powChain[:height+1] {
+		if b.Height > 0 && b.Height < start {
+			b.Transactions = nil
+		}
+		snap.Blocks = append(snap.Blocks, b)
+	}
+	for _, s := range schedules(powChain[:start]) {
+		if s.pending(height) {
+			snap.Schedules = append(snap.Schedules, s)
+		}
+	}
+	for _, bal := range ledgerState(powChain[:height+1]) {
+		snap.Accounts = append(snap.Accounts, *bal)
+	}
+	sort.Slice(snap.Accounts, func(i, j int) bool { return snap.Accounts[i].Address < snap.Accounts[j].Address })
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(snap)
+}
+
+// getJSON fetches path from the fast sync peer into v.
+func getJSON(path string, v interface{}) error {
+	resp, err := syncClient.Get(fastSyncPeer + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e httpapi.APIError
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s answered %s: %s", path, resp.Status, e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// trustedCheckpoint returns FAST_SYNC_CHECKPOINT or, without it, the
+// peer's latest finalized checkpoint once this node's validators have
+// attested it.
+func trustedCheckpoint() (int, string, error) {
+	if fastSyncHash != "" {
+		return fastSyncHeight, fastSyncHash, nil
+	}
+	var peer struct {
+		FinalizedHeight int    `json:"finalizedHeight"`
+		FinalizedHash   string `json:"finalizedHash"`
+	}
+	if err := getJSON("/checkpoints", &peer); err != nil {
+		return 0, "", err
+	}
+	if peer.FinalizedHeight == 0 {
+		return 0, "", errors.New("peer has no finalized checkpoint")
+	}
+	cp := collectAttestations(PowBlock{Height: peer.FinalizedHeight, Hash: peer.FinalizedHash})
+	if !cp.Finalized {
+		return 0, "", fmt.Errorf("validators attested only %d of %d stake for the peer's checkpoint at height %d", cp.Stake, cp.TotalStake, cp.Height)
+	}
+	return cp.Height, cp.Hash, nil
+}
+
+// verifySnapshot checks snap against the local genesis and the trusted
+// checkpoint and returns the state to start from.
+func verifySnapshot(snap Snapshot, height int, hash string) (*syncedState, error) {
+	if snap.Height != height || len(snap.Blocks) != height+1 {
+		return nil, fmt.Errorf("peer sent a snapshot at height %d, not %d", snap.Height, height)
+	}
+	if snap.Blocks[0].Hash != powChain[0].Hash {
+		return nil, errors.New("peer has a different genesis block")
+	}
+	snap.Blocks[0] = powChain[0]
+	start := windowStart(height)
+	for i := 1; i <= height; i++ {
+		b := snap.Blocks[i]
+		if !isHeaderValid(b, snap.Blocks[i-1]) {
+			return nil, fmt.Errorf("header %d is invalid", i)
+		}
+		if err := checkDifficulty(b, snap.Blocks[:i]); err != nil {
+			return nil, fmt.Errorf("header %d: %v", i, err)
+		}
+		if err := checkUncles(b, snap.Blocks[:i]); err != nil {
+			return nil, fmt.Errorf("header %d: %v", i, err)
+		}
+		if i >= start {
+			if err := validateTransactions(b); err != nil {
+				return nil, fmt.Errorf("block %d: %v", i, err)
+			}
+		} else if len(b.Transactions) > 0 {
+			return nil, fmt.Errorf("block %d carries transactions outside the window; is COINBASE_MATURITY the same on both nodes?", i)
+		}
+	}
+	if snap.Blocks[height].Hash != hash {
+		return nil, fmt.Errorf("block %d is %s, not the checkpoint %s", height, snap.Blocks[height].Hash, hash)
+	}
+
+	st := &syncedState{height: height, base: make(LedgerState)}
+	for _, bal := range snap.Accounts {
+		b := bal
+		b.Spendable, b.Immature = bal.Spendable+bal.Immature, 0
+		st.base[b.Address] = &b
+	}
+	if st.base.root() != snap.Blocks[height].StateRoot {
+		return nil, errors.New("accounts do not match the checkpoint's state root")
+	}
+	for _, b := range snap.Blocks[start:] {
+		for _, cb := range append([]Transaction{b.Transactions[0]}, uncleCredits(b)...) {
+			acct := st.base.account(cb.To)
+			if acct.Spendable < cb.Amount {
+				return nil, fmt.Errorf("accounts do not hold the rewards of block %d", b.Height)
+			}
+			acct.Spendable -= cb.Amount
+			acct.Immature += cb.Amount
+			st.window = append(st.window, cb)
+		}
+	}
+	if err := checkEscrows(st.base, snap.Schedules, schedules(snap.Blocks[start:]), height); err != nil {
+		return nil, err
+	}
+	st.schedules = snap.Schedules
+	return st, nil
+}
+
+// fastSync replaces the genesis-only chain with the peer's snapshot and
+// the blocks after it.
+func fastSync() error {
+	height, hash, err := trustedCheckpoint()
+	if err != nil {
+		return err
+	}
+	var snap Snapshot
+	if err := getJSON("/snapshot?height="+strconv.Itoa(height), &snap); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	st, err := verifySnapshot(snap, height, hash)
+	if err != nil {
+		return err
+	}
+	powChain, synced = snap.Blocks, st
+	for _, b := range powChain[windowStart(height):] {
+		recordReceipts(b)
+	}
+	for _, b := range powChain {
+		recordAnchors(b)
+		recordUncles(b)
+		recordIssuance(b)
+	}
+	log.Printf("⏩ Fast synced to height %d (%s) with %d accounts", height, hash, len(snap.Accounts))
+
+	var blocks []PowBlock
+	q := url.Values{"since_hash": {hash}, "since_height": {strconv.Itoa(height)}}
+	if err := getJSON("/chain?"+q.Encode(), &blocks); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if err := appendBlock(b); err != nil {
+			return fmt.Errorf("block %d after the snapshot: %v", b.Height, err)
+		}
+	}
+	log.Printf("⏩ Appended %d blocks after the snapshot", len(blocks))
+	return nil
+}
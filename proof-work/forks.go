@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Consensus upgrades. Each activates at the height configured in
+// FORK_HEIGHTS and applies to that block and every later one; blocks
+// below the activation height keep being validated by the old rules.
+const (
+	// forkHashV2 hashes a versioned record whose fields are separated by
+	// "|", so that adjacent fields can no longer run into each other.
+	forkHashV2 = "hashv2"
+	// forkRetarget derives the difficulty of each block from the time the
+	// previous block took instead of letting the miner choose it.
+	forkRetarget = "retarget"
+	// forkCompactBits replaces the leading-zero difficulty with a compact
+	// target (see bits.go) and retargets in proportion to block time.
+	forkCompactBits = "compactbits"
+)
+
+// knownForks lists the upgrades in activation order with a description.
+var knownForks = []struct{ Name, Description string }{
+	{forkHashV2, "delimited, versioned block hash record"},
+	{forkRetarget, "difficulty retargeting towards TARGET_BLOCK_TIME"},
+	{forkCompactBits, "compact difficulty bits with proportional retargeting"},
+}
+
+var (
+	// forkHeights maps a fork to its activation height; forks that are
+	// missing never activate.
+	forkHeights = make(map[string]int)
+
+	// targetBlockTime is the block interval the retarget rule aims for
+	// (TARGET_BLOCK_TIME).
+	targetBlockTime = 10 * time.Second
+)
+
+// maxRetargetDifficulty bounds the difficulty the retarget rule can reach.
+const maxRetargetDifficulty = 24
+
+// maxRetargetFactor bounds how far one compact retarget can move the
+// target in either direction.
+const maxRetargetFactor = 4
+
+// forkActive reports whether the named fork applies to a block at height.
+func forkActive(name string, height int) bool {
+	h, ok := forkHeights[name]
+	return ok && height >= h
+}
+
+// activeForks returns the forks that apply to a block at height.
+func activeForks(height int) []string {
+	var active []string
+	for _, f := range knownForks {
+		if forkActive(f.Name, height) {
+			active = append(active, f.Name)
+		}
+	}
+	return active
+}
+
+// loadForks reads FORK_HEIGHTS ("hashv2=100,retarget=500") and
+// TARGET_BLOCK_TIME. Forks cannot activate at genesis.
+func loadForks() error {
+	for _, entry := range strings.Split(os.Getenv("FORK_HEIGHTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, height, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(height)
+		if !ok || err != nil || n < 1 {
+			return fmt.Errorf("invalid FORK_HEIGHTS entry %q (want name=height, height >= 1)", entry)
+		}
+		known := false
+		for _, f := range knownForks {
+			known = known || f.Name == name
+		}
+		if !known {
+			return fmt.Errorf("unknown fork %q", name)
+		}
+		forkHeights[name] = n
+	}
+	if v := os.Getenv("TARGET_BLOCK_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid TARGET_BLOCK_TIME %q", v)
+		}
+		targetBlockTime = d
+	}
+	return nil
+}
+
+// nextDifficulty returns the difficulty the retarget rule requires of the
+// block following chain: one more than the previous block's if that block
+// came faster than targetBlockTime, one less if it took more than twice
+// as long. The first retargeted block starts from defaultDifficulty.
+func nextDifficulty(chain []PowBlock) int {
+	last := chain[len(chain)-1]
+	if !forkActive(forkRetarget, last.Height) || len(chain) < 2 {
+		return defaultDifficulty
+	}
+
+	d := last.Difficulty
+	gap := time.Duration(last.Timestamp-chain[len(chain)-2].Timestamp) * time.Second
+	switch {
+	case gap < targetBlockTime && d < maxRetargetDifficulty:
+		d++
+	case gap > 2*targetBlockTime && d > 1:
+		d--
+	}
+	return d
+}
+
+// nextBits returns the compact bits the retarget rule requires of the
+// block following chain once compactbits is active. By default this is
+// the previous target scaled by the time the previous block took over
+// targetBlockTime, with the scale clamped to maxRetargetFactor;
+// DIFFICULTY_ALGORITHM selects LWMA or ASERT instead (see daa.go). The
+// target stays within the range of difficulties 1 to
+// maxRetargetDifficulty. A parent without bits contributes the target of
+// its difficulty.
+func nextBits(chain []PowBlock) uint32 {
+	last := chain[len(chain)-1]
+	target := blockTarget(last)
+	if !forkActive(forkRetarget, last.Height) || len(chain) < 2 || target == nil {
+		return targetToCompact(difficultyTarget(defaultDifficulty))
+	}
+
+	var next *big.Int
+	switch difficultyAlgorithm {
+	case daaLWMA:
+		next = lwmaTarget(chain)
+	case daaASERT:
+		next = asertTarget(chain)
+	}
+	if next != nil {
+		target = next
+	} else {
+		gap := time.Duration(last.Timestamp-chain[len(chain)-2].Timestamp) * time.Second
+		if gap < targetBlockTime/maxRetargetFactor {
+			gap = targetBlockTime / maxRetargetFactor
+		}
+		if gap > targetBlockTime*maxRetargetFactor {
+			gap = targetBlockTime * maxRetargetFactor
+		}
+		target = new(big.Int).Mul(target, big.NewInt(int64(gap)))
+		target.Quo(target, big.NewInt(int64(targetBlockTime)))
+	}
+
+	if floor := difficultyTarget(maxRetargetDifficulty); target.Cmp(floor) < 0 {
+		target = floor
+	}
+	if target.Cmp(powLimit()) > 0 {
+		target = powLimit()
+	}
+	return targetToCompact(target)
+}
+
+// nextWork returns the difficulty fields of the block following chain
+// when its miner asks for difficulty: that difficulty, unless the
+// retarget rule decides, and encoded as bits once compactbits is active.
+// Exactly one of the results is non-zero. Callers must hold mu.
+func nextWork(chain []PowBlock, difficulty int) (int, uint32) {
+	height := chain[len(chain)-1].Height + 1
+	switch {
+	case forkActive(forkCompactBits, height) && forkActive(forkRetarget, height):
+		return 0, nextBits(chain)
+	case forkActive(forkCompactBits, height):
+		return 0, targetToCompact(difficultyTarget(difficulty))
+	case forkActive(forkRetarget, height):
+		return nextDifficulty(chain), 0
+	}
+	return difficulty, 0
+}
+
+// checkDifficulty enforces the difficulty rule in force at b's height on
+// a block extending chain. Before the retarget fork any difficulty the
+// hash meets is valid.
+func checkDifficulty(b PowBlock, chain []PowBlock) error {
+	if blockTarget(b) == nil {
+		if forkActive(forkCompactBits, b.Height) {
+			return fmt.Errorf("block at height %d needs valid bits and no difficulty", b.Height)
+		}
+		return fmt.Errorf("block at height %d needs a difficulty and no bits", b.Height)
+	}
+	if !forkActive(forkRetarget, b.Height) {
+		return nil
+	}
+	if forkActive(forkCompactBits, b.Height) {
+		if want := nextBits(chain); b.Bits != want {
+			return fmt.Errorf("bits must be %08x at height %d", want, b.Height)
+		}
+		return nil
+	}
+	if want := nextDifficulty(chain); b.Difficulty != want {
+		return fmt.Errorf("difficulty must be %d at height %d", want, b.Height)
+	}
+	return nil
+}
+
+// forksHandler lists the consensus upgrades and their activation heights.
+func forksHandler(w http.ResponseWriter, r *http.Request) {
+	type Fork struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Height      *int   `json:"height"` // null if not scheduled
+		Active      bool   `json:"active"`
+	}
+
+	mu.Lock()
+	next := powChain[len(powChain)-1].Height + 1
+	mu.Unlock()
+
+	resp := struct {
+		NextHeight int    `json:"nextHeight"`
+		Forks      []Fork `json:"forks"`
+	}{NextHeight: next}
+	for _, f := range knownForks {
+		fork := Fork{Name: f.Name, Description: f.Description, Active: forkActive(f.Name, next)}
+		if h, ok := forkHeights[f.Name]; ok {
+			fork.Height = &h
+		}
+		resp.Forks = append(resp.Forks, fork)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"alirezachain/genesis"
+)
+
+// genesisBlock builds the genesis block. Without a file the block is
+// empty and stamped with the current time; with one, it carries a mint
+// transaction per allocation (spendable immediately unless it vests) and
+// the file's timestamp, so every node given the same file builds the
+// same block. The file's halving schedule is carried as extension
+// fields.
+func genesisBlock(g *genesis.File) (PowBlock, error) {
+	block := PowBlock{
+		Height:     0,
+		Timestamp:  clk.Now().Unix(),
+		Data:       "Genesis ⛓️ " + chainName,
+		Difficulty: 1,
+		StateRoot:  make(LedgerState).root(),
+		Version:    blockVersion,
+	}
+	if g == nil {
+		block.Hash = calculateHash(block)
+		return block, nil
+	}
+
+	block.Timestamp = g.Timestamp
+	block.Extra = g.PowExtra()
+	state := make(LedgerState)
+	seen := make(map[string]bool, len(g.Allocations))
+	for _, a := range g.Allocations {
+		if a.Address == "" || a.Amount == 0 {
+			return PowBlock{}, fmt.Errorf("allocation needs an address and a positive amount")
+		}
+		if seen[a.Address] {
+			return PowBlock{}, fmt.Errorf("duplicate allocation for %s", a.Address)
+		}
+		seen[a.Address] = true
+		tx := Transaction{To: a.Address, Amount: a.Amount}
+		if a.Vesting != nil {
+			tx.Vesting = &Vesting{CliffHeight: a.Vesting.CliffHeight, EndHeight: a.Vesting.EndHeight}
+			if err := tx.Vesting.check(); err != nil {
+				return PowBlock{}, fmt.Errorf("allocation for %s: %v", a.Address, err)
+			}
+			vestingAccounts[a.Address] = vestingAllocation{Amount: a.Amount, Vesting: tx.Vesting}
+		}
+		tx.ID = txHash(tx)
+		block.Transactions = append(block.Transactions, tx)
+		state.account(a.Address).Spendable += a.Amount
+	}
+	block.TxRoot = merkleRoot(block.Transactions)
+	block.StateRoot = state.root()
+	block.Hash = calculateHash(block)
+
+	if want := g.Hashes["pow"]; want != "" && want != block.Hash {
+		return PowBlock{}, fmt.Errorf("genesis hash %s does not match the file's %s", block.Hash, want)
+	}
+	return block, nil
+}
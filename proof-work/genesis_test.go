@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"alirezachain/chainhash"
+	"alirezachain/genesis"
+)
+
+// TestGenesisHash checks that the block the node builds from a genesis
+// file has the hash `alimiad genesis` records for it.
+func TestGenesisHash(t *testing.T) {
+	files := []genesis.File{
+		{Timestamp: 1700000000},
+		{Timestamp: 1700000000, Allocations: []genesis.Allocation{
+			{Address: "alice", Amount: 100},
+			{Address: "bob", Amount: 50, Vesting: &genesis.Vesting{CliffHeight: 10, EndHeight: 100}},
+			{Address: "carol", Amount: 7},
+		}},
+		{Timestamp: 1700000001, HashAlgorithm: chainhash.BLAKE2b, Allocations: []genesis.Allocation{
+			{Address: "alice", Amount: 1},
+		}},
+		{Timestamp: 1700000000, HalvingInterval: 210},
+		{Timestamp: 1700000000, HalvingInterval: 210, BlockReward: 40, Allocations: []genesis.Allocation{
+			{Address: "alice", Amount: 100},
+		}},
+	}
+	defer func() {
+		hasher = chainhash.Default
+		vestingAccounts = make(map[string]vestingAllocation)
+	}()
+	for i, g := range files {
+		var err error
+		if hasher, err = chainhash.New(g.HashAlgorithm); err != nil {
+			t.Fatal(err)
+		}
+		vestingAccounts = make(map[string]vestingAllocation)
+		block, err := genesisBlock(&g)
+		if err != nil {
+			t.Fatalf("file %d: %v", i, err)
+		}
+		want, err := g.PowHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block.Hash != want {
+			t.Errorf("file %d: node builds %s, genesis package says %s", i, block.Hash, want)
+		}
+	}
+}
+
+// TestGenesisHashCoversHalving checks that files differing only in their
+// halving schedule start different chains.
+func TestGenesisHashCoversHalving(t *testing.T) {
+	seen := make(map[string]genesis.File)
+	for _, g := range []genesis.File{
+		{Timestamp: 1700000000},
+		{Timestamp: 1700000000, HalvingInterval: 210},
+		{Timestamp: 1700000000, HalvingInterval: 211},
+		{Timestamp: 1700000000, HalvingInterval: 210, BlockReward: 40},
+		{Timestamp: 1700000000, HalvingInterval: 210, BlockReward: 41},
+	} {
+		hash, err := g.PowHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other, ok := seen[hash]; ok {
+			t.Errorf("%+v and %+v share genesis hash %s", g, other, hash)
+		}
+		seen[hash] = g
+	}
+}
+
+// TestImportAdoptsChainID checks that a node without a genesis file
+// takes the chain ID of the genesis it imports along with the block.
+func TestImportAdoptsChainID(t *testing.T) {
+	defer func() { powChain, chainID = nil, "" }()
+	local, err := genesisBlock(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	powChain, chainID = []PowBlock{local}, local.Hash
+
+	imported, err := genesisBlock(&genesis.File{Timestamp: 1700000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(imported)
+	path := filepath.Join(t.TempDir(), "chain.jsonl")
+	if err := os.WriteFile(path, append(raw, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := importChain(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if chainID != imported.Hash {
+		t.Errorf("chain ID %s after importing genesis %s", chainID, imported.Hash)
+	}
+}
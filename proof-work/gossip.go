@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// TxAnnouncement advertises pending transaction IDs. Receivers fetch the
+// bodies they do not know via GET /tx/{id} from the announcing peer, at
+// the address they have configured for it in PEERS. Source is only
+// informational: it is not trusted as an address to fetch from.
+type TxAnnouncement struct {
+	Source string   `json:"source"`
+	IDs    []string `json:"ids"`
+}
+
+var (
+	// peers receive transaction announcements (PEERS, comma-separated
+	// URLs); nodeURL is how peers reach this node (NODE_URL).
+	peers   []string
+	nodeURL string
+
+	// bannedPeers are neither announced to nor fetched from (POST
+	// /admin/peers/ban). peersMu guards peers and bannedPeers.
+	bannedPeers = make(map[string]bool)
+	peersMu     sync.RWMutex
+
+	// seenTxs remembers recently announced IDs so that rejected or
+	// already-mined transactions are not fetched again. Guarded by mu.
+	seenTxs = make(map[string]time.Time)
+
+	gossipClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+// seenTTL bounds how long an announced ID is remembered.
+const seenTTL = 10 * time.Minute
+
+// markSeen records id and reports whether it was new. Callers must hold
+// mu.
+func markSeen(id string, now time.Time) bool {
+	for k, t := range seenTxs {
+		if now.Sub(t) > seenTTL {
+			delete(seenTxs, k)
+		}
+	}
+	if _, ok := seenTxs[id]; ok {
+		return false
+	}
+	seenTxs[id] = now
+	return true
+}
+
+// parsePeers splits a comma-separated PEERS value.
+func parsePeers(v string) []string {
+	var list []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// gossipPeers returns the peers that are not banned.
+func gossipPeers() []string {
+	peersMu.RLock()
+	defer peersMu.RUnlock()
+	var active []string
+	for _, p := range peers {
+		if !bannedPeers[strings.TrimRight(p, "/")] {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// peerBanned reports whether url was banned.
+func peerBanned(url string) bool {
+	peersMu.RLock()
+	defer peersMu.RUnlock()
+	return bannedPeers[strings.TrimRight(url, "/")]
+}
+
+// announcingPeers returns the configured, unbanned peers whose host is
+// the address r came from; several nodes may share a host.
+func announcingPeers(r *http.Request) []string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	var matches []string
+	for _, p := range gossipPeers() {
+		u, err := url.Parse(p)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		addrs, err := net.LookupIP(u.Hostname())
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if a.Equal(ip) {
+				matches = append(matches, p)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// announceTxs tells every peer about the given transaction IDs, passing
+// on the ID of the request that brought them in.
+func announceTxs(ids []string, reqID string) {
+	targets := gossipPeers()
+	if len(targets) == 0 || nodeURL == "" || len(ids) == 0 {
+		return
+	}
+
+	mu.Lock()
+	now := clk.Now()
+	for _, id := range ids {
+		markSeen(id, now)
+	}
+	mu.Unlock()
+
+	body, err := json.Marshal(TxAnnouncement{Source: nodeURL, IDs: ids})
+	if err != nil {
+		return
+	}
+	for _, p := range targets {
+		url := strings.TrimRight(p, "/") + "/tx/announce"
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if reqID != "" {
+			req.Header.Set(httpapi.RequestIDHeader, reqID)
+		}
+		peerAuth.Sign(req)
+		resp, err := gossipClient.Do(req)
+		if err != nil {
+			log.Printf("⚠️  Failed to announce txs to %s: %v", p, err)
+			continue
+		}
+		_ = resp.Body.Close()
+	}
+}
+
+// fetchTx downloads a pending transaction body from a peer.
+func fetchTx(source, id, reqID string) (Transaction, error) {
+	var tx Transaction
+	req, _ := http.NewRequest(http.MethodGet, strings.TrimRight(source, "/")+"/tx/"+id, nil)
+	if reqID != "" {
+		req.Header.Set(httpapi.RequestIDHeader, reqID)
+	}
+	resp, err := gossipClient.Do(req)
+	if err != nil {
+		return tx, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return tx, fmt.Errorf("peer answered %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tx); err != nil {
+		return tx, err
+	}
+	return tx, nil
+}
+
+// txAnnounceHandler receives announced IDs, fetches the unknown ones in
+// the background from the announcing peer and relays those it accepts
+// to its own peers. Announcements from addresses that are not a
+// configured, unbanned peer are refused.
+func txAnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	var ann TxAnnouncement
+	if err := json.NewDecoder(r.Body).Decode(&ann); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	sources := announcingPeers(r)
+	if len(sources) == 0 {
+		httpapi.WriteError(w, "announcements are only accepted from configured peers", http.StatusForbidden)
+		return
+	}
+
+	mu.Lock()
+	now := clk.Now()
+	wanted := make([]string, 0, len(ann.IDs))
+	for _, id := range ann.IDs {
+		if _, pending := mempool[id]; pending {
+			continue
+		}
+		if markSeen(id, now) {
+			wanted = append(wanted, id)
+		}
+	}
+	mu.Unlock()
+
+	go func() {
+		var relay []string
+		for _, id := range wanted {
+			var tx Transaction
+			var err error
+			for _, source := range sources {
+				if tx, err = fetchTx(source, id, httpapi.RequestID(r)); err != nil {
+					httpapi.LogRequest(r, "⚠️  Failed to fetch tx %s from %s: %v", id, source, err)
+					continue
+				}
+				if txHash(tx) != id {
+					httpapi.LogRequest(r, "⚠️  Peer %s served a transaction that does not match %s", source, id)
+					err = fmt.Errorf("mismatched transaction %s", id)
+					continue
+				}
+				break
+			}
+			if err != nil {
+				continue
+			}
+			mu.Lock()
+			_, err = acceptTx(&tx)
+			mu.Unlock()
+			if err != nil {
+				continue
+			}
+			relay = append(relay, id)
+		}
+		announceTxs(relay, httpapi.RequestID(r))
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{"requested": wanted})
+}
+
+// getTxHandler serves the body of a pending transaction.
+func getTxHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	mu.Lock()
+	e, ok := mempool[id]
+	mu.Unlock()
+	if !ok {
+		httpapi.WriteError(w, "transaction not pending", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(e.Tx)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// blockVersion is the header version this node produces and the newest
+// it accepts. A missing version (0) reads as 1.
+const blockVersion = 1
+
+// Limits on the extension fields a header may carry.
+const (
+	maxExtraFields = 16
+	maxExtraBytes  = 1024 // keys and values combined
+)
+
+// checkHeader rejects header versions from the future and oversized or
+// empty-keyed extension fields.
+func checkHeader(version int, extra map[string]string) error {
+	if version < 0 || version > blockVersion {
+		return fmt.Errorf("unsupported block version %d", version)
+	}
+	if len(extra) > maxExtraFields {
+		return fmt.Errorf("at most %d extra fields", maxExtraFields)
+	}
+	size := 0
+	for k, v := range extra {
+		if k == "" {
+			return errors.New("extra field keys must not be empty")
+		}
+		size += len(k) + len(v)
+	}
+	if size > maxExtraBytes {
+		return fmt.Errorf("extra fields exceed %d bytes", maxExtraBytes)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Hash-timelock contracts. A lock is a transfer to a contract address
+// derived from the contract terms: a sender, a recipient, the SHA-256
+// hash lock of a secret preimage and a timeout height. Before the
+// timeout the recipient may spend the contract by revealing the
+// preimage (a claim); from the timeout on the sender may take the funds
+// back (a refund). Both spends are signed like transfers and use the
+// contract address's nonce, so whichever is mined first wins.
+//
+// For an atomic swap the two parties lock on both chains with the same
+// hash lock, the side holding the secret with the longer timeout. Its
+// claim reveals the preimage, which the other side then uses to claim
+// in turn.
+
+// htlcPrefix marks contract addresses.
+const htlcPrefix = "htlc"
+
+// errRefundTooEarly is returned for refunds before the timeout; unlike
+// other contract errors, time fixes it.
+var errRefundTooEarly = errors.New("htlc cannot be refunded before its timeout")
+
+// HTLC holds the terms of a contract. A transaction locking funds in the
+// contract or spending from it carries them; a claim adds the preimage.
+type HTLC struct {
+	Sender    string `json:"sender"`             // hex ed25519 public key that locks and may refund
+	Recipient string `json:"recipient"`          // hex ed25519 public key that may claim
+	HashLock  string `json:"hashLock"`           // hex SHA-256 of the preimage
+	Timeout   int    `json:"timeout"`            // first height the sender may refund at
+	Preimage  string `json:"preimage,omitempty"` // hex; claims only
+}
+
+// address derives the contract address from its terms.
+func (c HTLC) address() string {
+	h := sha256.Sum256([]byte(c.Sender + "|" + c.Recipient + "|" + c.HashLock + "|" + strconv.Itoa(c.Timeout)))
+	return htlcPrefix + hex.EncodeToString(h[:])
+}
+
+// record is the encoding of the terms that a transaction ID covers.
+func (c HTLC) record() string {
+	return "htlc|" + c.Sender + "|" + c.Recipient + "|" + c.HashLock + "|" + strconv.Itoa(c.Timeout) + "|" + c.Preimage
+}
+
+// normalize validates the terms, lowercasing the hex fields, and checks
+// the preimage against the hash lock if one is given.
+func (c *HTLC) normalize() error {
+	c.Sender = strings.ToLower(strings.TrimSpace(c.Sender))
+	c.Recipient = strings.ToLower(strings.TrimSpace(c.Recipient))
+	c.HashLock = strings.ToLower(strings.TrimSpace(c.HashLock))
+	c.Preimage = strings.ToLower(strings.TrimSpace(c.Preimage))
+	if pub, err := hex.DecodeString(c.Sender); err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("sender must be a hex-encoded ed25519 public key")
+	}
+	if pub, err := hex.DecodeString(c.Recipient); err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("recipient must be a hex-encoded ed25519 public key")
+	}
+	if lock, err := hex.DecodeString(c.HashLock); err != nil || len(lock) != sha256.Size {
+		return errors.New("hash lock must be a hex SHA-256 digest")
+	}
+	if c.Timeout <= 0 {
+		return errors.New("timeout must be a positive height")
+	}
+	if c.Preimage != "" {
+		secret, err := hex.DecodeString(c.Preimage)
+		if err != nil {
+			return errors.New("preimage must be hex")
+		}
+		if h := sha256.Sum256(secret); hex.EncodeToString(h[:]) != c.HashLock {
+			return errors.New("preimage does not match the hash lock")
+		}
+	}
+	return nil
+}
+
+// verifyHTLC checks that a spend from a contract is signed by the party
+// it pays: the recipient for a claim, the sender for a refund.
+func verifyHTLC(tx Transaction) error {
+	if tx.HTLC == nil {
+		return errors.New("htlc sender requires the contract terms")
+	}
+	signer := tx.HTLC.Sender
+	if tx.HTLC.Preimage != "" {
+		signer = tx.HTLC.Recipient
+	}
+	return verifyKeySignature(signer, tx.ID, tx.Signature)
+}
+
+// checkHTLC validates the contract terms of tx for a block at height:
+// a lock must pay the address of its terms before their timeout, a claim
+// must pay the recipient before the timeout and a refund the sender from
+// it on. Transactions without terms must not pay a contract address.
+func checkHTLC(tx Transaction, height int) error {
+	if tx.HTLC == nil {
+		if strings.HasPrefix(tx.To, htlcPrefix) {
+			return errors.New("transfers to an htlc must carry its terms")
+		}
+		return nil
+	}
+	c := *tx.HTLC
+	if err := c.normalize(); err != nil {
+		return fmt.Errorf("htlc: %v", err)
+	}
+	if c != *tx.HTLC {
+		return errors.New("htlc terms must be lowercase hex")
+	}
+
+	if !strings.HasPrefix(tx.From, htlcPrefix) {
+		switch {
+		case c.Preimage != "":
+			return errors.New("an htlc lock must not reveal the preimage")
+		case tx.From != c.Sender:
+			return errors.New("an htlc must be locked by its sender")
+		case tx.To != c.address():
+			return errors.New("an htlc lock must pay the contract address")
+		case height >= c.Timeout:
+			return errors.New("htlc timeout has passed")
+		}
+		return nil
+	}
+
+	if tx.From != c.address() {
+		return errors.New("htlc terms do not match the contract address")
+	}
+	if c.Preimage != "" {
+		if tx.To != c.Recipient {
+			return errors.New("an htlc claim must pay the recipient")
+		}
+		if height >= c.Timeout {
+			return errors.New("htlc timeout has passed; only a refund is possible")
+		}
+		return nil
+	}
+	if tx.To != c.Sender {
+		return errors.New("an htlc refund must pay the sender")
+	}
+	if height < c.Timeout {
+		return errRefundTooEarly
+	}
+	return nil
+}
+
+// HTLCStatus answers GET /htlc/{address}.
+type HTLCStatus struct {
+	Address  string `json:"address"`
+	Status   string `json:"status"` // "locked", "expired", "claimed" or "refunded"
+	Terms    HTLC   `json:"terms"`
+	Balance  uint64 `json:"balance"` // still locked
+	LockTx   string `json:"lockTx"`
+	SpendTx  string `json:"spendTx,omitempty"`
+	Preimage string `json:"preimage,omitempty"` // revealed by the claim
+	Height   int    `json:"height"`             // of the chain tip
+}
+
+// createHTLCHandler validates contract terms and returns them with the
+// contract address to lock funds in.
+func createHTLCHandler(w http.ResponseWriter, r *http.Request) {
+	var c HTLC
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	c.Preimage = ""
+	if err := c.normalize(); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{"address": c.address(), "terms": c})
+}
+
+// htlcHandler reports the state of a contract: who locked it, whether it
+// was claimed or refunded and, once claimed, the preimage.
+func htlcHandler(w http.ResponseWriter, r *http.Request) {
+	addr := strings.ToLower(mux.Vars(r)["address"])
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	tip := powChain[len(powChain)-1].Height
+	st := HTLCStatus{Address: addr, Height: tip}
+	for _, b := range powChain {
+		for _, tx := range b.Transactions {
+			switch {
+			case tx.HTLC == nil:
+			case tx.To == addr && st.LockTx == "":
+				st.Terms, st.LockTx = *tx.HTLC, tx.ID
+			case tx.From == addr && st.SpendTx == "":
+				st.SpendTx, st.Preimage = tx.ID, tx.HTLC.Preimage
+			}
+		}
+	}
+	if st.LockTx == "" {
+		httpapi.WriteError(w, "no htlc was locked at this address", http.StatusNotFound)
+		return
+	}
+	if bal, ok := ledgerState(powChain)[addr]; ok {
+		st.Balance = bal.Spendable
+	}
+	switch {
+	case st.SpendTx != "" && st.Preimage != "":
+		st.Status = "claimed"
+	case st.SpendTx != "":
+		st.Status = "refunded"
+	case tip+1 >= st.Terms.Timeout:
+		st.Status = "expired"
+	default:
+		st.Status = "locked"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(st)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Hybrid consensus. Miners keep proposing blocks by proof of work; with
+// HYBRID_VALIDATORS set, every CHECKPOINT_INTERVAL-th block is also sent
+// to those PoS nodes' POST /attest, and their validators sign it. A
+// checkpoint signed by validators holding more than two thirds of the
+// active stake is final, and so is every block below it.
+//
+// The node trusts the stakes and public keys the PoS nodes report, but
+// verifies every signature itself.
+
+// maxCheckpoints caps how many checkpoints GET /checkpoints remembers.
+const maxCheckpoints = 1000
+
+// checkpointPrefix domain-separates checkpoint attestations; it matches
+// proof-stake/attest.go.
+const checkpointPrefix = "AlirezaChain Checkpoint:\n"
+
+var (
+	// hybridValidators are the PoS nodes asked to attest checkpoints
+	// (HYBRID_VALIDATORS); none leaves hybrid mode off.
+	hybridValidators []string
+
+	// checkpointInterval is the distance between checkpoints
+	// (CHECKPOINT_INTERVAL).
+	checkpointInterval = 10
+
+	// checkpoints are the attested checkpoints, oldest first. Guarded by
+	// mu.
+	checkpoints []Checkpoint
+
+	// finalized is the latest final checkpoint; Height is 0 before the
+	// first. Guarded by mu.
+	finalized Checkpoint
+
+	// checkpointDue hands the latest checkpoint block to
+	// checkpointWorker; an older one still waiting is replaced, since
+	// finalizing the newer block finalizes it too.
+	checkpointDue = make(chan PowBlock, 1)
+
+	attestClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Attestation is one validator's signature over a checkpoint.
+type Attestation struct {
+	Validator string `json:"validator"`
+	PubKey    string `json:"pubKey"`
+	Stake     uint64 `json:"stake"`
+	Signature string `json:"signature"`
+}
+
+// CheckpointVote is a PoS node's answer to POST /attest.
+type CheckpointVote struct {
+	Height       int           `json:"height"`
+	Hash         string        `json:"hash"`
+	Attestations []Attestation `json:"attestations"`
+	TotalStake   uint64        `json:"totalStake"`
+}
+
+// Checkpoint is a block and the stake that attested it.
+type Checkpoint struct {
+	Height       int           `json:"height"`
+	Hash         string        `json:"hash"`
+	Time         string        `json:"time"`
+	Stake        uint64        `json:"stake"`      // of the validators that signed
+	TotalStake   uint64        `json:"totalStake"` // of the active validator set
+	Finalized    bool          `json:"finalized"`
+	Attestations []Attestation `json:"attestations"`
+}
+
+// loadHybrid reads HYBRID_VALIDATORS (comma-separated PoS node URLs) and
+// CHECKPOINT_INTERVAL.
+func loadHybrid() error {
+	for _, u := range strings.Split(os.Getenv("HYBRID_VALIDATORS"), ",") {
+		if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+			hybridValidators = append(hybridValidators, u)
+		}
+	}
+	if v := os.Getenv("CHECKPOINT_INTERVAL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid CHECKPOINT_INTERVAL %q", v)
+		}
+		checkpointInterval = n
+	}
+	return nil
+}
+
+// checkpointDigest returns the digest validators sign for a checkpoint.
+func checkpointDigest(height int, hash string) []byte {
+	h := sha256.Sum256([]byte(checkpointPrefix + strconv.Itoa(height) + ":" + hash))
+	return h[:]
+}
+
+// supermajority reports whether stake is more than two thirds of total.
+func supermajority(stake, total uint64) bool {
+	if total == 0 {
+		return false
+	}
+	s := new(big.Int).Mul(new(big.Int).SetUint64(stake), big.NewInt(3))
+	t := new(big.Int).Mul(new(big.Int).SetUint64(total), big.NewInt(2))
+	return s.Cmp(t) > 0
+}
+
+// scheduleCheckpoint queues b for attestation if it is a checkpoint
+// block. Callers must hold mu.
+func scheduleCheckpoint(b PowBlock) {
+	if len(hybridValidators) == 0 || b.Height == 0 || b.Height%checkpointInterval != 0 {
+		return
+	}
+	select {
+	case <-checkpointDue:
+	default:
+	}
+	checkpointDue <- b
+}
+
+// checkpointWorker attests queued checkpoints one at a time.
+func checkpointWorker() {
+	for b := range checkpointDue {
+		attestCheckpoint(b)
+	}
+}
+
+// requestVote asks one PoS node to attest b.
+func requestVote(node string, b PowBlock) (CheckpointVote, error) {
+	var vote CheckpointVote
+	body, _ := json.Marshal(map[string]interface{}{"height": b.Height, "hash": b.Hash})
+	resp, err := attestClient.Post(node+"/attest", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return vote, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e httpapi.APIError
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return vote, fmt.Errorf("%s: %s", resp.Status, e.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vote); err != nil {
+		return vote, err
+	}
+	if vote.Height != b.Height || vote.Hash != b.Hash {
+		return vote, fmt.Errorf("answered for height %d hash %s", vote.Height, vote.Hash)
+	}
+	return vote, nil
+}
+
+// collectAttestations asks every PoS node to attest b and returns the
+// checkpoint made of the valid signatures, final if they carry a
+// supermajority of the stake.
+func collectAttestations(b PowBlock) Checkpoint {
+	cp := Checkpoint{Height: b.Height, Hash: b.Hash, Attestations: []Attestation{}}
+	digest := checkpointDigest(b.Height, b.Hash)
+	signed := make(map[string]bool)
+	for _, node := range hybridValidators {
+		vote, err := requestVote(node, b)
+		if err != nil {
+			log.Printf("⚠️  Checkpoint %d: %s did not attest: %v", b.Height, node, err)
+			continue
+		}
+		if vote.TotalStake > cp.TotalStake {
+			cp.TotalStake = vote.TotalStake
+		}
+		for _, a := range vote.Attestations {
+			pub, err := hex.DecodeString(a.PubKey)
+			sig, serr := hex.DecodeString(a.Signature)
+			if err != nil || serr != nil || len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, digest, sig) {
+				log.Printf("⚠️  Checkpoint %d: invalid attestation by %s from %s", b.Height, a.Validator, node)
+				continue
+			}
+			if signed[a.Validator] {
+				continue
+			}
+			signed[a.Validator] = true
+			cp.Stake += a.Stake
+			cp.Attestations = append(cp.Attestations, a)
+		}
+	}
+	cp.Finalized = supermajority(cp.Stake, cp.TotalStake)
+	cp.Time = clk.Now().Format(time.RFC3339)
+	return cp
+}
+
+// attestCheckpoint has b attested and records the checkpoint.
+func attestCheckpoint(b PowBlock) {
+	cp := collectAttestations(b)
+	if len(cp.Attestations) == 0 {
+		return
+	}
+
+	mu.Lock()
+	checkpoints = append(checkpoints, cp)
+	if len(checkpoints) > maxCheckpoints {
+		checkpoints = checkpoints[len(checkpoints)-maxCheckpoints:]
+	}
+	if cp.Finalized && cp.Height > finalized.Height {
+		finalized = cp
+	}
+	mu.Unlock()
+
+	if cp.Finalized {
+		log.Printf("🏁 Finalized checkpoint height=%d hash=%s (%d of %d stake)", cp.Height, cp.Hash, cp.Stake, cp.TotalStake)
+	} else {
+		log.Printf("⏳ Checkpoint height=%d attested by %d of %d stake, not final", cp.Height, cp.Stake, cp.TotalStake)
+	}
+}
+
+// isFinal reports whether the block at height is final. Callers must
+// hold mu.
+func isFinal(height int) bool {
+	return finalized.Height > 0 && height <= finalized.Height
+}
+
+// checkpointsHandler lists attested checkpoints, newest first, with the
+// latest final one.
+func checkpointsHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Hybrid          bool         `json:"hybrid"`
+		Interval        int          `json:"interval"`
+		FinalizedHeight int          `json:"finalizedHeight"`
+		FinalizedHash   string       `json:"finalizedHash,omitempty"`
+		Checkpoints     []Checkpoint `json:"checkpoints"`
+	}
+
+	mu.Lock()
+	resp := Response{
+		Hybrid:          len(hybridValidators) > 0,
+		Interval:        checkpointInterval,
+		FinalizedHeight: finalized.Height,
+		FinalizedHash:   finalized.Hash,
+		Checkpoints:     make([]Checkpoint, 0, len(checkpoints)),
+	}
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		resp.Checkpoints = append(resp.Checkpoints, checkpoints[i])
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
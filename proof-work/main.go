@@ -8,18 +8,24 @@
 package main
 
 import (
-	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"log"
-	"math"
-	"math/big"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"alirezachain/chainhash"
+	"alirezachain/clock"
+	"alirezachain/genesis"
+	"alirezachain/httpapi"
+	"alirezachain/idempotency"
+	"alirezachain/powcore"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
@@ -31,88 +37,156 @@ const (
 
 // Block represents a single block in the PoW blockchain.
 type PowBlock struct {
-	Height    int    `json:"height"`
-	Timestamp int64  `json:"timestamp"`
-	Data      string `json:"data"`
-	Nonce     int64  `json:"nonce"`
-	Hash      string `json:"hash"`
-	PrevHash  string `json:"prevHash"`
-	Difficulty int   `json:"difficulty"`
+	Height       int               `json:"height"`
+	Timestamp    int64             `json:"timestamp"`
+	Data         string            `json:"data"`
+	Nonce        int64             `json:"nonce"`
+	Hash         string            `json:"hash"`
+	PrevHash     string            `json:"prevHash"`
+	Difficulty   int               `json:"difficulty"`
+	Bits         uint32            `json:"bits,omitempty"` // compact target, replaces Difficulty after the compactbits fork
+	TxRoot       string            `json:"txRoot"`
+	StateRoot    string            `json:"stateRoot"`
+	Transactions []Transaction     `json:"transactions"`
+	Version      int               `json:"version,omitempty"`
+	Extra        map[string]string `json:"extra,omitempty"`   // extension fields, committed to by the hash
+	Anchors      []string          `json:"anchors,omitempty"` // anchored digests, committed to by Extra["anchorRoot"]
+	Uncles       []Uncle           `json:"uncles,omitempty"`  // stale blocks, committed to by Extra["uncleRoot"]
 }
 
-var powChain []PowBlock
+var (
+	powChain []PowBlock
+	mu       sync.Mutex // guards powChain and mempool
 
-// calculateHash computes the SHA-256 hash for a block.
+	// defaultDifficulty is used by /mine when no difficulty is given and
+	// is the minimum accepted from external miners (DIFFICULTY).
+	defaultDifficulty = 18
+
+	// minerAddress receives the coinbase when /mine names no miner
+	// (MINER_ADDRESS).
+	minerAddress = "miner"
+
+	// clk timestamps blocks and templates and ages the mempool; tests may
+	// replace it with a fake clock such as sim.Clock.
+	clk clock.Clock = clock.Real{}
+
+	// idem replays the responses to writes retried with an
+	// Idempotency-Key (see package idempotency).
+	idem = idempotency.New(func() time.Time { return clk.Now() })
+
+	// hasher computes block hashes; the genesis file may choose another
+	// algorithm than SHA-256.
+	hasher = chainhash.Default
+)
+
+// blockRecord returns the header fields a block's hash commits to, in
+// the encoding in force at the block's height, followed by the header
+// version and extension fields.
+func blockRecord(b PowBlock) string {
+	return powcore.Header{
+		Height:     b.Height,
+		Timestamp:  b.Timestamp,
+		Data:       b.Data,
+		Nonce:      b.Nonce,
+		PrevHash:   b.PrevHash,
+		Difficulty: difficultyField(b),
+		TxRoot:     b.TxRoot,
+		StateRoot:  b.StateRoot,
+		Version:    b.Version,
+		Extra:      b.Extra,
+	}.Record(forkActive(forkHashV2, b.Height))
+}
+
+// calculateHash computes the block hash with the chain's hash algorithm.
 func calculateHash(b PowBlock) string {
-	record := strconv.Itoa(b.Height) +
-		strconv.FormatInt(b.Timestamp, 10) +
-		b.Data +
-		strconv.FormatInt(b.Nonce, 10) +
-		b.PrevHash +
-		strconv.Itoa(b.Difficulty)
-
-	h := sha256.Sum256([]byte(record))
-	return hex.EncodeToString(h[:])
+	return hex.EncodeToString(hasher.Sum([]byte(blockRecord(b))))
 }
 
 // mineBlock performs a simple proof-of-work by finding a hash
-// that is below a target defined by the difficulty.
-func mineBlock(prev PowBlock, data string, difficulty int) PowBlock {
-	var nonce int64 = 0
-	target := big.NewInt(1)
-	shift := uint(256 - difficulty)
-	target.Lsh(target, shift)
-
-	for {
-		candidate := PowBlock{
-			Height:    prev.Height + 1,
-			Timestamp: time.Now().Unix(),
-			Data:      data,
-			PrevHash:  prev.Hash,
-			Nonce:     nonce,
-			Difficulty: difficulty,
-		}
-		hashBytes := sha256.Sum256([]byte(
-			strconv.Itoa(candidate.Height) +
-				strconv.FormatInt(candidate.Timestamp, 10) +
-				candidate.Data +
-				strconv.FormatInt(candidate.Nonce, 10) +
-				candidate.PrevHash +
-				strconv.Itoa(candidate.Difficulty),
-		))
-
-		var hashInt big.Int
-		hashInt.SetBytes(hashBytes[:])
-
-		if hashInt.Cmp(target) == -1 {
-			candidate.Hash = hex.EncodeToString(hashBytes[:])
-			log.Printf("🧱 Mined new block: height=%d nonce=%d hash=%s", candidate.Height, candidate.Nonce, candidate.Hash)
-			return candidate
-		}
+// that is below a target defined by the difficulty, given either as
+// leading zero bits or as compact bits (see nextWork). The block's first
+// transaction pays the reward and the fees of txs to miner, and the
+// header commits to the state that results from applying the block on
+// top of base. extra is carried in the header as extension fields,
+// including the roots of anchors (see anchor.go) and uncles (see
+// uncles.go). The nonce space is split between MINING_THREADS workers
+// (see searchNonce). A candidate that does not apply on top of base is
+// not mined at all.
+func mineBlock(prev PowBlock, data string, difficulty int, bits uint32, miner string, txs []Transaction, base LedgerState, extra map[string]string, anchors []string, uncles []Uncle) (PowBlock, error) {
+	target := blockTarget(PowBlock{Height: prev.Height + 1, Difficulty: difficulty, Bits: bits})
+
+	txs = append([]Transaction{newCoinbase(miner, prev.Height+1, minerFees(txs))}, txs...)
+	tmpl := PowBlock{
+		Height:       prev.Height + 1,
+		Data:         data,
+		PrevHash:     prev.Hash,
+		Difficulty:   difficulty,
+		Bits:         bits,
+		TxRoot:       merkleRoot(txs),
+		Transactions: txs,
+		Version:      blockVersion,
+		Extra:        extra,
+		Anchors:      anchors,
+		Uncles:       uncles,
+	}
+	post := base.clone()
+	if err := post.applyBlock(tmpl); err != nil {
+		return PowBlock{}, err
+	}
+	tmpl.StateRoot = post.root()
 
-		nonce++
-		if nonce == math.MaxInt64 {
-			log.Println("⚠️  Nonce overflow, restarting mining loop")
-			nonce = 0
-		}
+	ctl := miningControls()
+	var stop int32
+	found := make(chan PowBlock, 1)
+	for w := 0; w < ctl.Threads; w++ {
+		go searchNonce(tmpl, target, int64(w), int64(ctl.Threads), &stop, found, ctl.DutyCycle)
 	}
+	b := <-found
+	log.Printf("🧱 Mined new block: height=%d nonce=%d hash=%s", b.Height, b.Nonce, b.Hash)
+	return b, nil
 }
 
 // isBlockValid checks whether a new block is valid compared to the previous one.
 func isBlockValid(newBlock, prevBlock PowBlock) bool {
-	if newBlock.Height != prevBlock.Height+1 {
-		return false
+	return checkBlock(newBlock, prevBlock) == nil
+}
+
+// isHeaderValid checks everything isBlockValid does except the
+// transactions, which a header may come without.
+func isHeaderValid(newBlock, prevBlock PowBlock) bool {
+	return checkBlockHeader(newBlock, prevBlock) == nil
+}
+
+// checkBlock is isBlockValid, reporting why a block is invalid.
+func checkBlock(newBlock, prevBlock PowBlock) error {
+	if err := checkBlockHeader(newBlock, prevBlock); err != nil {
+		return err
 	}
-	if newBlock.PrevHash != prevBlock.Hash {
-		return false
+	return validateTransactions(newBlock)
+}
+
+// checkBlockHeader is isHeaderValid, reporting why a header is invalid.
+func checkBlockHeader(newBlock, prevBlock PowBlock) error {
+	if newBlock.Height != prevBlock.Height+1 || newBlock.PrevHash != prevBlock.Hash {
+		return errors.New("block does not extend the tip")
+	}
+	if err := checkHeader(newBlock.Version, newBlock.Extra); err != nil {
+		return err
+	}
+	if err := checkBlockTime(newBlock, prevBlock); err != nil {
+		return err
 	}
 	if calculateHash(newBlock) != newBlock.Hash {
-		return false
+		return errors.New("block hash does not match its contents")
 	}
-	return true
+	if !meetsTarget(newBlock.Hash, blockTarget(newBlock)) {
+		return errors.New("block fails proof of work")
+	}
+	return checkAnchors(newBlock)
 }
 
-// isChainValid validates an entire chain.
+// isChainValid validates an entire chain, each block under the rules of
+// its height.
 func isChainValid(chain []PowBlock) bool {
 	if len(chain) == 0 {
 		return false
@@ -121,136 +195,444 @@ func isChainValid(chain []PowBlock) bool {
 		if !isBlockValid(chain[i], chain[i-1]) {
 			return false
 		}
+		if checkDifficulty(chain[i], chain[:i]) != nil {
+			return false
+		}
+		if checkUncles(chain[i], chain[:i]) != nil {
+			return false
+		}
 	}
 	return true
 }
 
+// appendBlock validates b against the current tip and account state,
+// appends it and drops its transactions from the mempool. Callers must
+// hold mu.
+func appendBlock(b PowBlock) error {
+	if err := checkBlock(b, powChain[len(powChain)-1]); err != nil {
+		return err
+	}
+	if err := checkDifficulty(b, powChain); err != nil {
+		return err
+	}
+	if err := checkUncles(b, powChain); err != nil {
+		return err
+	}
+	post := ledgerState(powChain)
+	if err := post.applyBlock(b); err != nil {
+		return err
+	}
+	if post.root() != b.StateRoot {
+		return errors.New("state root does not match the state after applying the block")
+	}
+	if err := checkDueRuns(b); err != nil {
+		return err
+	}
+	powChain = append(powChain, b)
+	recordReceipts(b)
+	recordAnchors(b)
+	recordUncles(b)
+	recordIssuance(b)
+	scheduleCheckpoint(b)
+	removeIncluded(b)
+	revalidateMempool()
+	notifyTip()
+	notifyWatchers(b)
+	return nil
+}
+
 // BlockView is a user-friendly representation of a block.
 type BlockView struct {
-	Height    int    `json:"height"`
-	Timestamp int64  `json:"timestamp"`
-	TimeText  string `json:"time"`
-	Data      string `json:"data"`
-	Nonce     int64  `json:"nonce"`
-	Hash      string `json:"hash"`
-	PrevHash  string `json:"prevHash"`
-	Difficulty int   `json:"difficulty"`
+	Height       int               `json:"height"`
+	Timestamp    int64             `json:"timestamp"`
+	TimeText     string            `json:"time"`
+	Data         string            `json:"data"`
+	Nonce        int64             `json:"nonce"`
+	Hash         string            `json:"hash"`
+	PrevHash     string            `json:"prevHash"`
+	Difficulty   int               `json:"difficulty"`
+	Bits         uint32            `json:"bits,omitempty"`
+	TxRoot       string            `json:"txRoot"`
+	StateRoot    string            `json:"stateRoot"`
+	Transactions []Transaction     `json:"transactions"`
+	Version      int               `json:"version,omitempty"`
+	Extra        map[string]string `json:"extra,omitempty"`
+	Anchors      []string          `json:"anchors,omitempty"`
+	Uncles       []Uncle           `json:"uncles,omitempty"`
 }
 
 func toView(b PowBlock) BlockView {
 	return BlockView{
-		Height:    b.Height,
-		Timestamp: b.Timestamp,
-		TimeText:  time.Unix(b.Timestamp, 0).Format(time.RFC3339),
-		Data:      b.Data,
-		Nonce:     b.Nonce,
-		Hash:      b.Hash,
-		PrevHash:  b.PrevHash,
-		Difficulty: b.Difficulty,
+		Height:       b.Height,
+		Timestamp:    b.Timestamp,
+		TimeText:     time.Unix(b.Timestamp, 0).Format(time.RFC3339),
+		Data:         b.Data,
+		Nonce:        b.Nonce,
+		Hash:         b.Hash,
+		PrevHash:     b.PrevHash,
+		Difficulty:   b.Difficulty,
+		Bits:         b.Bits,
+		TxRoot:       b.TxRoot,
+		StateRoot:    b.StateRoot,
+		Transactions: b.Transactions,
+		Version:      b.Version,
+		Extra:        b.Extra,
+		Anchors:      b.Anchors,
+		Uncles:       b.Uncles,
 	}
 }
 
 // --- HTTP Handlers ---
 
 func getChainHandler(w http.ResponseWriter, r *http.Request) {
-	views := make([]BlockView, 0, len(powChain))
-	for _, b := range powChain {
-		views = append(views, toView(b))
+	mu.Lock()
+	start, fork, ok := sinceStart(r, powChain)
+	views := make([]BlockView, 0, len(powChain)-start)
+	if ok && fork == nil {
+		for _, b := range powChain[start:] {
+			views = append(views, toView(b))
+		}
 	}
+	mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(views); err != nil {
-		http.Error(w, "could not encode chain", http.StatusInternalServerError)
+	if !ok {
+		httpapi.WriteError(w, "since_height must be a height", http.StatusBadRequest)
+		return
 	}
+	if fork != nil {
+		writeFork(w, fork)
+		return
+	}
+
+	writeNegotiated(w, r, "Chain", views)
 }
 
 func mineHandler(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
-		Data       string `json:"data"`
-		Difficulty int    `json:"difficulty"`
+		Data       string            `json:"data"`
+		Difficulty int               `json:"difficulty"`
+		Miner      string            `json:"miner"`
+		Extra      map[string]string `json:"extra"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 	if strings.TrimSpace(payload.Data) == "" {
-		http.Error(w, "data is required", http.StatusBadRequest)
+		httpapi.WriteError(w, "data is required", http.StatusBadRequest)
 		return
 	}
-	if payload.Difficulty <= 0 || payload.Difficulty > 24 {
-		payload.Difficulty = 18
+	if err := checkHeader(blockVersion, payload.Extra); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, field := range []string{anchorRootField, uncleRootField} {
+		if _, ok := payload.Extra[field]; ok {
+			httpapi.WriteError(w, field+" is set by the node", http.StatusBadRequest)
+			return
+		}
+	}
+	if devMode || payload.Difficulty <= 0 || payload.Difficulty > 24 {
+		payload.Difficulty = defaultDifficulty
+	}
+	payload.Miner = strings.TrimSpace(payload.Miner)
+	if payload.Miner == "" {
+		payload.Miner = minerAddress
+	}
+	if miningControls().Paused {
+		httpapi.WriteError(w, "mining is paused", http.StatusServiceUnavailable)
+		return
 	}
 
+	mu.Lock()
 	last := powChain[len(powChain)-1]
-	newBlock := mineBlock(last, payload.Data, payload.Difficulty)
+	if wait := blockWait(last); wait > 0 {
+		mu.Unlock()
+		writeTooSoon(w, wait)
+		return
+	}
+	base := ledgerState(powChain)
+	txs := selectTransactions(base)
+	anchors, extra := takeAnchors(payload.Extra)
+	uncles, extra := takeUncles(extra)
+	difficulty, bits := nextWork(powChain, payload.Difficulty)
+	mu.Unlock()
+
+	newBlock, err := mineBlock(last, payload.Data, difficulty, bits, payload.Miner, txs, base, extra, anchors, uncles)
+	if err != nil {
+		httpapi.WriteError(w, "could not build a valid block: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	if isBlockValid(newBlock, last) {
-		powChain = append(powChain, newBlock)
-		w.Header().Set("Content-Type", "application/json")
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "  ")
-		_ = enc.Encode(toView(newBlock))
-	} else {
-		http.Error(w, "mined block is not valid", http.StatusInternalServerError)
+	mu.Lock()
+	err = appendBlock(newBlock)
+	mu.Unlock()
+
+	if err != nil {
+		httpapi.WriteError(w, "mined block is not valid: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(toView(newBlock))
 }
 
-func infoHandler(w http.ResponseWriter, r *http.Request) {
-	type Info struct {
-		Name      string `json:"name"`
-		Blocks    int    `json:"blocks"`
-		LastHash  string `json:"lastHash"`
-		Difficulty int   `json:"defaultDifficulty"`
-	}
+// NodeInfo is the body of GET /info.
+type NodeInfo struct {
+	Name          string `json:"name"`
+	ChainID       string `json:"chainId,omitempty"`
+	Blocks        int    `json:"blocks"`
+	LastHash      string `json:"lastHash"`
+	Difficulty    int    `json:"defaultDifficulty"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	Finalized     int    `json:"finalizedHeight,omitempty"` // hybrid mode only
+	Snapshot      int    `json:"snapshotHeight,omitempty"`  // fast-synced nodes only
+}
 
+func infoHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
 	last := powChain[len(powChain)-1]
 
-	resp := Info{
-		Name:      chainName,
-		Blocks:    len(powChain),
-		LastHash:  last.Hash,
-		Difficulty: 18,
+	resp := NodeInfo{
+		Name:          chainName,
+		ChainID:       chainID,
+		Blocks:        len(powChain),
+		LastHash:      last.Hash,
+		Difficulty:    defaultDifficulty,
+		HashAlgorithm: hasher.Name(),
+		Finalized:     finalized.Height,
 	}
+	if synced != nil {
+		resp.Snapshot = synced.height
+	}
+	mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "  ")
-	_ = enc.Encode(resp)
+	writeNegotiated(w, r, "NodeInfo", resp)
 }
 
+// makeRouter serves the API under /v1 and, deprecated, unversioned (see
+// api.go).
 func makeRouter() http.Handler {
-	r := mux.NewRouter()
+	return versioned(routes)
+}
+
+// routes adds every API route to r.
+func routes(r *mux.Router) {
 	r.HandleFunc("/chain", getChainHandler).Methods("GET")
-	r.HandleFunc("/mine", mineHandler).Methods("POST")
+	r.HandleFunc("/proto", protoHandler).Methods("GET")
+	r.HandleFunc("/chain/next", nextBlockHandler).Methods("GET")
+	r.HandleFunc("/chain/verify", verifyChainHandler).Methods("GET", "POST")
+	r.HandleFunc("/export", exportHandler).Methods("GET")
+	r.HandleFunc("/mine", idem.Wrap(mineHandler)).Methods("POST")
 	r.HandleFunc("/info", infoHandler).Methods("GET")
-	return r
+	r.HandleFunc("/forks", forksHandler).Methods("GET")
+	r.HandleFunc("/params", paramsHandler).Methods("GET")
+	r.HandleFunc("/supply", supplyHandler).Methods("GET")
+	r.HandleFunc("/balance/{address}", balanceHandler).Methods("GET")
+	r.HandleFunc("/address/{address}/nonce", nonceHandler).Methods("GET")
+	r.HandleFunc("/state/rebuild", rebuildStateHandler).Methods("GET")
+	r.HandleFunc("/richlist", richlistHandler).Methods("GET")
+	r.HandleFunc("/stats/timeseries", timeseriesHandler).Methods("GET")
+	r.HandleFunc("/tx", idem.Wrap(submitTxHandler)).Methods("POST")
+	r.HandleFunc("/tx/batch", idem.Wrap(submitTxBatchHandler)).Methods("POST")
+	r.HandleFunc("/tx/announce", peerAuth.Require(txAnnounceHandler)).Methods("POST")
+	r.HandleFunc("/tx/{id}", getTxHandler).Methods("GET")
+	r.HandleFunc("/tx/{id}/receipt", receiptHandler).Methods("GET")
+	r.HandleFunc("/logs", logsHandler).Methods("GET")
+	r.HandleFunc("/multisig", createMultisigHandler).Methods("POST")
+	r.HandleFunc("/multisig/aggregate", aggregateMultisigHandler).Methods("POST")
+	r.HandleFunc("/htlc", createHTLCHandler).Methods("POST")
+	r.HandleFunc("/htlc/{address}", htlcHandler).Methods("GET")
+	r.HandleFunc("/schedule/{address}", scheduleHandler).Methods("GET")
+	r.HandleFunc("/oracle", oracleHandler).Methods("GET")
+	r.HandleFunc("/oracle/{feed}/latest", oracleLatestHandler).Methods("GET")
+	r.HandleFunc("/verify", verifyMessageHandler).Methods("POST")
+	r.HandleFunc("/mempool", mempoolHandler).Methods("GET")
+	r.HandleFunc("/mempool/info", mempoolInfoHandler).Methods("GET")
+	r.HandleFunc("/mempool/{id}", mempoolTxHandler).Methods("GET")
+	r.HandleFunc("/mempool/{id}", adminOnly(deleteMempoolTxHandler)).Methods("DELETE")
+	r.HandleFunc("/watch", createWatchHandler).Methods("POST")
+	r.HandleFunc("/watch/{id}", getWatchHandler).Methods("GET")
+	r.HandleFunc("/watch/{id}", deleteWatchHandler).Methods("DELETE")
+	r.HandleFunc("/watch/{id}/ws", watchStreamHandler).Methods("GET")
+	r.HandleFunc("/template", templateHandler).Methods("GET")
+	r.HandleFunc("/mining/hashrate", hashrateHandler).Methods("GET")
+	r.HandleFunc("/mining/reward", rewardHandler).Methods("GET")
+	r.HandleFunc("/uncles", unclesHandler).Methods("GET")
+	r.HandleFunc("/mining", miningStatusHandler).Methods("GET")
+	r.HandleFunc("/submit", submitBlockHandler).Methods("POST")
+	r.HandleFunc("/anchor", idem.Wrap(anchorHandler)).Methods("POST")
+	r.HandleFunc("/anchor/{digest}", getAnchorHandler).Methods("GET")
+	r.HandleFunc("/checkpoints", checkpointsHandler).Methods("GET")
+	r.HandleFunc("/snapshot", snapshotHandler).Methods("GET")
+	r.HandleFunc("/relay", relayHandler).Methods("GET")
+	r.HandleFunc("/relay/pos/{hash}", relayProofHandler).Methods("GET")
+	r.HandleFunc("/admin", adminOnly(adminDashboardHandler)).Methods("GET")
+	r.HandleFunc("/admin/status", adminOnly(adminStatusHandler)).Methods("GET")
+	r.HandleFunc("/admin/mining/pause", adminOnly(pauseMiningHandler)).Methods("POST")
+	r.HandleFunc("/admin/mining/resume", adminOnly(resumeMiningHandler)).Methods("POST")
+	r.HandleFunc("/admin/peers/ban", adminOnly(adminPeerHandler(true))).Methods("POST")
+	r.HandleFunc("/admin/peers/unban", adminOnly(adminPeerHandler(false))).Methods("POST")
+	r.HandleFunc("/admin/config/reload", adminOnly(adminReloadHandler)).Methods("POST")
+	r.HandleFunc("/debug/statehash", adminOnly(stateHashHandler)).Methods("GET")
 }
 
 func main() {
 	_ = godotenv.Load()
+	importFile := flag.String("import", os.Getenv("IMPORT_FILE"), "JSONL chain from GET /export to load before serving")
+	repair := flag.Bool("repair", os.Getenv("REPAIR_CHAIN") == "true", "truncate a corrupt -import file to its last valid block instead of exiting")
+	dev := flag.Bool("dev", os.Getenv("DEV_MODE") == "true", "mine every submitted transaction at once, at difficulty 1")
+	flag.Parse()
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8081"
 	}
 
-	genesis := PowBlock{
-		Height:    0,
-		Timestamp: time.Now().Unix(),
-		Data:      "Genesis ⛓️ " + chainName,
-		Nonce:     0,
-		PrevHash:  "",
-		Difficulty: 1,
+	if err := loadEmission(); err != nil {
+		log.Fatalf("emission config: %v", err)
+	}
+	if err := loadMempoolPolicy(); err != nil {
+		log.Fatalf("mempool config: %v", err)
+	}
+	if err := loadAPIVersioning(); err != nil {
+		log.Fatalf("api config: %v", err)
+	}
+	if err := loadHTTPMiddleware(); err != nil {
+		log.Fatalf("middleware config: %v", err)
+	}
+	if err := loadForks(); err != nil {
+		log.Fatalf("fork config: %v", err)
+	}
+	if err := loadDifficultyAlgorithm(); err != nil {
+		log.Fatalf("difficulty config: %v", err)
+	}
+	if err := loadMiningControls(); err != nil {
+		log.Fatalf("mining config: %v", err)
+	}
+	if err := loadMinBlockInterval(); err != nil {
+		log.Fatalf("block interval config: %v", err)
+	}
+	if err := loadHybrid(); err != nil {
+		log.Fatalf("hybrid config: %v", err)
+	}
+	if err := loadOracle(); err != nil {
+		log.Fatalf("oracle config: %v", err)
+	}
+	if err := loadRelay(); err != nil {
+		log.Fatalf("relay config: %v", err)
+	}
+	if err := loadFastSync(); err != nil {
+		log.Fatalf("fast sync config: %v", err)
+	}
+	if err := loadAdmin(); err != nil {
+		log.Fatalf("admin config: %v", err)
+	}
+	if fastSyncPeer != "" && *importFile != "" {
+		log.Fatalf("fast sync config: FAST_SYNC_PEER cannot be combined with -import")
+	}
+	if v := os.Getenv("MINER_ADDRESS"); v != "" {
+		minerAddress = v
+	}
+	peers = parsePeers(os.Getenv("PEERS"))
+	nodeURL = os.Getenv("NODE_URL")
+	if nodeURL == "" {
+		nodeURL = "http://localhost:" + port
+	}
+	if v := os.Getenv("DIFFICULTY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 24 {
+			log.Fatalf("invalid DIFFICULTY %q (1-24)", v)
+		}
+		defaultDifficulty = n
+	}
+	if v := os.Getenv("MAX_BLOCK_TXS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid MAX_BLOCK_TXS %q", v)
+		}
+		maxBlockTxs = n
+	}
+	if v := os.Getenv("COINBASE_MATURITY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid COINBASE_MATURITY %q", v)
+		}
+		coinbaseMaturity = n
+	}
+	if err := loadDevMode(*dev); err != nil {
+		log.Fatalf("dev mode: %v", err)
+	}
+
+	var genesisFile *genesis.File
+	if path := os.Getenv("GENESIS_FILE"); path != "" {
+		g, err := genesis.Read(path)
+		if err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+		genesisFile = g
+		if hasher, err = chainhash.New(g.HashAlgorithm); err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+		if err := validChainID(g.ChainID); err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+		chainID = g.ChainID
+		if err := applyGenesisEmission(g); err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+	}
+	block, err := genesisBlock(genesisFile)
+	if err != nil {
+		log.Fatalf("genesis: %v", err)
+	}
+	if chainID == "" {
+		chainID = block.Hash
+	}
+	powChain = append(powChain, block)
+	if *importFile != "" {
+		if err := checkStoredChain(*importFile, *repair); err != nil {
+			log.Fatalf("import %s: %v", *importFile, err)
+		}
+		n, err := importChain(*importFile, genesisFile != nil)
+		if err != nil {
+			log.Fatalf("import %s: %v", *importFile, err)
+		}
+		log.Printf("📥 Imported %d blocks from %s", n, *importFile)
+	}
+	if fastSyncPeer != "" {
+		if err := fastSync(); err != nil {
+			log.Fatalf("fast sync from %s: %v", fastSyncPeer, err)
+		}
+	}
+	if path := os.Getenv("MEMPOOL_FILE"); path != "" {
+		mu.Lock()
+		err := restoreMempool(path)
+		mu.Unlock()
+		if err != nil {
+			log.Fatalf("mempool file %s: %v", path, err)
+		}
 	}
-	genesis.Hash = calculateHash(genesis)
-	powChain = append(powChain, genesis)
 
 	addr := ":" + port
 	log.Printf("%s", chainBanner)
 	log.Printf("⚡ PoW node listening on %s", addr)
+	if len(peers) > 0 {
+		log.Printf("🤝 Gossiping transactions with peers: %v", peers)
+	}
+	if devMode {
+		log.Printf("🛠️  Dev mode: difficulty 1, every submitted transaction is mined at once")
+	}
+	if len(hybridValidators) > 0 {
+		log.Printf("🏁 Hybrid mode: checkpoint every %d blocks, attested by %v", checkpointInterval, hybridValidators)
+		go checkpointWorker()
+	}
+	if relayPosNode != "" {
+		log.Printf("🌉 Relaying PoS blocks from %s every %s", relayPosNode, relayInterval)
+		relayLoop()
+	}
 
 	if err := http.ListenAndServe(addr, makeRouter()); err != nil {
 		log.Fatalf("server error: %v", err)
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Mempool eviction policies.
+const (
+	evictLowestFee = "fee" // drop the cheapest transaction, oldest first on ties
+	evictOldest    = "age" // drop the transaction that has waited longest
+)
+
+// mempoolEntry is a pending transaction, its encoded size and when it
+// was received.
+type mempoolEntry struct {
+	Tx    Transaction
+	Size  int
+	Added time.Time
+}
+
+// MempoolPolicy bounds the mempool (MEMPOOL_MAX_TXS, MEMPOOL_MAX_BYTES,
+// MEMPOOL_EVICTION, MEMPOOL_TTL).
+type MempoolPolicy struct {
+	MaxTxs   int           `json:"maxTxs"`
+	MaxBytes int           `json:"maxBytes"`
+	Eviction string        `json:"eviction"`
+	TTL      time.Duration `json:"-"`
+}
+
+var (
+	mempool      = make(map[string]*mempoolEntry) // tx ID -> entry
+	mempoolBytes int
+
+	// pendingSpends maps a sender's nonce (see spendKey) to the pending
+	// transaction using it, so conflicting spends are caught on arrival.
+	pendingSpends = make(map[string]string)
+
+	mempoolPolicy = MempoolPolicy{
+		MaxTxs:   5000,
+		MaxBytes: 4 << 20,
+		Eviction: evictLowestFee,
+		TTL:      time.Hour,
+	}
+
+	// maxBlockTxs caps the transfers selected into one block
+	// (MAX_BLOCK_TXS).
+	maxBlockTxs = 100
+)
+
+// loadMempoolPolicy reads the mempool bounds from the environment.
+func loadMempoolPolicy() error {
+	for key, dst := range map[string]*int{
+		"MEMPOOL_MAX_TXS":   &mempoolPolicy.MaxTxs,
+		"MEMPOOL_MAX_BYTES": &mempoolPolicy.MaxBytes,
+	} {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q", key, v)
+			}
+			*dst = n
+		}
+	}
+	if v := os.Getenv("MEMPOOL_EVICTION"); v != "" {
+		if v != evictLowestFee && v != evictOldest {
+			return fmt.Errorf("MEMPOOL_EVICTION must be %q or %q", evictLowestFee, evictOldest)
+		}
+		mempoolPolicy.Eviction = v
+	}
+	if v := os.Getenv("MEMPOOL_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid MEMPOOL_TTL %q", v)
+		}
+		mempoolPolicy.TTL = d
+	}
+	return nil
+}
+
+var errMempoolFull = errors.New("mempool is full and the transaction ranks below every pending one")
+
+// txSize returns the encoded size of tx, which is what the byte limit
+// accounts for.
+func txSize(tx Transaction) int {
+	raw, _ := json.Marshal(tx)
+	return len(raw)
+}
+
+// spendKey identifies the sender nonce a transaction consumes. Two
+// transactions with the same key are a double spend.
+func spendKey(tx Transaction) string {
+	return tx.From + "|" + strconv.FormatUint(tx.Nonce, 10)
+}
+
+// removeFromMempool drops a transaction and its bytes. Callers must hold
+// mu.
+func removeFromMempool(id string) {
+	if e, ok := mempool[id]; ok {
+		mempoolBytes -= e.Size
+		delete(pendingSpends, spendKey(e.Tx))
+		delete(mempool, id)
+	}
+}
+
+// revalidateMempool drops pending transactions invalidated by the
+// current chain: nonces that have since been used, spends the sender can
+// no longer cover, contract locks or claims past their timeout, oracle
+// reports whose round has closed and schedules whose start has come. It
+// runs after every block is appended. Callers must hold mu.
+func revalidateMempool() {
+	state := ledgerState(powChain)
+	next := powChain[len(powChain)-1].Height + 1
+	for id, e := range mempool {
+		sender := state.account(e.Tx.From)
+		htlcErr := checkHTLC(e.Tx, next)
+		oracleErr := checkOracle(e.Tx, next)
+		schedErr := checkSchedule(e.Tx, next)
+		switch {
+		case e.Tx.Nonce < sender.Nonce:
+			log.Printf("🗑️  Dropped tx %s: nonce %d already used", id, e.Tx.Nonce)
+		case sender.available() < e.Tx.Amount+e.Tx.Fee:
+			log.Printf("🗑️  Dropped tx %s: sender can no longer cover it", id)
+		case htlcErr != nil && htlcErr != errRefundTooEarly:
+			log.Printf("🗑️  Dropped tx %s: %v", id, htlcErr)
+		case oracleErr != nil:
+			log.Printf("🗑️  Dropped tx %s: %v", id, oracleErr)
+		case schedErr != nil:
+			log.Printf("🗑️  Dropped tx %s: %v", id, schedErr)
+		default:
+			continue
+		}
+		removeFromMempool(id)
+	}
+}
+
+// expireMempool drops transactions older than the TTL. Callers must hold
+// mu.
+func expireMempool(now time.Time) {
+	if mempoolPolicy.TTL <= 0 {
+		return
+	}
+	for id, e := range mempool {
+		if now.Sub(e.Added) > mempoolPolicy.TTL {
+			log.Printf("⌛ Expired tx %s after %s", id, mempoolPolicy.TTL)
+			removeFromMempool(id)
+		}
+	}
+}
+
+// evictionCandidate returns the entry the policy would drop next.
+// Callers must hold mu.
+func evictionCandidate() *mempoolEntry {
+	var victim *mempoolEntry
+	for _, e := range mempool {
+		if victim == nil {
+			victim = e
+			continue
+		}
+		worse := e.Added.Before(victim.Added)
+		if mempoolPolicy.Eviction == evictLowestFee && e.Tx.Fee != victim.Tx.Fee {
+			worse = e.Tx.Fee < victim.Tx.Fee
+		}
+		if worse {
+			victim = e
+		}
+	}
+	return victim
+}
+
+// overLimit reports whether the mempool exceeds its count or byte bound.
+// Callers must hold mu.
+func overLimit() bool {
+	return (mempoolPolicy.MaxTxs > 0 && len(mempool) > mempoolPolicy.MaxTxs) ||
+		(mempoolPolicy.MaxBytes > 0 && mempoolBytes > mempoolPolicy.MaxBytes)
+}
+
+// addToMempool inserts tx and evicts entries per policy until the pool is
+// within bounds. If tx itself is evicted it is rejected with
+// errMempoolFull. Callers must hold mu.
+func addToMempool(tx Transaction, now time.Time) error {
+	expireMempool(now)
+
+	e := &mempoolEntry{Tx: tx, Size: txSize(tx), Added: now}
+	mempool[tx.ID] = e
+	mempoolBytes += e.Size
+	pendingSpends[spendKey(tx)] = tx.ID
+
+	for overLimit() {
+		victim := evictionCandidate()
+		removeFromMempool(victim.Tx.ID)
+		if victim == e {
+			return errMempoolFull
+		}
+		log.Printf("🧹 Evicted tx %s (fee=%d) to make room", victim.Tx.ID, victim.Tx.Fee)
+	}
+	return nil
+}
+
+// selectTransactions picks pending transfers for the next block, highest
+// fee first, skipping any that do not apply cleanly on top of state.
+// Several passes are made so that a sender's later nonces can follow
+// earlier ones regardless of fee order. The scheduled runs due in the
+// block come first and do not count against maxBlockTxs. Callers must
+// hold mu.
+func selectTransactions(state LedgerState) []Transaction {
+	expireMempool(clk.Now())
+
+	candidates := make([]*mempoolEntry, 0, len(mempool))
+	for _, e := range mempool {
+		candidates = append(candidates, e)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Tx.Fee != b.Tx.Fee {
+			return a.Tx.Fee > b.Tx.Fee
+		}
+		if !a.Added.Equal(b.Added) {
+			return a.Added.Before(b.Added)
+		}
+		return a.Tx.ID < b.Tx.ID
+	})
+
+	work := state.clone()
+	next := powChain[len(powChain)-1].Height + 1
+	runs := dueRuns(next)
+	for _, run := range runs {
+		_ = work.applyTransfer(run)
+	}
+	picked := make(map[string]bool)
+	var selected []Transaction
+	for progress := true; progress && len(selected) < maxBlockTxs; {
+		progress = false
+		for _, e := range candidates {
+			if picked[e.Tx.ID] || len(selected) >= maxBlockTxs {
+				continue
+			}
+			if checkHTLC(e.Tx, next) != nil || checkOracle(e.Tx, next) != nil ||
+				checkSchedule(e.Tx, next) != nil || work.applyTransfer(e.Tx) != nil {
+				continue
+			}
+			picked[e.Tx.ID] = true
+			selected = append(selected, e.Tx)
+			progress = true
+		}
+	}
+	return append(runs, selected...)
+}
+
+// removeIncluded drops the transactions of b from the mempool. Callers
+// must hold mu.
+func removeIncluded(b PowBlock) {
+	for _, tx := range b.Transactions {
+		removeFromMempool(tx.ID)
+	}
+}
+
+// acceptTx validates a signed transfer against the chain and adds it to
+// the mempool, filling in its ID. On failure it returns the HTTP status
+// that best describes the problem. Callers must hold mu.
+func acceptTx(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() || tx.To == "" || (tx.Amount == 0 && tx.Oracle == nil) {
+		return http.StatusBadRequest, errors.New("from, to and positive amount are required")
+	}
+	if isScheduledRun(*tx) {
+		return http.StatusBadRequest, errors.New("scheduled runs are added by block producers")
+	}
+	if tx.Vesting != nil {
+		return http.StatusBadRequest, errors.New("vesting terms are only valid at genesis")
+	}
+	tx.ID = txHash(*tx)
+	if err := verifyTxSignature(*tx); err != nil {
+		return http.StatusBadRequest, err
+	}
+	next := powChain[len(powChain)-1].Height + 1
+	if err := checkHTLC(*tx, next); err != nil && err != errRefundTooEarly {
+		return http.StatusBadRequest, err
+	}
+	if err := checkOracle(*tx, next); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := checkSchedule(*tx, next); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if _, ok := mempool[tx.ID]; ok {
+		return http.StatusConflict, errors.New("transaction already pending")
+	}
+	if other, ok := pendingSpends[spendKey(*tx)]; ok {
+		return http.StatusConflict, fmt.Errorf("nonce %d conflicts with pending transaction %s", tx.Nonce, other)
+	}
+	sender := ledgerState(powChain).account(tx.From)
+	if tx.Nonce < sender.Nonce {
+		return http.StatusConflict, errors.New("nonce already used")
+	}
+	if tx.Amount+tx.Fee < tx.Amount || sender.Spendable < tx.Amount+tx.Fee {
+		return http.StatusBadRequest, errors.New("insufficient spendable balance")
+	}
+	if sender.available() < tx.Amount+tx.Fee {
+		return http.StatusBadRequest, fmt.Errorf("only %d of the balance is vested", sender.available())
+	}
+	if err := addToMempool(*tx, clk.Now()); err != nil {
+		return http.StatusServiceUnavailable, err
+	}
+	journalTx(mempool[tx.ID])
+	log.Printf("📨 Accepted tx %s from=%.8s… nonce=%d fee=%d", tx.ID, tx.From, tx.Nonce, tx.Fee)
+	return http.StatusOK, nil
+}
+
+// submitTxHandler validates a signed transfer, adds it to the mempool and
+// announces it to peers. In dev mode it is mined before the response.
+func submitTxHandler(w http.ResponseWriter, r *http.Request) {
+	var tx Transaction
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	status, err := acceptTx(&tx)
+	mu.Unlock()
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), status)
+		return
+	}
+	go announceTxs([]string{tx.ID}, httpapi.RequestID(r))
+	if devMode {
+		devMine()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(tx)
+}
+
+// mempoolHandler lists pending transactions, highest fee first; with
+// ?address=, only those sent from or to it.
+func mempoolHandler(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+
+	mu.Lock()
+	expireMempool(clk.Now())
+	list := make([]Transaction, 0, len(mempool))
+	for _, e := range mempool {
+		if address == "" || e.Tx.From == address || e.Tx.To == address {
+			list = append(list, e.Tx)
+		}
+	}
+	mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Fee != list[j].Fee {
+			return list[i].Fee > list[j].Fee
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(list)
+}
+
+// NonceInfo is the body of GET /address/{address}/nonce.
+type NonceInfo struct {
+	Address   string   `json:"address"`
+	Confirmed uint64   `json:"confirmed"`      // next nonce on chain; lower ones are used
+	Next      uint64   `json:"next"`           // next nonce free of mined and pending transactions
+	Pending   []uint64 `json:"pending"`        // nonces of the address's pending transactions, ascending
+	Gaps      []uint64 `json:"gaps,omitempty"` // missing nonces below the highest pending one
+}
+
+// nonceHandler reports the nonces of an address, so that clients sending
+// several transactions at once can number them. Next counts on from the
+// confirmed nonce through the pending transactions without a gap; pending
+// transactions after a gap wait until it is filled.
+func nonceHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+
+	mu.Lock()
+	expireMempool(clk.Now())
+	info := NonceInfo{Address: addr, Pending: []uint64{}}
+	if b, ok := ledgerState(powChain)[addr]; ok {
+		info.Confirmed = b.Nonce
+	}
+	for _, e := range mempool {
+		if e.Tx.From == addr && e.Tx.Nonce >= info.Confirmed {
+			info.Pending = append(info.Pending, e.Tx.Nonce)
+		}
+	}
+	mu.Unlock()
+
+	sort.Slice(info.Pending, func(i, j int) bool { return info.Pending[i] < info.Pending[j] })
+	info.Next = info.Confirmed
+	for _, n := range info.Pending {
+		for ; info.Next < n; info.Next++ {
+			info.Gaps = append(info.Gaps, info.Next)
+		}
+		info.Next = n + 1
+	}
+	if len(info.Gaps) > 0 {
+		info.Next = info.Gaps[0]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(info)
+}
+
+// mempoolInfoHandler reports the mempool policy and current utilization.
+func mempoolInfoHandler(w http.ResponseWriter, r *http.Request) {
+	type Info struct {
+		Policy     MempoolPolicy `json:"policy"`
+		TTL        string        `json:"ttl"`
+		Count      int           `json:"count"`
+		Bytes      int           `json:"bytes"`
+		CountUsage float64       `json:"countUsage"` // fraction of MaxTxs in use
+		BytesUsage float64       `json:"bytesUsage"` // fraction of MaxBytes in use
+		MinFee     uint64        `json:"minFee"`
+	}
+
+	mu.Lock()
+	expireMempool(clk.Now())
+	resp := Info{
+		Policy: mempoolPolicy,
+		TTL:    mempoolPolicy.TTL.String(),
+		Count:  len(mempool),
+		Bytes:  mempoolBytes,
+	}
+	first := true
+	for _, e := range mempool {
+		if first || e.Tx.Fee < resp.MinFee {
+			resp.MinFee = e.Tx.Fee
+			first = false
+		}
+	}
+	mu.Unlock()
+
+	if resp.Policy.MaxTxs > 0 {
+		resp.CountUsage = float64(resp.Count) / float64(resp.Policy.MaxTxs)
+	}
+	if resp.Policy.MaxBytes > 0 {
+		resp.BytesUsage = float64(resp.Bytes) / float64(resp.Policy.MaxBytes)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Mempool journal (MEMPOOL_FILE). Every accepted transaction is appended
+// to this JSON Lines file with the time it was received. On startup,
+// once the chain is loaded, the journal is replayed through acceptTx, so
+// transactions mined or invalidated meanwhile are dropped, and then
+// rewritten with what is still pending. Transactions leaving the pool are
+// not journaled; the journal is rewritten whenever it holds more than
+// twice the pool.
+
+// journalSlack is how many stale lines the journal may hold on top of
+// twice the pool before it is rewritten.
+const journalSlack = 64
+
+// journalEntry is one line of the mempool journal.
+type journalEntry struct {
+	Added time.Time   `json:"added"`
+	Tx    Transaction `json:"tx"`
+}
+
+var (
+	mempoolFile    string
+	mempoolJournal *os.File // nil while the journal is off or replaying
+	journalLines   int
+)
+
+// restoreMempool replays MEMPOOL_FILE into the mempool and opens it for
+// appending. Callers must hold mu.
+func restoreMempool(path string) error {
+	mempoolFile = path
+	f, err := os.Open(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		restored, dropped := 0, 0
+		now := clk.Now()
+		dec := json.NewDecoder(bufio.NewReader(f))
+		for {
+			var e journalEntry
+			if err := dec.Decode(&e); err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("⚠️  Mempool journal %s ends in an unreadable entry: %v", path, err)
+				}
+				break
+			}
+			if mempoolPolicy.TTL > 0 && now.Sub(e.Added) > mempoolPolicy.TTL {
+				dropped++
+				continue
+			}
+			tx := e.Tx
+			if _, err := acceptTx(&tx); err != nil {
+				log.Printf("🗑️  Dropped journaled tx %s: %v", tx.ID, err)
+				dropped++
+				continue
+			}
+			mempool[tx.ID].Added = e.Added
+			restored++
+		}
+		f.Close()
+		log.Printf("📥 Restored %d pending transaction(s) from %s (%d dropped)", restored, path, dropped)
+	}
+	return rewriteMempoolJournal()
+}
+
+// rewriteMempoolJournal replaces the journal with the current pool,
+// oldest first, and reopens it for appending. Callers must hold mu.
+func rewriteMempoolJournal() error {
+	if mempoolFile == "" {
+		return nil
+	}
+	if mempoolJournal != nil {
+		mempoolJournal.Close()
+		mempoolJournal = nil
+	}
+	tmp := mempoolFile + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	entries := make([]*mempoolEntry, 0, len(mempool))
+	for _, e := range mempool {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Added.Equal(entries[j].Added) {
+			return entries[i].Added.Before(entries[j].Added)
+		}
+		return entries[i].Tx.ID < entries[j].Tx.ID
+	})
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(journalEntry{Added: e.Added, Tx: e.Tx}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, mempoolFile); err != nil {
+		return err
+	}
+	mempoolJournal, err = os.OpenFile(mempoolFile, os.O_WRONLY|os.O_APPEND, 0o644)
+	journalLines = len(mempool)
+	return err
+}
+
+// journalTx appends an accepted transaction to the journal. Callers must
+// hold mu.
+func journalTx(e *mempoolEntry) {
+	if mempoolJournal == nil {
+		return
+	}
+	line, _ := json.Marshal(journalEntry{Added: e.Added, Tx: e.Tx})
+	if _, err := mempoolJournal.Write(append(line, '\n')); err != nil {
+		log.Printf("⚠️  Mempool journal: %v", err)
+		return
+	}
+	journalLines++
+	if journalLines > 2*len(mempool)+journalSlack {
+		compactMempoolJournal()
+	}
+}
+
+// compactMempoolJournal rewrites the journal, logging a failure. Callers
+// must hold mu.
+func compactMempoolJournal() {
+	if err := rewriteMempoolJournal(); err != nil {
+		log.Printf("⚠️  Mempool journal: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// PendingTx answers GET /mempool/{id}: where a pending transaction stands
+// and, if the next block would leave it out, why.
+type PendingTx struct {
+	Tx        Transaction `json:"tx"`
+	Size      int         `json:"size"`
+	Added     string      `json:"added"`
+	Age       string      `json:"age"`
+	ExpiresAt string      `json:"expiresAt,omitempty"` // without a TTL, never
+	Rank      int         `json:"rank"`                // by fee among Pending, 1 for the highest
+	Pending   int         `json:"pending"`
+	NextBlock bool        `json:"nextBlock"` // selected for the next block template
+	Reason    string      `json:"reason,omitempty"`
+}
+
+// pendingReason explains why tx, which is pending, is not in next, the
+// transactions selected for the next block on top of state. Callers must
+// hold mu.
+func pendingReason(tx Transaction, state LedgerState, next []Transaction) string {
+	height := len(powChain)
+	if err := checkHTLC(tx, height); err != nil {
+		return err.Error()
+	}
+	if err := checkOracle(tx, height); err != nil {
+		return err.Error()
+	}
+	if err := checkSchedule(tx, height); err != nil {
+		return err.Error()
+	}
+	work := state.clone()
+	transfers := 0
+	for _, t := range next {
+		_ = work.applyTransfer(t)
+		if !isScheduledRun(t) {
+			transfers++
+		}
+	}
+	if err := work.applyTransfer(tx); err != nil {
+		return err.Error()
+	}
+	if transfers >= maxBlockTxs {
+		return fmt.Sprintf("the next block is full with %d transactions paying at least as much", transfers)
+	}
+	return "not selected"
+}
+
+// mempoolTxHandler reports a pending transaction.
+func mempoolTxHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	mu.Lock()
+	now := clk.Now()
+	expireMempool(now)
+	e, ok := mempool[id]
+	if !ok {
+		mu.Unlock()
+		httpapi.WriteError(w, "transaction not pending", http.StatusNotFound)
+		return
+	}
+	p := PendingTx{
+		Tx:      e.Tx,
+		Size:    e.Size,
+		Added:   e.Added.Format(time.RFC3339),
+		Age:     now.Sub(e.Added).Round(time.Second).String(),
+		Rank:    1,
+		Pending: len(mempool),
+	}
+	for _, other := range mempool {
+		if other.Tx.Fee > e.Tx.Fee || (other.Tx.Fee == e.Tx.Fee && other.Tx.ID < e.Tx.ID) {
+			p.Rank++
+		}
+	}
+	if mempoolPolicy.TTL > 0 {
+		p.ExpiresAt = e.Added.Add(mempoolPolicy.TTL).Format(time.RFC3339)
+	}
+	state := ledgerState(powChain)
+	next := selectTransactions(state)
+	for _, t := range next {
+		if t.ID == id {
+			p.NextBlock = true
+		}
+	}
+	if !p.NextBlock {
+		p.Reason = pendingReason(e.Tx, state, next)
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
+
+// deleteMempoolTxHandler drops a pending transaction (admin). Peers that
+// still hold it may announce it again.
+func deleteMempoolTxHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	mu.Lock()
+	e, ok := mempool[id]
+	if ok {
+		removeFromMempool(id)
+		compactMempoolJournal()
+	}
+	mu.Unlock()
+	if !ok {
+		httpapi.WriteError(w, "transaction not pending", http.StatusNotFound)
+		return
+	}
+	httpapi.LogRequest(r, "🗑️  Removed tx %s from the mempool", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(e.Tx)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+
+	"alirezachain/httpapi"
+)
+
+// messagePrefix domain-separates off-chain messages so that a message
+// signature can never double as a transaction signature.
+const messagePrefix = "AlirezaChain Signed Message:\n"
+
+// messageDigest returns the digest signed for an off-chain message.
+func messageDigest(message string) []byte {
+	h := sha256.Sum256([]byte(messagePrefix + message))
+	return h[:]
+}
+
+// SignedMessage is an off-chain message signed by an address's key, as
+// produced by `wallet sign`.
+type SignedMessage struct {
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// verifyMessageHandler checks that a message was signed by the key of an
+// address, proving ownership of the address.
+func verifyMessageHandler(w http.ResponseWriter, r *http.Request) {
+	var req SignedMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	pub, err := hex.DecodeString(req.Address)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		httpapi.WriteError(w, "address must be a hex-encoded ed25519 public key", http.StatusBadRequest)
+		return
+	}
+	sig, err := hex.DecodeString(req.Signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		httpapi.WriteError(w, "malformed signature", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{
+		"valid":   ed25519.Verify(ed25519.PublicKey(pub), messageDigest(req.Message), sig),
+		"address": req.Address,
+	})
+}
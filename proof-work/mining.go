@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+)
+
+// hashrateWindow is the default number of recent blocks the hashrate is
+// estimated over.
+const hashrateWindow = 120
+
+// HashrateEstimate is the network hashrate implied by recent blocks.
+type HashrateEstimate struct {
+	Hashrate     float64 `json:"hashrate"` // hashes per second
+	Blocks       int     `json:"blocks"`
+	Timespan     int64   `json:"timespan"`     // seconds
+	AvgBlockTime float64 `json:"avgBlockTime"` // seconds
+	Work         float64 `json:"work"`         // expected hashes
+	Difficulty   int     `json:"difficulty"`
+}
+
+// blockWork is the expected number of hashes needed to find b: the
+// size of the hash space over its target, 2^d at difficulty d.
+func blockWork(b PowBlock) float64 {
+	target := blockTarget(b)
+	if target == nil {
+		return 0
+	}
+	t, _ := new(big.Float).SetInt(target).Float64()
+	return math.Ldexp(1, hasher.Size()*8) / t
+}
+
+// estimateHashrate divides the expected work of the last n blocks by the
+// time they took. The genesis block is not mined, so it only serves as a
+// starting timestamp. Callers must hold mu.
+func estimateHashrate(n int) HashrateEstimate {
+	est := HashrateEstimate{Difficulty: defaultDifficulty}
+	tip := len(powChain) - 1
+	if n > tip {
+		n = tip
+	}
+	if n <= 0 {
+		return est
+	}
+
+	first := powChain[tip-n]
+	for _, b := range powChain[tip-n+1:] {
+		est.Work += blockWork(b)
+	}
+	est.Blocks = n
+	est.Timespan = powChain[tip].Timestamp - first.Timestamp
+	est.AvgBlockTime = float64(est.Timespan) / float64(n)
+	if est.Timespan > 0 {
+		est.Hashrate = est.Work / float64(est.Timespan)
+	}
+	return est
+}
+
+// hashrateHandler returns the estimated network hashrate over the last
+// ?blocks= blocks.
+func hashrateHandler(w http.ResponseWriter, r *http.Request) {
+	n := hashrateWindow
+	if v := r.URL.Query().Get("blocks"); v != "" {
+		b, err := strconv.Atoi(v)
+		if err != nil || b <= 0 {
+			httpapi.WriteError(w, "blocks must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		n = b
+	}
+
+	mu.Lock()
+	est := estimateHashrate(n)
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(est)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+)
+
+// multisigPrefix marks multisig addresses, which are derived from their
+// descriptor rather than being a public key themselves.
+const multisigPrefix = "ms"
+
+// maxMultisigKeys bounds N in an M-of-N account.
+const maxMultisigKeys = 16
+
+// MultisigAccount describes an M-of-N account: any Threshold of Keys
+// (hex-encoded ed25519 public keys) may spend from Address.
+type MultisigAccount struct {
+	Address   string   `json:"address"`
+	Threshold int      `json:"threshold"`
+	Keys      []string `json:"keys"`
+}
+
+// PartialSig is one co-signer's signature over a transaction ID.
+type PartialSig struct {
+	PubKey    string `json:"pubKey"`
+	Signature string `json:"signature"`
+}
+
+// multisigAddress derives the address of an M-of-N account. Keys are
+// sorted first so that the order they are listed in does not matter.
+func multisigAddress(threshold int, keys []string) string {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	h := sha256.Sum256([]byte(strconv.Itoa(threshold) + "|" + strings.Join(sorted, ",")))
+	return multisigPrefix + hex.EncodeToString(h[:])
+}
+
+// normalize validates the descriptor, sorts its keys and fills in the
+// address.
+func (m *MultisigAccount) normalize() error {
+	if len(m.Keys) == 0 || len(m.Keys) > maxMultisigKeys {
+		return fmt.Errorf("between 1 and %d keys are required", maxMultisigKeys)
+	}
+	if m.Threshold < 1 || m.Threshold > len(m.Keys) {
+		return fmt.Errorf("threshold must be between 1 and %d", len(m.Keys))
+	}
+	seen := make(map[string]bool, len(m.Keys))
+	for i, k := range m.Keys {
+		k = strings.ToLower(strings.TrimSpace(k))
+		pub, err := hex.DecodeString(k)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("key %d is not a hex-encoded ed25519 public key", i)
+		}
+		if seen[k] {
+			return fmt.Errorf("key %d is listed twice", i)
+		}
+		seen[k] = true
+		m.Keys[i] = k
+	}
+	sort.Strings(m.Keys)
+	m.Address = multisigAddress(m.Threshold, m.Keys)
+	return nil
+}
+
+// verifyMultisig checks that a transaction from a multisig account
+// carries the descriptor matching its sender and at least Threshold valid
+// signatures from distinct keys of that descriptor.
+func verifyMultisig(tx Transaction) error {
+	if tx.Multisig == nil {
+		return errors.New("multisig sender requires the account descriptor")
+	}
+	m := *tx.Multisig
+	m.Keys = append([]string(nil), m.Keys...)
+	if err := m.normalize(); err != nil {
+		return fmt.Errorf("multisig descriptor: %v", err)
+	}
+	if m.Address != tx.From {
+		return errors.New("multisig descriptor does not match sender")
+	}
+	digest, err := txSigningDigest(tx.ID)
+	if err != nil {
+		return err
+	}
+
+	member := make(map[string]bool, len(m.Keys))
+	for _, k := range m.Keys {
+		member[k] = true
+	}
+	valid := make(map[string]bool)
+	for _, ps := range tx.Signatures {
+		if !member[ps.PubKey] || valid[ps.PubKey] {
+			continue
+		}
+		pub, _ := hex.DecodeString(ps.PubKey)
+		sig, err := hex.DecodeString(ps.Signature)
+		if err != nil || len(sig) != ed25519.SignatureSize {
+			continue
+		}
+		if ed25519.Verify(ed25519.PublicKey(pub), digest, sig) {
+			valid[ps.PubKey] = true
+		}
+	}
+	if len(valid) < m.Threshold {
+		return fmt.Errorf("multisig has %d of %d required signatures", len(valid), m.Threshold)
+	}
+	return nil
+}
+
+// createMultisigHandler returns the descriptor, including the address,
+// of an M-of-N account.
+func createMultisigHandler(w http.ResponseWriter, r *http.Request) {
+	var m MultisigAccount
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := m.normalize(); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(m)
+}
+
+// aggregateRequest combines partially signed copies of one transaction.
+type aggregateRequest struct {
+	Multisig MultisigAccount `json:"multisig"`
+	Partials []Transaction   `json:"partials"`
+}
+
+// aggregateMultisigHandler merges the co-signers' signatures into one
+// transaction carrying the account descriptor and reports whether it is
+// complete, i.e. ready for POST /tx.
+func aggregateMultisigHandler(w http.ResponseWriter, r *http.Request) {
+	var req aggregateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if err := req.Multisig.normalize(); err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Partials) == 0 {
+		httpapi.WriteError(w, "at least one partial transaction is required", http.StatusBadRequest)
+		return
+	}
+
+	tx := req.Partials[0]
+	tx.Signature = ""
+	tx.Signatures = nil
+	tx.Multisig = &req.Multisig
+	if tx.From != req.Multisig.Address {
+		httpapi.WriteError(w, "transaction is not from the multisig address", http.StatusBadRequest)
+		return
+	}
+	if tx.ID != txHash(tx) {
+		httpapi.WriteError(w, "transaction id does not match its contents", http.StatusBadRequest)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i, p := range req.Partials {
+		if p.ID != tx.ID {
+			httpapi.WriteError(w, fmt.Sprintf("partial %d signs a different transaction", i), http.StatusBadRequest)
+			return
+		}
+		for _, ps := range p.Signatures {
+			if seen[ps.PubKey] {
+				continue
+			}
+			seen[ps.PubKey] = true
+			tx.Signatures = append(tx.Signatures, ps)
+		}
+	}
+	sort.Slice(tx.Signatures, func(i, j int) bool {
+		return tx.Signatures[i].PubKey < tx.Signatures[j].PubKey
+	})
+
+	resp := map[string]interface{}{"tx": tx, "complete": true}
+	if err := verifyMultisig(tx); err != nil {
+		resp["complete"] = false
+		resp["reason"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
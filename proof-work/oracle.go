@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Oracle data feeds. Keys listed in ORACLE_KEYS may post signed reports
+// of off-chain data, such as prices, as transactions to the reserved
+// oracleAddress. A report names a feed, the round it belongs to and a
+// value; rounds are ORACLE_ROUND_BLOCKS blocks long and a report must be
+// mined within its round. GET /oracle/{feed}/latest aggregates the
+// newest round with at least ORACLE_QUORUM reporting keys into their
+// median. Like HYBRID_VALIDATORS, every node must be given the same keys
+// and round length.
+
+// oracleAddress receives oracle reports. They carry no amount, only a
+// fee for the miner.
+const oracleAddress = "oracle"
+
+var (
+	// oracleKeys holds the hex ed25519 public keys allowed to report
+	// (ORACLE_KEYS).
+	oracleKeys = make(map[string]bool)
+
+	// oracleRoundBlocks is the length of a round in blocks
+	// (ORACLE_ROUND_BLOCKS).
+	oracleRoundBlocks = 10
+
+	// oracleQuorum is the number of distinct keys a round needs before
+	// it is aggregated (ORACLE_QUORUM).
+	oracleQuorum = 1
+
+	feedPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_./-]{0,31}$`)
+)
+
+// OracleReport is the data a report transaction carries.
+type OracleReport struct {
+	Feed  string  `json:"feed"`  // e.g. "btc-usd"
+	Round int     `json:"round"` // height / ORACLE_ROUND_BLOCKS of the block mining it
+	Value float64 `json:"value"`
+}
+
+// record is the encoding of the report that a transaction ID covers.
+func (o OracleReport) record() string {
+	return "oracle|" + o.Feed + "|" + strconv.Itoa(o.Round) + "|" + strconv.FormatFloat(o.Value, 'g', -1, 64)
+}
+
+// loadOracle reads ORACLE_KEYS, ORACLE_ROUND_BLOCKS and ORACLE_QUORUM.
+func loadOracle() error {
+	for _, k := range strings.Split(os.Getenv("ORACLE_KEYS"), ",") {
+		if k = strings.ToLower(strings.TrimSpace(k)); k == "" {
+			continue
+		}
+		if pub, err := hex.DecodeString(k); err != nil || len(pub) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid ORACLE_KEYS entry %q: want a hex ed25519 public key", k)
+		}
+		oracleKeys[k] = true
+	}
+	if v := os.Getenv("ORACLE_ROUND_BLOCKS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid ORACLE_ROUND_BLOCKS %q", v)
+		}
+		oracleRoundBlocks = n
+	}
+	if v := os.Getenv("ORACLE_QUORUM"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid ORACLE_QUORUM %q", v)
+		}
+		oracleQuorum = n
+	}
+	return nil
+}
+
+// oracleRound returns the round a block at height belongs to.
+func oracleRound(height int) int {
+	return height / oracleRoundBlocks
+}
+
+// checkOracle validates the report carried by tx for a block at height:
+// it must come from a whitelisted key, pay nothing to oracleAddress and
+// belong to the block's round. Transactions without a report must not
+// pay oracleAddress.
+func checkOracle(tx Transaction, height int) error {
+	if tx.Oracle == nil {
+		if tx.To == oracleAddress {
+			return errors.New("transfers to the oracle address must carry a report")
+		}
+		return nil
+	}
+	o := tx.Oracle
+	switch {
+	case !oracleKeys[tx.From]:
+		return errors.New("sender is not a whitelisted oracle key")
+	case tx.To != oracleAddress || tx.Amount != 0:
+		return fmt.Errorf("an oracle report must pay nothing to %q", oracleAddress)
+	case !feedPattern.MatchString(o.Feed):
+		return errors.New("feed must be 1-32 lowercase letters, digits or _ . / -")
+	case math.IsNaN(o.Value) || math.IsInf(o.Value, 0):
+		return errors.New("oracle value must be finite")
+	case o.Round != oracleRound(height):
+		return fmt.Errorf("oracle report is for round %d, the block is in round %d", o.Round, oracleRound(height))
+	}
+	return nil
+}
+
+// OracleValue is one key's report in an aggregated round.
+type OracleValue struct {
+	Oracle string  `json:"oracle"`
+	Value  float64 `json:"value"`
+	TxID   string  `json:"txId"`
+	Height int     `json:"height"`
+}
+
+// OracleRound answers GET /oracle/{feed}/latest.
+type OracleRound struct {
+	Feed       string        `json:"feed"`
+	Round      int           `json:"round"`
+	FromHeight int           `json:"fromHeight"`
+	ToHeight   int           `json:"toHeight"`
+	Final      bool          `json:"final"` // false while the round is still open
+	Median     float64       `json:"median"`
+	Reports    []OracleValue `json:"reports"` // one per key, its last in the round
+}
+
+// median returns the median of values, averaging the middle two of an
+// even count. values must not be empty.
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[mid]
+	}
+	return (sorted[mid-1] + sorted[mid]) / 2
+}
+
+// feedRounds collects the reports of feed per round, keeping each key's
+// last report in a round. Callers must hold mu.
+func feedRounds(feed string) map[int]map[string]OracleValue {
+	rounds := make(map[int]map[string]OracleValue)
+	for _, b := range powChain {
+		for _, tx := range b.Transactions {
+			if tx.Oracle == nil || tx.Oracle.Feed != feed {
+				continue
+			}
+			r := tx.Oracle.Round
+			if rounds[r] == nil {
+				rounds[r] = make(map[string]OracleValue)
+			}
+			rounds[r][tx.From] = OracleValue{Oracle: tx.From, Value: tx.Oracle.Value, TxID: tx.ID, Height: b.Height}
+		}
+	}
+	return rounds
+}
+
+// oracleLatestHandler aggregates the newest round of a feed that reached
+// the quorum.
+func oracleLatestHandler(w http.ResponseWriter, r *http.Request) {
+	feed := strings.ToLower(mux.Vars(r)["feed"])
+
+	mu.Lock()
+	rounds := feedRounds(feed)
+	current := oracleRound(len(powChain))
+	mu.Unlock()
+
+	best := -1
+	for round, reports := range rounds {
+		if len(reports) >= oracleQuorum && round > best {
+			best = round
+		}
+	}
+	if best < 0 {
+		httpapi.WriteError(w, "no round of this feed has reached the quorum", http.StatusNotFound)
+		return
+	}
+
+	resp := OracleRound{
+		Feed:       feed,
+		Round:      best,
+		FromHeight: best * oracleRoundBlocks,
+		ToHeight:   (best+1)*oracleRoundBlocks - 1,
+		Final:      best < current,
+	}
+	values := make([]float64, 0, len(rounds[best]))
+	for _, v := range rounds[best] {
+		resp.Reports = append(resp.Reports, v)
+		values = append(values, v.Value)
+	}
+	sort.Slice(resp.Reports, func(i, j int) bool { return resp.Reports[i].Oracle < resp.Reports[j].Oracle })
+	resp.Median = median(values)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
+
+// oracleHandler reports the oracle configuration, the current round and
+// the feeds reported so far.
+func oracleHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Keys        []string `json:"keys"`
+		RoundBlocks int      `json:"roundBlocks"`
+		Quorum      int      `json:"quorum"`
+		Round       int      `json:"round"` // of the next block
+		Feeds       []string `json:"feeds"`
+	}
+
+	resp := Response{Keys: []string{}, RoundBlocks: oracleRoundBlocks, Quorum: oracleQuorum, Feeds: []string{}}
+	for k := range oracleKeys {
+		resp.Keys = append(resp.Keys, k)
+	}
+	sort.Strings(resp.Keys)
+
+	mu.Lock()
+	resp.Round = oracleRound(len(powChain))
+	seen := make(map[string]bool)
+	for _, b := range powChain {
+		for _, tx := range b.Transactions {
+			if tx.Oracle != nil && !seen[tx.Oracle.Feed] {
+				seen[tx.Oracle.Feed] = true
+				resp.Feeds = append(resp.Feeds, tx.Oracle.Feed)
+			}
+		}
+	}
+	mu.Unlock()
+	sort.Strings(resp.Feeds)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+)
+
+// DifficultyParams describes how the difficulty of a block is set.
+type DifficultyParams struct {
+	// Algorithm is "miner" before the retarget fork (any difficulty the
+	// hash meets), "step" under retarget alone (one up or down towards
+	// TargetBlockTime), or DIFFICULTY_ALGORITHM once compactbits is also
+	// active.
+	Algorithm     string `json:"algorithm"`
+	Encoding      string `json:"encoding"` // "leading-zeros" or "compact-bits"
+	Default       int    `json:"default"`  // what this node mines at when the miner decides
+	Max           int    `json:"max"`      // most a retarget can require
+	LWMAWindow    int    `json:"lwmaWindow,omitempty"`
+	ASERTHalfLife string `json:"asertHalfLife,omitempty"`
+
+	// NextDifficulty or NextBits is what the next block must carry; set
+	// only when asking about the next block.
+	NextDifficulty int    `json:"nextDifficulty,omitempty"`
+	NextBits       string `json:"nextBits,omitempty"`
+}
+
+// ConsensusParams answers GET /params: the rules a block at Height is
+// produced and validated by.
+type ConsensusParams struct {
+	Height           int              `json:"height"`
+	Reward           uint64           `json:"reward"`
+	Emission         EmissionSchedule `json:"emission"`
+	TargetBlockTime  string           `json:"targetBlockTime"`
+	MinBlockInterval string           `json:"minBlockInterval"`
+	MaxClockDrift    string           `json:"maxClockDrift,omitempty"` // only checked with a minimum interval
+	MaxBlockTxs      int              `json:"maxBlockTxs"`             // transfers this node selects into a block
+	CoinbaseMaturity int              `json:"coinbaseMaturity"`
+	HashAlgorithm    string           `json:"hashAlgorithm"`
+	Difficulty       DifficultyParams `json:"difficulty"`
+	Forks            []string         `json:"forks"` // active at Height
+}
+
+// difficultyAlgorithmAt returns the difficulty rule in force at height.
+func difficultyAlgorithmAt(height int) string {
+	switch {
+	case !forkActive(forkRetarget, height):
+		return "miner"
+	case !forkActive(forkCompactBits, height):
+		return "step"
+	}
+	return difficultyAlgorithm
+}
+
+// paramsHandler reports the consensus parameters in force for the next
+// block, or for the block at ?height=.
+func paramsHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	next := powChain[len(powChain)-1].Height + 1
+	difficulty, bits := nextWork(powChain, defaultDifficulty)
+	blockTxs := maxBlockTxs
+	mu.Unlock()
+
+	height := next
+	if v := r.URL.Query().Get("height"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			httpapi.WriteError(w, "height must be a positive block height", http.StatusBadRequest)
+			return
+		}
+		height = n
+	}
+
+	p := ConsensusParams{
+		Height:           height,
+		Reward:           emission.rewardAt(height),
+		Emission:         emission,
+		TargetBlockTime:  targetBlockTime.String(),
+		MinBlockInterval: minBlockInterval.String(),
+		MaxBlockTxs:      blockTxs,
+		CoinbaseMaturity: coinbaseMaturity,
+		HashAlgorithm:    hasher.Name(),
+		Difficulty: DifficultyParams{
+			Algorithm: difficultyAlgorithmAt(height),
+			Encoding:  "leading-zeros",
+			Default:   defaultDifficulty,
+			Max:       maxRetargetDifficulty,
+		},
+		Forks: activeForks(height),
+	}
+	if minBlockInterval > 0 {
+		p.MaxClockDrift = maxClockDrift.String()
+	}
+	if p.Forks == nil {
+		p.Forks = []string{}
+	}
+	if forkActive(forkCompactBits, height) {
+		p.Difficulty.Encoding = "compact-bits"
+	}
+	switch p.Difficulty.Algorithm {
+	case daaLWMA:
+		p.Difficulty.LWMAWindow = lwmaWindow
+	case daaASERT:
+		p.Difficulty.ASERTHalfLife = asertHalfLife.String()
+	}
+	if height == next {
+		if bits != 0 {
+			p.Difficulty.NextBits = fmt.Sprintf("%08x", bits)
+		} else {
+			p.Difficulty.NextDifficulty = difficulty
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(p)
+}
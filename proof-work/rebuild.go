@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"alirezachain/httpapi"
+)
+
+// maxStateDiffs caps how many divergences a StateReport lists.
+const maxStateDiffs = 100
+
+// StateDiff is a divergence between replayed and stored state.
+type StateDiff struct {
+	Height   int    `json:"height"`
+	Field    string `json:"field"`
+	Stored   string `json:"stored"`
+	Replayed string `json:"replayed"`
+}
+
+// StateReport is the result of replaying the chain from genesis.
+type StateReport struct {
+	OK          bool        `json:"ok"`
+	Blocks      int         `json:"blocks"`
+	Accounts    int         `json:"accounts"`
+	StateRoot   string      `json:"stateRoot"` // of the replayed state at the tip
+	Divergences int         `json:"divergences"`
+	Diffs       []StateDiff `json:"diffs,omitempty"` // the first maxStateDiffs
+}
+
+// add records a divergence.
+func (r *StateReport) add(d StateDiff) {
+	r.Divergences++
+	if len(r.Diffs) < maxStateDiffs {
+		r.Diffs = append(r.Diffs, d)
+	}
+}
+
+// rebuildState replays chain block by block, recomputing balances and
+// nonces, and compares the state after each block with the state root
+// stored in its header.
+func rebuildState(chain []PowBlock) StateReport {
+	rep := StateReport{Blocks: len(chain)}
+	for i, b := range chain {
+		var post LedgerState
+		if i == 0 {
+			post = ledgerState(chain[:1])
+		} else {
+			post = ledgerState(chain[:i])
+			if err := post.applyBlock(b); err != nil {
+				rep.add(StateDiff{Height: b.Height, Field: "transactions", Stored: "applied", Replayed: "rejected: " + err.Error()})
+				continue
+			}
+		}
+		if root := post.root(); root != b.StateRoot {
+			rep.add(StateDiff{Height: b.Height, Field: "stateRoot", Stored: b.StateRoot, Replayed: root})
+		}
+	}
+	final := ledgerState(chain)
+	rep.Accounts = len(final)
+	rep.StateRoot = final.root()
+	rep.OK = rep.Divergences == 0
+	return rep
+}
+
+// rebuildStateHandler replays the node's chain and reports every block
+// whose state root the replay does not reproduce.
+func rebuildStateHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	chain, pruned := powChain, synced != nil
+	mu.Unlock()
+	if pruned {
+		httpapi.WriteError(w, errPruned.Error(), http.StatusConflict)
+		return
+	}
+	rep := rebuildState(chain)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+}
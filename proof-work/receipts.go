@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Receipt statuses. Blocks only ever contain transactions that applied
+// cleanly, so every recorded receipt is successful.
+const receiptSuccess = "success"
+
+// TxEvent is something a transaction did, e.g. minting or a transfer.
+type TxEvent struct {
+	Type   string `json:"type"` // "mint" or "transfer"
+	From   string `json:"from,omitempty"`
+	To     string `json:"to"`
+	Amount uint64 `json:"amount"`
+}
+
+// BalanceChange is the net effect of a transaction on one account.
+type BalanceChange struct {
+	Address string `json:"address"`
+	Delta   int64  `json:"delta"`
+}
+
+// Receipt records the outcome of a confirmed transaction.
+type Receipt struct {
+	TxID           string          `json:"txId"`
+	Status         string          `json:"status"`
+	BlockHeight    int             `json:"blockHeight"`
+	BlockHash      string          `json:"blockHash"`
+	Index          int             `json:"index"`
+	FeePaid        uint64          `json:"feePaid"`
+	Events         []TxEvent       `json:"events"`
+	BalanceChanges []BalanceChange `json:"balanceChanges"`
+	Confirmations  int             `json:"confirmations"`
+	Finalized      bool            `json:"finalized,omitempty"` // attested in hybrid mode
+}
+
+// receipts holds the receipt of every confirmed transaction, by ID.
+// Guarded by mu.
+var receipts = make(map[string]Receipt)
+
+// recordReceipts stores a receipt for every transaction in b. Callers
+// must hold mu.
+func recordReceipts(b PowBlock) {
+	for i, tx := range b.Transactions {
+		rc := Receipt{
+			TxID:        tx.ID,
+			Status:      receiptSuccess,
+			BlockHeight: b.Height,
+			BlockHash:   b.Hash,
+			Index:       i,
+		}
+		if tx.IsCoinbase() {
+			rc.Events = []TxEvent{{Type: "mint", To: tx.To, Amount: tx.Amount}}
+			rc.BalanceChanges = []BalanceChange{{Address: tx.To, Delta: int64(tx.Amount)}}
+		} else {
+			rc.FeePaid = tx.Fee
+			rc.Events = []TxEvent{{Type: "transfer", From: tx.From, To: tx.To, Amount: tx.Amount}}
+			rc.BalanceChanges = []BalanceChange{
+				{Address: tx.From, Delta: -int64(tx.Amount + tx.Fee)},
+				{Address: tx.To, Delta: int64(tx.Amount)},
+			}
+		}
+		receipts[tx.ID] = rc
+	}
+}
+
+// receiptHandler returns the receipt of a confirmed transaction.
+func receiptHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	mu.Lock()
+	rc, ok := receipts[id]
+	_, pending := mempool[id]
+	tip := powChain[len(powChain)-1].Height
+	final := ok && isFinal(rc.BlockHeight)
+	mu.Unlock()
+
+	if !ok {
+		if pending {
+			httpapi.WriteError(w, "transaction is still pending", http.StatusNotFound)
+			return
+		}
+		httpapi.WriteError(w, "receipt not found", http.StatusNotFound)
+		return
+	}
+	rc.Confirmations = tip - rc.BlockHeight + 1
+	rc.Finalized = final
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rc)
+}
+
+// LogEntry is a receipt event located in the chain.
+type LogEntry struct {
+	BlockHeight int    `json:"blockHeight"`
+	BlockHash   string `json:"blockHash"`
+	TxID        string `json:"txId"`
+	LogIndex    int    `json:"logIndex"` // position among the block's events
+	TxEvent
+}
+
+// parseHeight reads an optional block height query parameter.
+func parseHeight(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		return def, nil
+	}
+	h, err := strconv.Atoi(s)
+	if err != nil || h < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative block height", name)
+	}
+	return h, nil
+}
+
+// logsHandler returns the events emitted in blocks from..to (inclusive,
+// defaulting to the whole chain), optionally filtered by an address that
+// appears as sender or recipient and by event type (topic).
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	address := q.Get("address")
+	topic := q.Get("topic")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	tip := powChain[len(powChain)-1].Height
+	from, err := parseHeight(r, "from", 0)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseHeight(r, "to", tip)
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to > tip {
+		to = tip
+	}
+
+	logs := []LogEntry{}
+	for h := from; h <= to; h++ {
+		b := powChain[h]
+		idx := 0
+		for _, tx := range b.Transactions {
+			for _, ev := range receipts[tx.ID].Events {
+				idx++
+				if topic != "" && ev.Type != topic {
+					continue
+				}
+				if address != "" && ev.From != address && ev.To != address {
+					continue
+				}
+				logs = append(logs, LogEntry{
+					BlockHeight: b.Height,
+					BlockHash:   b.Hash,
+					TxID:        tx.ID,
+					LogIndex:    idx - 1,
+					TxEvent:     ev,
+				})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(logs)
+}
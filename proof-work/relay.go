@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Cross-chain relay. With RELAY_POS_NODE set, the node asks that PoS
+// node for its latest block every RELAY_INTERVAL and anchors the block's
+// hash like any document digest (see anchor.go), so the next mined block
+// commits to it. GET /relay/pos/{hash} then proves the PoS block existed
+// by that block's time. The PoS node relays PoW blocks the other way
+// (see proof-stake/relay.go).
+
+var (
+	// relayPosNode is the PoS node whose blocks are relayed
+	// (RELAY_POS_NODE); "" relays nothing.
+	relayPosNode string
+
+	// relayInterval is the time between relays (RELAY_INTERVAL).
+	relayInterval = 30 * time.Second
+
+	// relayedPoS maps each relayed PoS block hash to its height, and
+	// lastRelayed is the newest one. Guarded by mu.
+	relayedPoS  = make(map[string]int)
+	lastRelayed *RelayedBlock
+
+	relayClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+// RelayedBlock is a block of the other chain handed to this one.
+type RelayedBlock struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+	Time   string `json:"time"` // when it was relayed
+}
+
+// PoSRelayProof answers GET /relay/pos/{hash}.
+type PoSRelayProof struct {
+	PosHeight int          `json:"posHeight,omitempty"` // unknown for digests anchored through POST /anchor
+	PosHash   string       `json:"posHash"`
+	Anchor    AnchorRecord `json:"anchor"`
+}
+
+// loadRelay reads RELAY_POS_NODE and RELAY_INTERVAL.
+func loadRelay() error {
+	relayPosNode = strings.TrimRight(os.Getenv("RELAY_POS_NODE"), "/")
+	if relayPosNode != "" && !strings.HasPrefix(relayPosNode, "http://") && !strings.HasPrefix(relayPosNode, "https://") {
+		return fmt.Errorf("invalid RELAY_POS_NODE %q: want an http(s) URL", relayPosNode)
+	}
+	if v := os.Getenv("RELAY_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid RELAY_INTERVAL %q: want a duration like 30s", v)
+		}
+		relayInterval = d
+	}
+	return nil
+}
+
+// relayLoop relays the PoS node's latest block every relayInterval.
+func relayLoop() {
+	clk.AfterFunc(relayInterval, func() {
+		if err := relayOnce(); err != nil {
+			log.Printf("⚠️  Relay from %s failed: %v", relayPosNode, err)
+		}
+		relayLoop()
+	})
+}
+
+// relayOnce queues the PoS node's latest block for anchoring unless it
+// was relayed before.
+func relayOnce() error {
+	resp, err := relayClient.Get(relayPosNode + "/info")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PoS node answered %s", resp.Status)
+	}
+	var info struct {
+		Blocks   int    `json:"blocks"`
+		LastHash string `json:"lastHash"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return err
+	}
+	digest, err := parseDigest(info.LastHash)
+	if err != nil {
+		return fmt.Errorf("PoS block hash: %v", err)
+	}
+
+	mu.Lock()
+	if _, ok := relayedPoS[digest]; ok {
+		mu.Unlock()
+		return nil
+	}
+	if _, ok := lookupAnchor(digest); !ok {
+		if len(pendingAnchors) >= maxPendingAnchors {
+			mu.Unlock()
+			return errors.New("anchor queue is full")
+		}
+		pendingAnchors = append(pendingAnchors, digest)
+	}
+	relayedPoS[digest] = info.Blocks - 1
+	lastRelayed = &RelayedBlock{Height: info.Blocks - 1, Hash: digest, Time: clk.Now().Format(time.RFC3339)}
+	mu.Unlock()
+
+	log.Printf("🌉 Relaying PoS block %d (%s)", info.Blocks-1, digest)
+	if devMode {
+		devMine()
+	}
+	return nil
+}
+
+// relayHandler reports the relay configuration and the newest relayed
+// PoS block.
+func relayHandler(w http.ResponseWriter, r *http.Request) {
+	type Response struct {
+		Source   string        `json:"source"` // RELAY_POS_NODE; "" when off
+		Interval string        `json:"interval"`
+		Relayed  int           `json:"relayed"`
+		Last     *RelayedBlock `json:"last,omitempty"`
+	}
+
+	mu.Lock()
+	resp := Response{Source: relayPosNode, Interval: relayInterval.String(), Relayed: len(relayedPoS), Last: lastRelayed}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(resp)
+}
+
+// relayProofHandler proves that a PoS block hash was anchored in this
+// chain, with the Merkle proof up to the anchor root of its block.
+func relayProofHandler(w http.ResponseWriter, r *http.Request) {
+	digest, err := parseDigest(mux.Vars(r)["hash"])
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	mu.Lock()
+	rec, ok := lookupAnchor(digest)
+	proof := PoSRelayProof{PosHeight: relayedPoS[digest], PosHash: digest, Anchor: rec}
+	mu.Unlock()
+	if !ok {
+		httpapi.WriteError(w, "PoS block was not relayed to this chain", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if rec.Status == "pending" {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(proof)
+}
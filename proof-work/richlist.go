@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"alirezachain/httpapi"
+)
+
+// maxRichlistLimit caps GET /richlist?limit=.
+const maxRichlistLimit = 1000
+
+// Holder is one entry of the rich list.
+type Holder struct {
+	Rank    int     `json:"rank"`
+	Address string  `json:"address"`
+	Balance uint64  `json:"balance"` // spendable plus immature
+	Share   float64 `json:"share"`   // percent of minted supply
+}
+
+// richIndex holds every funded account sorted by balance. It is rebuilt
+// from the ledger whenever the tip changes. Guarded by mu.
+var richIndex struct {
+	tip     string
+	minted  uint64
+	holders []Holder
+}
+
+// richlist returns the up-to-date index. Callers must hold mu.
+func richlist() []Holder {
+	tip := powChain[len(powChain)-1].Hash
+	if richIndex.tip == tip {
+		return richIndex.holders
+	}
+
+	var minted uint64
+	holders := make([]Holder, 0)
+	for addr, bal := range ledgerState(powChain) {
+		total := bal.Spendable + bal.Immature
+		if total == 0 {
+			continue
+		}
+		minted += total
+		holders = append(holders, Holder{Address: addr, Balance: total})
+	}
+	sort.Slice(holders, func(i, j int) bool {
+		if holders[i].Balance != holders[j].Balance {
+			return holders[i].Balance > holders[j].Balance
+		}
+		return holders[i].Address < holders[j].Address
+	})
+	for i := range holders {
+		holders[i].Rank = i + 1
+		holders[i].Share = float64(holders[i].Balance) * 100 / float64(minted)
+	}
+
+	richIndex.tip = tip
+	richIndex.minted = minted
+	richIndex.holders = holders
+	return holders
+}
+
+// richlistHandler lists the largest balances (?limit=, default 10).
+func richlistHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 10
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxRichlistLimit {
+			httpapi.WriteError(w, "limit must be between 1 and "+strconv.Itoa(maxRichlistLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	mu.Lock()
+	holders := richlist()
+	minted := richIndex.minted
+	total := len(holders)
+	if len(holders) > limit {
+		holders = holders[:limit]
+	}
+	holders = append([]Holder(nil), holders...)
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(map[string]interface{}{
+		"holders":  holders,
+		"accounts": total,
+		"minted":   minted,
+	})
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Scheduled transfers. A schedule transaction escrows Amount × Count
+// from its sender at a schedule address derived from its terms. From
+// height Start on, every Every blocks, Count times, the escrow pays
+// Amount to Recipient through a run: an unsigned transaction carrying
+// the same terms, with the run's index as nonce and no fee. Block
+// producers add due runs ahead of mempool transactions (see
+// selectTransactions) and appendBlock rejects a block that leaves one
+// out, so a schedule runs without anyone resubmitting it. Schedules
+// cannot be cancelled.
+
+// schedulePrefix marks schedule addresses.
+const schedulePrefix = "sched"
+
+// maxScheduleRuns caps the runs of one schedule.
+const maxScheduleRuns = 1000
+
+// Schedule holds the terms of a scheduled transfer. The schedule
+// transaction and each of its runs carry them.
+type Schedule struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Amount    uint64 `json:"amount"`          // paid per run
+	Start     int    `json:"start"`           // height of the first run
+	Every     int    `json:"every,omitempty"` // blocks between runs; 0 for a single run
+	Count     int    `json:"count"`           // number of runs
+	Nonce     uint64 `json:"nonce"`           // the sender's nonce for the schedule transaction
+}
+
+// address derives the schedule address from its terms.
+func (s Schedule) address() string {
+	h := sha256.Sum256([]byte(s.record()))
+	return schedulePrefix + hex.EncodeToString(h[:])
+}
+
+// record is the encoding of the terms that a transaction ID covers.
+func (s Schedule) record() string {
+	return "sched|" + s.Sender + "|" + s.Recipient + "|" +
+		strconv.FormatUint(s.Amount, 10) + "|" +
+		strconv.Itoa(s.Start) + "|" +
+		strconv.Itoa(s.Every) + "|" +
+		strconv.Itoa(s.Count) + "|" +
+		strconv.FormatUint(s.Nonce, 10)
+}
+
+// runAt returns the height of run k.
+func (s Schedule) runAt(k int) int {
+	return s.Start + k*s.Every
+}
+
+// runIndex returns the index of the run due at height, if any.
+func (s Schedule) runIndex(height int) (int, bool) {
+	if height < s.Start {
+		return 0, false
+	}
+	if s.Every == 0 {
+		return 0, height == s.Start
+	}
+	k := (height - s.Start) / s.Every
+	return k, k < s.Count && s.runAt(k) == height
+}
+
+// pending reports whether runs remain after height.
+func (s Schedule) pending(height int) bool {
+	return s.runAt(s.Count-1) > height
+}
+
+// total returns Amount × Count, or false if it overflows.
+func (s Schedule) total() (uint64, bool) {
+	t := s.Amount * uint64(s.Count)
+	return t, t/uint64(s.Count) == s.Amount
+}
+
+// run builds run k of the schedule.
+func (s Schedule) run(k int) Transaction {
+	terms := s
+	tx := Transaction{From: s.address(), To: s.Recipient, Amount: s.Amount, Nonce: uint64(k), Schedule: &terms}
+	tx.ID = txHash(tx)
+	return tx
+}
+
+// isScheduledRun reports whether tx is a run of a schedule.
+func isScheduledRun(tx Transaction) bool {
+	return strings.HasPrefix(tx.From, schedulePrefix)
+}
+
+// checkSchedule validates the terms carried by tx for a block at height:
+// a schedule transaction must escrow every run at the address of its
+// terms before the first run is due, and a run must pay one run's
+// amount to the recipient, without a fee, at the height it is due.
+// Transactions without terms must not pay or spend a schedule address.
+func checkSchedule(tx Transaction, height int) error {
+	if tx.Schedule == nil {
+		if strings.HasPrefix(tx.To, schedulePrefix) || isScheduledRun(tx) {
+			return errors.New("transfers to or from a schedule must carry its terms")
+		}
+		return nil
+	}
+	s := *tx.Schedule
+	switch {
+	case s.Amount == 0 || s.Count < 1 || s.Count > maxScheduleRuns:
+		return fmt.Errorf("a schedule must pay a positive amount 1 to %d times", maxScheduleRuns)
+	case s.Every < 0 || (s.Count > 1 && s.Every == 0):
+		return errors.New("a repeating schedule must run every one block or more")
+	case s.Recipient == "" || s.Recipient == oracleAddress ||
+		strings.HasPrefix(s.Recipient, htlcPrefix) || strings.HasPrefix(s.Recipient, schedulePrefix):
+		return errors.New("a schedule must pay an account, not a contract")
+	}
+
+	if !isScheduledRun(tx) {
+		total, ok := s.total()
+		switch {
+		case tx.From != s.Sender || tx.Nonce != s.Nonce:
+			return errors.New("a schedule must be created by its sender with the nonce in its terms")
+		case tx.To != s.address():
+			return errors.New("a schedule must pay its schedule address")
+		case !ok || tx.Amount != total:
+			return errors.New("a schedule must escrow amount × count")
+		case s.Start <= height:
+			return fmt.Errorf("schedule starts at height %d, which is not after %d", s.Start, height)
+		}
+		return nil
+	}
+
+	k, due := s.runIndex(height)
+	switch {
+	case tx.From != s.address():
+		return errors.New("schedule terms do not match the schedule address")
+	case tx.To != s.Recipient || tx.Amount != s.Amount || tx.Fee != 0:
+		return errors.New("a scheduled run must pay the amount to the recipient without a fee")
+	case !due || tx.Nonce != uint64(k):
+		return fmt.Errorf("run %d of the schedule is not due at height %d", tx.Nonce, height)
+	}
+	return nil
+}
+
+// schedules returns the terms of every schedule created on chain,
+// including those a fast-synced node took from its snapshot. Callers
+// must hold mu.
+func schedules(chain []PowBlock) []Schedule {
+	var all []Schedule
+	if synced != nil {
+		all = append(all, synced.schedules...)
+	}
+	for _, b := range chain {
+		for _, tx := range b.Transactions {
+			if tx.Schedule != nil && !isScheduledRun(tx) {
+				all = append(all, *tx.Schedule)
+			}
+		}
+	}
+	return all
+}
+
+// dueRuns returns the runs due in the block at height on top of the
+// current chain. Callers must hold mu.
+func dueRuns(height int) []Transaction {
+	var runs []Transaction
+	for _, s := range schedules(powChain) {
+		if k, ok := s.runIndex(height); ok {
+			runs = append(runs, s.run(k))
+		}
+	}
+	return runs
+}
+
+// checkEscrows verifies the schedules of a snapshot at height against
+// its verified state: every schedule address holding funds needs terms,
+// from the snapshot or a schedule transaction in the window, whose
+// remaining runs account for the balance, and every schedule in the
+// snapshot must hold funds.
+func checkEscrows(state LedgerState, snap, window []Schedule, height int) error {
+	terms := make(map[string]Schedule)
+	for _, s := range window {
+		terms[s.address()] = s
+	}
+	for _, s := range snap {
+		bal := state[s.address()]
+		if bal == nil || bal.Spendable == 0 {
+			return fmt.Errorf("schedule %s holds no escrow", s.address())
+		}
+		terms[s.address()] = s
+	}
+	for addr, bal := range state {
+		if !strings.HasPrefix(addr, schedulePrefix) || bal.Spendable == 0 {
+			continue
+		}
+		s, ok := terms[addr]
+		if !ok {
+			return fmt.Errorf("schedule %s has no terms", addr)
+		}
+		runs := int(bal.Nonce)
+		if runs >= s.Count || bal.Spendable != s.Amount*uint64(s.Count-runs) || s.runAt(runs) <= height {
+			return fmt.Errorf("schedule %s does not hold what its terms leave to run", addr)
+		}
+	}
+	return nil
+}
+
+// checkDueRuns verifies that b, which extends the tip, carries exactly
+// the runs due at its height. Callers must hold mu.
+func checkDueRuns(b PowBlock) error {
+	have := make(map[string]bool)
+	for _, tx := range b.Transactions {
+		if isScheduledRun(tx) {
+			have[tx.ID] = true
+		}
+	}
+	due := dueRuns(b.Height)
+	for _, run := range due {
+		if !have[run.ID] {
+			return fmt.Errorf("block leaves out run %d of schedule %s", run.Nonce, run.From)
+		}
+	}
+	if len(have) != len(due) {
+		return errors.New("block carries a scheduled run that is not due")
+	}
+	return nil
+}
+
+// ScheduleStatus answers GET /schedule/{address}.
+type ScheduleStatus struct {
+	Address  string   `json:"address"`
+	Status   string   `json:"status"` // "pending", "running" or "completed"
+	Terms    Schedule `json:"terms"`
+	Runs     int      `json:"runs"`              // completed so far
+	NextRun  int      `json:"nextRun,omitempty"` // height of the next run
+	Escrowed uint64   `json:"escrowed"`          // still held for later runs
+	Height   int      `json:"height"`            // of the chain tip
+}
+
+// scheduleHandler reports how far a schedule has run.
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	addr := strings.ToLower(mux.Vars(r)["address"])
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	st := ScheduleStatus{Address: addr, Height: powChain[len(powChain)-1].Height}
+	found := false
+	for _, s := range schedules(powChain) {
+		if s.address() == addr {
+			st.Terms, found = s, true
+			break
+		}
+	}
+	if !found {
+		httpapi.WriteError(w, "no schedule was created at this address", http.StatusNotFound)
+		return
+	}
+	if bal, ok := ledgerState(powChain)[addr]; ok {
+		st.Runs, st.Escrowed = int(bal.Nonce), bal.Spendable
+	}
+	switch {
+	case st.Runs >= st.Terms.Count:
+		st.Status = "completed"
+	case st.Runs == 0:
+		st.Status, st.NextRun = "pending", st.Terms.runAt(0)
+	default:
+		st.Status, st.NextRun = "running", st.Terms.runAt(st.Runs)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(st)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"alirezachain/httpapi"
+)
+
+// ForkDetected answers GET /chain?since_hash= with 409 Conflict when the
+// hash is not on this node's chain.
+type ForkDetected struct {
+	Error   string `json:"error"`
+	Height  int    `json:"height"` // where the chains disagree; 0 unless since_height was given
+	Tip     int    `json:"tip"`
+	TipHash string `json:"tipHash"`
+}
+
+// sinceStart returns the index of the first block GET /chain should
+// serve: 0 without ?since_hash=, otherwise the block after that hash.
+// ?since_height= names the height the requester has the hash at, which
+// makes the lookup direct and lets a fork be located. fork is set if the
+// hash is not on chain. Callers must hold mu.
+func sinceStart(r *http.Request, chain []PowBlock) (start int, fork *ForkDetected, ok bool) {
+	hash := r.URL.Query().Get("since_hash")
+	if hash == "" {
+		return 0, nil, true
+	}
+	height := -1
+	if v := r.URL.Query().Get("since_height"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, nil, false
+		}
+		height = n
+	}
+
+	if height >= 0 && height < len(chain) && chain[height].Hash == hash {
+		return height + 1, nil, true
+	}
+	if height < 0 {
+		for i := len(chain) - 1; i >= 0; i-- {
+			if chain[i].Hash == hash {
+				return i + 1, nil, true
+			}
+		}
+	}
+
+	tip := chain[len(chain)-1]
+	fork = &ForkDetected{Error: "fork detected", Tip: tip.Height, TipHash: tip.Hash}
+	if height >= 0 {
+		fork.Height = height
+		if fork.Height > tip.Height+1 {
+			fork.Height = tip.Height + 1
+		}
+	}
+	return 0, fork, true
+}
+
+// writeFork sends a ForkDetected response: an httpapi.APIError with the
+// fork as details, which also carries the fork's fields at the top
+// level, where peers of earlier releases read them.
+func writeFork(w http.ResponseWriter, fork *ForkDetected) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(struct {
+		httpapi.APIError
+		*ForkDetected
+	}{httpapi.APIError{Code: "fork_detected", Message: fork.Error, Details: fork, RequestID: w.Header().Get(httpapi.RequestIDHeader)}, fork})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// StateHash answers GET /debug/statehash: the canonical encoding of the
+// account state after the tip and its hash, so that another
+// implementation can check that it derives the same state root.
+type StateHash struct {
+	Height       int    `json:"height"`
+	BlockHash    string `json:"blockHash"`
+	Encoding     string `json:"encoding"`
+	Accounts     int    `json:"accounts"` // lines in State
+	State        string `json:"state"`
+	StateRoot    string `json:"stateRoot"` // SHA-256 of State, hex
+	TipStateRoot string `json:"tipStateRoot"`
+	Matches      bool   `json:"matches"` // StateRoot equals the tip's
+}
+
+// stateEncoding describes LedgerState.canonical for clients.
+const stateEncoding = `one "address|total|nonce\n" line per account with a balance or nonce, in byte order of address; total is spendable plus immature`
+
+// stateHashHandler reports the canonical state at the tip (admin).
+func stateHashHandler(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	tip := powChain[len(powChain)-1]
+	state := ledgerState(powChain)
+	mu.Unlock()
+
+	raw := state.canonical()
+	sh := StateHash{
+		Height:       tip.Height,
+		BlockHash:    tip.Hash,
+		Encoding:     stateEncoding,
+		Accounts:     bytes.Count(raw, []byte("\n")),
+		State:        string(raw),
+		StateRoot:    state.root(),
+		TipStateRoot: tip.StateRoot,
+	}
+	sh.Matches = sh.StateRoot == sh.TipStateRoot
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(sh)
+}
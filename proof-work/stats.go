@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"alirezachain/httpapi"
+)
+
+// Limits of GET /stats/timeseries.
+const (
+	maxTimeseriesBuckets  = 1000
+	minTimeseriesInterval = time.Minute
+	defaultTimeseriesSpan = 24 // buckets when from is not given
+)
+
+// timeseriesMetrics are what GET /stats/timeseries can count per bucket.
+var timeseriesMetrics = map[string]func(b PowBlock) uint64{
+	"blocks": func(b PowBlock) uint64 { return 1 },
+	"txs": func(b PowBlock) uint64 {
+		var n uint64
+		for _, tx := range b.Transactions {
+			if !tx.IsCoinbase() && !isScheduledRun(tx) {
+				n++
+			}
+		}
+		return n
+	},
+	"fees": func(b PowBlock) uint64 { return totalFees(b.Transactions) },
+}
+
+// Bucket is one interval of a time series. Start is a unix time, aligned
+// to a multiple of the interval.
+type Bucket struct {
+	Start int64  `json:"start"`
+	Value uint64 `json:"value"`
+}
+
+// Timeseries answers GET /stats/timeseries.
+type Timeseries struct {
+	Metric   string   `json:"metric"`
+	Interval string   `json:"interval"`
+	From     int64    `json:"from"`
+	To       int64    `json:"to"`
+	Total    uint64   `json:"total"`
+	Buckets  []Bucket `json:"buckets"`
+}
+
+// parseUnix reads an optional unix-time query parameter.
+func parseUnix(r *http.Request, name string) (int64, bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, false, nil
+	}
+	t, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || t < 0 {
+		return 0, false, fmt.Errorf("%s must be a unix time in seconds", name)
+	}
+	return t, true, nil
+}
+
+// timeseriesHandler buckets the blocks after genesis by timestamp and
+// reports metric per bucket. Empty buckets are included, so that the
+// series can be charted as is. The range [from, to) defaults to the
+// last 24 intervals up to now.
+func timeseriesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	metric := q.Get("metric")
+	if metric == "" {
+		metric = "blocks"
+	}
+	value, ok := timeseriesMetrics[metric]
+	if !ok {
+		httpapi.WriteError(w, "metric must be blocks, txs or fees", http.StatusBadRequest)
+		return
+	}
+	interval := time.Hour
+	if v := q.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < minTimeseriesInterval || d%time.Second != 0 {
+			httpapi.WriteError(w, fmt.Sprintf("interval must be a duration of whole seconds, at least %s", minTimeseriesInterval), http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+	step := int64(interval / time.Second)
+
+	from, hasFrom, err := parseUnix(r, "from")
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, hasTo, err := parseUnix(r, "to")
+	if err != nil {
+		httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !hasTo {
+		to = clk.Now().Unix()
+	}
+	if !hasFrom {
+		from = to - defaultTimeseriesSpan*step
+	}
+	if from >= to {
+		httpapi.WriteError(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+	// Widen the range to whole buckets: from is rounded down and to up.
+	from -= from % step
+	if rem := to % step; rem != 0 {
+		to += step - rem
+	}
+	if (to-from)/step > maxTimeseriesBuckets {
+		httpapi.WriteError(w, fmt.Sprintf("at most %d buckets; widen the interval or narrow the range", maxTimeseriesBuckets), http.StatusBadRequest)
+		return
+	}
+
+	ts := Timeseries{
+		Metric:   metric,
+		Interval: interval.String(),
+		From:     from,
+		To:       to,
+		Buckets:  make([]Bucket, (to-from)/step),
+	}
+	for i := range ts.Buckets {
+		ts.Buckets[i].Start = from + int64(i)*step
+	}
+	mu.Lock()
+	for _, b := range powChain[1:] {
+		if b.Timestamp < from || b.Timestamp >= to {
+			continue
+		}
+		v := value(b)
+		ts.Buckets[(b.Timestamp-from)/step].Value += v
+		ts.Total += v
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(ts)
+}
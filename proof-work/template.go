@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"alirezachain/httpapi"
+	"alirezachain/powcore"
+)
+
+// BlockTemplate is everything external mining software needs to build a
+// candidate for the next block. The miner fills in the coinbase
+// recipient (unless requested via ?miner=), recomputes the coinbase ID,
+// TxRoot and StateRoot, then searches for a nonce and submits to
+// POST /submit.
+type BlockTemplate struct {
+	Version      int           `json:"version"`
+	Height       int           `json:"height"`
+	PrevHash     string        `json:"prevHash"`
+	Timestamp    int64         `json:"timestamp"`
+	MinTimestamp int64         `json:"minTimestamp,omitempty"` // earliest timestamp MIN_BLOCK_INTERVAL allows
+	Difficulty   int           `json:"difficulty"`
+	Bits         uint32        `json:"bits,omitempty"` // set instead of Difficulty after the compactbits fork
+	Target       string        `json:"target"`
+	Reward       uint64        `json:"reward"`
+	Fees         uint64        `json:"fees"`                 // collected by the coinbase
+	FeesBurned   uint64        `json:"feesBurned,omitempty"` // see FEE_BURN_PERCENT
+	Coinbase     Transaction   `json:"coinbase"`
+	Transactions []Transaction `json:"transactions"`
+	TxRoot       string        `json:"txRoot,omitempty"`
+	StateRoot    string        `json:"stateRoot,omitempty"`
+	Forks        []string      `json:"forks,omitempty"` // upgrades in force at Height
+	HashAlg      string        `json:"hashAlgorithm"`
+}
+
+// difficultyTarget returns the value a block hash must stay below at the
+// given difficulty (the number of leading zero bits required), relative
+// to the digest length of the chain's hash algorithm.
+func difficultyTarget(difficulty int) *big.Int {
+	return powcore.DifficultyTarget(hasher.Size(), difficulty)
+}
+
+// meetsTarget reports whether a hex block hash is below target (see
+// blockTarget); a nil target is never met.
+func meetsTarget(hash string, target *big.Int) bool {
+	if target == nil || len(hash) != hasher.Size()*2 {
+		return false
+	}
+	h, ok := new(big.Int).SetString(hash, 16)
+	if !ok {
+		return false
+	}
+	return h.Cmp(target) == -1
+}
+
+// templateHandler returns a template for the next block.
+func templateHandler(w http.ResponseWriter, r *http.Request) {
+	miner := strings.TrimSpace(r.URL.Query().Get("miner"))
+
+	mu.Lock()
+	last := powChain[len(powChain)-1]
+	base := ledgerState(powChain)
+	txs := selectTransactions(base)
+	difficulty, bits := nextWork(powChain, defaultDifficulty)
+	mu.Unlock()
+
+	height := last.Height + 1
+	fees := minerFees(txs)
+	target := blockTarget(PowBlock{Height: height, Difficulty: difficulty, Bits: bits})
+
+	tmpl := BlockTemplate{
+		Version:      blockVersion,
+		Height:       height,
+		PrevHash:     last.Hash,
+		Timestamp:    clk.Now().Unix(),
+		Difficulty:   difficulty,
+		Bits:         bits,
+		Target:       fmt.Sprintf("%0*x", hasher.Size()*2, target),
+		Reward:       emission.rewardAt(height),
+		Fees:         fees,
+		FeesBurned:   burnedFees(txs),
+		Coinbase:     Transaction{Amount: emission.rewardAt(height) + fees, Nonce: uint64(height)},
+		Transactions: txs,
+		Forks:        activeForks(height),
+		HashAlg:      hasher.Name(),
+	}
+	if minBlockInterval > 0 {
+		tmpl.MinTimestamp = last.Timestamp + intervalSeconds()
+		if tmpl.Timestamp < tmpl.MinTimestamp {
+			tmpl.Timestamp = tmpl.MinTimestamp
+		}
+	}
+	if tmpl.Transactions == nil {
+		tmpl.Transactions = []Transaction{}
+	}
+	if miner != "" {
+		tmpl.Coinbase = newCoinbase(miner, height, fees)
+		all := append([]Transaction{tmpl.Coinbase}, txs...)
+		root, err := postStateRoot(base, all)
+		if err != nil {
+			httpapi.WriteError(w, "could not build a valid template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmpl.TxRoot = merkleRoot(all)
+		tmpl.StateRoot = root
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(tmpl)
+}
+
+// submitBlockHandler accepts a block mined by external software.
+func submitBlockHandler(w http.ResponseWriter, r *http.Request) {
+	var b PowBlock
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+
+	// After the retarget fork appendBlock enforces the exact difficulty.
+	// Before it the target may be no easier than that of DIFFICULTY.
+	target := blockTarget(b)
+	if !forkActive(forkRetarget, b.Height) && target != nil && target.Cmp(difficultyTarget(defaultDifficulty)) > 0 {
+		httpapi.WriteError(w, fmt.Sprintf("difficulty must be at least %d", defaultDifficulty), http.StatusBadRequest)
+		return
+	}
+
+	if err := appendBlock(b); err != nil {
+		if rec, ok := recordStale(b, clk.Now()); ok {
+			httpapi.LogRequest(r, "🥀 Stale block: height=%d hash=%s lost to %s", b.Height, b.Hash, rec.Winner)
+			httpapi.WriteErrorDetails(w, http.StatusConflict, httpapi.APIError{
+				Code:    "stale_block",
+				Message: fmt.Sprintf("block is stale: height %d already holds %s; see GET /uncles", b.Height, rec.Winner),
+				Details: rec,
+			})
+			return
+		}
+		httpapi.WriteError(w, "block rejected: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	httpapi.LogRequest(r, "📥 Accepted submitted block: height=%d nonce=%d hash=%s", b.Height, b.Nonce, b.Hash)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(toView(b))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MiningControls limit how much CPU mining may use (MINING_THREADS,
+// MINING_DUTY_CYCLE) and let an operator pause it.
+type MiningControls struct {
+	Threads   int  `json:"threads"`
+	DutyCycle int  `json:"dutyCycle"` // percent of each dutySlice spent hashing
+	Paused    bool `json:"paused"`
+}
+
+// dutySlice is the period over which the duty cycle is applied: a
+// worker hashes for DutyCycle percent of it and sleeps for the rest.
+const dutySlice = 100 * time.Millisecond
+
+// hashesPerCheck is how many hashes a worker computes between checks for
+// a found block, a pause or the end of its duty slice.
+const hashesPerCheck = 1024
+
+var (
+	miningMu   sync.Mutex // guards mining
+	miningCond = sync.NewCond(&miningMu)
+	mining     = MiningControls{Threads: 1, DutyCycle: 100}
+)
+
+// loadMiningControls reads MINING_THREADS and MINING_DUTY_CYCLE (1-100).
+func loadMiningControls() error {
+	c, err := readMiningControls(mining)
+	if err != nil {
+		return err
+	}
+	mining = c
+	return nil
+}
+
+// readMiningControls returns c with MINING_THREADS and MINING_DUTY_CYCLE
+// applied.
+func readMiningControls(c MiningControls) (MiningControls, error) {
+	if v := os.Getenv("MINING_THREADS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c, fmt.Errorf("invalid MINING_THREADS %q", v)
+		}
+		c.Threads = n
+	}
+	if v := os.Getenv("MINING_DUTY_CYCLE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return c, fmt.Errorf("invalid MINING_DUTY_CYCLE %q (1-100)", v)
+		}
+		c.DutyCycle = n
+	}
+	return c, nil
+}
+
+// miningControls returns a snapshot of the controls.
+func miningControls() MiningControls {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+	return mining
+}
+
+// waitWhilePaused blocks a mining worker until mining is resumed.
+func waitWhilePaused() {
+	miningMu.Lock()
+	for mining.Paused {
+		miningCond.Wait()
+	}
+	miningMu.Unlock()
+}
+
+// searchNonce hashes candidates with nonces start, start+step, ... until
+// one meets target or stop is set, and sends the winner to found. It
+// checks for a pause and sleeps off its duty cycle every hashesPerCheck
+// hashes.
+func searchNonce(tmpl PowBlock, target *big.Int, start, step int64, stop *int32, found chan<- PowBlock, duty int) {
+	work := dutySlice * time.Duration(duty) / 100
+	sliceStart := time.Now()
+	nonce := start
+	for i := 1; ; i++ {
+		candidate := tmpl
+		candidate.Timestamp = clk.Now().Unix()
+		candidate.Nonce = nonce
+		hashBytes := hasher.Sum([]byte(blockRecord(candidate)))
+
+		var hashInt big.Int
+		hashInt.SetBytes(hashBytes)
+		if hashInt.Cmp(target) == -1 {
+			if atomic.CompareAndSwapInt32(stop, 0, 1) {
+				candidate.Hash = hex.EncodeToString(hashBytes)
+				found <- candidate
+			}
+			return
+		}
+
+		if nonce > math.MaxInt64-step {
+			log.Println("⚠️  Nonce overflow, restarting mining loop")
+			nonce = start
+		} else {
+			nonce += step
+		}
+
+		if i%hashesPerCheck != 0 {
+			continue
+		}
+		if atomic.LoadInt32(stop) != 0 {
+			return
+		}
+		waitWhilePaused()
+		if duty < 100 && time.Since(sliceStart) >= work {
+			time.Sleep(dutySlice - work)
+			sliceStart = time.Now()
+		}
+	}
+}
+
+// setMiningPaused pauses or resumes every mining worker.
+func setMiningPaused(paused bool) MiningControls {
+	miningMu.Lock()
+	defer miningMu.Unlock()
+	if mining.Paused != paused {
+		mining.Paused = paused
+		miningCond.Broadcast()
+		if paused {
+			log.Println("⏸️  Mining paused")
+		} else {
+			log.Println("▶️  Mining resumed")
+		}
+	}
+	return mining
+}
+
+// miningStatusHandler returns the mining controls.
+func miningStatusHandler(w http.ResponseWriter, r *http.Request) {
+	writeMiningControls(w, miningControls())
+}
+
+// pauseMiningHandler stops all hashing until POST /admin/mining/resume.
+// Blocks being mined wait; new /mine requests are refused.
+func pauseMiningHandler(w http.ResponseWriter, r *http.Request) {
+	writeMiningControls(w, setMiningPaused(true))
+}
+
+// resumeMiningHandler continues after POST /admin/mining/pause.
+func resumeMiningHandler(w http.ResponseWriter, r *http.Request) {
+	writeMiningControls(w, setMiningPaused(false))
+}
+
+func writeMiningControls(w http.ResponseWriter, c MiningControls) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(c)
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"alirezachain/powcore"
+	"github.com/gorilla/mux"
+)
+
+// Transaction moves Amount from From to To, paying Fee to the miner.
+// From is the sender's hex-encoded ed25519 public key and Signature
+// signs the transaction ID for the chain ID (see txSigningDigest). A
+// coinbase transaction has no sender, mints the block reward plus fees
+// and uses the block height as nonce so that every coinbase has a
+// distinct ID. A transfer from a multisig account carries the account
+// descriptor and the co-signers' Signatures instead. One that locks
+// funds in or spends from a hash-timelock contract carries its terms in
+// HTLC, and an oracle's report of off-chain data its Oracle report. A
+// scheduled transfer and each of its runs carry the schedule's terms in
+// Schedule. A genesis allocation released over time carries its terms in
+// Vesting.
+type Transaction struct {
+	ID         string           `json:"id"`
+	From       string           `json:"from,omitempty"`
+	To         string           `json:"to"`
+	Amount     uint64           `json:"amount"`
+	Fee        uint64           `json:"fee,omitempty"`
+	Nonce      uint64           `json:"nonce"`
+	Signature  string           `json:"signature,omitempty"`
+	Multisig   *MultisigAccount `json:"multisig,omitempty"`
+	Signatures []PartialSig     `json:"signatures,omitempty"`
+	HTLC       *HTLC            `json:"htlc,omitempty"`
+	Oracle     *OracleReport    `json:"oracle,omitempty"`
+	Schedule   *Schedule        `json:"schedule,omitempty"`
+	Vesting    *Vesting         `json:"vesting,omitempty"`
+}
+
+// chainID names the network: the genesis file's chainId, or else the
+// genesis block hash. Signatures cover it, so that a transaction signed
+// for one network, say a testnet, can never be replayed on another.
+var chainID string
+
+// maxChainIDLength caps the length of a configured chain ID.
+const maxChainIDLength = 64
+
+// validChainID accepts printable ASCII without spaces.
+func validChainID(id string) error {
+	if len(id) > maxChainIDLength {
+		return fmt.Errorf("chain ID is longer than %d characters", maxChainIDLength)
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return fmt.Errorf("chain ID %q must be printable ASCII without spaces", id)
+		}
+	}
+	return nil
+}
+
+// txSigningDigest returns what the transaction with ID id is signed
+// over on this chain (see powcore.TxSigningDigest).
+func txSigningDigest(id string) ([]byte, error) {
+	return powcore.TxSigningDigest(chainID, id)
+}
+
+// coinbaseMaturity is the number of confirmations a coinbase output
+// needs before it can be spent (COINBASE_MATURITY).
+var coinbaseMaturity = 10
+
+// IsCoinbase reports whether tx mints new supply.
+func (tx Transaction) IsCoinbase() bool {
+	return tx.From == ""
+}
+
+// txHash computes the ID of a transaction from its contents. The
+// signature is not part of the ID; contract terms, oracle reports,
+// schedules and vesting terms are.
+func txHash(tx Transaction) string {
+	record := powcore.TransferRecord(tx.From, tx.To, tx.Amount, tx.Fee, tx.Nonce)
+	if tx.HTLC != nil {
+		record += "|" + tx.HTLC.record()
+	}
+	if tx.Oracle != nil {
+		record += "|" + tx.Oracle.record()
+	}
+	if tx.Schedule != nil {
+		record += "|" + tx.Schedule.record()
+	}
+	if tx.Vesting != nil {
+		record += "|" + tx.Vesting.record()
+	}
+	return powcore.TxID(record)
+}
+
+// verifyTxSignature checks that tx is signed by the key in tx.From, by
+// enough co-signers if tx.From is a multisig address, or by the party it
+// pays if tx.From is a hash-timelock contract.
+func verifyTxSignature(tx Transaction) error {
+	if strings.HasPrefix(tx.From, multisigPrefix) {
+		return verifyMultisig(tx)
+	}
+	if strings.HasPrefix(tx.From, htlcPrefix) {
+		return verifyHTLC(tx)
+	}
+	return verifyKeySignature(tx.From, tx.ID, tx.Signature)
+}
+
+// verifyKeySignature checks that signature is key's signature over the
+// transaction ID id on this chain.
+func verifyKeySignature(key, id, signature string) error {
+	pub, err := hex.DecodeString(key)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return errors.New("sender must be a hex-encoded ed25519 public key")
+	}
+	digest, err := txSigningDigest(id)
+	if err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("malformed signature")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pub), digest, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// newCoinbase builds the reward transaction for a block at height that
+// also collects fees, what minerFees leaves of the block's fees.
+func newCoinbase(miner string, height int, fees uint64) Transaction {
+	tx := Transaction{
+		To:     miner,
+		Amount: emission.rewardAt(height) + fees,
+		Nonce:  uint64(height),
+	}
+	tx.ID = txHash(tx)
+	return tx
+}
+
+// totalFees sums the fees of the non-coinbase transactions in txs.
+func totalFees(txs []Transaction) uint64 {
+	var fees uint64
+	for _, tx := range txs {
+		if !tx.IsCoinbase() {
+			fees += tx.Fee
+		}
+	}
+	return fees
+}
+
+// merkleRoot computes the Merkle root of the transaction IDs, duplicating
+// the last node of odd-sized levels.
+func merkleRoot(txs []Transaction) string {
+	ids := make([]string, len(txs))
+	for i, tx := range txs {
+		ids[i] = tx.ID
+	}
+	return powcore.MerkleRoot(ids)
+}
+
+// validateTransactions checks the transaction list of a block: it must
+// start with exactly one coinbase paying the scheduled reward plus fees,
+// every ID must match its contents, every transfer but a scheduled run
+// must be signed by its sender, and the header must commit to the list.
+func validateTransactions(b PowBlock) error {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction must be the coinbase")
+	}
+	for i, tx := range b.Transactions {
+		if tx.ID != txHash(tx) {
+			return fmt.Errorf("transaction %d has a mismatched id", i)
+		}
+		if tx.Vesting != nil {
+			return fmt.Errorf("transaction %d: vesting terms are only valid at genesis", i)
+		}
+		if i == 0 {
+			continue
+		}
+		if tx.IsCoinbase() {
+			return fmt.Errorf("transaction %d is a second coinbase", i)
+		}
+		if !isScheduledRun(tx) {
+			if err := verifyTxSignature(tx); err != nil {
+				return fmt.Errorf("transaction %d: %v", i, err)
+			}
+		}
+		if err := checkHTLC(tx, b.Height); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+		if err := checkOracle(tx, b.Height); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+		if err := checkSchedule(tx, b.Height); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	cb := b.Transactions[0]
+	if cb.To == "" || cb.Nonce != uint64(b.Height) {
+		return errors.New("malformed coinbase")
+	}
+	want := emission.rewardAt(b.Height) + minerFees(b.Transactions)
+	if cb.Amount != want {
+		return fmt.Errorf("coinbase pays %d, expected %d", cb.Amount, want)
+	}
+	if b.TxRoot != merkleRoot(b.Transactions) {
+		return errors.New("transaction root mismatch")
+	}
+	return nil
+}
+
+// Balance is an account's holdings derived from the chain.
+type Balance struct {
+	Address   string `json:"address"`
+	Spendable uint64 `json:"spendable"`
+	Immature  uint64 `json:"immature"`          // coinbase rewards awaiting maturity
+	Nonce     uint64 `json:"nonce"`             // nonce of the account's next transaction
+	Vesting   uint64 `json:"vesting,omitempty"` // part of Spendable not vested yet
+}
+
+// LedgerState maps addresses to balances.
+type LedgerState map[string]*Balance
+
+// account returns the balance of addr, creating an empty one if needed.
+func (s LedgerState) account(addr string) *Balance {
+	if s[addr] == nil {
+		s[addr] = &Balance{Address: addr}
+	}
+	return s[addr]
+}
+
+// root returns a deterministic hash of the state: the SHA-256 of its
+// canonical encoding.
+func (s LedgerState) root() string {
+	return powcore.StateRoot(s.canonical())
+}
+
+// canonical encodes the state as one "address|total|nonce" line per
+// non-empty account, in address order, where total is spendable plus
+// immature. Maturity and vesting are left out because they only depend
+// on height.
+func (s LedgerState) canonical() []byte {
+	accounts := make(map[string]powcore.Account, len(s))
+	for addr, b := range s {
+		accounts[addr] = powcore.Account{Total: b.Spendable + b.Immature, Nonce: b.Nonce}
+	}
+	return powcore.CanonicalState(accounts)
+}
+
+// clone returns a deep copy of the state.
+func (s LedgerState) clone() LedgerState {
+	c := make(LedgerState, len(s))
+	for addr, b := range s {
+		cp := *b
+		c[addr] = &cp
+	}
+	return c
+}
+
+// applyTransfer checks tx against the state and applies it. The nonce
+// must be the sender's next nonce and the sender must be able to cover
+// amount plus fee from spendable funds.
+func (s LedgerState) applyTransfer(tx Transaction) error {
+	from := s.account(tx.From)
+	if tx.Nonce != from.Nonce {
+		return fmt.Errorf("expected nonce %d, got %d", from.Nonce, tx.Nonce)
+	}
+	cost := tx.Amount + tx.Fee
+	if cost < tx.Amount || from.Spendable < cost {
+		return errors.New("insufficient spendable balance")
+	}
+	if from.available() < cost {
+		return fmt.Errorf("only %d of the balance is vested", from.available())
+	}
+	from.Spendable -= cost
+	from.Nonce++
+	s.account(tx.To).Spendable += tx.Amount
+	return nil
+}
+
+// postStateRoot returns the state root after applying txs (coinbase
+// first) on top of base, which is left untouched.
+func postStateRoot(base LedgerState, txs []Transaction) (string, error) {
+	post := base.clone()
+	if err := post.applyBlock(PowBlock{Transactions: txs}); err != nil {
+		return "", err
+	}
+	return post.root(), nil
+}
+
+// applyBlock applies every transfer in b to the state, followed by its
+// coinbase and uncle rewards. Both are credited as immature; use
+// ledgerState to get maturity right for a whole chain.
+func (s LedgerState) applyBlock(b PowBlock) error {
+	for i, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		if err := s.applyTransfer(tx); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	if len(b.Transactions) > 0 {
+		cb := b.Transactions[0]
+		s.account(cb.To).Immature += cb.Amount
+	}
+	for _, c := range uncleCredits(b) {
+		s.account(c.To).Immature += c.Amount
+	}
+	return nil
+}
+
+// ledgerState replays the chain and returns every account's balance as
+// seen by the next block: a coinbase output is spendable once the next
+// block would be at least coinbaseMaturity blocks above it. Genesis
+// allocations are spendable at once, less what is not vested yet. On a
+// fast-synced node, replay starts from the snapshot state. Callers must
+// hold mu.
+func ledgerState(chain []PowBlock) LedgerState {
+	state := make(LedgerState)
+
+	next := chain[len(chain)-1].Height + 1
+	if synced != nil && len(chain) > synced.height {
+		state = synced.base.clone()
+		for _, cb := range synced.window {
+			if powcore.Mature(int(cb.Nonce), next, coinbaseMaturity) {
+				acct := state.account(cb.To)
+				acct.Immature -= cb.Amount
+				acct.Spendable += cb.Amount
+			}
+		}
+		chain = chain[synced.height+1:]
+	}
+	for _, b := range chain {
+		for _, tx := range b.Transactions {
+			if tx.IsCoinbase() {
+				if b.Height == 0 || powcore.Mature(b.Height, next, coinbaseMaturity) {
+					state.account(tx.To).Spendable += tx.Amount
+				} else {
+					state.account(tx.To).Immature += tx.Amount
+				}
+				continue
+			}
+			from := state.account(tx.From)
+			from.Spendable -= tx.Amount + tx.Fee
+			from.Nonce++
+			state.account(tx.To).Spendable += tx.Amount
+		}
+		for _, c := range uncleCredits(b) {
+			if powcore.Mature(b.Height, next, coinbaseMaturity) {
+				state.account(c.To).Spendable += c.Amount
+			} else {
+				state.account(c.To).Immature += c.Amount
+			}
+		}
+	}
+	applyVesting(state, next)
+	return state
+}
+
+// balanceHandler returns the balance of a single address.
+func balanceHandler(w http.ResponseWriter, r *http.Request) {
+	addr := mux.Vars(r)["address"]
+
+	mu.Lock()
+	bal := Balance{Address: addr}
+	if b, ok := ledgerState(powChain)[addr]; ok {
+		bal = *b
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(bal)
+}
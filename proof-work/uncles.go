@@ -0,0 +1,397 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Uncle blocks. A block submitted for a height the chain already has, on
+// top of the same parent, lost a race: it is valid, but stale. The node
+// keeps such blocks in a side store and lists them at GET /uncles, so
+// miners can see how much of their work was wasted. With
+// UNCLE_REWARD_PERCENT set, the blocks this node mines include recent
+// stale blocks as uncles, and each uncle's miner is credited that
+// percentage of the reward for the uncle's height. A block commits to
+// its uncles through the uncleRoot extension field, the Merkle root of
+// their hashes.
+
+const (
+	// uncleRootField is the extension field holding a block's uncle root.
+	uncleRootField = "uncleRoot"
+
+	// maxBlockUncles caps the uncles one block includes.
+	maxBlockUncles = 2
+
+	// maxUncleDepth is how far below a block its uncles may be: a stale
+	// block at height h can be included up to height h+maxUncleDepth.
+	maxUncleDepth = 6
+
+	// maxStaleBlocks caps the side store; the oldest entries go first.
+	maxStaleBlocks = 256
+)
+
+// Uncle is a stale block as a later block includes it: the header, and
+// the coinbase with its Merkle proof, which names the miner to credit.
+type Uncle struct {
+	Header   PowBlock    `json:"header"` // without transactions or uncles
+	Coinbase Transaction `json:"coinbase"`
+	Proof    []ProofStep `json:"proof,omitempty"` // from the coinbase ID up to Header.TxRoot
+}
+
+// staleBlock is an entry of the side store.
+type staleBlock struct {
+	Uncle
+	received time.Time // zero if only seen as the uncle of a block
+}
+
+var (
+	// staleBlocks is the side store, oldest first. Guarded by mu.
+	staleBlocks []staleBlock
+
+	// includedUncles maps the hash of each included uncle to the height
+	// of the block including it. Guarded by mu.
+	includedUncles = make(map[string]int)
+)
+
+// UncleRecord describes a stale block in GET /uncles.
+type UncleRecord struct {
+	Height     int     `json:"height"`
+	Hash       string  `json:"hash"`
+	PrevHash   string  `json:"prevHash"`
+	Miner      string  `json:"miner"`
+	Work       float64 `json:"work"`                 // expected hashes
+	Winner     string  `json:"winner"`               // hash of the main-chain block at Height
+	Received   string  `json:"received,omitempty"`   // when it was submitted to this node
+	IncludedIn int     `json:"includedIn,omitempty"` // height of the block including it as an uncle
+	Reward     uint64  `json:"reward,omitempty"`     // credited to Miner for it
+}
+
+// MinerWaste sums up a miner's stale blocks against the blocks it got
+// into the chain over the same heights.
+type MinerWaste struct {
+	Miner         string  `json:"miner"`
+	Blocks        int     `json:"blocks"`
+	Stale         int     `json:"stale"`
+	WastedWork    float64 `json:"wastedWork"`    // expected hashes spent on stale blocks
+	WastedPercent float64 `json:"wastedPercent"` // of all the miner's work
+	UncleRewards  uint64  `json:"uncleRewards,omitempty"`
+}
+
+// UncleReport answers GET /uncles.
+type UncleReport struct {
+	RewardPercent uint64        `json:"rewardPercent"`
+	MaxDepth      int           `json:"maxDepth"`
+	FromHeight    int           `json:"fromHeight,omitempty"` // lowest height with a stale block
+	Uncles        []UncleRecord `json:"uncles"`
+	Miners        []MinerWaste  `json:"miners"`
+}
+
+// uncleReward is what the miner of an uncle at height is credited,
+// rounded down.
+func uncleReward(height int) uint64 {
+	reward := emission.rewardAt(height)
+	if reward > math.MaxUint64/100 {
+		return reward / 100 * emission.UncleRewardPercent
+	}
+	return reward * emission.UncleRewardPercent / 100
+}
+
+// uncleCredits returns the rewards b pays the miners of its uncles, as
+// coinbase-like transactions that mature with b.
+func uncleCredits(b PowBlock) []Transaction {
+	var credits []Transaction
+	for _, u := range b.Uncles {
+		if amount := uncleReward(u.Header.Height); amount > 0 {
+			credits = append(credits, Transaction{To: u.Coinbase.To, Amount: amount, Nonce: uint64(b.Height)})
+		}
+	}
+	return credits
+}
+
+// proofRoot folds a Merkle proof from leaf up to the root.
+func proofRoot(leaf string, proof []ProofStep) (string, error) {
+	node, err := hex.DecodeString(leaf)
+	if err != nil {
+		return "", err
+	}
+	for _, step := range proof {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return "", err
+		}
+		var h [sha256.Size]byte
+		switch step.Side {
+		case "left":
+			h = sha256.Sum256(append(sibling, node...))
+		case "right":
+			h = sha256.Sum256(append(node, sibling...))
+		default:
+			return "", fmt.Errorf("proof side must be left or right, not %q", step.Side)
+		}
+		node = h[:]
+	}
+	return hex.EncodeToString(node), nil
+}
+
+// newUncle turns a full stale block into the uncle a block includes.
+func newUncle(b PowBlock) Uncle {
+	ids := make([]string, len(b.Transactions))
+	for i, tx := range b.Transactions {
+		ids[i] = tx.ID
+	}
+	u := Uncle{Header: b, Coinbase: b.Transactions[0], Proof: anchorProof(ids, 0)}
+	u.Header.Transactions, u.Header.Uncles = nil, nil
+	return u
+}
+
+// checkUncleCoinbase verifies that u's coinbase is the first transaction
+// its header commits to.
+func checkUncleCoinbase(u Uncle) error {
+	cb := u.Coinbase
+	if !cb.IsCoinbase() || cb.To == "" || cb.Nonce != uint64(u.Header.Height) || cb.ID != txHash(cb) {
+		return errors.New("malformed coinbase")
+	}
+	for _, step := range u.Proof {
+		if step.Side != "right" {
+			return errors.New("coinbase proof is not for the first transaction")
+		}
+	}
+	if root, err := proofRoot(cb.ID, u.Proof); err != nil || root != u.Header.TxRoot {
+		return errors.New("coinbase proof does not lead to the transaction root")
+	}
+	return nil
+}
+
+// checkUncles verifies the uncles of b against chain, the blocks before
+// it: each must be a valid block on top of a block of chain, within
+// maxUncleDepth of b, not on chain itself and not included before. Only
+// headers and coinbases are checked; an uncle's other transactions are
+// never applied.
+func checkUncles(b PowBlock, chain []PowBlock) error {
+	if len(b.Uncles) == 0 && b.Extra[uncleRootField] == "" {
+		return nil
+	}
+	if emission.UncleRewardPercent == 0 {
+		return errors.New("uncles are only valid with UNCLE_REWARD_PERCENT")
+	}
+	if len(b.Uncles) > maxBlockUncles {
+		return fmt.Errorf("at most %d uncles per block", maxBlockUncles)
+	}
+	hashes := make([]string, len(b.Uncles))
+	for i, u := range b.Uncles {
+		h := u.Header.Height
+		if h < 1 || h >= b.Height || b.Height-h > maxUncleDepth || h >= len(chain) {
+			return fmt.Errorf("uncle %d is not within %d blocks below the block", i, maxUncleDepth)
+		}
+		if len(u.Header.Transactions) > 0 || len(u.Header.Uncles) > 0 {
+			return fmt.Errorf("uncle %d carries transactions or uncles in its header", i)
+		}
+		if u.Header.Hash == chain[h].Hash {
+			return fmt.Errorf("uncle %d is on the chain", i)
+		}
+		if !isHeaderValid(u.Header, chain[h-1]) {
+			return fmt.Errorf("uncle %d does not extend the chain or fails proof of work", i)
+		}
+		if err := checkDifficulty(u.Header, chain[:h]); err != nil {
+			return fmt.Errorf("uncle %d: %v", i, err)
+		}
+		if err := checkUncleCoinbase(u); err != nil {
+			return fmt.Errorf("uncle %d: %v", i, err)
+		}
+		for _, v := range b.Uncles[:i] {
+			if v.Header.Hash == u.Header.Hash {
+				return fmt.Errorf("uncle %d is a duplicate", i)
+			}
+		}
+		for _, prev := range chain[h+1:] {
+			for _, v := range prev.Uncles {
+				if v.Header.Hash == u.Header.Hash {
+					return fmt.Errorf("uncle %d was included at height %d", i, prev.Height)
+				}
+			}
+		}
+		hashes[i] = u.Header.Hash
+	}
+	if b.Extra[uncleRootField] != anchorRoot(hashes) {
+		return errors.New("uncle root mismatch")
+	}
+	return nil
+}
+
+// takeUncles returns the stale blocks the next block includes, oldest
+// first, and the header fields committing to them, merged into extra.
+// Nothing is included without UNCLE_REWARD_PERCENT. Callers must hold
+// mu.
+func takeUncles(extra map[string]string) ([]Uncle, map[string]string) {
+	if emission.UncleRewardPercent == 0 {
+		return nil, extra
+	}
+	next := len(powChain)
+	var uncles []Uncle
+	var hashes []string
+	for _, s := range staleBlocks {
+		if len(uncles) == maxBlockUncles {
+			break
+		}
+		if _, ok := includedUncles[s.Header.Hash]; ok || next-s.Header.Height > maxUncleDepth {
+			continue
+		}
+		uncles = append(uncles, s.Uncle)
+		hashes = append(hashes, s.Header.Hash)
+	}
+	if len(uncles) == 0 {
+		return nil, extra
+	}
+	merged := make(map[string]string, len(extra)+1)
+	for k, v := range extra {
+		merged[k] = v
+	}
+	merged[uncleRootField] = anchorRoot(hashes)
+	return uncles, merged
+}
+
+// storeStale adds an entry to the side store unless it holds the block
+// already, evicting the oldest entry when full. Callers must hold mu.
+func storeStale(s staleBlock) {
+	for _, have := range staleBlocks {
+		if have.Header.Hash == s.Header.Hash {
+			return
+		}
+	}
+	staleBlocks = append(staleBlocks, s)
+	if len(staleBlocks) > maxStaleBlocks {
+		staleBlocks = append([]staleBlock(nil), staleBlocks[len(staleBlocks)-maxStaleBlocks:]...)
+	}
+}
+
+// recordUncles indexes the uncles b includes and adds those the side
+// store does not know yet. Callers must hold mu.
+func recordUncles(b PowBlock) {
+	for _, u := range b.Uncles {
+		includedUncles[u.Header.Hash] = b.Height
+		storeStale(staleBlock{Uncle: u})
+	}
+}
+
+// recordStale keeps b in the side store if it is a valid block for a
+// height the chain already has, on top of the same parent, and returns
+// its record. Callers must hold mu.
+func recordStale(b PowBlock, received time.Time) (UncleRecord, bool) {
+	h := b.Height
+	if h < 1 || h >= len(powChain) || b.PrevHash != powChain[h-1].Hash || b.Hash == powChain[h].Hash {
+		return UncleRecord{}, false
+	}
+	if synced != nil && h <= synced.height {
+		return UncleRecord{}, false
+	}
+	chain := powChain[:h]
+	if !isBlockValid(b, chain[h-1]) || checkDifficulty(b, chain) != nil || checkUncles(b, chain) != nil {
+		return UncleRecord{}, false
+	}
+	post := ledgerState(chain)
+	if err := post.applyBlock(b); err != nil || post.root() != b.StateRoot {
+		return UncleRecord{}, false
+	}
+	s := staleBlock{Uncle: newUncle(b), received: received}
+	storeStale(s)
+	return uncleRecord(s), true
+}
+
+// uncleRecord describes a side store entry. Callers must hold mu.
+func uncleRecord(s staleBlock) UncleRecord {
+	rec := UncleRecord{
+		Height:   s.Header.Height,
+		Hash:     s.Header.Hash,
+		PrevHash: s.Header.PrevHash,
+		Miner:    s.Coinbase.To,
+		Work:     blockWork(s.Header),
+		Winner:   powChain[s.Header.Height].Hash,
+	}
+	if !s.received.IsZero() {
+		rec.Received = s.received.UTC().Format(time.RFC3339)
+	}
+	if h, ok := includedUncles[s.Header.Hash]; ok {
+		rec.IncludedIn = h
+		for _, c := range uncleCredits(PowBlock{Uncles: []Uncle{s.Uncle}}) {
+			rec.Reward = c.Amount
+		}
+	}
+	return rec
+}
+
+// unclesHandler lists the stale blocks in the side store, newest first,
+// and how much work each miner lost to them since the lowest of their
+// heights, optionally for one ?miner= only.
+func unclesHandler(w http.ResponseWriter, r *http.Request) {
+	miner := strings.TrimSpace(r.URL.Query().Get("miner"))
+
+	mu.Lock()
+	rep := UncleReport{
+		RewardPercent: emission.UncleRewardPercent,
+		MaxDepth:      maxUncleDepth,
+		Uncles:        []UncleRecord{},
+		Miners:        []MinerWaste{},
+	}
+	waste := make(map[string]*MinerWaste)
+	minerWaste := func(addr string) *MinerWaste {
+		if waste[addr] == nil {
+			waste[addr] = &MinerWaste{Miner: addr}
+		}
+		return waste[addr]
+	}
+	mainWork := make(map[string]float64)
+	for i := len(staleBlocks) - 1; i >= 0; i-- {
+		rec := uncleRecord(staleBlocks[i])
+		if miner != "" && rec.Miner != miner {
+			continue
+		}
+		rep.Uncles = append(rep.Uncles, rec)
+		if rep.FromHeight == 0 || rec.Height < rep.FromHeight {
+			rep.FromHeight = rec.Height
+		}
+		m := minerWaste(rec.Miner)
+		m.Stale++
+		m.WastedWork += rec.Work
+		m.UncleRewards += rec.Reward
+	}
+	if rep.FromHeight > 0 {
+		for _, b := range powChain[rep.FromHeight:] {
+			if len(b.Transactions) == 0 {
+				continue
+			}
+			addr := b.Transactions[0].To
+			if miner != "" && addr != miner {
+				continue
+			}
+			minerWaste(addr).Blocks++
+			mainWork[addr] += blockWork(b)
+		}
+	}
+	mu.Unlock()
+
+	for addr, m := range waste {
+		if total := m.WastedWork + mainWork[addr]; total > 0 {
+			m.WastedPercent = math.Round(m.WastedWork/total*10000) / 100
+		}
+		rep.Miners = append(rep.Miners, *m)
+	}
+	sort.Slice(rep.Miners, func(i, j int) bool {
+		if rep.Miners[i].Stale != rep.Miners[j].Stale {
+			return rep.Miners[i].Stale > rep.Miners[j].Stale
+		}
+		return rep.Miners[i].Miner < rep.Miners[j].Miner
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(rep)
+}
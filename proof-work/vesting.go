@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Vesting releases a genesis allocation by height: nothing of it can be
+// spent below CliffHeight; from there the vested part is the share
+// height/EndHeight of the allocation, and all of it from EndHeight on.
+// The terms are carried by the allocation's mint transaction in the
+// genesis block, so they are covered by its ID and the genesis hash.
+type Vesting struct {
+	CliffHeight int `json:"cliffHeight"`
+	EndHeight   int `json:"endHeight"`
+}
+
+// record is what the transaction ID covers.
+func (v *Vesting) record() string {
+	return fmt.Sprintf("vesting|%d|%d", v.CliffHeight, v.EndHeight)
+}
+
+// check rejects terms that cannot be met.
+func (v *Vesting) check() error {
+	if v.CliffHeight < 0 || v.EndHeight < 1 || v.CliffHeight > v.EndHeight {
+		return errors.New("vesting needs 0 <= cliffHeight <= endHeight and endHeight >= 1")
+	}
+	return nil
+}
+
+// unvested returns how much of amount is still locked for a block at
+// height.
+func (v *Vesting) unvested(amount uint64, height int) uint64 {
+	switch {
+	case height >= v.EndHeight:
+		return 0
+	case height < v.CliffHeight:
+		return amount
+	}
+	vested := new(big.Int).SetUint64(amount)
+	vested.Mul(vested, big.NewInt(int64(height)))
+	vested.Div(vested, big.NewInt(int64(v.EndHeight)))
+	return amount - vested.Uint64()
+}
+
+// vestingAllocation is a genesis allocation released by a schedule.
+type vestingAllocation struct {
+	Amount  uint64
+	Vesting *Vesting
+}
+
+// vestingAccounts holds the vesting allocations of the genesis block by
+// address. It is set before the node serves and read-only afterwards.
+var vestingAccounts = make(map[string]vestingAllocation)
+
+// applyVesting sets the unvested part of every vesting allocation in
+// state, as seen by a block at height.
+func applyVesting(state LedgerState, height int) {
+	for addr, a := range vestingAccounts {
+		state.account(addr).Vesting = a.Vesting.unvested(a.Amount, height)
+	}
+}
+
+// available returns what the account can spend: its spendable funds
+// less the unvested part of a genesis allocation.
+func (b *Balance) available() uint64 {
+	if b.Spendable < b.Vesting {
+		return 0
+	}
+	return b.Spendable - b.Vesting
+}
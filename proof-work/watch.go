@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"alirezachain/httpapi"
+	"github.com/gorilla/mux"
+)
+
+// Limits on the watch list, which is kept in memory only.
+const (
+	maxWatches          = 1000
+	maxWatchAddresses   = 100
+	watchQueue          = 256 // events buffered per webhook or stream
+	watchPingInterval   = 30 * time.Second
+	watchWebhookTimeout = 10 * time.Second
+)
+
+// Watch is a set of addresses registered with POST /watch. Events about
+// them are POSTed to Webhook, if set, and pushed to every WebSocket
+// stream open on GET /watch/{id}/ws.
+type Watch struct {
+	ID        string   `json:"id"`
+	Addresses []string `json:"addresses"`
+	Webhook   string   `json:"webhook,omitempty"`
+	Created   string   `json:"created"`
+	Streams   int      `json:"streams"`
+	Dropped   int      `json:"dropped"` // events lost to a full queue
+}
+
+// WatchEvent reports a confirmed transaction touching a watched address
+// ("tx") or a change of its balance ("balance").
+type WatchEvent struct {
+	Watch     string       `json:"watch"`
+	Type      string       `json:"type"`
+	Address   string       `json:"address"`
+	Height    int          `json:"height"`
+	BlockHash string       `json:"blockHash"`
+	Tx        *Transaction `json:"tx,omitempty"`
+	Balance   *Balance     `json:"balance,omitempty"`
+	Previous  *Balance     `json:"previous,omitempty"`
+}
+
+// watch is a registered Watch with its delivery state.
+type watch struct {
+	Watch
+	set      map[string]bool
+	balances map[string]Balance // as of the last notified block
+	hook     chan WatchEvent    // nil without a webhook
+	streams  map[chan WatchEvent]bool
+}
+
+var (
+	// watches by ID. watchMu guards them; it is taken after mu.
+	watches = make(map[string]*watch)
+	watchMu sync.Mutex
+
+	webhookClient = &http.Client{Timeout: watchWebhookTimeout}
+)
+
+// balanceOf returns the balance of addr in state, empty if unknown.
+func balanceOf(state LedgerState, addr string) Balance {
+	if b, ok := state[addr]; ok {
+		return *b
+	}
+	return Balance{Address: addr}
+}
+
+// deliver queues ev on ch without blocking, counting it as dropped if
+// the queue is full. Callers must hold watchMu.
+func (w *watch) deliver(ch chan WatchEvent, ev WatchEvent) {
+	select {
+	case ch <- ev:
+	default:
+		if w.Dropped == 0 {
+			log.Printf("⚠️  Watch %s is not keeping up; dropping events", w.ID)
+		}
+		w.Dropped++
+	}
+}
+
+// notifyWatchers reports b, just appended, to the watches of the
+// addresses it touches or whose balance it changed. Callers must hold
+// mu.
+func notifyWatchers(b PowBlock) {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	if len(watches) == 0 {
+		return
+	}
+	state := ledgerState(powChain)
+	for _, w := range watches {
+		var events []WatchEvent
+		for i := range b.Transactions {
+			tx := b.Transactions[i]
+			for _, addr := range []string{tx.From, tx.To} {
+				if w.set[addr] {
+					events = append(events, WatchEvent{Type: "tx", Address: addr, Tx: &tx})
+				}
+				if tx.From == tx.To {
+					break
+				}
+			}
+		}
+		for _, addr := range w.Addresses {
+			prev, now := w.balances[addr], balanceOf(state, addr)
+			if now != prev {
+				w.balances[addr] = now
+				events = append(events, WatchEvent{Type: "balance", Address: addr, Balance: &now, Previous: &prev})
+			}
+		}
+		for _, ev := range events {
+			ev.Watch, ev.Height, ev.BlockHash = w.ID, b.Height, b.Hash
+			if w.hook != nil {
+				w.deliver(w.hook, ev)
+			}
+			for ch := range w.streams {
+				w.deliver(ch, ev)
+			}
+		}
+	}
+}
+
+// postWebhook delivers the events of a watch to its webhook, one at a
+// time, until the watch is removed.
+func postWebhook(id, hook string, events <-chan WatchEvent) {
+	for ev := range events {
+		body, _ := json.Marshal(ev)
+		resp, err := webhookClient.Post(hook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("⚠️  Watch %s webhook failed: %v", id, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("⚠️  Watch %s webhook answered %s", id, resp.Status)
+		}
+	}
+}
+
+// checkWebhook accepts absolute http and https URLs.
+func checkWebhook(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook %q: want an http or https URL", raw)
+	}
+	return nil
+}
+
+// createWatchHandler registers a watch. Its first events describe the
+// next block; current balances are the starting point.
+func createWatchHandler(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Addresses []string `json:"addresses"`
+		Webhook   string   `json:"webhook"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		httpapi.WriteError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if len(payload.Addresses) == 0 || len(payload.Addresses) > maxWatchAddresses {
+		httpapi.WriteError(w, fmt.Sprintf("watch 1 to %d addresses", maxWatchAddresses), http.StatusBadRequest)
+		return
+	}
+	if payload.Webhook != "" {
+		if err := checkWebhook(payload.Webhook); err != nil {
+			httpapi.WriteError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	wt := &watch{
+		Watch: Watch{
+			ID:      httpapi.NewRequestID(),
+			Webhook: payload.Webhook,
+			Created: clk.Now().UTC().Format(time.RFC3339),
+		},
+		set:      make(map[string]bool),
+		balances: make(map[string]Balance),
+		streams:  make(map[chan WatchEvent]bool),
+	}
+	for _, addr := range payload.Addresses {
+		if addr == "" {
+			httpapi.WriteError(w, "empty address", http.StatusBadRequest)
+			return
+		}
+		if !wt.set[addr] {
+			wt.set[addr] = true
+			wt.Addresses = append(wt.Addresses, addr)
+		}
+	}
+
+	mu.Lock()
+	state := ledgerState(powChain)
+	watchMu.Lock()
+	if len(watches) >= maxWatches {
+		watchMu.Unlock()
+		mu.Unlock()
+		httpapi.WriteError(w, "too many watches", http.StatusServiceUnavailable)
+		return
+	}
+	for _, addr := range wt.Addresses {
+		wt.balances[addr] = balanceOf(state, addr)
+	}
+	if wt.Webhook != "" {
+		wt.hook = make(chan WatchEvent, watchQueue)
+		go postWebhook(wt.ID, wt.Webhook, wt.hook)
+	}
+	watches[wt.ID] = wt
+	view := wt.Watch
+	watchMu.Unlock()
+	mu.Unlock()
+	httpapi.LogRequest(r, "👀 Watch %s registered for %d address(es)", view.ID, len(view.Addresses))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(view)
+}
+
+// getWatchHandler reports a watch.
+func getWatchHandler(w http.ResponseWriter, r *http.Request) {
+	watchMu.Lock()
+	wt, ok := watches[mux.Vars(r)["id"]]
+	var view Watch
+	if ok {
+		view = wt.Watch
+		view.Streams = len(wt.streams)
+	}
+	watchMu.Unlock()
+	if !ok {
+		httpapi.WriteError(w, "no such watch", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(view)
+}
+
+// deleteWatchHandler removes a watch, stopping its webhook and closing
+// its streams.
+func deleteWatchHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	watchMu.Lock()
+	wt, ok := watches[id]
+	if ok {
+		delete(watches, id)
+		if wt.hook != nil {
+			close(wt.hook)
+		}
+		for ch := range wt.streams {
+			close(ch)
+		}
+		wt.streams = nil
+	}
+	watchMu.Unlock()
+	if !ok {
+		httpapi.WriteError(w, "no such watch", http.StatusNotFound)
+		return
+	}
+	httpapi.LogRequest(r, "👀 Watch %s removed", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// watchStreamHandler upgrades to a WebSocket and sends the events of a
+// watch as JSON text messages until either side closes.
+func watchStreamHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	watchMu.Lock()
+	_, ok := watches[id]
+	watchMu.Unlock()
+	if !ok {
+		httpapi.WriteError(w, "no such watch", http.StatusNotFound)
+		return
+	}
+	conn, rw, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events := make(chan WatchEvent, watchQueue)
+	watchMu.Lock()
+	wt, ok := watches[id]
+	if ok {
+		wt.streams[events] = true
+	}
+	watchMu.Unlock()
+	if !ok {
+		_ = writeFrame(rw.Writer, wsClose, nil)
+		return
+	}
+	defer func() {
+		watchMu.Lock()
+		delete(wt.streams, events)
+		watchMu.Unlock()
+	}()
+
+	// The reader answers pings and closes; writes are serialised by
+	// writeMu.
+	var writeMu sync.Mutex
+	send := func(opcode byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeFrame(rw.Writer, opcode, payload)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			opcode, payload, err := readFrame(rw.Reader)
+			if err != nil {
+				return
+			}
+			switch opcode {
+			case wsPing:
+				_ = send(wsPong, payload)
+			case wsClose:
+				_ = send(wsClose, payload)
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(watchPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, open := <-events:
+			if !open {
+				_ = send(wsClose, nil)
+				return
+			}
+			msg, _ := json.Marshal(ev)
+			if send(wsText, msg) != nil {
+				return
+			}
+		case <-ping.C:
+			if send(wsPing, nil) != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// ------------------------------------------------------------
+// 🔏 AlirezaChain Remote Signer
+// Description: Holds PoS validator keys away from the internet-facing
+//              node and signs blocks on request. It hashes each block
+//              header itself and refuses to sign two different blocks
+//              at the same height, so a misbehaving node cannot make
+//              its validators double-sign or sign anything but a block.
+// ------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"alirezachain/chainhash"
+	"alirezachain/genesis"
+	"alirezachain/poscore"
+	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
+)
+
+// SignRequest asks for a signature over a block, given by its header.
+type SignRequest struct {
+	Block poscore.Header `json:"block"`
+}
+
+// SignResponse carries the signature, the signing public key and the
+// block hash it covers.
+type SignResponse struct {
+	PubKey    string `json:"pubKey"`
+	Signature string `json:"signature"`
+	Hash      string `json:"hash"`
+}
+
+// SignedMark is the last block signed for a validator.
+type SignedMark struct {
+	Height int    `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+var (
+	keys       = make(map[string]ed25519.PrivateKey)
+	lastSigned = make(map[string]SignedMark)
+	mu         sync.Mutex
+
+	// token must be presented as a bearer token (SIGNER_TOKEN).
+	token string
+	// statePath persists lastSigned across restarts (SIGNER_STATE).
+	statePath string
+
+	// hasher and forks hash blocks as the PoS node does: the hash
+	// algorithm of its GENESIS_FILE and its FORK_HEIGHTS.
+	hasher = chainhash.Default
+	forks  = make(poscore.Forks)
+)
+
+// loadKeys parses SIGNER_KEYS ("name:hexseed,...").
+func loadKeys(spec string) error {
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, seedHex, ok := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return fmt.Errorf("malformed key entry %q", entry)
+		}
+		seed, err := hex.DecodeString(strings.TrimSpace(seedHex))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("key for %s must be a %d-byte hex seed", name, ed25519.SeedSize)
+		}
+		keys[name] = ed25519.NewKeyFromSeed(seed)
+	}
+	return nil
+}
+
+// loadState restores the signing marks written by saveState.
+func loadState() error {
+	if statePath == "" {
+		return nil
+	}
+	raw, err := os.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, &lastSigned)
+}
+
+// saveState persists the signing marks. Callers must hold mu.
+func saveState() error {
+	if statePath == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(lastSigned, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := statePath + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, statePath)
+}
+
+// authorized checks the bearer token.
+func authorized(r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// --- HTTP Handlers ---
+
+// signHandler signs a block unless that would be a double sign: heights
+// must not go backwards, and a height already signed may only be signed
+// again for the same block. The signer computes the hash from the header
+// and takes the height from it, so the height it tracks is the one the
+// signature commits to, and it never signs a digest it did not compute,
+// such as that of an off-chain message.
+func signHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req SignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	b := req.Block
+	if b.Height < 1 {
+		http.Error(w, "block height must be at least 1", http.StatusBadRequest)
+		return
+	}
+	hash := b.Hash(hasher, forks)
+	digest, _ := hex.DecodeString(hash)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	priv, ok := keys[b.Validator]
+	if !ok {
+		http.Error(w, "unknown validator", http.StatusNotFound)
+		return
+	}
+	if last, ok := lastSigned[b.Validator]; ok {
+		if b.Height < last.Height || (b.Height == last.Height && hash != last.Hash) {
+			log.Printf("🛑 Refused to sign %s at height %d: already signed height %d", b.Validator, b.Height, last.Height)
+			http.Error(w, fmt.Sprintf("refusing to double-sign: already signed height %d", last.Height), http.StatusConflict)
+			return
+		}
+	}
+
+	lastSigned[b.Validator] = SignedMark{Height: b.Height, Hash: hash}
+	if err := saveState(); err != nil {
+		log.Printf("⚠️  Failed to persist signer state: %v", err)
+		http.Error(w, "failed to persist signer state", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("🔏 Signed %s at height %d: %s", b.Validator, b.Height, hash)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(SignResponse{
+		PubKey:    hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(priv, digest)),
+		Hash:      hash,
+	})
+}
+
+// keysHandler lists the validators this signer holds keys for.
+func keysHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	mu.Lock()
+	out := make(map[string]string, len(keys))
+	for name, priv := range keys {
+		out[name] = hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	}
+	mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(out)
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "9100"
+	}
+	if err := loadKeys(os.Getenv("SIGNER_KEYS")); err != nil {
+		log.Fatalf("invalid SIGNER_KEYS: %v", err)
+	}
+	if token = os.Getenv("SIGNER_TOKEN"); token == "" {
+		log.Fatal("SIGNER_TOKEN is required: without it anyone who can reach the signer could use its keys")
+	}
+	if path := os.Getenv("GENESIS_FILE"); path != "" {
+		g, err := genesis.Read(path)
+		if err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+		if hasher, err = chainhash.New(g.HashAlgorithm); err != nil {
+			log.Fatalf("genesis file: %v", err)
+		}
+	}
+	var err error
+	if forks, err = poscore.ParseForks(os.Getenv("FORK_HEIGHTS")); err != nil {
+		log.Fatalf("fork config: %v", err)
+	}
+	statePath = os.Getenv("SIGNER_STATE")
+	if err := loadState(); err != nil {
+		log.Fatalf("invalid SIGNER_STATE: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/sign", signHandler).Methods("POST")
+	r.HandleFunc("/keys", keysHandler).Methods("GET")
+
+	addr := ":" + port
+	log.Printf("🔏 Remote signer listening on %s with %d key(s)", addr, len(keys))
+	if err := http.ListenAndServe(addr, r); err != nil {
+		log.Fatalf("server error: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"alirezachain/poscore"
+)
+
+func TestSignHandler(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	priv := ed25519.NewKeyFromSeed(seed)
+	keys = map[string]ed25519.PrivateKey{"v1": priv}
+	lastSigned = make(map[string]SignedMark)
+	token = "secret"
+
+	sign := func(b poscore.Header, tok string) (int, SignResponse) {
+		body, _ := json.Marshal(SignRequest{Block: b})
+		req := httptest.NewRequest(http.MethodPost, "/sign", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tok)
+		rec := httptest.NewRecorder()
+		signHandler(rec, req)
+		var sr SignResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &sr)
+		return rec.Code, sr
+	}
+
+	b10 := poscore.Header{Height: 10, Timestamp: 1700000100, Data: "a", Validator: "v1"}
+	other := b10
+	other.Data = "b"
+	b11 := other
+	b11.Height = 11
+
+	code, sr := sign(b10, "secret")
+	if code != http.StatusOK {
+		t.Fatalf("first block: %d", code)
+	}
+	if sr.Hash != b10.Hash(hasher, forks) {
+		t.Errorf("signed hash %s, want the header's %s", sr.Hash, b10.Hash(hasher, forks))
+	}
+	digest, _ := hex.DecodeString(sr.Hash)
+	sig, _ := hex.DecodeString(sr.Signature)
+	if !ed25519.Verify(priv.Public().(ed25519.PublicKey), digest, sig) {
+		t.Error("signature does not cover the returned hash")
+	}
+
+	tests := []struct {
+		name  string
+		block poscore.Header
+		token string
+		want  int
+	}{
+		{"no token", b10, "", http.StatusUnauthorized},
+		{"same block again", b10, "secret", http.StatusOK},
+		{"other block at the same height", other, "secret", http.StatusConflict},
+		{"lower height", poscore.Header{Height: 9, Validator: "v1"}, "secret", http.StatusConflict},
+		{"unknown validator", poscore.Header{Height: 12, Validator: "v2"}, "secret", http.StatusNotFound},
+		{"genesis", poscore.Header{Validator: "v1"}, "secret", http.StatusBadRequest},
+		{"next height", b11, "secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, _ := sign(tt.block, tt.token); code != tt.want {
+			t.Errorf("%s: %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if got := lastSigned["v1"]; got.Height != 11 || got.Hash != b11.Hash(hasher, forks) {
+		t.Errorf("last signed %+v, want height 11", got)
+	}
+}
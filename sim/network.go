@@ -0,0 +1,167 @@
+package sim
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"time"
+)
+
+// ErrUnreachable is returned by the in-memory HTTP transport when the
+// target is partitioned away, unknown, or the request was dropped.
+var ErrUnreachable = errors.New("sim: host unreachable")
+
+// Network serves HTTP requests between nodes in memory. Each hop, the
+// request and the response, takes a simulated latency. It can drop
+// requests and split nodes into partitions. All randomness comes from
+// the seed, so runs are reproducible.
+type Network struct {
+	clock *Clock
+	rng   *rand.Rand
+
+	handlers map[string]http.Handler
+
+	latency  time.Duration
+	jitter   time.Duration
+	links    map[[2]string]time.Duration // per-link base latency, see SetLinkLatency
+	dropRate float64
+	group    map[string]int // partition group; unlisted nodes are in group 0
+
+	Delivered int
+	Dropped   int
+}
+
+// NewNetwork returns an empty network on clock. Hops take 10ms by
+// default.
+func NewNetwork(clock *Clock, seed int64) *Network {
+	return &Network{
+		clock:    clock,
+		rng:      rand.New(rand.NewSource(seed)),
+		handlers: make(map[string]http.Handler),
+		latency:  10 * time.Millisecond,
+		links:    make(map[[2]string]time.Duration),
+		group:    make(map[string]int),
+	}
+}
+
+// Handle serves h as host id for clients returned by Client.
+func (n *Network) Handle(id string, h http.Handler) {
+	n.handlers[id] = h
+}
+
+// IDs returns the hosts registered with Handle in sorted order.
+func (n *Network) IDs() []string {
+	ids := make([]string, 0, len(n.handlers))
+	for id := range n.handlers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SetLatency makes every hop take base plus a uniform random extra of up
+// to jitter.
+func (n *Network) SetLatency(base, jitter time.Duration) {
+	n.latency, n.jitter = base, jitter
+}
+
+// SetLinkLatency replaces the base latency between a and b, in both
+// directions. Jitter still applies.
+func (n *Network) SetLinkLatency(a, b string, d time.Duration) {
+	n.links[link(a, b)] = d
+}
+
+// SetDropRate makes each request get lost with probability p.
+func (n *Network) SetDropRate(p float64) {
+	n.dropRate = p
+}
+
+// Partition splits the network: nodes in different groups cannot reach
+// each other. Nodes not listed form one more group together.
+func (n *Network) Partition(groups ...[]string) {
+	n.group = make(map[string]int)
+	for i, g := range groups {
+		for _, id := range g {
+			n.group[id] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions.
+func (n *Network) Heal() {
+	n.group = make(map[string]int)
+}
+
+// Reachable reports whether from can currently talk to to.
+func (n *Network) Reachable(from, to string) bool {
+	return n.group[from] == n.group[to]
+}
+
+// lost decides whether a request from -> to is lost.
+func (n *Network) lost(from, to string) bool {
+	if !n.Reachable(from, to) {
+		return true
+	}
+	return n.dropRate > 0 && n.rng.Float64() < n.dropRate
+}
+
+// delay draws the latency of one hop between from and to.
+func (n *Network) delay(from, to string) time.Duration {
+	d, ok := n.links[link(from, to)]
+	if !ok {
+		d = n.latency
+	}
+	if n.jitter > 0 {
+		d += time.Duration(n.rng.Int63n(int64(n.jitter) + 1))
+	}
+	return d
+}
+
+// link is the key of the link between a and b, whichever way round.
+func link(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+// Client returns an HTTP client for from whose requests are served
+// in-memory by the handler registered for the URL's host. A request
+// returns once the clock has slept through both hops; the events that
+// fall due meanwhile run after the caller's current event.
+func (n *Network) Client(from string) *http.Client {
+	return &http.Client{Transport: transport{net: n, from: from}}
+}
+
+type transport struct {
+	net  *Network
+	from string
+}
+
+// RoundTrip serves req with the target's handler.
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	to := req.URL.Host
+	h, ok := t.net.handlers[to]
+	if !ok || t.net.lost(t.from, to) {
+		t.net.Dropped++
+		return nil, ErrUnreachable
+	}
+	t.net.Delivered++
+	t.net.clock.Sleep(t.net.delay(t.from, to))
+
+	in := req.Clone(req.Context())
+	in.RemoteAddr = t.from + ":0"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, in)
+	resp := rec.Result()
+	resp.Request = req
+	if resp.Body == nil {
+		resp.Body = io.NopCloser(bytes.NewReader(nil))
+	}
+	t.net.clock.Sleep(t.net.delay(to, t.from))
+	return resp, nil
+}
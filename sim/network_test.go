@@ -0,0 +1,112 @@
+package sim
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientLatency(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewClock(start)
+	n := NewNetwork(c, 1)
+	n.SetLinkLatency("b", "a", 100*time.Millisecond)
+	n.Handle("b", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RemoteAddr != "a:0" {
+			t.Errorf("RemoteAddr = %q", r.RemoteAddr)
+		}
+		if got := c.Since(start); got != 100*time.Millisecond {
+			t.Errorf("request arrived after %s, want 100ms", got)
+		}
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	n.Handle("c", http.NotFoundHandler())
+
+	resp, err := n.Client("a").Get("http://b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("status %d", resp.StatusCode)
+	}
+	if got := c.Since(start); got != 200*time.Millisecond {
+		t.Errorf("round trip took %s, want 200ms", got)
+	}
+
+	before := c.Now()
+	resp, err = n.Client("a").Get("http://c/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := c.Since(before); got != 20*time.Millisecond {
+		t.Errorf("round trip on the default link took %s, want 20ms", got)
+	}
+}
+
+func TestClientPartition(t *testing.T) {
+	n := NewNetwork(NewClock(time.Unix(0, 0)), 1)
+	n.Handle("b", http.NotFoundHandler())
+	n.Partition([]string{"a"})
+	if _, err := n.Client("a").Get("http://b/"); !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("partitioned request: %v", err)
+	}
+	n.Heal()
+	resp, err := n.Client("a").Get("http://b/")
+	if err != nil {
+		t.Fatalf("healed request: %v", err)
+	}
+	resp.Body.Close()
+	if n.Dropped != 1 || n.Delivered != 1 {
+		t.Errorf("dropped %d, delivered %d", n.Dropped, n.Delivered)
+	}
+}
+
+func TestAdvanceAfterSleep(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := NewClock(start)
+	var ran []time.Duration
+	c.AfterFunc(time.Second, func() {
+		ran = append(ran, c.Since(start))
+		c.Sleep(3 * time.Second)
+	})
+	c.AfterFunc(2*time.Second, func() { ran = append(ran, c.Since(start)) })
+	c.Advance(2 * time.Second)
+
+	if len(ran) != 2 || ran[0] != time.Second || ran[1] != 4*time.Second {
+		t.Errorf("events ran at %v, want [1s 4s]", ran)
+	}
+	if got := c.Since(start); got != 4*time.Second {
+		t.Errorf("clock at %s after Advance, want 4s: it must not go back", got)
+	}
+}
+
+// TestClockConcurrentUse schedules and reads the clock from other
+// goroutines while events run, as the P2P node's body fetchers do. Run
+// it with -race.
+func TestClockConcurrentUse(t *testing.T) {
+	c := NewClock(time.Unix(0, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.AfterFunc(time.Millisecond, func() {})
+				c.Sleep(time.Microsecond)
+				_ = c.Now()
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		c.Advance(time.Millisecond)
+	}
+	wg.Wait()
+	c.Advance(time.Second)
+	if got := c.Pending(); got != 0 {
+		t.Errorf("%d events left", got)
+	}
+}
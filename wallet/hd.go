@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"alirezachain/kdf"
+)
+
+// defaultPath is the account path keys are derived under; the address
+// index is appended as the last component.
+const defaultPath = "m/44'/7777'/0'/0'"
+
+// hardenedOffset marks a hardened derivation index.
+const hardenedOffset = 0x80000000
+
+var (
+	bip39Words = strings.Fields(englishWordlist)
+	wordIndex  = func() map[string]int {
+		m := make(map[string]int, len(bip39Words))
+		for i, w := range bip39Words {
+			m[w] = i
+		}
+		return m
+	}()
+)
+
+// newMnemonic returns a BIP-39 phrase of 12, 15, 18, 21 or 24 words.
+func newMnemonic(words int) (string, error) {
+	if words < 12 || words > 24 || words%3 != 0 {
+		return "", errors.New("word count must be 12, 15, 18, 21 or 24")
+	}
+	entropy := make([]byte, words/3*4)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+	return entropyToMnemonic(entropy), nil
+}
+
+// entropyToMnemonic encodes entropy plus its SHA-256 checksum bits as
+// 11-bit word indices.
+func entropyToMnemonic(entropy []byte) string {
+	checksumBits := len(entropy) * 8 / 32
+	sum := sha256.Sum256(entropy)
+
+	n := new(big.Int).SetBytes(entropy)
+	n.Lsh(n, uint(checksumBits))
+	n.Or(n, big.NewInt(int64(sum[0]>>(8-checksumBits))))
+
+	count := (len(entropy)*8 + checksumBits) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = bip39Words[new(big.Int).And(n, mask).Int64()]
+		n.Rsh(n, 11)
+	}
+	return strings.Join(words, " ")
+}
+
+// normalizeMnemonic lower-cases the phrase, collapses whitespace and
+// checks the words and the checksum.
+func normalizeMnemonic(phrase string) (string, error) {
+	words := strings.Fields(strings.ToLower(phrase))
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return "", errors.New("mnemonic must have 12, 15, 18, 21 or 24 words")
+	}
+
+	n := new(big.Int)
+	for _, w := range words {
+		i, ok := wordIndex[w]
+		if !ok {
+			return "", fmt.Errorf("unknown mnemonic word %q", w)
+		}
+		n.Lsh(n, 11)
+		n.Or(n, big.NewInt(int64(i)))
+	}
+
+	checksumBits := len(words) / 3
+	checksum := new(big.Int).And(n, big.NewInt(int64(1)<<checksumBits-1)).Int64()
+	n.Rsh(n, uint(checksumBits))
+	entropy := make([]byte, len(words)/3*4)
+	n.FillBytes(entropy)
+
+	sum := sha256.Sum256(entropy)
+	if int64(sum[0]>>(8-checksumBits)) != checksum {
+		return "", errors.New("mnemonic checksum mismatch")
+	}
+	return strings.Join(words, " "), nil
+}
+
+// mnemonicSeed derives the 64-byte BIP-39 seed from a phrase and an
+// optional passphrase.
+func mnemonicSeed(phrase, passphrase string) []byte {
+	return kdf.PBKDF2([]byte(phrase), []byte("mnemonic"+passphrase), 2048, 64, sha512.New)
+}
+
+// parsePath parses a derivation path such as m/44'/7777'/0'/0'/3'.
+// ed25519 only supports hardened derivation, so every component must be
+// hardened.
+func parsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("path %q must start with m", path)
+	}
+	idx := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		if !strings.HasSuffix(p, "'") && !strings.HasSuffix(p, "h") {
+			return nil, fmt.Errorf("path component %q must be hardened", p)
+		}
+		n, err := strconv.ParseUint(p[:len(p)-1], 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path component %q", p)
+		}
+		idx = append(idx, uint32(n)+hardenedOffset)
+	}
+	return idx, nil
+}
+
+// deriveKey derives the ed25519 key at path from a seed following
+// SLIP-0010, the ed25519 variant of BIP-32.
+func deriveKey(seed []byte, path string) (ed25519.PrivateKey, error) {
+	indices, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	key, chain := I[:32], I[32:]
+
+	for _, i := range indices {
+		data := make([]byte, 37)
+		copy(data[1:33], key)
+		binary.BigEndian.PutUint32(data[33:], i)
+
+		mac = hmac.New(sha512.New, chain)
+		mac.Write(data)
+		I = mac.Sum(nil)
+		key, chain = I[:32], I[32:]
+	}
+	return ed25519.NewKeyFromSeed(key), nil
+}
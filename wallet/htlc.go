@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+)
+
+// HTLC mirrors the PoW node's hash-timelock contract terms.
+type HTLC struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	HashLock  string `json:"hashLock"`
+	Timeout   int    `json:"timeout"`
+	Preimage  string `json:"preimage,omitempty"`
+}
+
+// htlcAddress must match the PoW node's contract address derivation.
+func htlcAddress(c HTLC) string {
+	h := sha256.Sum256([]byte(c.Sender + "|" + c.Recipient + "|" + c.HashLock + "|" + strconv.Itoa(c.Timeout)))
+	return "htlc" + hex.EncodeToString(h[:])
+}
+
+// htlcCmd builds and signs the transactions of a hash-timelock contract:
+// a lock paying the contract, a claim paying the recipient with the
+// preimage, or a refund paying the sender after the timeout. "secret"
+// generates a preimage and its hash lock.
+func htlcCmd(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: wallet htlc <secret|lock|claim|refund> [flags]")
+	}
+	action := args[0]
+	if action == "secret" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatal(err)
+		}
+		lock := sha256.Sum256(secret)
+		printJSON(map[string]string{"preimage": hex.EncodeToString(secret), "hashLock": hex.EncodeToString(lock[:])})
+		return
+	}
+
+	fs := flag.NewFlagSet("htlc "+action, flag.ExitOnError)
+	key := fs.String("key", os.Getenv("WALLET_KEY"), "hex seed of the signing key (or WALLET_KEY)")
+	keystore := fs.String("keystore", os.Getenv("WALLET_KEYSTORE"), "encrypted keystore file to sign with instead of -key (or WALLET_KEYSTORE)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	sender := fs.String("sender", "", "address that locked the contract (claim)")
+	recipient := fs.String("recipient", "", "address that may claim (lock and refund)")
+	hashLock := fs.String("hashlock", "", "hex SHA-256 of the preimage (see wallet htlc secret)")
+	timeout := fs.Int("timeout", 0, "first height at which the sender may refund")
+	preimage := fs.String("preimage", "", "hex preimage revealed by a claim")
+	amount := fs.Uint64("amount", 0, "amount to lock, or to pay out of the contract")
+	fee := fs.Uint64("fee", 0, "fee paid to the miner")
+	nonce := fs.Uint64("nonce", 0, "nonce of the lock's sender, or of the contract address when spending")
+	chainID := fs.String("chain-id", os.Getenv("WALLET_CHAIN_ID"), "chain ID of the network, as in GET /info (or WALLET_CHAIN_ID)")
+	_ = fs.Parse(args[1:])
+
+	priv, err := signingKey(*key, *keystore, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *hashLock == "" || *timeout <= 0 || *amount == 0 {
+		log.Fatal("-hashlock, a positive -timeout and a positive -amount are required")
+	}
+	self := hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	c := HTLC{Sender: self, Recipient: *recipient, HashLock: *hashLock, Timeout: *timeout}
+	if action == "claim" {
+		c.Sender, c.Recipient = *sender, self
+	}
+	if c.Sender == "" || c.Recipient == "" {
+		log.Fatal("-recipient is required to lock or refund, -sender to claim")
+	}
+
+	tx := Transaction{Amount: *amount, Fee: *fee, Nonce: *nonce, HTLC: &c}
+	switch action {
+	case "lock":
+		tx.From, tx.To = self, htlcAddress(c)
+	case "claim":
+		if *preimage == "" {
+			log.Fatal("-preimage is required to claim")
+		}
+		tx.From, tx.To = htlcAddress(c), self
+		c.Preimage = *preimage
+	case "refund":
+		tx.From, tx.To = htlcAddress(c), self
+	default:
+		log.Fatalf("unknown htlc action %q", action)
+	}
+	tx.ID = txHash(tx)
+	tx.Signature = signTx(priv, tx.ID, *chainID)
+	printJSON(tx)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"alirezachain/kdf"
+)
+
+// Default scrypt cost: 256 MiB of memory per derivation.
+const (
+	keystoreN = 1 << 18
+	keystoreR = 8
+	keystoreP = 1
+)
+
+// Keystore is a passphrase-encrypted private key. The key is sealed with
+// AES-256-GCM under a key derived by scrypt; the address is authenticated
+// as additional data so that it cannot be swapped.
+type Keystore struct {
+	Version int            `json:"version"`
+	Address string         `json:"address"`
+	Crypto  KeystoreCrypto `json:"crypto"`
+}
+
+// KeystoreCrypto holds the cipher and KDF parameters of a keystore.
+type KeystoreCrypto struct {
+	Cipher     string       `json:"cipher"`
+	Ciphertext string       `json:"ciphertext"`
+	Nonce      string       `json:"nonce"`
+	KDF        string       `json:"kdf"`
+	KDFParams  ScryptParams `json:"kdfparams"`
+}
+
+// ScryptParams are the scrypt settings used to derive the AES key.
+type ScryptParams struct {
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	DKLen int    `json:"dklen"`
+	Salt  string `json:"salt"`
+}
+
+// encryptKey seals priv under password.
+func encryptKey(priv ed25519.PrivateKey, password string) (*Keystore, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params := ScryptParams{N: keystoreN, R: keystoreR, P: keystoreP, DKLen: 32, Salt: hex.EncodeToString(salt)}
+	gcm, err := keystoreCipher(password, params)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	address := hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	return &Keystore{
+		Version: 1,
+		Address: address,
+		Crypto: KeystoreCrypto{
+			Cipher:     "aes-256-gcm",
+			Ciphertext: hex.EncodeToString(gcm.Seal(nil, nonce, priv.Seed(), []byte(address))),
+			Nonce:      hex.EncodeToString(nonce),
+			KDF:        "scrypt",
+			KDFParams:  params,
+		},
+	}, nil
+}
+
+// decryptKey opens a keystore with password.
+func decryptKey(ks *Keystore, password string) (ed25519.PrivateKey, error) {
+	if ks.Version != 1 || ks.Crypto.Cipher != "aes-256-gcm" || ks.Crypto.KDF != "scrypt" {
+		return nil, errors.New("unsupported keystore format")
+	}
+	if ks.Crypto.KDFParams.DKLen != 32 {
+		return nil, errors.New("keystore dklen must be 32")
+	}
+	gcm, err := keystoreCipher(password, ks.Crypto.KDFParams)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(ks.Crypto.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("malformed keystore nonce")
+	}
+	ct, err := hex.DecodeString(ks.Crypto.Ciphertext)
+	if err != nil {
+		return nil, errors.New("malformed keystore ciphertext")
+	}
+	seed, err := gcm.Open(nil, nonce, ct, []byte(ks.Address))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("wrong password or corrupted keystore")
+	}
+	priv := ed25519.NewKeyFromSeed(seed)
+	if hex.EncodeToString(priv.Public().(ed25519.PublicKey)) != ks.Address {
+		return nil, errors.New("keystore address does not match its key")
+	}
+	return priv, nil
+}
+
+// keystoreCipher derives the AES-GCM cipher for a password.
+func keystoreCipher(password string, params ScryptParams) (cipher.AEAD, error) {
+	salt, err := hex.DecodeString(params.Salt)
+	if err != nil {
+		return nil, errors.New("malformed keystore salt")
+	}
+	dk, err := kdf.Scrypt([]byte(password), salt, params.N, params.R, params.P, params.DKLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readKeystore loads and decrypts a keystore file.
+func readKeystore(path, password string) (ed25519.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var ks Keystore
+	if err := json.Unmarshal(raw, &ks); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return decryptKey(&ks, password)
+}
+
+// readPassword returns the password given by flag or WALLET_PASSWORD, or
+// asks for it on the terminal.
+func readPassword(given string) (string, error) {
+	if given != "" {
+		return given, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password given")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,370 @@
+// ------------------------------------------------------------
+// 👛 AlirezaChain Wallet
+// Description: Minimal command-line wallet for the AlirezaChain PoW node.
+//              Generates ed25519 keys and signs transfer transactions
+//              ready to be posted to POST /tx.
+// ------------------------------------------------------------
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"alirezachain/powcore"
+)
+
+// Transaction mirrors the PoW node's transaction format. The multisig
+// descriptor is attached by the node's POST /multisig/aggregate; HTLC
+// carries the terms of a hash-timelock contract (see htlc.go), Oracle
+// an oracle report (see oracle.go) and Schedule the terms of a scheduled
+// transfer (see schedule.go).
+type Transaction struct {
+	ID         string        `json:"id"`
+	From       string        `json:"from,omitempty"`
+	To         string        `json:"to"`
+	Amount     uint64        `json:"amount"`
+	Fee        uint64        `json:"fee,omitempty"`
+	Nonce      uint64        `json:"nonce"`
+	Signature  string        `json:"signature,omitempty"`
+	Signatures []PartialSig  `json:"signatures,omitempty"`
+	HTLC       *HTLC         `json:"htlc,omitempty"`
+	Oracle     *OracleReport `json:"oracle,omitempty"`
+	Schedule   *Schedule     `json:"schedule,omitempty"`
+}
+
+// PartialSig is one co-signer's signature on a multisig transfer.
+type PartialSig struct {
+	PubKey    string `json:"pubKey"`
+	Signature string `json:"signature"`
+}
+
+// txHash must match the PoW node's transaction ID computation.
+func txHash(tx Transaction) string {
+	record := tx.From + "|" +
+		tx.To + "|" +
+		strconv.FormatUint(tx.Amount, 10) + "|" +
+		strconv.FormatUint(tx.Fee, 10) + "|" +
+		strconv.FormatUint(tx.Nonce, 10)
+	if c := tx.HTLC; c != nil {
+		record += "|htlc|" + c.Sender + "|" + c.Recipient + "|" + c.HashLock + "|" + strconv.Itoa(c.Timeout) + "|" + c.Preimage
+	}
+	if o := tx.Oracle; o != nil {
+		record += "|oracle|" + o.Feed + "|" + strconv.Itoa(o.Round) + "|" + strconv.FormatFloat(o.Value, 'g', -1, 64)
+	}
+	if tx.Schedule != nil {
+		record += "|" + tx.Schedule.record()
+	}
+
+	h := sha256.Sum256([]byte(record))
+	return hex.EncodeToString(h[:])
+}
+
+// signTx signs the transaction ID id for the network chainID, over the
+// digest the PoW node verifies (powcore.TxSigningDigest). Every node has
+// a chain ID, so signing without one is refused.
+func signTx(priv ed25519.PrivateKey, id, chainID string) string {
+	if chainID == "" {
+		log.Fatal("-chain-id (or WALLET_CHAIN_ID) is required: the chainId of GET /info")
+	}
+	digest, _ := powcore.TxSigningDigest(chainID, id)
+	return hex.EncodeToString(ed25519.Sign(priv, digest))
+}
+
+// parseKey decodes a hex-encoded 32-byte ed25519 seed.
+func parseKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("key must be a %d-byte hex seed", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// signingKey loads the key from a keystore file if one is given, and
+// from a hex seed otherwise.
+func signingKey(key, keystore, password string) (ed25519.PrivateKey, error) {
+	if keystore == "" {
+		return parseKey(key)
+	}
+	pw, err := readPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return readKeystore(keystore, pw)
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// derivedKey is one key derived from a mnemonic.
+type derivedKey struct {
+	Path    string `json:"path"`
+	Address string `json:"address"`
+	Key     string `json:"key"`
+}
+
+// derive returns the keys at indices from..from+count-1 under account.
+func derive(phrase, passphrase, account string, from, count int) ([]derivedKey, error) {
+	seed := mnemonicSeed(phrase, passphrase)
+	keys := make([]derivedKey, 0, count)
+	for i := from; i < from+count; i++ {
+		path := fmt.Sprintf("%s/%d'", strings.TrimRight(account, "/"), i)
+		priv, err := deriveKey(seed, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, derivedKey{
+			Path:    path,
+			Address: hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+			Key:     hex.EncodeToString(priv.Seed()),
+		})
+	}
+	return keys, nil
+}
+
+// newCmd generates a seed phrase and prints it with its first key. With
+// -raw it generates a standalone key pair instead.
+func newCmd(args []string) {
+	fs := flag.NewFlagSet("new", flag.ExitOnError)
+	words := fs.Int("words", 12, "mnemonic length: 12, 15, 18, 21 or 24 words")
+	passphrase := fs.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "optional BIP-39 passphrase (or WALLET_PASSPHRASE)")
+	raw := fs.Bool("raw", false, "generate a single key without a seed phrase")
+	_ = fs.Parse(args)
+
+	if *raw {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatalf("generate key: %v", err)
+		}
+		printJSON(map[string]string{
+			"address": hex.EncodeToString(pub),
+			"key":     hex.EncodeToString(priv.Seed()),
+		})
+		return
+	}
+
+	phrase, err := newMnemonic(*words)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := derive(phrase, *passphrase, defaultPath, 0, 1)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(map[string]string{
+		"mnemonic": phrase,
+		"path":     keys[0].Path,
+		"address":  keys[0].Address,
+		"key":      keys[0].Key,
+	})
+}
+
+// deriveCmd derives keys from a seed phrase.
+func deriveCmd(args []string) {
+	fs := flag.NewFlagSet("derive", flag.ExitOnError)
+	mnemonic := fs.String("mnemonic", os.Getenv("WALLET_MNEMONIC"), "seed phrase (or WALLET_MNEMONIC)")
+	passphrase := fs.String("passphrase", os.Getenv("WALLET_PASSPHRASE"), "optional BIP-39 passphrase (or WALLET_PASSPHRASE)")
+	account := fs.String("path", defaultPath, "account path; the index is appended as a hardened component")
+	index := fs.Int("index", 0, "first address index")
+	count := fs.Int("count", 1, "number of keys to derive")
+	_ = fs.Parse(args)
+
+	phrase, err := normalizeMnemonic(*mnemonic)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *index < 0 || *count < 1 {
+		log.Fatal("-index must be non-negative and -count positive")
+	}
+	keys, err := derive(phrase, *passphrase, *account, *index, *count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(keys)
+}
+
+// txCmd builds and signs a transfer. With -from set to a multisig
+// address it produces a partial signature to be combined with the other
+// co-signers' via POST /multisig/aggregate.
+func txCmd(args []string) {
+	fs := flag.NewFlagSet("tx", flag.ExitOnError)
+	key := fs.String("key", os.Getenv("WALLET_KEY"), "hex seed of the sending key (or WALLET_KEY)")
+	keystore := fs.String("keystore", os.Getenv("WALLET_KEYSTORE"), "encrypted keystore file to sign with instead of -key (or WALLET_KEYSTORE)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	to := fs.String("to", "", "recipient address")
+	amount := fs.Uint64("amount", 0, "amount to send")
+	fee := fs.Uint64("fee", 0, "fee paid to the miner")
+	nonce := fs.Uint64("nonce", 0, "sender nonce (see GET /balance/{address})")
+	from := fs.String("from", "", "multisig address to co-sign for (default: the key's own address)")
+	chainID := fs.String("chain-id", os.Getenv("WALLET_CHAIN_ID"), "chain ID of the network, as in GET /info (or WALLET_CHAIN_ID)")
+	_ = fs.Parse(args)
+
+	priv, err := signingKey(*key, *keystore, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *to == "" || *amount == 0 {
+		log.Fatal("-to and a positive -amount are required")
+	}
+
+	pub := hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	tx := Transaction{
+		From:   pub,
+		To:     *to,
+		Amount: *amount,
+		Fee:    *fee,
+		Nonce:  *nonce,
+	}
+	if *from != "" {
+		tx.From = *from
+	}
+	tx.ID = txHash(tx)
+	sig := signTx(priv, tx.ID, *chainID)
+	if tx.From == pub {
+		tx.Signature = sig
+	} else {
+		tx.Signatures = []PartialSig{{PubKey: pub, Signature: sig}}
+	}
+	printJSON(tx)
+}
+
+// messagePrefix must match the nodes' message domain separator.
+const messagePrefix = "AlirezaChain Signed Message:\n"
+
+// signCmd signs an off-chain message, e.g. an ownership challenge, for
+// POST /verify.
+func signCmd(args []string) {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	key := fs.String("key", os.Getenv("WALLET_KEY"), "hex seed of the signing key (or WALLET_KEY)")
+	keystore := fs.String("keystore", os.Getenv("WALLET_KEYSTORE"), "encrypted keystore file to sign with instead of -key (or WALLET_KEYSTORE)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	message := fs.String("message", "", "message to sign")
+	_ = fs.Parse(args)
+
+	priv, err := signingKey(*key, *keystore, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte(messagePrefix + *message))
+	printJSON(map[string]string{
+		"address":   hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		"message":   *message,
+		"signature": hex.EncodeToString(ed25519.Sign(priv, digest[:])),
+	})
+}
+
+// importCmd encrypts a plaintext key into a keystore file.
+func importCmd(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	key := fs.String("key", os.Getenv("WALLET_KEY"), "hex seed to import (or WALLET_KEY)")
+	out := fs.String("out", "", "keystore file to write (default <address>.json)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	_ = fs.Parse(args)
+
+	priv, err := parseKey(*key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pw, err := readPassword(*password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pw == "" {
+		log.Fatal("refusing to encrypt with an empty password")
+	}
+	ks, err := encryptKey(priv, pw)
+	if err != nil {
+		log.Fatalf("encrypt key: %v", err)
+	}
+	if *out == "" {
+		*out = ks.Address + ".json"
+	}
+	raw, _ := json.MarshalIndent(ks, "", "  ")
+	if err := os.WriteFile(*out, append(raw, '\n'), 0600); err != nil {
+		log.Fatal(err)
+	}
+	printJSON(map[string]string{"address": ks.Address, "keystore": *out})
+}
+
+// exportCmd decrypts a keystore file and prints its plaintext key.
+func exportCmd(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	in := fs.String("in", os.Getenv("WALLET_KEYSTORE"), "keystore file to decrypt (or WALLET_KEYSTORE)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	_ = fs.Parse(args)
+
+	if *in == "" {
+		log.Fatal("-in is required")
+	}
+	pw, err := readPassword(*password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	priv, err := readKeystore(*in, pw)
+	if err != nil {
+		log.Fatal(err)
+	}
+	printJSON(map[string]string{
+		"address": hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		"key":     hex.EncodeToString(priv.Seed()),
+	})
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: wallet <command> [flags]
+
+commands:
+  new       generate a seed phrase and its first key
+  derive    derive keys from a seed phrase
+  import    encrypt a key into a keystore file
+  export    decrypt a keystore file and print its key
+  tx        build and sign a transfer for POST /tx
+  htlc      lock, claim or refund a hash-timelock contract
+  oracle    sign an oracle data report for POST /tx
+  schedule  build and sign a scheduled or recurring transfer
+  sign      sign an off-chain message for POST /verify`)
+	os.Exit(2)
+}
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "new":
+		newCmd(os.Args[2:])
+	case "derive":
+		deriveCmd(os.Args[2:])
+	case "import":
+		importCmd(os.Args[2:])
+	case "export":
+		exportCmd(os.Args[2:])
+	case "tx":
+		txCmd(os.Args[2:])
+	case "htlc":
+		htlcCmd(os.Args[2:])
+	case "oracle":
+		oracleCmd(os.Args[2:])
+	case "schedule":
+		scheduleCmd(os.Args[2:])
+	case "sign":
+		signCmd(os.Args[2:])
+	default:
+		usage()
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+)
+
+// OracleReport mirrors the PoW node's oracle report.
+type OracleReport struct {
+	Feed  string  `json:"feed"`
+	Round int     `json:"round"`
+	Value float64 `json:"value"`
+}
+
+// oracleCmd builds and signs an oracle report, a transaction paying
+// nothing to the node's reserved "oracle" address.
+func oracleCmd(args []string) {
+	fs := flag.NewFlagSet("oracle", flag.ExitOnError)
+	key := fs.String("key", os.Getenv("WALLET_KEY"), "hex seed of a whitelisted oracle key (or WALLET_KEY)")
+	keystore := fs.String("keystore", os.Getenv("WALLET_KEYSTORE"), "encrypted keystore file to sign with instead of -key (or WALLET_KEYSTORE)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	feed := fs.String("feed", "", "feed name, e.g. btc-usd")
+	round := fs.Int("round", -1, "round the report is for (see the node's GET /oracle)")
+	value := fs.Float64("value", 0, "reported value")
+	fee := fs.Uint64("fee", 0, "fee paid to the miner")
+	nonce := fs.Uint64("nonce", 0, "sender nonce (see GET /balance/{address})")
+	chainID := fs.String("chain-id", os.Getenv("WALLET_CHAIN_ID"), "chain ID of the network, as in GET /info (or WALLET_CHAIN_ID)")
+	_ = fs.Parse(args)
+
+	priv, err := signingKey(*key, *keystore, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *feed == "" || *round < 0 {
+		log.Fatal("-feed and -round are required")
+	}
+
+	tx := Transaction{
+		From:   hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		To:     "oracle",
+		Fee:    *fee,
+		Nonce:  *nonce,
+		Oracle: &OracleReport{Feed: *feed, Round: *round, Value: *value},
+	}
+	tx.ID = txHash(tx)
+	tx.Signature = signTx(priv, tx.ID, *chainID)
+	printJSON(tx)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Schedule mirrors the PoW node's scheduled transfer terms.
+type Schedule struct {
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Amount    uint64 `json:"amount"`
+	Start     int    `json:"start"`
+	Every     int    `json:"every,omitempty"`
+	Count     int    `json:"count"`
+	Nonce     uint64 `json:"nonce"`
+}
+
+// record must match the PoW node's encoding of schedule terms.
+func (s Schedule) record() string {
+	return "sched|" + s.Sender + "|" + s.Recipient + "|" +
+		strconv.FormatUint(s.Amount, 10) + "|" +
+		strconv.Itoa(s.Start) + "|" +
+		strconv.Itoa(s.Every) + "|" +
+		strconv.Itoa(s.Count) + "|" +
+		strconv.FormatUint(s.Nonce, 10)
+}
+
+// scheduleCmd builds and signs a scheduled transfer, escrowing -amount
+// for each of -count runs starting at height -start.
+func scheduleCmd(args []string) {
+	fs := flag.NewFlagSet("schedule", flag.ExitOnError)
+	key := fs.String("key", os.Getenv("WALLET_KEY"), "hex seed of the sending key (or WALLET_KEY)")
+	keystore := fs.String("keystore", os.Getenv("WALLET_KEYSTORE"), "encrypted keystore file to sign with instead of -key (or WALLET_KEYSTORE)")
+	password := fs.String("password", os.Getenv("WALLET_PASSWORD"), "keystore password (or WALLET_PASSWORD; prompted if empty)")
+	to := fs.String("to", "", "recipient address")
+	amount := fs.Uint64("amount", 0, "amount paid per run")
+	start := fs.Int("start", 0, "height of the first run")
+	every := fs.Int("every", 0, "blocks between runs (required with -count above 1)")
+	count := fs.Int("count", 1, "number of runs")
+	fee := fs.Uint64("fee", 0, "fee paid to the miner")
+	nonce := fs.Uint64("nonce", 0, "sender nonce (see GET /balance/{address})")
+	chainID := fs.String("chain-id", os.Getenv("WALLET_CHAIN_ID"), "chain ID of the network, as in GET /info (or WALLET_CHAIN_ID)")
+	_ = fs.Parse(args)
+
+	priv, err := signingKey(*key, *keystore, *password)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *to == "" || *amount == 0 || *start <= 0 || *count < 1 {
+		log.Fatal("-to, a positive -amount, -start and -count are required")
+	}
+
+	s := Schedule{
+		Sender:    hex.EncodeToString(priv.Public().(ed25519.PublicKey)),
+		Recipient: *to,
+		Amount:    *amount,
+		Start:     *start,
+		Every:     *every,
+		Count:     *count,
+		Nonce:     *nonce,
+	}
+	h := sha256.Sum256([]byte(s.record()))
+	tx := Transaction{
+		From:     s.Sender,
+		To:       "sched" + hex.EncodeToString(h[:]),
+		Amount:   s.Amount * uint64(s.Count),
+		Fee:      *fee,
+		Nonce:    *nonce,
+		Schedule: &s,
+	}
+	tx.ID = txHash(tx)
+	tx.Signature = signTx(priv, tx.ID, *chainID)
+	printJSON(tx)
+}